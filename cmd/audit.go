@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// auditCronPurge is the daily cron schedule for purging audit logs past the retention period.
+	auditCronPurge = "0 3 * * *"
+
+	// auditMaxBody is the max size of request and response bodies read for audit logs.
+	auditMaxBody = 1 << 20
+
+	auditRedacted = "********"
+)
+
+var (
+	// auditSkipRoutes are non-GET routes that don't mutate anything and aren't audited.
+	auditSkipRoutes = map[string]struct{}{
+		"POST /api/campaigns/:id/preview":         {},
+		"POST /api/campaigns/:id/preview/archive": {},
+		"POST /api/campaigns/:id/content":         {},
+		"POST /api/campaigns/:id/text":            {},
+		"POST /api/campaigns/:id/test":            {},
+		"POST /api/templates/preview":             {},
+		"POST /api/settings/smtp/test":            {},
+		"POST /api/tx":                            {},
+		"POST /api/tx/batch":                      {},
+		"POST /api/logout":                        {},
+		"POST /webhooks/bounce":                   {},
+	}
+
+	// auditActions overrides the action derived from the HTTP method for certain routes.
+	auditActions = map[string]string{
+		"POST /api/admin/reload":             models.AuditActionUpdate,
+		"POST /api/subscribers/query/delete": models.AuditActionDelete,
+	}
+
+	// auditIgnoreFields are fields that are not compared in before/after diffs.
+	auditIgnoreFields = []string{"updated_at"}
+)
+
+// GetAuditLogs handles retrieval of audit log entries.
+func (a *App) GetAuditLogs(c echo.Context) error {
+	var (
+		userID, _   = strconv.Atoi(c.QueryParam("user_id"))
+		targetID, _ = strconv.Atoi(c.QueryParam("target_id"))
+		action      = c.QueryParam("action")
+		targetType  = c.QueryParam("target_type")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	from, err := parseAuditDate(c.QueryParam("from"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "from"))
+	}
+	to, err := parseAuditDate(c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "to"))
+	}
+
+	res, total, err := a.core.QueryAuditLogs(userID, action, targetType, targetID, from, to, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// No results.
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.AuditLog{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// auditLog is a middleware that records an audit log entry for every successful
+// mutating (non-GET) request made by an authenticated user. Where the target
+// of the request can be fetched, its before and after states are diffed.
+func (a *App) auditLog(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions {
+			return next(c)
+		}
+
+		route := req.Method + " " + c.Path()
+		if _, ok := auditSkipRoutes[route]; ok {
+			return next(c)
+		}
+
+		var (
+			user               = auth.GetUser(c)
+			targetType, action = getAuditTarget(req.Method, c.Path())
+			targetID, _        = strconv.Atoi(c.Param("id"))
+		)
+		if act, ok := auditActions[route]; ok {
+			action = act
+		}
+
+		// The profile endpoint modifies the current user.
+		if targetType == "profile" {
+			targetType, targetID = "users", user.ID
+		}
+
+		// Read the request body (if it's JSON) and restore it for the handler.
+		var body []byte
+		if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) && req.Body != nil {
+			b, err := io.ReadAll(io.LimitReader(req.Body, auditMaxBody))
+			if err != nil {
+				return err
+			}
+			body = b
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), req.Body))
+		}
+
+		// Fetch the target's state before the request.
+		before := a.getAuditState(targetType, targetID)
+
+		// On create, capture the response to get the ID of the new target.
+		var rw *auditRespWriter
+		if action == models.AuditActionCreate {
+			rw = &auditRespWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rw
+		}
+
+		if err := next(c); err != nil {
+			return err
+		}
+
+		// Only successful requests are audited.
+		if c.Response().Status >= http.StatusBadRequest {
+			return nil
+		}
+
+		if rw != nil && targetID == 0 {
+			var resp struct {
+				Data struct {
+					ID int `json:"id"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(rw.buf.Bytes(), &resp); err == nil {
+				targetID = resp.Data.ID
+			}
+		}
+
+		// Fetch the target's state after the request. If the target can't be fetched,
+		// the request body is recorded as the after state.
+		var after map[string]any
+		if action != models.AuditActionDelete {
+			after = a.getAuditState(targetType, targetID)
+		}
+		if after == nil && len(body) > 0 {
+			var b any
+			if err := json.Unmarshal(body, &b); err == nil {
+				if m, ok := redactAuditData(b).(map[string]any); ok {
+					after = m
+				} else {
+					after = map[string]any{"body": redactAuditData(b)}
+				}
+			}
+		}
+
+		diff := models.AuditDiff{}
+		diff.Before, diff.After = makeAuditDiff(before, after)
+		if q := req.URL.Query(); len(q) > 0 {
+			diff.Query = make(map[string]any, len(q))
+			for k, v := range q {
+				diff.Query[k] = v
+			}
+		}
+
+		b, err := json.Marshal(diff)
+		if err != nil {
+			a.log.Printf("error marshalling audit diff: %v", err)
+			return nil
+		}
+
+		_ = a.core.InsertAuditLog(models.AuditLog{
+			UserID:     null.NewInt(user.ID, user.ID > 0),
+			Username:   user.Username,
+			Action:     action,
+			TargetType: targetType,
+			TargetID:   null.NewInt(targetID, targetID > 0),
+			Route:      route,
+			Diff:       b,
+			IP:         c.RealIP(),
+		})
+
+		return nil
+	}
+}
+
+// getAuditState fetches the current state of an audit target as a map with
+// sensitive fields redacted. It returns nil if the target can't be fetched.
+func (a *App) getAuditState(targetType string, id int) map[string]any {
+	if id < 1 && targetType != "settings" {
+		return nil
+	}
+
+	var (
+		out any
+		err error
+	)
+	switch targetType {
+	case "lists":
+		out, err = a.core.GetList(id, "")
+	case "campaigns":
+		out, err = a.core.GetCampaign(id, "", "")
+	case "templates":
+		out, err = a.core.GetTemplate(id, false)
+	case "subscribers":
+		out, err = a.core.GetSubscriber(id, "", "")
+	case "bounces":
+		out, err = a.core.GetBounce(id)
+	case "media":
+		out, err = a.core.GetMedia(id, "", "", a.media)
+	case "users":
+		out, err = a.core.GetUser(id, "", "")
+	case "roles":
+		out, err = a.core.GetRole(id)
+	case "settings":
+		out, err = a.core.GetSettings()
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+
+	return redactAuditData(m).(map[string]any)
+}
+
+// auditRespWriter is an http.ResponseWriter that copies the response body
+// (up to auditMaxBody) to an internal buffer.
+type auditRespWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *auditRespWriter) Write(b []byte) (int, error) {
+	if w.buf.Len()+len(b) <= auditMaxBody {
+		w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// getAuditTarget returns the target type and action for the given method and
+// route path, eg: PUT /api/lists/:id = (lists, update).
+func getAuditTarget(method, path string) (string, string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 1 && parts[0] == "api" {
+		parts = parts[1:]
+	}
+
+	action := models.AuditActionUpdate
+	switch {
+	case method == http.MethodDelete:
+		action = models.AuditActionDelete
+	case method == http.MethodPost && len(parts) <= 2 && !strings.Contains(path, ":"):
+		action = models.AuditActionCreate
+	}
+
+	return parts[0], action
+}
+
+// makeAuditDiff returns the before and after maps with only the fields that differ.
+// If either of them is nil, the other one is returned in full.
+func makeAuditDiff(before, after map[string]any) (map[string]any, map[string]any) {
+	if before == nil || after == nil {
+		return before, after
+	}
+
+	b, a := map[string]any{}, map[string]any{}
+	for k, v := range after {
+		if inArray(k, auditIgnoreFields) {
+			continue
+		}
+		if bv, ok := before[k]; !ok || !reflect.DeepEqual(bv, v) {
+			b[k], a[k] = before[k], v
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok && !inArray(k, auditIgnoreFields) {
+			b[k], a[k] = v, nil
+		}
+	}
+
+	return b, a
+}
+
+// redactAuditData recursively replaces the values of sensitive fields
+// such as passwords, secrets, and tokens in decoded JSON data.
+func redactAuditData(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if isAuditSensitive(k) {
+				if s, ok := item.(string); !ok || s != "" {
+					val[k] = auditRedacted
+				}
+				continue
+			}
+			val[k] = redactAuditData(item)
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = redactAuditData(item)
+		}
+	}
+
+	return v
+}
+
+// isAuditSensitive checks if a field name (eg: password, smtp.password, pgp_passphrase,
+// aws_secret_access_key) holds a sensitive value. The config of plugin messengers
+// is handed to plugins as-is and may hold credentials.
+func isAuditSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"password", "passphrase", "secret", "token", "_key"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return key == "key" || key == "config"
+}
+
+// parseAuditDate parses an optional YYYY-MM-DD or RFC3339 date.
+func parseAuditDate(s string) (null.Time, error) {
+	if s == "" {
+		return null.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", s); err != nil {
+			return null.Time{}, err
+		}
+	}
+
+	return null.TimeFrom(t), nil
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"html/template"
+	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -14,6 +18,7 @@ import (
 	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -21,6 +26,16 @@ import (
 	"gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// Inline style of the CTR badges inserted into campaign bodies by the click map.
+	clickMapStyle = "display: inline-block; margin: 0 3px; padding: 1px 5px; border-radius: 3px; " +
+		"background: #ffd54f; color: #333; font: bold 11px sans-serif; vertical-align: middle;"
+
+	// Max number of per-subscriber attachments on a campaign and the max length of their templates.
+	maxSubAttachments   = 10
+	maxSubAttachmentLen = 100000
+)
+
 // campReq is a wrapper over the Campaign model for receiving
 // campaign creation and update data from APIs.
 type campReq struct {
@@ -49,6 +64,9 @@ type campContentReq struct {
 var (
 	reFromAddress = regexp.MustCompile(`((.+?)\s)?<(.+?)@(.+?)>`)
 	reSlug        = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]`)
+
+	// Matches <a href="..">..</a> tags in rendered campaign bodies.
+	reAnchor = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']+)["'][^>]*>.*?</a>`)
 )
 
 // GetCampaigns handles retrieval of campaigns.
@@ -565,6 +583,8 @@ func (a *App) TestCampaign(c echo.Context) error {
 	camp.Messenger = req.Messenger
 	camp.ContentType = req.ContentType
 	camp.Headers = req.Headers
+	camp.SubAttachments = req.SubAttachments
+	camp.Security = req.Security
 	camp.TemplateID = req.TemplateID
 	for _, id := range req.MediaIDs {
 		if id > 0 {
@@ -627,6 +647,293 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetCampaignLinkReport returns the per-link click report of a campaign with
+// the position of every link in the rendered body and its click-through rate.
+func (a *App) GetCampaignLinkReport(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, _, err := a.makeCampaignLinkReport(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignClickMap renders a campaign's body with the click-through
+// rate of every link annotated next to it.
+func (a *App) GetCampaignClickMap(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	rep, body, err := a.makeCampaignLinkReport(id)
+	if err != nil {
+		return err
+	}
+
+	// Index the stats by URL.
+	stats := make(map[string]models.CampaignLinkStats, len(rep.Links))
+	for _, l := range rep.Links {
+		stats[l.URL] = l
+	}
+
+	// Insert a badge with the CTR after every link in the body.
+	var (
+		b    bytes.Buffer
+		last = 0
+	)
+	for _, m := range reAnchor.FindAllSubmatchIndex(body, -1) {
+		b.Write(body[last:m[1]])
+		last = m[1]
+
+		l, ok := stats[html.UnescapeString(string(body[m[2]:m[3]]))]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&b, `<span class="listmonk-clickmap" title="%d clicks, %d unique" style="%s">%.2f%%</span>`,
+			l.Clicks, l.UniqueClicks, clickMapStyle, l.CTR)
+	}
+	b.Write(body[last:])
+
+	return c.HTML(http.StatusOK, b.String())
+}
+
+// makeCampaignLinkReport renders the given campaign and prepares its per-link
+// click report. It returns the report and the rendered campaign body.
+func (a *App) makeCampaignLinkReport(id int) (models.CampaignLinkReport, []byte, error) {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return models.CampaignLinkReport{}, nil, err
+	}
+
+	// Render {{ TrackLink }} URLs as-is so that they can be matched against
+	// the link stats and to prevent clicks and views from being registered.
+	var (
+		tracked = map[string]bool{}
+		funcs   = a.manager.TemplateFuncs(&camp)
+	)
+	funcs["TrackLink"] = func(url string, msg *manager.CampaignMessage) string {
+		url = strings.ReplaceAll(url, "&amp;", "&")
+		tracked[url] = true
+		return url
+	}
+	funcs["TrackView"] = func(msg *manager.CampaignMessage) template.HTML {
+		return ""
+	}
+
+	if err := camp.CompileTemplate(funcs); err != nil {
+		a.log.Printf("error compiling template: %v", err)
+		return models.CampaignLinkReport{}, nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
+	if err != nil {
+		a.log.Printf("error rendering message: %v", err)
+		return models.CampaignLinkReport{}, nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+	body := msg.Body()
+
+	// Get the click counts of all the links in the campaign.
+	counts, err := a.core.GetCampaignLinkStats(id)
+	if err != nil {
+		return models.CampaignLinkReport{}, nil, err
+	}
+
+	// Record the positions of the links in the order they appear in the body.
+	var (
+		links = []models.CampaignLinkStats{}
+		idx   = map[string]int{}
+	)
+	for n, m := range reAnchor.FindAllSubmatch(body, -1) {
+		u := html.UnescapeString(string(m[1]))
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			continue
+		}
+
+		i, ok := idx[u]
+		if !ok {
+			i = len(links)
+			idx[u] = i
+			links = append(links, models.CampaignLinkStats{URL: u, Tracked: tracked[u], Positions: []int{}})
+		}
+		links[i].Positions = append(links[i].Positions, n+1)
+	}
+
+	// Links that were clicked but are no longer in the body (eg: the template changed).
+	for _, l := range counts {
+		i, ok := idx[l.URL]
+		if !ok {
+			i = len(links)
+			idx[l.URL] = i
+			links = append(links, models.CampaignLinkStats{URL: l.URL, Tracked: true, Positions: []int{}})
+		}
+		links[i].Clicks = l.Clicks
+		links[i].UniqueClicks = l.UniqueClicks
+	}
+
+	// Compute the CTR. Without individual tracking, unique clicks are unknown
+	// and total clicks are used instead.
+	for i, l := range links {
+		if camp.Sent == 0 {
+			break
+		}
+
+		n := l.Clicks
+		if a.cfg.Privacy.IndividualTracking {
+			n = l.UniqueClicks
+		}
+		links[i].CTR = math.Round(float64(n)/float64(camp.Sent)*10000) / 100
+	}
+
+	return models.CampaignLinkReport{CampaignID: id, Sent: camp.Sent, Links: links}, body, nil
+}
+
+// ExportCampaignAnalytics streams the analytics of a campaign, or campaigns created
+// within a date range, as CSV or JSON. `type=subscribers` (default) exports per-subscriber
+// rows and `type=summary` exports aggregate rows per campaign.
+func (a *App) ExportCampaignAnalytics(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	var (
+		hasAllPerm     = user.HasPerm(auth.PermCampaignsGetAll)
+		permittedLists []int
+	)
+	if !hasAllPerm {
+		hasAllPerm, permittedLists = user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	}
+
+	var (
+		typ    = c.QueryParam("type")
+		format = c.QueryParam("format")
+		from   = c.QueryParam("from")
+		to     = c.QueryParam("to")
+
+		ids []int
+	)
+	if typ == "" {
+		typ = "subscribers"
+	}
+	if format == "" {
+		format = "csv"
+	}
+	if (typ != "subscribers" && typ != "summary") || (format != "csv" && format != "json") {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	// A single campaign (/api/campaigns/:id/analytics/export) or multiple campaigns.
+	if c.Param("id") != "" {
+		id := getID(c)
+		if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+			return err
+		}
+		ids = []int{id}
+	} else {
+		v, err := parseStringIDs(c.QueryParams()["id"])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+		}
+		ids = v
+	}
+
+	// Get the campaigns to export. This also filters out campaigns the user doesn't have access to.
+	camps, err := a.core.GetCampaignAnalyticsSummary(ids, from, to, hasAllPerm, permittedLists)
+	if err != nil {
+		return err
+	}
+
+	var (
+		hdr = c.Response().Header()
+		fn  = "campaign-analytics-" + typ + "." + format
+	)
+	if format == "csv" {
+		hdr.Set(echo.HeaderContentType, "text/csv")
+	} else {
+		hdr.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	hdr.Set(echo.HeaderContentDisposition, "attachment; filename="+fn)
+	hdr.Set("Content-Transfer-Encoding", "binary")
+	hdr.Set("Cache-Control", "no-cache")
+
+	var (
+		wr      = newExportWriter(c.Response(), format)
+		fmtTime = func(t null.Time) string {
+			if !t.Valid {
+				return ""
+			}
+			return t.Time.Format(time.RFC3339)
+		}
+	)
+
+	// Aggregate rows per campaign.
+	if typ == "summary" {
+		wr.header([]string{"campaign_id", "name", "subject", "status", "created_at", "started_at", "to_send", "sent",
+			"views", "unique_views", "clicks", "unique_clicks", "bounces", "unsubscribes"})
+		for _, r := range camps {
+			if err := wr.write(r, []string{strconv.Itoa(r.CampaignID), r.Name, r.Subject, r.Status, fmtTime(r.CreatedAt), fmtTime(r.StartedAt),
+				strconv.Itoa(r.ToSend), strconv.Itoa(r.Sent), strconv.Itoa(r.Views), strconv.Itoa(r.UniqueViews),
+				strconv.Itoa(r.Clicks), strconv.Itoa(r.UniqueClicks), strconv.Itoa(r.Bounces), strconv.Itoa(r.Unsubscribes)}); err != nil {
+				a.log.Printf("error streaming campaign analytics export: %v", err)
+				break
+			}
+		}
+
+		return wr.close()
+	}
+
+	campIDs := make([]int, len(camps))
+	for i, r := range camps {
+		campIDs[i] = r.CampaignID
+	}
+
+	// Get the batched export iterator.
+	exp := a.core.ExportCampaignAnalytics(campIDs, a.cfg.DBBatchSize)
+
+	wr.header([]string{"campaign_id", "subscriber_uuid", "email", "name", "sent", "views", "first_viewed_at", "last_viewed_at",
+		"clicks", "clicked_links", "bounce_type", "unsubscribed"})
+
+loop:
+	// Iterate in batches until there are no more rows to export.
+	for {
+		out, err := exp()
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			break
+		}
+
+		for _, r := range out {
+			if err := wr.write(r, []string{strconv.Itoa(r.CampaignID), r.SubscriberUUID, r.Email, r.Name, strconv.FormatBool(r.Sent),
+				strconv.Itoa(r.Views), fmtTime(r.FirstViewedAt), fmtTime(r.LastViewedAt), strconv.Itoa(r.Clicks),
+				strings.Join(r.ClickedLinks, " "), r.BounceType.String, strconv.FormatBool(r.Unsubscribed)}); err != nil {
+				a.log.Printf("error streaming campaign analytics export: %v", err)
+				break loop
+			}
+		}
+
+		// Flush to the stream after each batch.
+		wr.flush()
+	}
+
+	return wr.close()
+}
+
 // sendTestMessage takes a campaign and a subscriber and sends out a sample campaign message.
 func (a *App) sendTestMessage(sub models.Subscriber, camp *models.Campaign) error {
 	if err := camp.CompileTemplate(a.manager.TemplateFuncs(camp)); err != nil {
@@ -692,6 +999,40 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidMessenger", "name", c.Messenger))
 	}
 
+	if !isValidSecurity(c.Security) {
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "security"))
+	}
+
+	// Validate per-subscriber attachments. Their templates are compiled with the body.
+	if len(c.SubAttachments) > maxSubAttachments {
+		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidAttachment", "error", fmt.Sprintf("> %d", maxSubAttachments)))
+	}
+	for i, s := range c.SubAttachments {
+		if s.Type != models.SubAttachmentMedia && s.Type != models.SubAttachmentText && s.Type != models.SubAttachmentCSV {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidAttachment", "error", "type"))
+		}
+		if !strHasLen(s.Name, 1, stdInputMaxLen) {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidAttachment", "error", "name"))
+		}
+
+		if s.Type == models.SubAttachmentMedia {
+			c.SubAttachments[i].Template = ""
+		} else if !strHasLen(s.Template, 1, maxSubAttachmentLen) {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidAttachment", "error", "template"))
+		}
+
+		switch s.OnMissing {
+		case models.SubAttachmentMissingSkip, models.SubAttachmentMissingSend, models.SubAttachmentMissingFail:
+		case "":
+			c.SubAttachments[i].OnMissing = models.SubAttachmentMissingSkip
+		default:
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidAttachment", "error", "on_missing"))
+		}
+	}
+	if c.SubAttachments == nil {
+		c.SubAttachments = models.SubAttachments{}
+	}
+
 	camp := models.Campaign{Body: c.Body, TemplateBody: tplTag}
 	if err := c.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
 		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
@@ -813,3 +1154,79 @@ func canEditCampaign(status string) bool {
 		status == models.CampaignStatusPaused ||
 		status == models.CampaignStatusScheduled
 }
+
+// exportWriter streams exported rows either as CSV, a JSON array,
+// or NDJSON (one JSON object per line).
+type exportWriter struct {
+	w        io.Writer
+	csv      *csv.Writer
+	json     *json.Encoder
+	isJSON   bool
+	isNDJSON bool
+	n        int
+}
+
+// newExportWriter returns a new exportWriter for the given format (csv|json|ndjson).
+func newExportWriter(w io.Writer, format string) *exportWriter {
+	switch format {
+	case "json":
+		return &exportWriter{w: w, json: json.NewEncoder(w), isJSON: true}
+	case "ndjson":
+		return &exportWriter{w: w, json: json.NewEncoder(w), isJSON: true, isNDJSON: true}
+	}
+	return &exportWriter{w: w, csv: csv.NewWriter(w)}
+}
+
+// header writes the CSV header. It's a no-op for JSON.
+func (e *exportWriter) header(cols []string) {
+	if e.isJSON {
+		return
+	}
+	e.csv.Write(cols)
+}
+
+// write writes a single row. rec is written for JSON and cols for CSV.
+func (e *exportWriter) write(rec any, cols []string) error {
+	if !e.isJSON {
+		return e.csv.Write(cols)
+	}
+	if e.isNDJSON {
+		return e.json.Encode(rec)
+	}
+
+	sep := ","
+	if e.n == 0 {
+		sep = "["
+	}
+	e.n++
+
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	return e.json.Encode(rec)
+}
+
+// flush flushes buffered CSV rows to the underlying writer.
+func (e *exportWriter) flush() {
+	if !e.isJSON {
+		e.csv.Flush()
+	}
+}
+
+// close terminates the JSON array and flushes the writer.
+func (e *exportWriter) close() error {
+	if !e.isJSON {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	if e.isNDJSON {
+		return nil
+	}
+
+	end := "]"
+	if e.n == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdgvda/cron"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatJSON   = "json"
+	exportFormatNDJSON = "ndjson"
+)
+
+var (
+	// subExportCols are the columns that can be exported in subscriber exports.
+	subExportCols = []string{"id", "uuid", "email", "name", "attributes", "status", "created_at", "updated_at", "subscriptions"}
+
+	// subExportCSVCols are the columns in CSV exports by default.
+	subExportCSVCols = []string{"uuid", "email", "name", "attributes", "status", "created_at", "updated_at"}
+
+	// regexExportName is the pattern for the names of scheduled exports,
+	// which are used as the prefix of exported file names.
+	regexExportName = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+)
+
+// exportFilePrefix is the prefix of the names of the files written by scheduled
+// exports to the media store. They're not media files and are only served via the
+// authenticated API. On the filesystem store, the prefix isn't served publicly.
+const exportFilePrefix = "_exports-"
+
+// subExportOpt represents the format and columns of a subscriber export.
+type subExportOpt struct {
+	Format  string
+	Columns []string
+
+	// Attribute keys to flatten into columns. Dots denote nested keys, eg: address.city.
+	Attribs []string
+}
+
+// makeSubExportOpt validates and returns the options for a subscriber export.
+// cols and attribs can be lists of names and/or comma separated names.
+// If there are no columns, CSV exports have the default columns and JSON
+// exports have all the columns including subscriptions.
+func makeSubExportOpt(format string, cols, attribs []string) (subExportOpt, error) {
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if format != exportFormatCSV && format != exportFormatJSON && format != exportFormatNDJSON {
+		return subExportOpt{}, fmt.Errorf("unknown format: %s", format)
+	}
+
+	o := subExportOpt{Format: format, Columns: splitExportNames(cols), Attribs: splitExportNames(attribs)}
+	for _, c := range o.Columns {
+		if !inArray(c, subExportCols) {
+			return subExportOpt{}, fmt.Errorf("unknown column: %s", c)
+		}
+	}
+
+	if len(o.Columns) == 0 {
+		if format == exportFormatCSV {
+			o.Columns = subExportCSVCols
+		} else {
+			o.Columns = subExportCols
+		}
+	}
+
+	return o, nil
+}
+
+// hasCol checks if the export has the given column.
+func (o subExportOpt) hasCol(col string) bool {
+	return inArray(col, o.Columns)
+}
+
+// contentType returns the MIME type of the export's format.
+func (o subExportOpt) contentType() string {
+	switch o.Format {
+	case exportFormatJSON:
+		return "application/json"
+	case exportFormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// writeSubscriberExport streams subscribers from an export iterator
+// (core.ExportSubscribers) to w in the export's format. If flush is set,
+// it's called after every batch.
+func writeSubscriberExport(w io.Writer, exp func() ([]models.SubscriberExport, error), o subExportOpt, flush func()) error {
+	wr := newExportWriter(w, o.Format)
+
+	hdr := make([]string, 0, len(o.Columns)+len(o.Attribs))
+	hdr = append(hdr, o.Columns...)
+	for _, k := range o.Attribs {
+		hdr = append(hdr, "attribs."+k)
+	}
+	wr.header(hdr)
+
+	// Iterate in batches until there are no more subscribers to export.
+	for {
+		out, err := exp()
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			break
+		}
+
+		for _, r := range out {
+			rec, cols := makeSubExportRow(r, o)
+			if err := wr.write(rec, cols); err != nil {
+				return err
+			}
+		}
+
+		// Flush to the stream after each batch.
+		wr.flush()
+		if flush != nil {
+			flush()
+		}
+	}
+
+	return wr.close()
+}
+
+// makeSubExportRow returns a subscriber as a JSON record and as CSV columns
+// with the export's columns and flattened attributes.
+func makeSubExportRow(r models.SubscriberExport, o subExportOpt) (map[string]any, []string) {
+	var (
+		rec  = make(map[string]any, len(o.Columns)+len(o.Attribs))
+		cols = make([]string, 0, len(o.Columns)+len(o.Attribs))
+	)
+
+	for _, c := range o.Columns {
+		var (
+			v   any
+			str string
+		)
+		switch c {
+		case "id":
+			v, str = r.ID, strconv.Itoa(r.ID)
+		case "uuid":
+			v, str = r.UUID, r.UUID
+		case "email":
+			v, str = r.Email, r.Email
+		case "name":
+			v, str = r.Name, r.Name
+		case "attributes":
+			v, str = json.RawMessage(r.Attribs), r.Attribs
+		case "status":
+			v, str = r.Status, r.Status
+		case "created_at":
+			v, str = r.CreatedAt, r.CreatedAt.Time.String()
+		case "updated_at":
+			v, str = r.UpdatedAt, r.UpdatedAt.Time.String()
+		case "subscriptions":
+			subs := r.Subscriptions
+			if len(subs) == 0 {
+				subs = json.RawMessage("[]")
+			}
+			v, str = subs, string(subs)
+		}
+
+		rec[c] = v
+		cols = append(cols, str)
+	}
+
+	if len(o.Attribs) == 0 {
+		return rec, cols
+	}
+
+	var attribs map[string]any
+	_ = json.Unmarshal([]byte(r.Attribs), &attribs)
+	for _, k := range o.Attribs {
+		v := getNestedAttrib(attribs, k)
+		rec["attribs."+k] = v
+
+		switch val := v.(type) {
+		case nil:
+			cols = append(cols, "")
+		case string:
+			cols = append(cols, val)
+		default:
+			b, _ := json.Marshal(val)
+			cols = append(cols, string(b))
+		}
+	}
+
+	return rec, cols
+}
+
+// GetScheduledExports returns the files written by scheduled exports, newest first.
+func (a *App) GetScheduledExports(c echo.Context) error {
+	out, err := a.core.GetScheduledExportFiles()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetScheduledExportFile handles the download of a file written by a scheduled export.
+// The file is read from the media store and streamed.
+func (a *App) GetScheduledExportFile(c echo.Context) error {
+	f, err := a.core.GetScheduledExportFile(int64(getID(c)))
+	if err != nil {
+		return err
+	}
+
+	b, err := a.media.GetBlob(f.Filename)
+	if err != nil {
+		a.log.Printf("error reading scheduled export file %s: %v", f.Filename, err)
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "{subscribers.export}"))
+	}
+
+	o, _ := makeSubExportOpt(strings.TrimPrefix(filepath.Ext(f.Filename), "."), nil, nil)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`,
+		strings.TrimPrefix(f.Filename, exportFilePrefix)))
+
+	return c.Blob(http.StatusOK, o.contentType(), b)
+}
+
+// runScheduledExport runs a scheduled subscriber export and writes the exported
+// file to the media store. Every instance runs the export's cron, but the run
+// (runAt) is claimed in the DB so that only one of them exports it. The name of
+// the file has the private prefix, the name of the export, the time of the export,
+// and a random suffix so that it isn't guessable on public stores. It returns
+// the name of the file, which is empty if the run was claimed by another instance.
+func runScheduledExport(e models.ScheduledExport, runAt time.Time, co *core.Core, store media.Store, batchSize int) (string, error) {
+	o, err := makeSubExportOpt(e.Format, e.Columns, e.Attribs)
+	if err != nil {
+		return "", err
+	}
+
+	id, ok, err := co.ClaimScheduledExport(e.Name, runAt)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	name, size, err := writeScheduledExport(e, o, co, store, batchSize)
+	if err != nil {
+		co.DeleteScheduledExportFile(id)
+		return "", err
+	}
+
+	if err := co.UpdateScheduledExportFile(id, name, size); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// writeScheduledExport exports subscribers to a file in the media store and
+// returns its name and size.
+func writeScheduledExport(e models.ScheduledExport, o subExportOpt, co *core.Core, store media.Store, batchSize int) (string, int64, error) {
+	exp, err := co.ExportSubscribers("", formatSQLExp(e.Query), nil, e.ListIDs, e.SubStatus, o.hasCol("subscriptions"), batchSize)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Write the export to a temporary file as the store needs an io.ReadSeeker.
+	f, err := os.CreateTemp("", "listmonk-export-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	if err := writeSubscriberExport(bw, exp, o, nil); err != nil {
+		return "", 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return "", 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	suffix, err := generateRandomString(16)
+	if err != nil {
+		return "", 0, err
+	}
+
+	name := fmt.Sprintf("%s%s-%s-%s.%s", exportFilePrefix, e.Name, time.Now().Format("20060102-150405"), suffix, o.Format)
+	if _, err := store.Put(name, o.contentType(), f); err != nil {
+		return "", 0, err
+	}
+
+	return name, size, nil
+}
+
+// validateScheduledExport validates the settings of a scheduled export.
+func validateScheduledExport(e models.ScheduledExport) error {
+	if !regexExportName.MatchString(e.Name) {
+		return errors.New("invalid name. Only letters, numbers, - and _ are allowed")
+	}
+	if _, err := cron.ParseStandard(e.Cron); err != nil {
+		return fmt.Errorf("invalid cron: %v", err)
+	}
+	if _, err := makeSubExportOpt(e.Format, e.Columns, e.Attribs); err != nil {
+		return err
+	}
+
+	switch e.SubStatus {
+	case "", models.SubscriptionStatusUnconfirmed, models.SubscriptionStatusConfirmed, models.SubscriptionStatusUnsubscribed:
+	default:
+		return fmt.Errorf("invalid subscription status: %s", e.SubStatus)
+	}
+
+	return nil
+}
+
+// splitExportNames splits a list of names and/or comma separated names
+// into a list of unique names.
+func splitExportNames(in []string) []string {
+	out := []string{}
+	for _, s := range in {
+		for _, n := range strings.Split(s, ",") {
+			if n = strings.TrimSpace(n); n != "" && !inArray(n, out) {
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+// getNestedAttrib returns the value of a (dot separated) nested key in
+// a map of attributes, or nil.
+func getNestedAttrib(attribs map[string]any, key string) any {
+	var cur any = attribs
+	for _, k := range strings.Split(key, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[k]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
@@ -95,7 +95,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 					return next(c)
 				}
-			})
+			}, a.auditLog)
 		)
 
 		// API endpoints.
@@ -104,13 +104,16 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/lang/:lang", a.GetI18nLang)
 		g.GET("/api/dashboard/charts", a.GetDashboardCharts)
 		g.GET("/api/dashboard/counts", a.GetDashboardCounts)
+		g.GET("/api/reports/digest", pm(a.GetDigestReport, "campaigns:get_analytics"))
 
 		g.GET("/api/settings", pm(a.GetSettings, "settings:get"))
 		g.PUT("/api/settings", pm(a.UpdateSettings, "settings:manage"))
 		g.PUT("/api/settings/:key", pm(a.UpdateSettingsByKey, "settings:manage"))
 		g.POST("/api/settings/smtp/test", pm(a.TestSMTPSettings, "settings:manage"))
+		g.GET("/api/settings/messengers/health", pm(a.GetMessengersHealth, "settings:get"))
 		g.POST("/api/admin/reload", pm(a.ReloadApp, "settings:manage"))
 		g.GET("/api/logs", pm(a.GetLogs, "settings:get"))
+		g.GET("/api/audit", pm(a.GetAuditLogs, "audit:get"))
 		g.GET("/api/events", pm(a.EventStream, "settings:get"))
 		g.GET("/api/about", a.GetAboutInfo)
 
@@ -120,7 +123,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/subscribers/:id/export", pm(hasID(a.ExportSubscriberData), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/bounces", pm(hasID(a.GetSubscriberBounces), "bounces:get"))
 		g.DELETE("/api/subscribers/:id/bounces", pm(hasID(a.DeleteSubscriberBounces), "bounces:manage"))
-		g.POST("/api/subscribers", pm(a.CreateSubscriber, "subscribers:manage"))
+		g.POST("/api/subscribers", pm(a.idempotent(a.CreateSubscriber), "subscribers:manage"))
 		g.PUT("/api/subscribers/:id", pm(hasID(a.UpdateSubscriber), "subscribers:manage"))
 		g.POST("/api/subscribers/:id/optin", pm(hasID(a.SubscriberSendOptin), "subscribers:manage"))
 		g.PUT("/api/subscribers/blocklist", pm(a.BlocklistSubscribers, "subscribers:manage"))
@@ -143,11 +146,18 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/subscribers/query/lists", pm(a.ManageSubscriberListsByQuery, "subscribers:manage"))
 		g.GET("/api/subscribers/export",
 			pm(middleware.GzipWithConfig(middleware.GzipConfig{Level: 9})(a.ExportSubscribers), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/exports", pm(a.GetScheduledExports, "subscribers:get_all"))
+		g.GET("/api/subscribers/exports/:id", pm(hasID(a.GetScheduledExportFile), "subscribers:get_all"))
 
 		g.GET("/api/import/subscribers", pm(a.GetImportSubscribers, "subscribers:import"))
 		g.GET("/api/import/subscribers/logs", pm(a.GetImportSubscriberStats, "subscribers:import"))
+		g.GET("/api/import/subscribers/rejects", pm(a.GetImportRejects, "subscribers:import"))
 		g.POST("/api/import/subscribers", pm(a.ImportSubscribers, "subscribers:import"))
 		g.DELETE("/api/import/subscribers", pm(a.StopImportSubscribers, "subscribers:import"))
+		g.GET("/api/import/jobs", pm(a.GetImportJobs, "subscribers:import"))
+		g.GET("/api/import/jobs/:id", pm(hasID(a.GetImportJob), "subscribers:import"))
+		g.GET("/api/import/jobs/:id/rejects", pm(hasID(a.GetImportJobRejects), "subscribers:import"))
+		g.DELETE("/api/import/jobs/:id", pm(hasID(a.StopImportJob), "subscribers:import"))
 
 		// Individual list permissions are applied directly within handleGetLists.
 		g.GET("/api/lists", a.GetLists)
@@ -161,6 +171,12 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/campaigns/running/stats", pm(a.GetRunningCampaignStats, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/:id", pm(hasID(a.GetCampaign), "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/analytics/:type", pm(a.GetCampaignViewAnalytics, "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/analytics/links", pm(hasID(a.GetCampaignLinkReport), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/analytics/clickmap", pm(hasID(a.GetCampaignClickMap), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/analytics/export",
+			pm(middleware.GzipWithConfig(middleware.GzipConfig{Level: 9})(hasID(a.ExportCampaignAnalytics)), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/analytics/export",
+			pm(middleware.GzipWithConfig(middleware.GzipConfig{Level: 9})(a.ExportCampaignAnalytics), "campaigns:get_analytics"))
 		g.GET("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview/archive", pm(hasID(a.PreviewCampaignArchive), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
@@ -175,8 +191,12 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/campaigns/:id", pm(hasID(a.DeleteCampaign), "campaigns:manage_all", "campaigns:manage"))
 
 		g.GET("/api/media", pm(a.GetAllMedia, "media:get"))
+		g.GET("/api/media/folders", pm(a.GetMediaFolders, "media:get"))
+		g.GET("/api/media/storage", pm(a.GetMediaStorage, "media:get"))
 		g.GET("/api/media/:id", pm(hasID(a.GetMedia), "media:get"))
+		g.GET("/api/media/:id/usage", pm(hasID(a.GetMediaUsage), "media:get"))
 		g.POST("/api/media", pm(a.UploadMedia, "media:manage"))
+		g.PUT("/api/media/:id", pm(hasID(a.UpdateMedia), "media:manage"))
 		g.DELETE("/api/media/:id", pm(hasID(a.DeleteMedia), "media:manage"))
 
 		g.GET("/api/templates", pm(a.GetTemplates, "templates:get"))
@@ -192,7 +212,11 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/maintenance/analytics/:type", pm(a.GCCampaignAnalytics, "settings:maintain"))
 		g.DELETE("/api/maintenance/subscriptions/unconfirmed", pm(a.GCSubscriptions, "settings:maintain"))
 
-		g.POST("/api/tx", pm(a.SendTxMessage, "tx:send"))
+		g.POST("/api/tx", pm(a.idempotent(a.SendTxMessage), "tx:send"))
+		g.POST("/api/tx/batch", pm(a.idempotent(a.SendTxBatch), "tx:send"))
+		g.GET("/api/tx/messages", pm(a.QueryTxMessages, "tx:get"))
+		g.GET("/api/tx/messages/:uuid", pm(a.GetTxMessage, "tx:get", "tx:send"))
+		g.GET("/api/tx/batches/:uuid", pm(a.GetTxBatch, "tx:get", "tx:send"))
 
 		g.GET("/api/profile", a.GetUserProfile)
 		g.PUT("/api/profile", a.UpdateUserProfile)
@@ -275,6 +299,13 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/link/:linkUUID/:campUUID/:subUUID", noIndex(a.hasUUID(a.LinkRedirect, "linkUUID", "campUUID", "subUUID")))
 		g.GET("/campaign/:campUUID/:subUUID", noIndex(a.hasUUID(a.ViewCampaignMessage, "campUUID", "subUUID")))
 		g.GET("/campaign/:campUUID/:subUUID/px.png", noIndex(a.hasUUID(a.RegisterCampaignView, "campUUID", "subUUID")))
+		g.GET("/tx/link/:linkUUID/:txUUID", noIndex(a.hasUUID(a.TxLinkRedirect, "linkUUID", "txUUID")))
+		g.GET("/tx/:txUUID/px.png", noIndex(a.hasUUID(a.RegisterTxView, "txUUID")))
+
+		if a.cfg.WebPushEnabled {
+			g.POST("/subscription/push/:subUUID", a.hasUUID(a.SubscribePush, "subUUID"))
+			g.DELETE("/subscription/push/:subUUID", a.hasUUID(a.UnsubscribePush, "subUUID"))
+		}
 
 		if a.cfg.EnablePublicArchive {
 			g.GET("/archive", a.CampaignArchivesPage)
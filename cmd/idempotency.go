@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	hdrIdempotencyKey      = "Idempotency-Key"
+	hdrIdempotencyReplayed = "Idempotent-Replayed"
+
+	// idempotencyCronPurge is the hourly cron schedule for deleting expired keys.
+	idempotencyCronPurge = "0 * * * *"
+
+	// idempotencyLease is the expiry of in-progress keys. If a request doesn't
+	// finish in time (eg: the app crashed), its key can be reused. Keys are
+	// extended to app.idempotency_ttl once their response is stored.
+	idempotencyLease = time.Minute * 5
+
+	idempotencyMaxKeyLen = 255
+)
+
+// idempotent is a middleware for POST handlers that makes requests with an
+// Idempotency-Key header idempotent. The first successful response for a key
+// is stored in the DB and returned for repeated requests with the same key and
+// body until the key expires (app.idempotency_ttl). A repeated request with a
+// different body, or while the first request is in progress, is a conflict.
+// Failed requests aren't stored so that they can be retried with the same key.
+// In-progress keys expire after a short lease (idempotencyLease).
+func (a *App) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := strings.TrimSpace(c.Request().Header.Get(hdrIdempotencyKey))
+		if key == "" || a.cfg.IdempotencyTTL <= 0 {
+			return next(c)
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", hdrIdempotencyKey))
+		}
+
+		// Read the request body and restore it for the handler.
+		req := c.Request()
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to the user and the route.
+		var (
+			user    = auth.GetUser(c)
+			k       = fmt.Sprintf("%d:%s %s:%s", user.ID, req.Method, c.Path(), key)
+			reqHash = hashIdempotentBody(body, req.Header.Get(echo.HeaderContentType))
+		)
+
+		ok, err := a.core.CreateIdempotencyKey(k, reqHash, idempotencyLease)
+		if err != nil {
+			return err
+		}
+
+		// The key exists. Replay its response.
+		if !ok {
+			r, err := a.core.GetIdempotencyKey(k)
+			if err != nil {
+				return err
+			}
+
+			if r.RequestHash != reqHash {
+				return echo.NewHTTPError(http.StatusConflict, a.i18n.T("globals.messages.idempotencyMismatch"))
+			}
+			if r.StatusCode == 0 {
+				return echo.NewHTTPError(http.StatusConflict, a.i18n.T("globals.messages.idempotencyInProgress"))
+			}
+
+			c.Response().Header().Set(hdrIdempotencyReplayed, "true")
+			return c.Blob(r.StatusCode, r.ContentType, r.Response)
+		}
+
+		// Capture the response to store it.
+		rw := &idempotentRespWriter{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rw
+
+		// Don't leave the key in progress if the handler panics.
+		defer func() {
+			if r := recover(); r != nil {
+				_ = a.core.DeleteIdempotencyKey(k)
+				panic(r)
+			}
+		}()
+
+		if err := next(c); err != nil || c.Response().Status >= http.StatusMultipleChoices {
+			_ = a.core.DeleteIdempotencyKey(k)
+			return err
+		}
+
+		if err := a.core.UpdateIdempotencyKey(k, c.Response().Status,
+			c.Response().Header().Get(echo.HeaderContentType), rw.buf.Bytes(), a.cfg.IdempotencyTTL); err != nil {
+			// Don't leave the key in progress.
+			_ = a.core.DeleteIdempotencyKey(k)
+		}
+
+		return nil
+	}
+}
+
+// idempotentRespWriter captures the response body of a request with an idempotency key.
+type idempotentRespWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *idempotentRespWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// hashIdempotentBody returns the SHA256 hash of a request body. The boundary
+// of multipart bodies, which clients generate randomly on every request,
+// is stripped so that retries with the same content have the same hash.
+func hashIdempotentBody(body []byte, contentType string) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["boundary"] != "" {
+		body = bytes.ReplaceAll(body, []byte(params["boundary"]), nil)
+	}
+
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
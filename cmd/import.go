@@ -5,21 +5,26 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"strings"
 
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// maxPresetFiles is the maximum number of CSV files imported from
+	// a ZIP file exported from an ESP.
+	maxPresetFiles = 10
+
+	// importCronPurge is the daily cron schedule for purging import jobs past the retention period.
+	importCronPurge = "45 3 * * *"
+)
+
 // ImportSubscribers handles the uploading and bulk importing of
-// a ZIP file of one or more CSV files.
+// a CSV, JSON, or NDJSON file, or a ZIP file with one of them.
+// The import is queued as a job that runs after the jobs before it.
 func (a *App) ImportSubscribers(c echo.Context) error {
-	// Is an import already running?
-	if a.importer.GetStats().Status == subimporter.StatusImporting {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.alreadyRunning"))
-	}
-
 	// Unmarshal the JSON params.
 	var opt subimporter.SessionOpt
 	if err := json.Unmarshal([]byte(c.FormValue("params")), &opt); err != nil {
@@ -48,8 +53,35 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidSubStatus"))
 	}
 
-	if len(opt.Delim) != 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidDelim"))
+	// Validate the attribute merge strategy and column mapping.
+	switch opt.AttribsMerge {
+	case "", subimporter.AttribsReplace, subimporter.AttribsMerge, subimporter.AttribsDeepMerge:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "attribs_merge"))
+	}
+	if err := subimporter.ValidateColumns(opt.Columns); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidParams", "error", err.Error()))
+	}
+
+	// Validate the ESP preset. Presets have their own column mappings.
+	if opt.Preset != "" {
+		if !subimporter.IsPreset(opt.Preset) {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "preset"))
+		}
+		opt.Columns = nil
+	}
+
+	// Creating lists for tags requires the permission to manage lists.
+	user := auth.GetUser(c)
+	if opt.CreateLists && !user.HasPerm(auth.PermListManageAll) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermListManageAll))
+	}
+
+	// Check if the user has manage permission for the lists being imported into.
+	if err := user.HasListPerm(auth.PermTypeManage, opt.ListIDs...); err != nil {
+		return err
 	}
 
 	// Open the HTTP file.
@@ -59,6 +91,17 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			a.i18n.Ts("import.invalidFile", "error", err.Error()))
 	}
 
+	// The delimiter is only relevant to CSV files (and ZIPs that may contain them).
+	if !subimporter.IsJSON(file.Filename) && len(opt.Delim) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidDelim"))
+	}
+
+	// ESP exports are CSV files.
+	if opt.Preset != "" && subimporter.IsJSON(file.Filename) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", "preset imports require CSV files"))
+	}
+
 	src, err := file.Open()
 	if err != nil {
 		return err
@@ -78,19 +121,50 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
 	}
 
-	// Start the importer session.
+	// Create the importer session (job).
 	opt.Filename = file.Filename
-	sess, err := a.importer.NewSession(opt)
+	// Lists in the file (per-record lists and ESP tags) are
+	// filtered by the user's list permissions.
+	sess, err := a.importer.NewSession(opt, user.ID, func(listIDs []int) []int {
+		return user.FilterListsByPerm(auth.PermTypeManage, listIDs)
+	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			a.i18n.Ts("import.errorStarting", "error", err.Error()))
 	}
-	go sess.Start()
 
-	if strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
-		go sess.LoadCSV(out.Name(), rune(opt.Delim[0]))
-	} else {
-		// Only 1 CSV from the ZIP is considered. If multiple files have
+	// ESP exports may be ZIPs with multiple CSV files, eg: Mailchimp's subscribed,
+	// unsubscribed, and cleaned members, all of which are imported.
+	if opt.Preset != "" {
+		paths := []string{out.Name()}
+		if !subimporter.IsImportable(file.Filename) {
+			dir, files, err := sess.ExtractZIP(out.Name(), maxPresetFiles)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError,
+					a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
+			}
+
+			paths = paths[:0]
+			for _, f := range files {
+				if !subimporter.IsJSON(f) {
+					paths = append(paths, dir+"/"+f)
+				}
+			}
+		}
+
+		if err := sess.Queue(func() error {
+			return sess.LoadPreset(paths, rune(opt.Delim[0]))
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("import.errorStarting", "error", err.Error()))
+		}
+
+		return c.JSON(http.StatusOK, okResp{sess.GetStats()})
+	}
+
+	fName, fPath := file.Filename, out.Name()
+	if !subimporter.IsImportable(fName) {
+		// Only 1 file from the ZIP is considered. If multiple files have
 		// to be processed, counting the net number of lines (to track progress),
 		// keeping the global import state (failed / successful) etc. across
 		// multiple files becomes complex. Instead, it's just easier for the
@@ -101,11 +175,24 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError,
 				a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
 		}
+		fName, fPath = files[0], dir+"/"+files[0]
+	}
 
-		go sess.LoadCSV(dir+"/"+files[0], rune(opt.Delim[0]))
+	// Queue the session.
+	load := func() error {
+		return sess.LoadCSV(fPath, rune(opt.Delim[0]))
+	}
+	if subimporter.IsJSON(fName) {
+		load = func() error {
+			return sess.LoadJSON(fPath)
+		}
+	}
+	if err := sess.Queue(load); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorStarting", "error", err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
+	return c.JSON(http.StatusOK, okResp{sess.GetStats()})
 }
 
 // GetImportSubscribers returns import statistics.
@@ -119,6 +206,18 @@ func (a *App) GetImportSubscriberStats(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{string(a.importer.GetLogs())})
 }
 
+// GetImportRejects handles the download of the file with the records
+// rejected in the last import.
+func (a *App) GetImportRejects(c echo.Context) error {
+	path, name := a.importer.GetRejectsFile()
+	if path == "" {
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "{import.rejects}"))
+	}
+
+	return c.Attachment(path, name)
+}
+
 // StopImportSubscribers sends a stop signal to the importer.
 // If there's an ongoing import, it'll be stopped, and if an import
 // is finished, it's state is cleared.
@@ -126,3 +225,101 @@ func (a *App) StopImportSubscribers(c echo.Context) error {
 	a.importer.Stop()
 	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
 }
+
+// GetImportJobs returns the paginated history of import jobs.
+func (a *App) GetImportJobs(c echo.Context) error {
+	pg := a.pg.NewFromURL(c.Request().URL.Query())
+
+	res, total, err := a.core.QueryImportJobs(c.QueryParam("status"), pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// Overlay the live stats of running jobs.
+	for n, j := range res {
+		if s := a.importer.GetSession(j.ID); s != nil {
+			res[n] = overlayImportStats(j, s.GetStats())
+		}
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetImportJob returns an import job with its logs.
+func (a *App) GetImportJob(c echo.Context) error {
+	id := getID(c)
+
+	out, err := a.core.GetImportJob(id)
+	if err != nil {
+		return err
+	}
+
+	// Overlay the live stats and logs of a running job.
+	if s := a.importer.GetSession(id); s != nil {
+		out = overlayImportStats(out, s.GetStats())
+		out.Log = string(s.GetLogs())
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetImportJobRejects handles the download of the file with the records
+// rejected in an import job.
+func (a *App) GetImportJobRejects(c echo.Context) error {
+	id := getID(c)
+
+	job, err := a.core.GetImportJob(id)
+	if err != nil {
+		return err
+	}
+
+	path := job.RejectsFile
+	if s := a.importer.GetSession(id); s != nil {
+		path, _ = s.GetRejectsFile()
+	}
+
+	if path == "" {
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "{import.rejects}"))
+	}
+	if _, err := os.Stat(path); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "{import.rejects}"))
+	}
+
+	return c.Attachment(path, subimporter.RejectsFilename(job.Name, path))
+}
+
+// StopImportJob stops a running import job or cancels a queued one.
+func (a *App) StopImportJob(c echo.Context) error {
+	id := getID(c)
+
+	s := a.importer.GetSession(id)
+	if s == nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.importJob}"))
+	}
+	s.Stop()
+
+	return c.JSON(http.StatusOK, okResp{s.GetStats()})
+}
+
+// overlayImportStats overlays the live stats of an import session on its job record.
+func overlayImportStats(j models.ImportJob, s subimporter.Status) models.ImportJob {
+	j.Status = s.Status
+	j.Total = s.Total
+	j.Imported = s.Imported
+	j.HasRejects = s.HasRejects
+	if b, err := json.Marshal(s.Report); err == nil {
+		j.Report = b
+	}
+
+	return j
+}
@@ -13,6 +13,7 @@ import (
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -41,10 +42,16 @@ import (
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/media/images"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
+	"github.com/knadh/listmonk/internal/media/providers/sftp"
+	"github.com/knadh/listmonk/internal/media/providers/webdav"
+	"github.com/knadh/listmonk/internal/messenger/chat"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/plugin"
 	"github.com/knadh/listmonk/internal/messenger/postback"
+	"github.com/knadh/listmonk/internal/messenger/webpush"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
@@ -60,6 +67,12 @@ const (
 	queryFilePath = "/queries"
 
 	emailMsgr = "email"
+
+	// Types of messengers in settings. Messengers without
+	// a type are HTTP postback messengers.
+	msgrTypePostback = "postback"
+	msgrTypePlugin   = "plugin"
+	msgrTypeChat     = "chat"
 )
 
 // UrlConfig contains various URL constants used in the app.
@@ -72,8 +85,11 @@ type UrlConfig struct {
 	LinkTrackURL string
 	ViewTrackURL string
 	OptinURL     string
-	MessageURL   string
-	ArchiveURL   string
+
+	TxLinkTrackURL string
+	TxViewTrackURL string
+	MessageURL     string
+	ArchiveURL     string
 }
 
 // Config contains static, constant config values required by arbitrary handlers and functions.
@@ -136,8 +152,13 @@ type Config struct {
 	AssetVersion  string
 
 	MediaUpload struct {
-		Provider   string
-		Extensions []string
+		Provider    string
+		Extensions  []string
+		Deduplicate bool
+
+		// Storage quotas in bytes. 0 is unlimited.
+		Quota     int64
+		UserQuota int64
 	}
 
 	BounceWebhooksEnabled     bool
@@ -146,6 +167,12 @@ type Config struct {
 	BouncePostmarkEnabled     bool
 	BounceForwardemailEnabled bool
 
+	WebPushEnabled   bool
+	WebPushPublicKey string
+
+	// Duration for which the responses of API requests with idempotency keys are stored.
+	IdempotencyTTL time.Duration `koanf:"-"`
+
 	PermissionsRaw json.RawMessage
 	Permissions    map[string]struct{}
 }
@@ -451,6 +478,12 @@ func initUrlConfig(ko *koanf.Koanf) *UrlConfig {
 
 		// url.com/campaign/{campaign_uuid}/{subscriber_uuid}/px.png
 		ViewTrackURL: fmt.Sprintf("%s/campaign/%%s/%%s/px.png", root),
+
+		// url.com/tx/link/{link_uuid}/{tx_message_uuid}
+		TxLinkTrackURL: fmt.Sprintf("%s/tx/link/%%s/%%s", root),
+
+		// url.com/tx/{tx_message_uuid}/px.png
+		TxViewTrackURL: fmt.Sprintf("%s/tx/%%s/px.png", root),
 	}
 }
 
@@ -476,14 +509,21 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	c.Privacy.Exportable = koanfmaps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
 	c.MediaUpload.Provider = ko.String("upload.provider")
 	c.MediaUpload.Extensions = ko.Strings("upload.extensions")
+	c.MediaUpload.Deduplicate = ko.Bool("upload.deduplicate")
+	c.MediaUpload.Quota = ko.Int64("upload.quota") * 1024 * 1024
+	c.MediaUpload.UserQuota = ko.Int64("upload.user_quota") * 1024 * 1024
 	c.Privacy.DomainBlocklist = ko.Strings("privacy.domain_blocklist")
 	c.Privacy.DomainAllowlist = ko.Strings("privacy.domain_allowlist")
 
+	c.IdempotencyTTL = ko.Duration("app.idempotency_ttl")
+
 	c.BounceWebhooksEnabled = ko.Bool("bounce.webhooks_enabled")
 	c.BounceSESEnabled = ko.Bool("bounce.ses_enabled")
 	c.BounceSendgridEnabled = ko.Bool("bounce.sendgrid_enabled")
 	c.BouncePostmarkEnabled = ko.Bool("bounce.postmark.enabled")
 	c.BounceForwardemailEnabled = ko.Bool("bounce.forwardemail.enabled")
+	c.WebPushEnabled = ko.Bool("webpush.enabled")
+	c.WebPushPublicKey = ko.String("webpush.vapid_public_key")
 	c.HasLegacyUser = ko.Exists("app.admin_username") || ko.Exists("app.admin_password")
 
 	b := md5.Sum([]byte(time.Now().String()))
@@ -554,8 +594,13 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 	})
 }
 
+// initTxCallbacks initializes the poster of async tx message callbacks.
+func initTxCallbacks(ko *koanf.Koanf) *txCallbacks {
+	return newTxCallbacks(ko.String("app.tx_callback_secret"), ko.Strings("app.tx_callback_allowed_hosts"))
+}
+
 // initCampaignManager initializes the campaign manager.
-func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlConfig, co *core.Core, md media.Store, i *i18n.I18n, ko *koanf.Koanf) *manager.Manager {
+func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlConfig, co *core.Core, md media.Store, cb *txCallbacks, i *i18n.I18n, ko *koanf.Koanf) *manager.Manager {
 	if ko.Bool("passive") {
 		lo.Println("running in passive mode. won't process campaigns.")
 	}
@@ -571,6 +616,8 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 		OptinURL:              u.OptinURL,
 		LinkTrackURL:          u.LinkTrackURL,
 		ViewTrackURL:          u.ViewTrackURL,
+		TxLinkTrackURL:        u.TxLinkTrackURL,
+		TxViewTrackURL:        u.TxViewTrackURL,
 		MessageURL:            u.MessageURL,
 		ArchiveURL:            u.ArchiveURL,
 		RootURL:               u.RootURL,
@@ -580,7 +627,7 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 		SlidingWindowRate:     ko.Int("app.message_sliding_window_rate"),
 		ScanInterval:          time.Second * 5,
 		ScanCampaigns:         !ko.Bool("passive"),
-	}, newManagerStore(q, co, md), i, lo)
+	}, newManagerStore(q, co, md, cb), i, lo)
 
 	// Attach all messengers to the campaign manager.
 	for _, m := range msgrs {
@@ -599,7 +646,7 @@ func initTxTemplates(m *manager.Manager, co *core.Core) {
 
 	for _, t := range tpls {
 		tpl := t
-		if err := tpl.Compile(m.GenericTemplateFuncs()); err != nil {
+		if err := tpl.Compile(m.TxTemplateFuncs()); err != nil {
 			lo.Printf("error compiling transactional template %d: %v", tpl.ID, err)
 			continue
 		}
@@ -611,11 +658,52 @@ func initTxTemplates(m *manager.Manager, co *core.Core) {
 func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n, ko *koanf.Koanf) *subimporter.Importer {
 	return subimporter.New(
 		subimporter.Options{
-			DomainBlocklist:    ko.Strings("privacy.domain_blocklist"),
-			DomainAllowlist:    ko.Strings("privacy.domain_allowlist"),
-			UpsertStmt:         q.UpsertSubscriber.Stmt,
-			BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
-			UpdateListDateStmt: q.UpdateListsDate.Stmt,
+			DomainBlocklist:       ko.Strings("privacy.domain_blocklist"),
+			DomainAllowlist:       ko.Strings("privacy.domain_allowlist"),
+			UpsertStmt:            q.UpsertSubscriber.Stmt,
+			BlocklistStmt:         q.UpsertBlocklistSubscriber.Stmt,
+			UpdateListDateStmt:    q.UpdateListsDate.Stmt,
+			GetEmailsStmt:         q.GetSubscriberEmails.Stmt,
+			CreateJobStmt:         q.CreateImportJob.Stmt,
+			UpdateJobStmt:         q.UpdateImportJob.Stmt,
+			TouchJobsStmt:         q.TouchImportJobs.Stmt,
+			FailJobsStmt:          q.FailInterruptedImportJobs.Stmt,
+			CreateStagingQuery:    q.CreateImportStaging,
+			UpsertStagingQuery:    q.UpsertImportStaging,
+			BlocklistStagingQuery: q.BlocklistImportStaging,
+			Concurrency:           ko.Int("app.import_concurrency"),
+
+			// Map tags in ESP exports to lists by their names, optionally creating them.
+			ListIDsCB: func(names []string, create bool) (map[string]int, error) {
+				lists, err := core.GetListsByNames(names)
+				if err != nil {
+					return nil, err
+				}
+
+				out := make(map[string]int, len(names))
+				for _, l := range lists {
+					if _, ok := out[strings.ToLower(l.Name)]; !ok {
+						out[strings.ToLower(l.Name)] = l.ID
+					}
+				}
+
+				if !create {
+					return out, nil
+				}
+				for _, name := range names {
+					if _, ok := out[strings.ToLower(name)]; ok {
+						continue
+					}
+
+					l, err := core.CreateList(models.List{Name: name, Tags: []string{"import"}})
+					if err != nil {
+						return nil, err
+					}
+					out[strings.ToLower(name)] = l.ID
+				}
+
+				return out, nil
+			},
 
 			// Hook for triggering admin notifications and refreshing stats materialized
 			// views after a successful import.
@@ -637,6 +725,16 @@ func initSMTPMessengers() []manager.Messenger {
 		out     = []manager.Messenger{}
 	)
 
+	// Load the keys for signing and encrypting e-mails.
+	var secOpt email.SecurityOpt
+	if err := ko.UnmarshalWithConf("security.email_crypto", &secOpt, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading e-mail security config: %v", err)
+	}
+	sec, err := email.NewSecurity(secOpt)
+	if err != nil {
+		lo.Fatalf("error initializing e-mail security: %v", err)
+	}
+
 	// Load the config for multiple SMTP servers.
 	for _, item := range ko.Slices("smtp") {
 		if !item.Bool("enabled") {
@@ -655,7 +753,7 @@ func initSMTPMessengers() []manager.Messenger {
 		// If the server has a name, initialize it as a standalone e-mail messenger
 		// allowing campaigns to select individual SMTPs. In the UI and config, it'll appear as `email / $name`.
 		if s.Name != "" {
-			msgr, err := email.New(s.Name, s)
+			msgr, err := email.New(s.Name, sec, s)
 			if err != nil {
 				lo.Fatalf("error initializing e-mail messenger: %v", err)
 			}
@@ -664,7 +762,7 @@ func initSMTPMessengers() []manager.Messenger {
 	}
 
 	// Initialize the 'email' messenger with all SMTP servers.
-	msgr, err := email.New(email.MessengerName, servers...)
+	msgr, err := email.New(email.MessengerName, sec, servers...)
 	if err != nil {
 		lo.Fatalf("error initializing e-mail messenger: %v", err)
 	}
@@ -690,7 +788,7 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 
 	var out []manager.Messenger
 	for _, item := range items {
-		if !item.Bool("enabled") {
+		if t := item.String("type"); !item.Bool("enabled") || (t != "" && t != msgrTypePostback) {
 			continue
 		}
 
@@ -704,7 +802,7 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 		}
 
 		// Initialize the Messenger.
-		p, err := postback.New(o)
+		p, err := postback.New(o, lo)
 		if err != nil {
 			lo.Fatalf("error initializing Postback messenger %s: %v", name, err)
 		}
@@ -716,6 +814,119 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 	return out
 }
 
+// initPluginMessengers initializes and returns all the enabled
+// out-of-process plugin messengers.
+func initPluginMessengers(ko *koanf.Koanf) []manager.Messenger {
+	items := ko.Slices("messengers")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []manager.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") || item.String("type") != msgrTypePlugin {
+			continue
+		}
+
+		// Read the plugin config.
+		var (
+			name = item.String("name")
+			o    plugin.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading plugin messenger config: %v", err)
+		}
+		o.Dir = ko.String("app.messenger_plugins_dir")
+
+		// Initialize the Messenger.
+		p, err := plugin.New(o, lo)
+		if err != nil {
+			lo.Fatalf("error initializing plugin messenger %s: %v", name, err)
+		}
+		out = append(out, p)
+
+		lo.Printf("loaded plugin messenger: %s", name)
+	}
+
+	return out
+}
+
+// initChatMessengers initializes and returns all the enabled
+// chat platform (Slack, Matrix, Telegram) messengers.
+func initChatMessengers(ko *koanf.Koanf) []manager.Messenger {
+	items := ko.Slices("messengers")
+	if len(items) == 0 {
+		return nil
+	}
+
+	var out []manager.Messenger
+	for _, item := range items {
+		if !item.Bool("enabled") || item.String("type") != msgrTypeChat {
+			continue
+		}
+
+		// Read the chat messenger config.
+		var (
+			name = item.String("name")
+			o    chat.Options
+		)
+		if err := item.UnmarshalWithConf("", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading chat messenger config: %v", err)
+		}
+
+		// Initialize the Messenger.
+		c, err := chat.New(o, lo)
+		if err != nil {
+			lo.Fatalf("error initializing chat messenger %s: %v", name, err)
+		}
+		out = append(out, c)
+
+		lo.Printf("loaded chat messenger (%s): %s", o.Platform, name)
+	}
+
+	return out
+}
+
+// initWebPushMessenger initializes and returns the web push messenger if it's enabled.
+func initWebPushMessenger(co *core.Core, u *UrlConfig, ko *koanf.Koanf) []manager.Messenger {
+	if !ko.Bool("webpush.enabled") {
+		return nil
+	}
+
+	w, err := webpush.New(webpush.Options{
+		Subject:    ko.String("webpush.subject"),
+		PublicKey:  ko.String("webpush.vapid_public_key"),
+		PrivateKey: ko.String("webpush.vapid_private_key"),
+		MessageURL: u.MessageURL,
+		RootURL:    u.RootURL,
+		IconURL:    u.LogoURL,
+		MaxConns:   10,
+		Timeout:    time.Second * 10,
+	}, co, lo)
+	if err != nil {
+		lo.Fatalf("error initializing web push messenger: %v", err)
+	}
+
+	lo.Printf("loaded web push messenger")
+	return []manager.Messenger{w}
+}
+
+// initImageProcessor initializes the processor for uploaded images.
+func initImageProcessor(ko *koanf.Koanf) *images.Processor {
+	o := images.Options{
+		MaxWidth:  ko.Int("upload.image.max_width"),
+		MaxHeight: ko.Int("upload.image.max_height"),
+		Quality:   ko.Int("upload.image.quality"),
+		StripEXIF: ko.Bool("upload.image.strip_exif"),
+		Formats:   ko.Strings("upload.image.formats"),
+	}
+	if err := ko.UnmarshalWithConf("upload.image.renditions", &o.Renditions, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading image renditions config: %v", err)
+	}
+
+	return images.New(o, lo)
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore(ko *koanf.Koanf) media.Store {
 	switch provider := ko.String("upload.provider"); provider {
@@ -730,6 +941,28 @@ func initMediaStore(ko *koanf.Koanf) media.Store {
 		lo.Println("media upload provider: s3")
 		return up
 
+	case "webdav":
+		var o webdav.Opt
+		ko.Unmarshal("upload.webdav", &o)
+
+		up, err := webdav.New(o)
+		if err != nil {
+			lo.Fatalf("error initializing webdav upload provider %s", err)
+		}
+		lo.Println("media upload provider: webdav")
+		return up
+
+	case "sftp":
+		var o sftp.Opt
+		ko.Unmarshal("upload.sftp", &o)
+
+		up, err := sftp.New(o, lo)
+		if err != nil {
+			lo.Fatalf("error initializing sftp upload provider %s", err)
+		}
+		lo.Println("media upload provider: sftp")
+		return up
+
 	case "filesystem":
 		var o filesystem.Opts
 
@@ -745,7 +978,7 @@ func initMediaStore(ko *koanf.Koanf) media.Store {
 		return up
 
 	default:
-		lo.Fatalf("unknown provider. select filesystem or s3")
+		lo.Fatalf("unknown provider. select filesystem, s3, webdav, or sftp")
 	}
 	return nil
 }
@@ -913,7 +1146,17 @@ func initHTTPServer(cfg *Config, urlCfg *UrlConfig, i *i18n.I18n, fs stuffbin.Fi
 
 	// Public (subscriber) facing media upload files.
 	if ko.String("upload.provider") == "filesystem" && ko.String("upload.filesystem.upload_uri") != "" {
-		srv.Static(ko.String("upload.filesystem.upload_uri"), ko.String("upload.filesystem.upload_path"))
+		// Files written by scheduled exports are only served via the authenticated API.
+		g := srv.Group(ko.String("upload.filesystem.upload_uri"), func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				p, err := url.PathUnescape(c.Request().URL.Path)
+				if err != nil || strings.HasPrefix(path.Base(p), exportFilePrefix) {
+					return echo.NewHTTPError(http.StatusNotFound)
+				}
+				return next(c)
+			}
+		})
+		g.Static("/", ko.String("upload.filesystem.upload_path"))
 	}
 
 	// Register all HTTP handlers.
@@ -943,8 +1186,9 @@ func initCaptcha() *captcha.Captcha {
 	return captcha.New(opt)
 }
 
-// initCron initializes cron jobs for slow query cache refresh and database vacuum.
-func initCron(co *core.Core, db *sqlx.DB) {
+// initCron initializes cron jobs for slow query cache refresh, database vacuum, digest reports,
+// and scheduled exports.
+func initCron(co *core.Core, db *sqlx.DB, i *i18n.I18n, store media.Store) {
 	c := cron.New(cron.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
 
 	// Slow query cache cron job.
@@ -983,6 +1227,126 @@ func initCron(co *core.Core, db *sqlx.DB) {
 		}
 	}
 
+	// Admin digest report cron job.
+	if ko.Bool("app.digest_report.enabled") {
+		var (
+			freq   = ko.String("app.digest_report.frequency")
+			intval = digestCronWeekly
+		)
+		if freq == models.DigestFrequencyMonthly {
+			intval = digestCronMonthly
+		}
+
+		_, err := c.Add(intval, func() {
+			sendDigestReport(co, i, freq, ko.Ints("app.digest_report.user_ids"), ko.Bool("privacy.individual_tracking"))
+		})
+		if err != nil {
+			lo.Printf("error initializing digest report cron: %v", err)
+		} else {
+			lo.Printf("%s digest report cron enabled", freq)
+		}
+	}
+
+	// Scheduled subscriber exports to the media store.
+	for _, item := range ko.Slices("app.scheduled_exports") {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		var e models.ScheduledExport
+		if err := item.UnmarshalWithConf("", &e, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Printf("error reading scheduled export config: %v", err)
+			continue
+		}
+
+		_, err := c.Add(e.Cron, func() {
+			// The scheduled time of the run that's claimed by one of the instances.
+			runAt := time.Now().Truncate(time.Minute)
+
+			name, err := runScheduledExport(e, runAt, co, store, ko.Int("app.batch_size"))
+			if err != nil {
+				lo.Printf("error running scheduled export '%s': %v", e.Name, err)
+				return
+			}
+			if name == "" {
+				lo.Printf("scheduled export '%s' was run by another instance", e.Name)
+				return
+			}
+			lo.Printf("scheduled export '%s' written to '%s'", e.Name, name)
+		})
+		if err != nil {
+			lo.Printf("error initializing scheduled export '%s' cron: %v", e.Name, err)
+		} else {
+			lo.Printf("scheduled export '%s' enabled at interval: %s", e.Name, e.Cron)
+		}
+	}
+
+	// Audit log retention cron job.
+	if days := ko.Int("security.audit_retention_days"); days > 0 {
+		_, err := c.Add(auditCronPurge, func() {
+			n, err := co.DeleteAuditLogs(days)
+			if err != nil {
+				return
+			}
+			lo.Printf("deleted %d audit log entries older than %d days", n, days)
+		})
+		if err != nil {
+			lo.Printf("error initializing audit log retention cron: %v", err)
+		}
+	}
+
+	// Transactional message log retention cron job.
+	if days := ko.Int("privacy.tx_log_retention_days"); days > 0 {
+		_, err := c.Add(txLogCronPurge, func() {
+			n, err := co.DeleteTxMessages(days)
+			if err != nil {
+				return
+			}
+			lo.Printf("deleted %d transactional message log entries older than %d days", n, days)
+		})
+		if err != nil {
+			lo.Printf("error initializing tx message log retention cron: %v", err)
+		}
+	}
+
+	// Import job retention cron job. The rejects files of deleted jobs are also removed.
+	if days := ko.Int("privacy.import_retention_days"); days > 0 {
+		_, err := c.Add(importCronPurge, func() {
+			files, err := co.DeleteImportJobs(days)
+			if err != nil {
+				return
+			}
+			for _, f := range files {
+				if f == "" {
+					continue
+				}
+				if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+					lo.Printf("error removing import rejects file: %v", err)
+				}
+			}
+			lo.Printf("deleted %d import jobs older than %d days", len(files), days)
+		})
+		if err != nil {
+			lo.Printf("error initializing import job retention cron: %v", err)
+		}
+	}
+
+	// Expired idempotency keys cleanup cron job.
+	if ko.Duration("app.idempotency_ttl") > 0 {
+		_, err := c.Add(idempotencyCronPurge, func() {
+			n, err := co.DeleteExpiredIdempotencyKeys()
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				lo.Printf("deleted %d expired idempotency keys", n)
+			}
+		})
+		if err != nil {
+			lo.Printf("error initializing idempotency key cleanup cron: %v", err)
+		}
+	}
+
 	if len(c.Entries()) > 0 {
 		c.Start()
 	}
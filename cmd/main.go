@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,6 +25,7 @@ import (
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/media/images"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
@@ -45,6 +47,7 @@ type App struct {
 	importer   *subimporter.Importer
 	auth       *auth.Auth
 	media      media.Store
+	imgProc    *images.Processor
 	bounce     *bounce.Manager
 	captcha    *captcha.Captcha
 	i18n       *i18n.I18n
@@ -59,6 +62,12 @@ type App struct {
 	// Channel for passing reload signals.
 	chReload chan os.Signal
 
+	// Channel for waking up the async tx message queue worker.
+	chTxQueue chan bool
+
+	// Poster of async tx message callbacks.
+	txCallbacks *txCallbacks
+
 	// Global variable that stores the state indicating that a restart is required
 	// after a settings update.
 	needsRestart bool
@@ -195,11 +204,15 @@ func main() {
 		// Crud core.
 		core = initCore(fbOptinNotify, queries, db, i18n, ko)
 
-		// Initialize all messengers, SMTP and postback.
-		msgrs = append(initSMTPMessengers(), initPostbackMessengers(ko)...)
+		// Initialize all messengers, SMTP, postback, plugins, chat, and web push.
+		msgrs = slices.Concat(initSMTPMessengers(), initPostbackMessengers(ko),
+			initPluginMessengers(ko), initChatMessengers(ko), initWebPushMessenger(core, urlCfg, ko))
+
+		// Poster of async tx message callbacks.
+		txCB = initTxCallbacks(ko)
 
 		// Campaign manager.
-		mgr = initCampaignManager(msgrs, queries, urlCfg, core, media, i18n, ko)
+		mgr = initCampaignManager(msgrs, queries, urlCfg, core, media, txCB, i18n, ko)
 
 		// Bulk importer.
 		importer = initImporter(queries, db, core, i18n, ko)
@@ -241,7 +254,7 @@ func main() {
 	}
 
 	// Start cronjobs.
-	initCron(core, db)
+	initCron(core, db, i18n, media)
 
 	// Start the campaign manager workers. The campaign batches (fetch from DB, push out
 	// messages) get processed at the specified interval.
@@ -262,6 +275,7 @@ func main() {
 		importer:   importer,
 		auth:       auth,
 		media:      media,
+		imgProc:    initImageProcessor(ko),
 		bounce:     bounce,
 		captcha:    initCaptcha(),
 		i18n:       i18n,
@@ -281,11 +295,16 @@ func main() {
 		fnOptinNotify: fbOptinNotify,
 		about:         initAbout(queries, db),
 		chReload:      chReload,
+		chTxQueue:     make(chan bool, 1),
+		txCallbacks:   txCB,
 
 		// If there are no users, then the app needs to prompt for new user setup.
 		needsUserSetup: !hasUsers,
 	}
 
+	// Start the async tx message queue worker.
+	go app.runTxQueue()
+
 	// Star the update checker.
 	if ko.Bool("app.check_updates") {
 		go app.checkUpdates(versionString, time.Hour*24)
@@ -15,6 +15,7 @@ type store struct {
 	queries *models.Queries
 	core    *core.Core
 	media   media.Store
+	txCB    *txCallbacks
 }
 
 type runningCamp struct {
@@ -25,11 +26,12 @@ type runningCamp struct {
 	ListID           int    `db:"list_id"`
 }
 
-func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
+func newManagerStore(q *models.Queries, c *core.Core, m media.Store, cb *txCallbacks) *store {
 	return &store{
 		queries: q,
 		core:    c,
 		media:   m,
+		txCB:    cb,
 	}
 }
 
@@ -87,7 +89,16 @@ func (s *store) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID
 
 // GetAttachment fetches a media attachment blob.
 func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
-	m, err := s.core.GetMedia(mediaID, "", "", s.media)
+	return s.getAttachment(mediaID, "")
+}
+
+// GetAttachmentByName fetches a media attachment blob by its filename.
+func (s *store) GetAttachmentByName(filename string) (models.Attachment, error) {
+	return s.getAttachment(0, filename)
+}
+
+func (s *store) getAttachment(mediaID int, filename string) (models.Attachment, error) {
+	m, err := s.core.GetMedia(mediaID, "", filename, s.media)
 	if err != nil {
 		return models.Attachment{}, err
 	}
@@ -150,3 +161,46 @@ func (s *store) DeleteSubscriber(id int64) error {
 	_, err := s.queries.DeleteSubscribers.Exec(pq.Int64Array{id})
 	return err
 }
+
+// UpdateTxMessage records the delivery status and the rendered subject of a tx
+// message and POSTs it to the message's callback URL, if there's one.
+func (s *store) UpdateTxMessage(uuid, subject string, sendErr error) error {
+	status, errMsg := models.TxStatusSent, ""
+	if sendErr != nil {
+		status, errMsg = models.TxStatusFailed, sendErr.Error()
+	}
+
+	m, err := s.core.UpdateTxMessage(uuid, status, errMsg, subject)
+	if err != nil {
+		return err
+	}
+
+	if m.CallbackURL != "" {
+		go s.txCB.post(m)
+	}
+
+	return nil
+}
+
+// GetMediaURL returns the URL of a media file, or of one of its renditions
+// and alternative formats. If the rendition or the format doesn't exist
+// (eg: for images uploaded before it was configured), the original's URL is returned.
+func (s *store) GetMediaURL(filename, rendition, format string) (string, error) {
+	m, err := s.core.GetMedia(0, "", filename, s.media)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		url     = m.URL
+		formats = m.Formats
+	)
+	if r, ok := m.Renditions[rendition]; ok {
+		url, formats = r.URL, r.Formats
+	}
+	if u, ok := formats[format]; ok {
+		url = u
+	}
+
+	return url, nil
+}
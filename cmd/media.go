@@ -2,19 +2,26 @@ package main
 
 import (
 	"bytes"
-	"mime/multipart"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/disintegration/imaging"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/media/images"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 const (
-	thumbPrefix   = "thumb_"
-	thumbnailSize = 250
+	thumbPrefix = "thumb_"
 )
 
 var (
@@ -52,9 +59,45 @@ func (a *App) UploadMedia(c echo.Context) error {
 		}
 	}
 
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+	}
+
 	// Sanitize the filename.
 	fName := makeFilename(file.Filename)
 
+	// If the same content has already been uploaded, return the existing media item,
+	// recording the new filename as an alias of it.
+	var (
+		sum  = sha256.Sum256(b)
+		hash = hex.EncodeToString(sum[:])
+	)
+	if a.cfg.MediaUpload.Deduplicate {
+		m, err := a.core.GetMediaByHash(a.cfg.MediaUpload.Provider, hash, a.media)
+		if err == nil {
+			if err := a.core.AddMediaAlias(m.ID, fName); err != nil {
+				return err
+			}
+
+			out, err := a.core.GetMedia(m.ID, "", "", a.media)
+			if err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusOK, okResp{out})
+		} else if err != core.ErrNotFound {
+			return err
+		}
+	}
+
+	// Check the storage quotas before uploading.
+	user := auth.GetUser(c)
+	if err := a.checkMediaQuota(user.ID, int64(len(b))); err != nil {
+		return err
+	}
+
 	// If the filename already exists in the DB, make it unique by adding a random suffix.
 	if _, err := a.core.GetMedia(0, "", fName, a.media); err == nil {
 		suffix, err := generateRandomString(6)
@@ -66,71 +109,136 @@ func (a *App) UploadMedia(c echo.Context) error {
 		fName = appendSuffixToFilename(fName, suffix)
 	}
 
+	// Images are processed (resized, re-encoded, thumbnailed, and rendered into
+	// renditions and alternative formats) before they're stored.
+	var (
+		isImage = inArray(ext, imageExts)
+		img     images.Result
+		body    = b
+	)
+	if isImage {
+		img, err = a.imgProc.Process(b, ext)
+		if err != nil {
+			a.log.Printf("error resizing image: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("media.errorResizing", "error", err.Error()))
+		}
+
+		if img.Original != nil && img.Original.Data != nil {
+			body = img.Original.Data
+		}
+	}
+
 	// Upload the file to the media store.
-	fName, err = a.media.Put(fName, contentType, src)
+	fName, err = a.media.Put(fName, contentType, bytes.NewReader(body))
 	if err != nil {
 		a.log.Printf("error uploading file: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			a.i18n.Ts("media.errorUploading", "error", err.Error()))
 	}
 
-	// This keeps track of whether the file has to be deleted from the DB and the store
+	// This keeps track of the files that have to be deleted from the store
 	// if any of the subsequent steps fail.
 	var (
 		cleanUp    = false
+		files      = []string{fName}
 		thumbfName = ""
+
+		// Total size of all the stored files.
+		size = int64(len(body))
 	)
 	defer func() {
 		if cleanUp {
-			a.media.Delete(fName)
-
-			if thumbfName != "" {
-				a.media.Delete(thumbfName)
+			for _, f := range files {
+				a.media.Delete(f)
 			}
 		}
 	}()
 
-	// Thumbnail width and height.
-	var width, height int
-
-	// Create thumbnail from file for non-vector formats.
-	isImage := inArray(ext, imageExts)
-	if isImage {
-		thumbFile, wi, he, err := processImage(file)
-		if err != nil {
-			cleanUp = true
-			a.log.Printf("error resizing image: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
-				a.i18n.Ts("media.errorResizing", "error", err.Error()))
-		}
-		width = wi
-		height = he
-
-		// Upload thumbnail.
-		tf, err := a.media.Put(thumbPrefix+fName, contentType, thumbFile)
+	// put uploads a generated file to the media store.
+	put := func(name, cType string, b []byte) (string, error) {
+		f, err := a.media.Put(name, cType, bytes.NewReader(b))
 		if err != nil {
 			cleanUp = true
-			a.log.Printf("error saving thumbnail: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
+			a.log.Printf("error saving file %s: %v", name, err)
+			return "", echo.NewHTTPError(http.StatusInternalServerError,
 				a.i18n.Ts("media.errorSavingThumbnail", "error", err.Error()))
 		}
-		thumbfName = tf
+		files = append(files, f)
+		size += int64(len(b))
+
+		return f, nil
 	}
-	if inArray(ext, vectorExts) {
-		thumbfName = fName
+
+	// putFormats uploads the alternative formats of an image and returns their filenames.
+	putFormats := func(name string, formats map[string][]byte) (map[string]string, error) {
+		out := make(map[string]string, len(formats))
+		for f, b := range formats {
+			fn, err := put(name+"."+f, "image/"+f, b)
+			if err != nil {
+				return nil, err
+			}
+			out[f] = fn
+		}
+
+		return out, nil
 	}
 
 	// Images have metadata.
 	meta := models.JSON{}
 	if isImage {
+		// Upload the thumbnail.
+		tf, err := put(thumbPrefix+fName, contentType, img.Thumb)
+		if err != nil {
+			return err
+		}
+		thumbfName = tf
+
+		var fm media.FileMeta
+		if img.Original != nil {
+			if fm.Formats, err = putFormats(fName, img.Original.Formats); err != nil {
+				return err
+			}
+		}
+
+		// Upload the renditions.
+		fm.Renditions = make(map[string]media.RenditionFile, len(img.Renditions))
+		for name, r := range img.Renditions {
+			rf, err := put(name+"_"+fName, contentType, r.Data)
+			if err != nil {
+				return err
+			}
+
+			formats, err := putFormats(rf, r.Formats)
+			if err != nil {
+				return err
+			}
+
+			fm.Renditions[name] = media.RenditionFile{Filename: rf, Width: r.Width, Height: r.Height, Formats: formats}
+		}
+
 		meta = models.JSON{
-			"width":  width,
-			"height": height,
+			"width":      img.Width,
+			"height":     img.Height,
+			"formats":    fm.Formats,
+			"renditions": fm.Renditions,
 		}
 	}
+	if inArray(ext, vectorExts) {
+		thumbfName = fName
+	}
+
+	// Optional folder and tags.
+	var (
+		folder = makeMediaFolder(c.FormValue("folder"))
+		tags   []string
+	)
+	if form, err := c.FormParams(); err == nil {
+		tags = makeMediaTags(form["tags"])
+	}
 
 	// Insert the media into the DB.
-	m, err := a.core.InsertMedia(fName, thumbfName, contentType, meta, a.cfg.MediaUpload.Provider, a.media)
+	m, err := a.core.InsertMedia(fName, thumbfName, contentType, meta, folder, tags, hash, size, user.ID, a.cfg.MediaUpload.Provider, a.media)
 	if err != nil {
 		cleanUp = true
 		return err
@@ -139,15 +247,35 @@ func (a *App) UploadMedia(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{m})
 }
 
-// GetAllMedia handles retrieval of uploaded media.
+// GetAllMedia handles retrieval of uploaded media, optionally filtered by
+// a filename query, folder, tags, content type, and a date range.
 func (a *App) GetAllMedia(c echo.Context) error {
 	var (
-		query = c.FormValue("query")
+		qp          = c.QueryParams()
+		query       = c.FormValue("query")
+		contentType = strings.TrimSpace(c.FormValue("type"))
+		tags        = makeMediaTags(qp["tag"])
 
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
+
+	// An empty folder is the root folder. The absence of the param is all folders.
+	var folder null.String
+	if v, ok := qp["folder"]; ok && len(v) > 0 {
+		folder = null.StringFrom(makeMediaFolder(v[0]))
+	}
+
+	from, err := parseAuditDate(c.FormValue("from"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "from"))
+	}
+	to, err := parseAuditDate(c.FormValue("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "to"))
+	}
+
 	// Fetch the media items from the DB.
-	res, total, err := a.core.QueryMedia(a.cfg.MediaUpload.Provider, a.media, query, pg.Offset, pg.Limit)
+	res, total, err := a.core.QueryMedia(a.cfg.MediaUpload.Provider, a.media, query, folder, tags, contentType, from, to, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -174,46 +302,139 @@ func (a *App) GetMedia(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// DeleteMedia handles deletion of uploaded media.
-func (a *App) DeleteMedia(c echo.Context) error {
+// UpdateMedia handles the updating of a media item's folder and tags.
+func (a *App) UpdateMedia(c echo.Context) error {
+	var req struct {
+		Folder string   `json:"folder"`
+		Tags   []string `json:"tags"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateMedia(getID(c), makeMediaFolder(req.Folder), makeMediaTags(req.Tags), a.media)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetMediaFolders handles retrieval of media folders.
+func (a *App) GetMediaFolders(c echo.Context) error {
+	out, err := a.core.GetMediaFolders(a.cfg.MediaUpload.Provider)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetMediaStorage handles retrieval of the storage used by media files, overall and by the
+// current user, and the quotas. The storage used by every user is only returned to users
+// who can view users.
+func (a *App) GetMediaStorage(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	out, err := a.core.GetMediaStorage(a.cfg.MediaUpload.Provider, user.ID, user.HasPerm(auth.PermUsersGet))
+	if err != nil {
+		return err
+	}
+	out.Quota = a.cfg.MediaUpload.Quota
+	out.UserQuota = a.cfg.MediaUpload.UserQuota
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetMediaUsage handles retrieval of the campaigns and templates that reference a media item.
+func (a *App) GetMediaUsage(c echo.Context) error {
+	out, err := a.core.GetMediaUsage(getID(c))
+	if err != nil {
+		return err
+	}
 
-	// Delete the media from the DB. The query returns the filename.
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteMedia handles deletion of uploaded media. Media that's referenced by
+// campaigns or templates is only deleted with ?force=true.
+func (a *App) DeleteMedia(c echo.Context) error {
+	// Get the media item to delete all its files (thumbnail, renditions etc.)
 	id := getID(c)
-	fname, err := a.core.DeleteMedia(id)
+	m, err := a.core.GetMedia(id, "", "", a.media)
 	if err != nil {
 		return err
 	}
 
+	if force, _ := strconv.ParseBool(c.QueryParam("force")); !force {
+		usage, err := a.core.GetMediaUsage(id)
+		if err != nil {
+			return err
+		}
+		if len(usage) > 0 {
+			return echo.NewHTTPError(http.StatusConflict, a.i18n.Ts("media.inUse", "num", strconv.Itoa(len(usage))))
+		}
+	}
+
+	// Delete the media from the DB.
+	if _, err := a.core.DeleteMedia(id); err != nil {
+		return err
+	}
+
 	// Delete the files from the media store.
-	a.media.Delete(fname)
-	a.media.Delete(thumbPrefix + fname)
+	for _, f := range m.Files() {
+		a.media.Delete(f)
+	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// processImage reads the image file and returns thumbnail bytes and
-// the original image's width, and height.
-func processImage(file *multipart.FileHeader) (*bytes.Reader, int, int, error) {
-	src, err := file.Open()
-	if err != nil {
-		return nil, 0, 0, err
+// checkMediaQuota checks whether uploading size bytes would exceed the global
+// storage quota or the user's storage quota.
+func (a *App) checkMediaQuota(userID int, size int64) error {
+	q := a.cfg.MediaUpload
+	if q.Quota == 0 && q.UserQuota == 0 {
+		return nil
 	}
-	defer src.Close()
 
-	img, err := imaging.Decode(src)
+	st, err := a.core.GetMediaStorage(q.Provider, userID, false)
 	if err != nil {
-		return nil, 0, 0, err
+		return err
 	}
 
-	// Encode the image into a byte slice as PNG.
-	var (
-		thumb = imaging.Resize(img, thumbnailSize, 0, imaging.Lanczos)
-		out   bytes.Buffer
-	)
-	if err := imaging.Encode(&out, thumb, imaging.PNG); err != nil {
-		return nil, 0, 0, err
+	if q.Quota > 0 && st.Size+size > q.Quota {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			a.i18n.Ts("media.quotaExceeded", "quota", strconv.FormatInt(q.Quota>>20, 10)))
+	}
+	if q.UserQuota > 0 && userID > 0 && st.UserSize+size > q.UserQuota {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			a.i18n.Ts("media.userQuotaExceeded", "quota", strconv.FormatInt(q.UserQuota>>20, 10)))
+	}
+
+	return nil
+}
+
+// makeMediaFolder sanitizes a media folder path, eg: "/newsletters//2024/ " => "newsletters/2024".
+// The root folder is an empty string.
+func makeMediaFolder(folder string) string {
+	f := strings.Trim(path.Clean("/"+strings.TrimSpace(folder)), "/")
+	if len(f) > 200 {
+		f = f[:200]
+	}
+
+	return f
+}
+
+// makeMediaTags trims and de-duplicates media tags.
+func makeMediaTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || len(t) > 100 || inArray(t, out) {
+			continue
+		}
+		out = append(out, t)
 	}
 
-	b := img.Bounds().Max
-	return bytes.NewReader(out.Bytes()), b.X, b.Y, nil
+	return out
 }
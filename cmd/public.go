@@ -9,6 +9,7 @@ import (
 	"image/png"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -68,6 +69,9 @@ type unsubTpl struct {
 	AllowWipe        bool
 	AllowPreferences bool
 	ShowManage       bool
+
+	// VAPID public key for web push subscriptions. Empty if web push is disabled.
+	PushPublicKey string
 }
 
 type optinReq struct {
@@ -217,6 +221,9 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 		AllowWipe:        a.cfg.Privacy.AllowWipe,
 		AllowPreferences: a.cfg.Privacy.AllowPreferences,
 	}
+	if a.cfg.WebPushEnabled {
+		out.PushPublicKey = a.cfg.WebPushPublicKey
+	}
 
 	// If the subscriber is blocklisted, throw an error.
 	if s.Status == models.SubscriberStatusBlockListed {
@@ -332,7 +339,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	}
 
 	// Unsubscribe from lists.
-	if err := a.core.UnsubscribeLists([]int{sub.ID}, nil, unsubUUIDs); err != nil {
+	if err := a.core.UnsubscribeLists([]int{sub.ID}, nil, unsubUUIDs, models.SubscriptionSourcePublic); err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 
@@ -575,6 +582,38 @@ func (a *App) RegisterCampaignView(c echo.Context) error {
 	return c.Blob(http.StatusOK, "image/png", pixelPNG)
 }
 
+// TxLinkRedirect redirects a link UUID in a transactional message to its
+// original underlying link after recording the link click.
+func (a *App) TxLinkRedirect(c echo.Context) error {
+	// If individual tracking is disabled, do not record the click.
+	txUUID := c.Param("txUUID")
+	if !a.cfg.Privacy.IndividualTracking {
+		txUUID = ""
+	}
+
+	url, err := a.core.RegisterTxLinkClick(c.Param("linkUUID"), txUUID)
+	if err != nil {
+		e := err.(*echo.HTTPError)
+		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+// RegisterTxView registers a transactional message view which comes in the
+// form of an pixel image request. The pixel URL is generated by the
+// {{ TrackView }} template tag in transactional templates.
+func (a *App) RegisterTxView(c echo.Context) error {
+	if a.cfg.Privacy.IndividualTracking {
+		if err := a.core.RegisterTxView(c.Param("txUUID")); err != nil {
+			a.log.Printf("error registering tx message view: %s", err)
+		}
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(http.StatusOK, "image/png", pixelPNG)
+}
+
 // SelfExportSubscriberData pulls the subscriber's profile, list subscriptions,
 // campaign views and clicks and produces a JSON report that is then e-mailed
 // to the subscriber. This is a privacy feature and the data that's exported
@@ -653,6 +692,55 @@ func (a *App) WipeSubscriberData(c echo.Context) error {
 		makeMsgTpl(a.i18n.T("public.dataRemovedTitle"), "", a.i18n.T("public.dataRemoved")))
 }
 
+// pushSubReq is a browser's web push subscription (PushSubscription.toJSON()).
+type pushSubReq struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// SubscribePush registers a browser's web push subscription for a subscriber.
+func (a *App) SubscribePush(c echo.Context) error {
+	var req pushSubReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Push services are only reachable over HTTPS.
+	u, err := url.Parse(req.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(req.Endpoint) > 2000 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "endpoint"))
+	}
+	if req.Keys.P256dh == "" || req.Keys.Auth == "" || len(req.Keys.P256dh) > 200 || len(req.Keys.Auth) > 200 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "keys"))
+	}
+
+	if err := a.core.UpsertPushSubscription(c.Param("subUUID"), req.Endpoint, req.Keys.P256dh, req.Keys.Auth); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// UnsubscribePush deletes a browser's web push subscription of a subscriber.
+func (a *App) UnsubscribePush(c echo.Context) error {
+	var req pushSubReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.Endpoint == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "endpoint"))
+	}
+
+	if err := a.core.DeletePushSubscription(req.Endpoint, c.Param("subUUID")); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
 // AltchaChallenge generates a challenge for Altcha captcha.
 func (a *App) AltchaChallenge(c echo.Context) error {
 	// Check if Altcha is enabled.
@@ -740,7 +828,7 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 		Name:   req.Name,
 		Email:  req.Email,
 		Status: models.SubscriberStatusEnabled,
-	}, nil, listUUIDs, false, true)
+	}, nil, listUUIDs, false, true, models.SubscriptionSourcePublic)
 	if err == nil {
 		return hasOptin, nil
 	}
@@ -757,7 +845,7 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 		}
 
 		// Update the subscriber's subscriptions in the DB.
-		_, hasOptin, err := a.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false, true)
+		_, hasOptin, err := a.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false, true, models.SubscriptionSourcePublic)
 		if err == nil {
 			return hasOptin, nil
 		}
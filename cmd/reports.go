@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Cron intervals for the digest reports. Weekly reports are sent on Mondays
+	// and monthly reports on the first day of the month.
+	digestCronWeekly  = "0 8 * * 1"
+	digestCronMonthly = "0 8 1 * *"
+)
+
+// GetDigestReport returns the data of the admin digest report for the
+// `frequency` (weekly|monthly) period ending today.
+func (a *App) GetDigestReport(c echo.Context) error {
+	freq := c.QueryParam("frequency")
+	if freq == "" {
+		freq = models.DigestFrequencyWeekly
+	}
+	if freq != models.DigestFrequencyWeekly && freq != models.DigestFrequencyMonthly {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "frequency"))
+	}
+
+	from, to := getDigestPeriod(freq, time.Now())
+	out, err := a.core.GetDigestReport(from, to, a.cfg.Privacy.IndividualTracking)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// sendDigestReport prepares the digest report for the given frequency and e-mails it to
+// the given users, or if there are no users, to the admin notification e-mails.
+func sendDigestReport(co *core.Core, i *i18n.I18n, freq string, userIDs []int, uniqueCounts bool) {
+	from, to := getDigestPeriod(freq, time.Now())
+
+	data, err := co.GetDigestReport(from, to, uniqueCounts)
+	if err != nil {
+		lo.Printf("error preparing digest report: %v", err)
+		return
+	}
+
+	// No users are selected. Send it to the notification e-mails.
+	if len(userIDs) == 0 {
+		_ = notifs.NotifySystem(i.T("email.digest.subject"), notifs.TplDigestReport, data, nil)
+		return
+	}
+
+	users, err := co.GetUsers()
+	if err != nil {
+		lo.Printf("error fetching users for digest report: %v", err)
+		return
+	}
+
+	ids := make(map[int]bool, len(userIDs))
+	for _, id := range userIDs {
+		ids[id] = true
+	}
+
+	var emails []string
+	for _, u := range users {
+		if ids[u.ID] && u.Status == auth.UserStatusEnabled && u.Email.String != "" {
+			emails = append(emails, u.Email.String)
+		}
+	}
+
+	_ = notifs.Notify(emails, i.T("email.digest.subject"), notifs.TplDigestReport, data, nil)
+}
+
+// getDigestPeriod returns the date range of the digest report of the given frequency.
+// Weekly reports cover the last seven days and monthly reports the last calendar month.
+func getDigestPeriod(freq string, now time.Time) (time.Time, time.Time) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if freq == models.DigestFrequencyMonthly {
+		to = to.AddDate(0, 0, 1-to.Day())
+		return to.AddDate(0, -1, 0), to
+	}
+
+	return to.AddDate(0, 0, -7), to
+}
@@ -20,10 +20,15 @@ import (
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/media/images"
+	"github.com/knadh/listmonk/internal/messenger/chat"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/messenger/postback"
+	"github.com/knadh/listmonk/internal/messenger/webpush"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/ssh"
 )
 
 const pwdMask = "•"
@@ -70,14 +75,23 @@ func (a *App) GetSettings(c echo.Context) error {
 	}
 	for i := range s.Messengers {
 		s.Messengers[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Password))
+		s.Messengers[i].HMACSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].HMACSecret))
+		s.Messengers[i].Config = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Config))
 	}
 
 	s.UploadS3AwsSecretAccessKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadS3AwsSecretAccessKey))
+	s.UploadWebDAVPassword = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadWebDAVPassword))
+	s.UploadSFTPPassword = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadSFTPPassword))
+	s.UploadSFTPPrivateKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadSFTPPrivateKey))
 	s.SendgridKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SendgridKey))
 	s.BouncePostmark.Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BouncePostmark.Password))
 	s.BounceForwardEmail.Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceForwardEmail.Key))
 	s.SecurityCaptcha.HCaptcha.Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityCaptcha.HCaptcha.Secret))
 	s.OIDC.ClientSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.OIDC.ClientSecret))
+	s.SecurityEmailCrypto.SMIMEKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityEmailCrypto.SMIMEKey))
+	s.SecurityEmailCrypto.PGPKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityEmailCrypto.PGPKey))
+	s.SecurityEmailCrypto.PGPPassphrase = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityEmailCrypto.PGPPassphrase))
+	s.WebPushVAPIDPrivateKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.WebPushVAPIDPrivateKey))
 
 	return c.JSON(http.StatusOK, okResp{s})
 }
@@ -98,8 +112,8 @@ func (a *App) UpdateSettings(c echo.Context) error {
 
 	// Validate and sanitize postback Messenger names along with SMTP names
 	// (where each SMTP is also considered as a standalone messenger).
-	// Duplicates are disallowed and "email" is a reserved name.
-	names := map[string]bool{emailMsgr: true}
+	// Duplicates are disallowed and "email" and "webpush" are reserved names.
+	names := map[string]bool{emailMsgr: true, webpush.Name: true}
 
 	// There should be at least one SMTP block that's enabled.
 	has := false
@@ -195,6 +209,77 @@ func (a *App) UpdateSettings(c echo.Context) error {
 				}
 			}
 		}
+		if m.HMACSecret == "" {
+			for _, c := range cur.Messengers {
+				if m.UUID == c.UUID {
+					set.Messengers[i].HMACSecret = c.HMACSecret
+				}
+			}
+		}
+		if m.Config == "" {
+			for _, c := range cur.Messengers {
+				if m.UUID == c.UUID {
+					set.Messengers[i].Config = c.Config
+				}
+			}
+		}
+
+		// Validate the plugin options.
+		switch m.Type {
+		case "", msgrTypePostback:
+		case msgrTypePlugin:
+			if strings.TrimSpace(m.Command) == "" && strings.TrimSpace(m.Address) == "" {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.missingFields", "name", "command / address"))
+			}
+			if cfg := set.Messengers[i].Config; cfg != "" && !json.Valid([]byte(cfg)) {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", "config"))
+			}
+		case msgrTypeChat:
+			// Slack can post to an incoming webhook URL without a token or a target attribute.
+			var (
+				token = set.Messengers[i].Password
+				field string
+			)
+			switch {
+			case m.Platform != chat.PlatformSlack && m.Platform != chat.PlatformMatrix && m.Platform != chat.PlatformTelegram:
+				field = "platform"
+			case m.Platform == chat.PlatformSlack && token == "" && m.RootURL == "",
+				m.Platform == chat.PlatformMatrix && m.RootURL == "":
+				field = "root_url"
+			case m.Platform != chat.PlatformSlack && token == "":
+				field = "token"
+			case strings.TrimSpace(m.Attrib) == "" && (m.Platform != chat.PlatformSlack || token != ""):
+				field = "attrib"
+			}
+			if field != "" {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", field))
+			}
+			if m.RateLimit < 0 {
+				set.Messengers[i].RateLimit = 0
+			}
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+
+		// Validate the batching options and the body template.
+		if m.BatchSize < 0 {
+			set.Messengers[i].BatchSize = 0
+		}
+		if m.BatchWait == "" {
+			set.Messengers[i].BatchWait = "0s"
+		} else if _, err := time.ParseDuration(m.BatchWait); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "batch_wait"))
+		}
+		if m.BodyTemplate != "" {
+			if _, err := postback.CompileTemplate(m.BodyTemplate); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
 
 		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
 		if _, ok := names[name]; ok {
@@ -213,6 +298,15 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	if set.UploadS3AwsSecretAccessKey == "" {
 		set.UploadS3AwsSecretAccessKey = cur.UploadS3AwsSecretAccessKey
 	}
+	if set.UploadWebDAVPassword == "" {
+		set.UploadWebDAVPassword = cur.UploadWebDAVPassword
+	}
+	if set.UploadSFTPPassword == "" {
+		set.UploadSFTPPassword = cur.UploadSFTPPassword
+	}
+	if set.UploadSFTPPrivateKey == "" {
+		set.UploadSFTPPrivateKey = cur.UploadSFTPPrivateKey
+	}
 	if set.SendgridKey == "" {
 		set.SendgridKey = cur.SendgridKey
 	}
@@ -228,6 +322,37 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	if set.OIDC.ClientSecret == "" {
 		set.OIDC.ClientSecret = cur.OIDC.ClientSecret
 	}
+	if set.SecurityEmailCrypto.SMIMEKey == "" {
+		set.SecurityEmailCrypto.SMIMEKey = cur.SecurityEmailCrypto.SMIMEKey
+	}
+	if set.SecurityEmailCrypto.PGPKey == "" {
+		set.SecurityEmailCrypto.PGPKey = cur.SecurityEmailCrypto.PGPKey
+	}
+	if set.SecurityEmailCrypto.PGPPassphrase == "" {
+		set.SecurityEmailCrypto.PGPPassphrase = cur.SecurityEmailCrypto.PGPPassphrase
+	}
+
+	// Web push. Generate a VAPID key pair if there isn't one.
+	if set.WebPushVAPIDPrivateKey == "" {
+		set.WebPushVAPIDPrivateKey = cur.WebPushVAPIDPrivateKey
+	}
+	if set.WebPushEnabled {
+		sub := strings.TrimSpace(set.WebPushSubject)
+		if !strings.HasPrefix(sub, "mailto:") && !strings.HasPrefix(sub, "https://") {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "webpush.subject"))
+		}
+		set.WebPushSubject = sub
+
+		if set.WebPushVAPIDPublicKey == "" || set.WebPushVAPIDPrivateKey == "" {
+			pub, priv, err := webpush.GenerateVAPIDKeys()
+			if err != nil {
+				a.log.Printf("error generating VAPID keys: %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+			}
+			set.WebPushVAPIDPublicKey, set.WebPushVAPIDPrivateKey = pub, priv
+		}
+	}
 
 	// OIDC user auto-creation is enabled. Validate.
 	if set.OIDC.AutoCreateUsers {
@@ -241,6 +366,77 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		set.UploadExtensions[n] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))
 	}
 
+	// WebDAV and SFTP media providers.
+	switch set.UploadProvider {
+	case "webdav":
+		if !strHasLen(set.UploadWebDAVURL, 1, stdInputMaxLen) || !strings.HasPrefix(set.UploadWebDAVURL, "http") {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.webdav.url"))
+		}
+		if _, err := time.ParseDuration(set.UploadWebDAVTimeout); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.webdav.timeout"))
+		}
+
+	case "sftp":
+		if !strHasLen(set.UploadSFTPHost, 1, stdInputMaxLen) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.sftp.host"))
+		}
+		if !strings.HasPrefix(set.UploadSFTPPublicURL, "http") {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.sftp.public_url"))
+		}
+		if set.UploadSFTPPassword == "" && set.UploadSFTPPrivateKey == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.media.sftp.noAuth"))
+		}
+		if set.UploadSFTPPrivateKey != "" {
+			if _, err := ssh.ParsePrivateKey([]byte(set.UploadSFTPPrivateKey)); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", "upload.sftp.private_key"))
+			}
+		}
+		if _, err := time.ParseDuration(set.UploadSFTPTimeout); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.sftp.timeout"))
+		}
+	}
+
+	// Storage quotas (MB). 0 is unlimited.
+	set.UploadQuota = max(set.UploadQuota, 0)
+	set.UploadUserQuota = max(set.UploadUserQuota, 0)
+
+	// Image processing. 0 max dimensions disable resizing.
+	set.UploadImageMaxWidth = max(set.UploadImageMaxWidth, 0)
+	set.UploadImageMaxHeight = max(set.UploadImageMaxHeight, 0)
+	if set.UploadImageQuality < 1 || set.UploadImageQuality > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "upload.image.quality"))
+	}
+	for _, f := range set.UploadImageFormats {
+		if f != images.FormatWebP && f != images.FormatAVIF {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload.image.formats"))
+		}
+	}
+
+	// Rendition names are used as filename prefixes. "thumb" is reserved for thumbnails.
+	rNames := map[string]bool{strings.TrimSuffix(thumbPrefix, "_"): true}
+	for i, r := range set.UploadImageRenditions {
+		name := reAlphaNum.ReplaceAllString(strings.ToLower(strings.TrimSpace(r.Name)), "")
+		if name == "" || rNames[name] {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("settings.media.image.invalidRendition", "name", r.Name))
+		}
+		if r.Width < 0 || r.Height < 0 || (r.Width == 0 && r.Height == 0) || (r.Crop && (r.Width == 0 || r.Height == 0)) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("settings.media.image.invalidRenditionSize", "name", name))
+		}
+
+		set.UploadImageRenditions[i].Name = name
+		rNames[name] = true
+	}
+
 	// Domain blocklist / allowlist.
 	doms := make([]string, 0, len(set.DomainBlocklist))
 	for _, d := range set.DomainBlocklist {
@@ -279,6 +475,63 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.SecurityCORSOrigins = cors
 
+	// Validate the e-mail signing keys by loading them.
+	ec := set.SecurityEmailCrypto
+	if ec.Method != email.MethodSMIME && ec.Method != email.MethodPGP {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.email_crypto.method"))
+	}
+	if _, err := email.NewSecurity(email.SecurityOpt(ec)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("settings.security.emailCryptoError", "error", err.Error()))
+	}
+
+	if set.AppImportConcurrency < 1 {
+		set.AppImportConcurrency = 1
+	}
+
+	// An empty TTL (or 0) disables idempotency keys.
+	if set.AppIdempotencyTTL != "" {
+		if d, err := time.ParseDuration(set.AppIdempotencyTTL); err != nil || d < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.idempotency_ttl"))
+		}
+	}
+
+	// 0 retains audit logs forever.
+	if set.SecurityAuditRetentionDays < 0 {
+		set.SecurityAuditRetentionDays = 0
+	}
+
+	// 0 retains transactional message logs forever.
+	if set.PrivacyTxLogRetentionDays < 0 {
+		set.PrivacyTxLogRetentionDays = 0
+	}
+	if set.PrivacyImportRetentionDays < 0 {
+		set.PrivacyImportRetentionDays = 0
+	}
+
+	// Validate the digest report settings.
+	if set.AppDigestReport.Frequency != models.DigestFrequencyWeekly && set.AppDigestReport.Frequency != models.DigestFrequencyMonthly {
+		set.AppDigestReport.Frequency = models.DigestFrequencyWeekly
+	}
+	if set.AppDigestReport.UserIDs == nil {
+		set.AppDigestReport.UserIDs = []int{}
+	}
+
+	// Validate the scheduled exports.
+	if set.AppScheduledExports == nil {
+		set.AppScheduledExports = []models.ScheduledExport{}
+	}
+	for n, e := range set.AppScheduledExports {
+		if e.ListIDs == nil {
+			set.AppScheduledExports[n].ListIDs = []int{}
+		}
+		if err := validateScheduledExport(e); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": scheduled export: "+err.Error())
+		}
+	}
+
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
@@ -344,26 +597,53 @@ func (a *App) GetLogs(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{a.bufLog.Lines()})
 }
 
+// GetMessengersHealth returns the health of the messengers that report it,
+// such as plugin messengers.
+func (a *App) GetMessengersHealth(c echo.Context) error {
+	type health struct {
+		Name    string `json:"name"`
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	out := []health{}
+	for _, m := range a.messengers {
+		h, ok := m.(interface{ Health() error })
+		if !ok {
+			continue
+		}
+
+		r := health{Name: m.Name(), Healthy: true}
+		if err := h.Health(); err != nil {
+			r.Healthy = false
+			r.Error = err.Error()
+		}
+		out = append(out, r)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // TestSMTPSettings returns the log entries stored in the log buffer.
 func (a *App) TestSMTPSettings(c echo.Context) error {
 	// Copy the raw JSON post body.
 	reqBody, err := io.ReadAll(c.Request().Body)
 	if err != nil {
 		a.log.Printf("error reading SMTP test: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.internalError"))
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.internalError"))
 	}
 
 	// Load the JSON into koanf to parse SMTP settings properly including timestrings.
 	ko := koanf.New(".")
 	if err := ko.Load(rawbytes.Provider(reqBody), koanfjson.Parser()); err != nil {
 		a.log.Printf("error unmarshalling SMTP test request: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.internalError"))
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.internalError"))
 	}
 
 	req := email.Server{}
 	if err := ko.UnmarshalWithConf("", &req, koanf.UnmarshalConf{Tag: "json"}); err != nil {
 		a.log.Printf("error scanning SMTP test request: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.internalError"))
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.internalError"))
 	}
 
 	to := ko.String("email")
@@ -375,7 +655,7 @@ func (a *App) TestSMTPSettings(c echo.Context) error {
 	req.MaxConns = 1
 	req.IdleTimeout = time.Second * 2
 	req.PoolWaitTimeout = time.Second * 2
-	msgr, err := email.New("", req)
+	msgr, err := email.New("", nil, req)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			a.i18n.Ts("globals.messages.errorCreating", "name", "SMTP", "error", err.Error()))
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -139,7 +138,8 @@ func (a *App) QuerySubscribers(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// ExportSubscribers handles querying subscribers based on an arbitrary SQL expression.
+// ExportSubscribers handles querying subscribers based on an arbitrary SQL expression
+// and streaming them as CSV, JSON, or NDJSON.
 func (a *App) ExportSubscribers(c echo.Context) error {
 	// Get the authenticated user.
 	user := auth.GetUser(c)
@@ -171,45 +171,30 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 		}
 	}
 
+	// Export format, columns, and attributes to flatten into columns.
+	o, err := makeSubExportOpt(c.QueryParam("format"), c.QueryParams()["columns"], c.QueryParams()["attribs"])
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
 	// Get the batched export iterator.
-	exp, err := a.core.ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, a.cfg.DBBatchSize)
+	exp, err := a.core.ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, o.hasCol("subscriptions"), a.cfg.DBBatchSize)
 	if err != nil {
 		return err
 	}
 
-	var (
-		hdr = c.Response().Header()
-		wr  = csv.NewWriter(c.Response())
-	)
-
-	hdr.Set(echo.HeaderContentType, echo.MIMEOctetStream)
-	hdr.Set("Content-type", "text/csv")
-	hdr.Set(echo.HeaderContentDisposition, "attachment; filename="+"subscribers.csv")
+	hdr := c.Response().Header()
+	hdr.Set(echo.HeaderContentType, o.contentType())
+	hdr.Set(echo.HeaderContentDisposition, "attachment; filename="+"subscribers."+o.Format)
 	hdr.Set("Content-Transfer-Encoding", "binary")
 	hdr.Set("Cache-Control", "no-cache")
-	wr.Write([]string{"uuid", "email", "name", "attributes", "status", "created_at", "updated_at"})
 
-loop:
-	// Iterate in batches until there are no more subscribers to export.
-	for {
-		out, err := exp()
-		if err != nil {
+	if err := writeSubscriberExport(c.Response(), exp, o, c.Response().Flush); err != nil {
+		// The error can only be sent if nothing has been streamed yet.
+		if !c.Response().Committed {
 			return err
 		}
-		if len(out) == 0 {
-			break
-		}
-
-		for _, r := range out {
-			if err = wr.Write([]string{r.UUID, r.Email, r.Name, r.Attribs, r.Status,
-				r.CreatedAt.Time.String(), r.UpdatedAt.Time.String()}); err != nil {
-				a.log.Printf("error streaming CSV export: %v", err)
-				break loop
-			}
-		}
-
-		// Flush CSV to stream after each batch.
-		wr.Flush()
+		a.log.Printf("error streaming subscriber export: %v", err)
 	}
 
 	return nil
@@ -236,7 +221,7 @@ func (a *App) CreateSubscriber(c echo.Context) error {
 	listIDs := user.FilterListsByPerm(auth.PermTypeManage, req.Lists)
 
 	// Insert the subscriber into the DB.
-	sub, _, err := a.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false)
+	sub, _, err := a.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false, subscriptionSource(user))
 	if err != nil {
 		return err
 	}
@@ -275,7 +260,7 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 
 	// Update the subscriber in the DB.
 	id := getID(c)
-	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false)
+	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false, subscriptionSource(user))
 	if err != nil {
 		return err
 	}
@@ -304,7 +289,7 @@ func (a *App) SubscriberSendOptin(c echo.Context) error {
 func (a *App) BlocklistSubscriber(c echo.Context) error {
 	// Update the subscribers in the DB.
 	id := getID(c)
-	if err := a.core.BlocklistSubscribers([]int{id}); err != nil {
+	if err := a.core.BlocklistSubscribers([]int{id}, subscriptionSource(auth.GetUser(c))); err != nil {
 		return err
 	}
 
@@ -324,7 +309,7 @@ func (a *App) BlocklistSubscribers(c echo.Context) error {
 	}
 
 	// Update the subscribers in the DB.
-	if err := a.core.BlocklistSubscribers(req.SubscriberIDs); err != nil {
+	if err := a.core.BlocklistSubscribers(req.SubscriberIDs, subscriptionSource(auth.GetUser(c))); err != nil {
 		return err
 	}
 
@@ -379,11 +364,11 @@ func (a *App) ManageSubscriberLists(c echo.Context) error {
 	var err error
 	switch req.Action {
 	case "add":
-		err = a.core.AddSubscriptions(subIDs, listIDs, req.Status)
+		err = a.core.AddSubscriptions(subIDs, listIDs, req.Status, subscriptionSource(user))
 	case "remove":
 		err = a.core.DeleteSubscriptions(subIDs, listIDs)
 	case "unsubscribe":
-		err = a.core.UnsubscribeLists(subIDs, listIDs, nil)
+		err = a.core.UnsubscribeLists(subIDs, listIDs, nil, subscriptionSource(user))
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidAction"))
 	}
@@ -493,7 +478,7 @@ func (a *App) BlocklistSubscribersByQuery(c echo.Context) error {
 	}
 
 	// Update the subscribers in the DB.
-	if err := a.core.BlocklistSubscribersByQuery(req.Search, req.Query, req.ListIDs, req.SubscriptionStatus); err != nil {
+	if err := a.core.BlocklistSubscribersByQuery(req.Search, req.Query, req.ListIDs, req.SubscriptionStatus, subscriptionSource(user)); err != nil {
 		return err
 	}
 
@@ -534,11 +519,11 @@ func (a *App) ManageSubscriberListsByQuery(c echo.Context) error {
 	var err error
 	switch req.Action {
 	case "add":
-		err = a.core.AddSubscriptionsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus)
+		err = a.core.AddSubscriptionsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus, subscriptionSource(user))
 	case "remove":
 		err = a.core.DeleteSubscriptionsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
 	case "unsubscribe":
-		err = a.core.UnsubscribeListsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
+		err = a.core.UnsubscribeListsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus, subscriptionSource(user))
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidAction"))
 	}
@@ -687,6 +672,15 @@ func formatSQLExp(q string) string {
 	return q
 }
 
+// subscriptionSource returns the subscription history source for changes
+// made by the given user, distinguishing API users from admin users.
+func subscriptionSource(u auth.User) string {
+	if u.Type == auth.UserTypeAPI {
+		return models.SubscriptionSourceAPI
+	}
+	return models.SubscriptionSourceAdmin
+}
+
 // makeOptinNotifyHook returns an enclosed callback that sends optin confirmation e-mails.
 // This is plugged into the 'core' package to send optin confirmations when a new subscriber is
 // created via `core.CreateSubscriber()`.
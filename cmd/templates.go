@@ -121,7 +121,7 @@ func (a *App) CreateTemplate(c echo.Context) error {
 		o.Subject = ""
 		funcs = a.manager.TemplateFuncs(nil)
 	} else {
-		funcs = a.manager.GenericTemplateFuncs()
+		funcs = a.manager.TxTemplateFuncs()
 	}
 
 	// Compile the template and validate.
@@ -130,7 +130,7 @@ func (a *App) CreateTemplate(c echo.Context) error {
 	}
 
 	// Create the template the in the DB.
-	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource, o.Security)
 	if err != nil {
 		return err
 	}
@@ -161,7 +161,7 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 		o.Subject = ""
 		funcs = a.manager.TemplateFuncs(nil)
 	} else {
-		funcs = a.manager.GenericTemplateFuncs()
+		funcs = a.manager.TxTemplateFuncs()
 	}
 
 	// Compile the template and validate.
@@ -171,7 +171,7 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 
 	// Update the template in the DB.
 	id := getID(c)
-	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource, o.Security)
 	if err != nil {
 		return err
 	}
@@ -226,9 +226,24 @@ func (a *App) validateTemplate(o models.Template) error {
 			a.i18n.Ts("globals.messages.missingFields", "name", "subject"))
 	}
 
+	if !isValidSecurity(o.Security) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security"))
+	}
+
 	return nil
 }
 
+// isValidSecurity checks if the given string is a message security policy.
+// An empty policy is valid and falls back to the default.
+func isValidSecurity(s string) bool {
+	switch s {
+	case "", models.SecurityNone, models.SecuritySign, models.SecurityEncrypt, models.SecurityEncryptRequired:
+		return true
+	}
+	return false
+}
+
 // previewTemplate renders the HTML preview of a template.
 func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
 	var out []byte
@@ -256,7 +271,7 @@ func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
 		out = msg.Body()
 	} else {
 		// Compile transactional template.
-		if err := tpl.Compile(a.manager.GenericTemplateFuncs()); err != nil {
+		if err := tpl.Compile(a.manager.TxTemplateFuncs()); err != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 // SendTxMessage handles the sending of a transactional message.
@@ -87,82 +98,49 @@ func (a *App) SendTxMessage(c echo.Context) error {
 
 	notFound := []string{}
 	for n := range num {
-		var sub models.Subscriber
-
-		if m.SubscriberMode == models.TxSubModeExternal {
-			// `external`: Always create an ephemeral "subscriber" and don't
-			// lookup in the DB.
-			sub = models.Subscriber{
-				Email: m.SubscriberEmails[n],
-			}
+		var (
+			subID    int
+			subEmail string
+		)
+		if !isEmails {
+			subID = m.SubscriberIDs[n]
 		} else {
-			// Default/fallback mode: lookup subscriber in DB.
-			var (
-				subID    int
-				subEmail string
-			)
-
-			if !isEmails {
-				subID = m.SubscriberIDs[n]
-			} else {
-				subEmail = m.SubscriberEmails[n]
-			}
+			subEmail = m.SubscriberEmails[n]
+		}
 
-			var err error
-			sub, err = a.core.GetSubscriber(subID, "", subEmail)
-			if err != nil {
-				if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
-					// `fallback`: Create an ephemeral "subscriber" if the subscriber wasn't found.
-					if m.SubscriberMode == models.TxSubModeFallback {
-						sub = models.Subscriber{
-							Email: subEmail,
-						}
-					} else {
-						// `default`: log error and continue.
-						notFound = append(notFound, fmt.Sprintf("%v", er.Message))
-						continue
-					}
-				} else {
-					return err
-				}
+		sub, err := a.getTxSubscriber(m.SubscriberMode, subID, subEmail)
+		if err != nil {
+			// `default`: log error and continue.
+			if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
+				notFound = append(notFound, fmt.Sprintf("%v", er.Message))
+				continue
 			}
+			return err
 		}
 
 		// Render the message.
+		m.UUID = uuid.Must(uuid.NewV4()).String()
 		if err := m.Render(sub, tpl); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("globals.messages.errorFetching", "name"))
 		}
 
-		// Prepare the final message.
-		msg := models.Message{}
-		msg.Subscriber = sub
-		msg.To = []string{sub.Email}
-		msg.From = m.FromEmail
-		msg.Subject = m.Subject
-		msg.ContentType = m.ContentType
-		msg.Messenger = m.Messenger
-		msg.Body = m.Body
-		for _, a := range m.Attachments {
-			msg.Attachments = append(msg.Attachments, models.Attachment{
-				Name:    a.Name,
-				Header:  a.Header,
-				Content: a.Content,
-			})
-		}
-
-		// Optional headers.
-		if len(m.Headers) != 0 {
-			msg.Headers = make(textproto.MIMEHeader, len(m.Headers))
-			for _, set := range m.Headers {
-				for hdr, val := range set {
-					msg.Headers.Add(hdr, val)
-				}
-			}
+		// Record the message in the log.
+		if err := a.core.InsertTxMessages("", m.TemplateID, m.Messenger, "", []models.TxMessageLog{{
+			UUID:         m.UUID,
+			SubscriberID: null.NewInt(sub.ID, sub.ID > 0),
+			Email:        sub.Email,
+			Subject:      m.Subject,
+		}}); err != nil {
+			return err
 		}
 
+		msg := makeTxMessage(m, sub)
+		msg.TxUUID = m.UUID
+		msg.Security = tpl.Security
 		if err := a.manager.PushMessage(msg); err != nil {
-			a.log.Printf("error sending message (%s): %v", msg.Subject, err)
+			a.log.Printf("error sending message (%s): %v", m.Subject, err)
+			a.failTxMessage(m.UUID, "", err)
 			return err
 		}
 	}
@@ -174,6 +152,61 @@ func (a *App) SendTxMessage(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// getTxSubscriber returns the subscriber a tx message is sent to based on the
+// subscriber mode. In the default mode, a subscriber that isn't found in
+// the DB is a 400 error.
+func (a *App) getTxSubscriber(mode string, subID int, subEmail string) (models.Subscriber, error) {
+	// `external`: Always create an ephemeral "subscriber" and don't
+	// lookup in the DB.
+	if mode == models.TxSubModeExternal {
+		return models.Subscriber{Email: subEmail}, nil
+	}
+
+	// Default/fallback mode: lookup subscriber in DB.
+	sub, err := a.core.GetSubscriber(subID, "", subEmail)
+	if err != nil {
+		// `fallback`: Create an ephemeral "subscriber" if the subscriber wasn't found.
+		if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest && mode == models.TxSubModeFallback {
+			return models.Subscriber{Email: subEmail}, nil
+		}
+		return sub, err
+	}
+
+	return sub, nil
+}
+
+// makeTxMessage prepares the final message to push to a messenger from
+// a rendered tx message.
+func makeTxMessage(m models.TxMessage, sub models.Subscriber) models.Message {
+	msg := models.Message{}
+	msg.Subscriber = sub
+	msg.To = []string{sub.Email}
+	msg.From = m.FromEmail
+	msg.Subject = m.Subject
+	msg.ContentType = m.ContentType
+	msg.Messenger = m.Messenger
+	msg.Body = m.Body
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, models.Attachment{
+			Name:    a.Name,
+			Header:  a.Header,
+			Content: a.Content,
+		})
+	}
+
+	// Optional headers.
+	if len(m.Headers) != 0 {
+		msg.Headers = make(textproto.MIMEHeader, len(m.Headers))
+		for _, set := range m.Headers {
+			for hdr, val := range set {
+				msg.Headers.Add(hdr, val)
+			}
+		}
+	}
+
+	return msg
+}
+
 // validateTxMessage validates the tx message fields.
 func (a *App) validateTxMessage(m models.TxMessage) (models.TxMessage, error) {
 	if len(m.SubscriberEmails) > 0 && m.SubscriberEmail != "" {
@@ -242,3 +275,467 @@ func (a *App) validateTxMessage(m models.TxMessage) (models.TxMessage, error) {
 
 	return m, nil
 }
+
+const (
+	// Maximum number of recipients in an async tx batch.
+	maxTxBatchSize = 1000
+
+	txCallbackTimeout = time.Second * 10
+
+	// Number of queued async tx messages that are picked up for sending at a time,
+	// the interval at which the queue is polled, and the duration after which
+	// messages that were picked up but whose status wasn't recorded are failed.
+	txQueueBatchSize = 100
+	txQueueInterval  = time.Second * 5
+	txQueueStaleAge  = time.Minute * 30
+
+	// txLogCronPurge is the daily cron schedule for purging tx message logs past the retention period.
+	txLogCronPurge = "30 3 * * *"
+)
+
+// txBatchResult is the result of queueing a recipient in an async tx batch.
+type txBatchResult struct {
+	ID              string `json:"id,omitempty"`
+	SubscriberEmail string `json:"subscriber_email,omitempty"`
+	SubscriberID    int    `json:"subscriber_id,omitempty"`
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+}
+
+// txBatchMsg is a validated message in an async tx batch that's stored as
+// the payload of its log entry until it's picked up by the queue worker.
+type txBatchMsg struct {
+	Msg models.TxMessage  `json:"message"`
+	Sub models.Subscriber `json:"subscriber"`
+}
+
+// SendTxBatch handles the queueing of a batch of asynchronous transactional
+// messages with per-recipient data. Recipients are validated, recorded, and
+// queued in the DB, and the ID of each queued message is returned. The messages
+// are rendered and pushed by the queue worker (runTxQueue) and their statuses
+// can be retrieved with GetTxMessage and GetTxBatch.
+func (a *App) SendTxBatch(c echo.Context) error {
+	var b models.TxBatch
+	if err := c.Bind(&b); err != nil {
+		return err
+	}
+
+	if len(b.Messages) == 0 || len(b.Messages) > maxTxBatchSize {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("messages (1 - %d)", maxTxBatchSize)))
+	}
+
+	if b.CallbackURL != "" {
+		if !a.txCallbacks.validURL(b.CallbackURL) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "callback_url"))
+		}
+	}
+
+	// Check that the tx template exists.
+	if _, err := a.manager.GetTpl(b.TemplateID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", fmt.Sprintf("template %d", b.TemplateID)))
+	}
+
+	var (
+		batchUUID = uuid.Must(uuid.NewV4()).String()
+		out       = make([]txBatchResult, len(b.Messages))
+		logs      = make([]models.TxMessageLog, 0, len(b.Messages))
+	)
+	for n, r := range b.Messages {
+		out[n] = txBatchResult{SubscriberEmail: r.SubscriberEmail, SubscriberID: r.SubscriberID, Status: models.TxStatusFailed}
+
+		m, err := a.validateTxMessage(models.TxMessage{
+			SubscriberMode:  b.SubscriberMode,
+			SubscriberEmail: r.SubscriberEmail,
+			SubscriberID:    r.SubscriberID,
+			TemplateID:      b.TemplateID,
+			Data:            r.Data,
+			FromEmail:       b.FromEmail,
+			Headers:         b.Headers,
+			ContentType:     b.ContentType,
+			Messenger:       b.Messenger,
+			Subject:         b.Subject,
+		})
+		if err != nil {
+			out[n].Error = txErrMsg(err)
+			continue
+		}
+
+		var (
+			subID    int
+			subEmail string
+		)
+		if len(m.SubscriberIDs) > 0 {
+			subID = m.SubscriberIDs[0]
+		} else {
+			subEmail = m.SubscriberEmails[0]
+		}
+
+		sub, err := a.getTxSubscriber(m.SubscriberMode, subID, subEmail)
+		if err != nil {
+			out[n].Error = txErrMsg(err)
+			continue
+		}
+
+		payload, err := json.Marshal(txBatchMsg{Msg: m, Sub: sub})
+		if err != nil {
+			a.log.Printf("error marshalling tx message: %v", err)
+			out[n].Error = err.Error()
+			continue
+		}
+
+		id := uuid.Must(uuid.NewV4()).String()
+		out[n].ID = id
+		out[n].Status = models.TxStatusQueued
+
+		logs = append(logs, models.TxMessageLog{
+			UUID:         id,
+			SubscriberID: null.NewInt(sub.ID, sub.ID > 0),
+			Email:        sub.Email,
+			Payload:      payload,
+		})
+	}
+
+	if len(logs) > 0 {
+		messenger := b.Messenger
+		if messenger == "" {
+			messenger = emailMsgr
+		}
+		if err := a.core.InsertTxMessages(batchUUID, b.TemplateID, messenger, b.CallbackURL, logs); err != nil {
+			return err
+		}
+
+		// Wake up the queue worker.
+		select {
+		case a.chTxQueue <- true:
+		default:
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		BatchID  string          `json:"batch_id"`
+		Messages []txBatchResult `json:"messages"`
+	}{batchUUID, out}})
+}
+
+// GetTxMessage returns the log entry of a tx message with its delivery status,
+// views, and clicks.
+func (a *App) GetTxMessage(c echo.Context) error {
+	id := c.Param("uuid")
+	if !reUUID.MatchString(id) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	out, err := a.core.GetTxMessage(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// QueryTxMessages searches the transactional message log.
+func (a *App) QueryTxMessages(c echo.Context) error {
+	var (
+		templateID, _ = strconv.Atoi(c.QueryParam("template_id"))
+		subID, _      = strconv.Atoi(c.QueryParam("subscriber_id"))
+		email         = strings.TrimSpace(c.QueryParam("email"))
+		status        = c.QueryParam("status")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	from, err := parseAuditDate(c.QueryParam("from"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "from"))
+	}
+	to, err := parseAuditDate(c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "to"))
+	}
+
+	res, total, err := a.core.QueryTxMessages(email, status, templateID, subID, from, to, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// No results.
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.TxMessageLog{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetTxBatch returns the delivery statuses of all the messages in an async tx batch.
+func (a *App) GetTxBatch(c echo.Context) error {
+	id := c.Param("uuid")
+	if !reUUID.MatchString(id) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	out, err := a.core.GetTxBatch(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// runTxQueue is the queue worker that picks up queued async tx messages from
+// the DB and pushes them to the manager. It runs when it's woken up by
+// SendTxBatch or at a regular interval to pick up messages queued by other
+// instances or before a restart. Pushing blocks while the manager's queue is
+// full, which throttles the worker. Messages that were picked up but whose
+// status was never recorded, eg: due to a restart, are marked as failed.
+func (a *App) runTxQueue() {
+	a.failStaleTxMessages()
+
+	var (
+		poll  = time.NewTicker(txQueueInterval)
+		stale = time.NewTicker(txQueueStaleAge / 2)
+	)
+	defer poll.Stop()
+	defer stale.Stop()
+
+	for {
+		select {
+		case <-a.chTxQueue:
+		case <-poll.C:
+		case <-stale.C:
+			a.failStaleTxMessages()
+			continue
+		}
+
+		// Keep picking up messages until the queue is drained.
+		for {
+			msgs, err := a.core.NextTxMessages(txQueueBatchSize)
+			if err != nil {
+				break
+			}
+
+			for _, m := range msgs {
+				a.pushTxMessage(m)
+			}
+
+			if len(msgs) < txQueueBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// pushTxMessage renders a queued async tx message and pushes it to the
+// manager's queue. Messages that can't be rendered or pushed are marked
+// as failed. The statuses of pushed messages are recorded by the manager.
+func (a *App) pushTxMessage(m models.TxMessageLog) {
+	var b txBatchMsg
+	if err := json.Unmarshal(m.Payload, &b); err != nil {
+		a.failTxMessage(m.UUID, "", fmt.Errorf("error reading queued message: %v", err))
+		return
+	}
+
+	tpl, err := a.manager.GetTpl(int(m.TemplateID.Int))
+	if err != nil {
+		a.failTxMessage(m.UUID, "", fmt.Errorf("error fetching template: %v", err))
+		return
+	}
+
+	b.Msg.UUID = m.UUID
+	if err := b.Msg.Render(b.Sub, tpl); err != nil {
+		a.failTxMessage(m.UUID, "", fmt.Errorf("error rendering message: %v", err))
+		return
+	}
+
+	msg := makeTxMessage(b.Msg, b.Sub)
+	msg.TxUUID = m.UUID
+	msg.Security = tpl.Security
+	if err := a.manager.PushMessage(msg); err != nil {
+		a.failTxMessage(m.UUID, b.Msg.Subject, err)
+	}
+}
+
+// failStaleTxMessages marks tx messages that were picked up for sending but
+// whose status was never recorded as failed.
+func (a *App) failStaleTxMessages() {
+	msgs, err := a.core.FailStaleTxMessages(txQueueStaleAge, "message was interrupted before it could be sent")
+	if err != nil {
+		return
+	}
+
+	for _, m := range msgs {
+		a.log.Printf("tx message %s was interrupted before it could be sent", m.UUID)
+		if m.CallbackURL != "" {
+			go a.txCallbacks.post(m)
+		}
+	}
+}
+
+// failTxMessage marks a tx message as failed.
+func (a *App) failTxMessage(id, subject string, sendErr error) {
+	a.log.Printf("error sending tx message %s: %v", id, sendErr)
+
+	m, err := a.core.UpdateTxMessage(id, models.TxStatusFailed, sendErr.Error(), subject)
+	if err != nil {
+		return
+	}
+	if m.CallbackURL != "" {
+		go a.txCallbacks.post(m)
+	}
+}
+
+// txCallbacks POSTs the delivery statuses of async tx messages to their
+// callback URLs.
+type txCallbacks struct {
+	client *http.Client
+
+	// Optional secret with which callback bodies are signed.
+	secret []byte
+
+	// Optional allowlist of callback hosts. If it's empty, any host that
+	// resolves to a public address is allowed.
+	hosts map[string]struct{}
+}
+
+// newTxCallbacks returns a txCallbacks that signs callback bodies with the given
+// secret (optional) and only posts to the given hosts (optional). Without an
+// allowlist, connections to loopback, private, link-local, and other
+// non-public addresses are refused when they're dialed, so that callbacks
+// can't be used to reach internal services. Allowlisted hosts are trusted
+// and may resolve to internal addresses.
+func newTxCallbacks(secret string, hosts []string) *txCallbacks {
+	cb := &txCallbacks{
+		secret: []byte(secret),
+		hosts:  make(map[string]struct{}, len(hosts)),
+	}
+	for _, h := range hosts {
+		cb.hosts[strings.ToLower(h)] = struct{}{}
+	}
+
+	dialer := &net.Dialer{Timeout: txCallbackTimeout}
+	if len(cb.hosts) == 0 {
+		dialer.Control = checkTxCallbackAddr
+	}
+
+	cb.client = &http.Client{
+		Timeout: txCallbackTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: txCallbackTimeout,
+		},
+
+		// Redirects could point anywhere.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return cb
+}
+
+// validURL checks whether a callback URL is an HTTP(S) URL with an allowed host.
+// Hostnames are only resolved and checked when they're dialed.
+func (cb *txCallbacks) validURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(cb.hosts) > 0 {
+		_, ok := cb.hosts[host]
+		return ok
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return false
+	}
+
+	return true
+}
+
+// post POSTs the delivery status of an async tx message to its callback URL.
+// If there's a secret, the body is signed with HMAC-SHA256 and the signature
+// is sent in the X-Listmonk-Signature header as t={unix_timestamp},v1={hex_signature}.
+func (cb *txCallbacks) post(m models.TxMessageLog) {
+	if !cb.validURL(m.CallbackURL) {
+		lo.Printf("tx callback URL for %s isn't allowed", m.UUID)
+		return
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		lo.Printf("error marshalling tx callback: %v", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.CallbackURL, bytes.NewReader(b))
+	if err != nil {
+		lo.Printf("error creating tx callback request for %s: %v", m.UUID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(cb.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+		mac := hmac.New(sha256.New, cb.secret)
+		mac.Write([]byte(ts + "."))
+		mac.Write(b)
+		req.Header.Set("X-Listmonk-Signature", fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil))))
+	}
+
+	resp, err := cb.client.Do(req)
+	if err != nil {
+		lo.Printf("error posting tx callback for %s: %v", m.UUID, err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		lo.Printf("tx callback for %s returned status %d", m.UUID, resp.StatusCode)
+	}
+}
+
+// checkTxCallbackAddr is a dialer control function that refuses connections
+// to non-public IP addresses.
+func checkTxCallbackAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("connecting to %s isn't allowed", host)
+	}
+
+	return nil
+}
+
+// isPublicIP checks whether an IP address is a public unicast address.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// txErrMsg returns the message of an (HTTP) error for per-recipient results.
+func txErrMsg(err error) string {
+	if er, ok := err.(*echo.HTTPError); ok {
+		return fmt.Sprintf("%v", er.Message)
+	}
+	return err.Error()
+}
@@ -179,6 +179,8 @@ export const getSubscriberActivity = async (id) => http.get(
   { loading: models.subscribers },
 );
 
+export const getScheduledExports = async () => http.get('/api/subscribers/exports');
+
 export const getSubscriberBounces = async (id) => http.get(
   `/api/subscribers/${id}/bounces`,
   { loading: models.bounces },
@@ -266,7 +268,10 @@ export const deleteSubscribersByQuery = (data) => http.post(
 // Subscriber import.
 export const importSubscribers = (data) => http.post('/api/import/subscribers', data);
 
-export const getImportStatus = () => http.get('/api/import/subscribers');
+export const getImportStatus = () => http.get(
+  '/api/import/subscribers',
+  { camelCase: (keyPath) => !keyPath.startsWith('.report.reasons.') },
+);
 
 export const getImportLogs = async () => http.get(
   '/api/import/subscribers/logs',
@@ -275,6 +280,18 @@ export const getImportLogs = async () => http.get(
 
 export const stopImport = () => http.delete('/api/import/subscribers');
 
+export const getImportJobs = async (params) => http.get(
+  '/api/import/jobs',
+  { params, camelCase: (keyPath) => !keyPath.match(/^\.results\.\*\.(options|report)\./) },
+);
+
+export const getImportJob = async (id) => http.get(
+  `/api/import/jobs/${id}`,
+  { camelCase: (keyPath) => !keyPath.match(/^\.(options|report)\./) },
+);
+
+export const stopImportJob = (id) => http.delete(`/api/import/jobs/${id}`);
+
 // Bounces.
 export const getBounces = async (params) => http.get(
   '/api/bounces',
@@ -375,11 +392,23 @@ export const uploadMedia = (data) => http.post(
   { loading: models.media },
 );
 
-export const deleteMedia = (id) => http.delete(
+export const updateMedia = (id, data) => http.put(
   `/api/media/${id}`,
+  data,
   { loading: models.media },
 );
 
+export const getMediaFolders = async () => http.get('/api/media/folders');
+
+export const getMediaUsage = async (id) => http.get(`/api/media/${id}/usage`);
+
+export const getMediaStorage = async () => http.get('/api/media/storage');
+
+export const deleteMedia = (id, params) => http.delete(
+  `/api/media/${id}`,
+  { params, loading: models.media },
+);
+
 // Templates.
 export const createTemplate = async (data) => http.post(
   '/api/templates',
@@ -437,6 +466,11 @@ export const updateSettingsByKey = async (key, data) => http.put(
   { loading: models.settings },
 );
 
+export const getMessengersHealth = async () => http.get(
+  '/api/settings/messengers/health',
+  { camelCase: false, disableToast: true },
+);
+
 export const testSMTP = async (data) => http.post(
   '/api/settings/smtp/test',
   data,
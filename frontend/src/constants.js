@@ -32,6 +32,7 @@ export const uris = Object.freeze({
   previewTemplate: '/api/templates/:id/preview',
   previewRawTemplate: '/api/templates/preview',
   exportSubscribers: '/api/subscribers/export',
+  importRejects: '/api/import/subscribers/rejects',
   errorEvents: '/api/events?type=error',
   base: `${baseURL}/static`,
   root: rootURL,
@@ -48,6 +49,15 @@ export const timestamp = 'ddd D MMM YYYY, hh:mm A';
 
 export const colors = Object.freeze({
   primary: '#0055d4',
+  danger: '#ff5722',
 });
 
 export const regDuration = '[0-9]+(ms|s|m|h|d)';
+
+// E-mail signing and encryption policies and their i18n labels.
+export const securityPolicies = Object.freeze({
+  none: 'templates.securityNone',
+  sign: 'templates.securitySign',
+  encrypt: 'templates.securityEncrypt',
+  encrypt_required: 'templates.securityEncryptRequired',
+});
@@ -122,6 +122,19 @@ export default class Utils {
     return this.intlNumFormat.format(v);
   }
 
+  // Format a size in bytes as a human readable string, eg: 1.5 MB.
+  niceBytes = (n) => {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let i = 0;
+    let v = n || 0;
+    while (v >= 1024 && i < units.length - 1) {
+      v /= 1024;
+      i += 1;
+    }
+
+    return `${i === 0 ? v : v.toFixed(1)} ${units[i]}`;
+  };
+
   // Parse one or more numeric ids as query params and return as an array of ints.
   parseQueryIDs = (ids) => {
     if (!ids) {
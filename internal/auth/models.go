@@ -52,6 +52,7 @@ const (
 	PermSubscribersImport     = "subscribers:import"
 	PermSubscribersSqlQuery   = "subscribers:sql_query"
 	PermTxSend                = "tx:send"
+	PermTxGet                 = "tx:get"
 	PermCampaignsGet          = "campaigns:get"
 	PermCampaignsGetAll       = "campaigns:get_all"
 	PermCampaignsGetAnalytics = "campaigns:get_analytics"
@@ -71,6 +72,7 @@ const (
 	PermSettingsGet           = "settings:get"
 	PermSettingsManage        = "settings:manage"
 	PermSettingsMaintain      = "settings:maintain"
+	PermAuditGet              = "audit:get"
 )
 
 // Base holds common fields shared across models.
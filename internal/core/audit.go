@@ -0,0 +1,52 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// InsertAuditLog records an audit log entry.
+func (c *Core) InsertAuditLog(l models.AuditLog) error {
+	if _, err := c.q.InsertAuditLog.Exec(l.UserID.Int, l.Username, l.Action, l.TargetType,
+		l.TargetID.Int, l.Route, l.Diff, l.IP); err != nil {
+		c.log.Printf("error inserting audit log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.auditLog}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// QueryAuditLogs retrieves paginated audit log entries based on the given filters.
+// It also returns the total number of matching entries in the DB.
+func (c *Core) QueryAuditLogs(userID int, action, targetType string, targetID int, from, to null.Time, offset, limit int) ([]models.AuditLog, int, error) {
+	out := []models.AuditLog{}
+	if err := c.q.QueryAuditLogs.Select(&out, userID, action, targetType, targetID, from, to, offset, limit); err != nil {
+		c.log.Printf("error fetching audit logs: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.auditLog}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// DeleteAuditLogs deletes audit log entries older than the given number of days.
+func (c *Core) DeleteAuditLogs(days int) (int, error) {
+	res, err := c.q.DeleteAuditLogs.Exec(days)
+	if err != nil {
+		c.log.Printf("error deleting audit logs: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.auditLog}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
@@ -192,6 +192,8 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
 		o.BodySource,
+		o.SubAttachments,
+		o.Security,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
@@ -231,7 +233,9 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
-		o.BodySource)
+		o.BodySource,
+		o.SubAttachments,
+		o.Security)
 	if err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -421,6 +425,74 @@ func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate st
 	return out, nil
 }
 
+// GetCampaignLinkStats returns the total and unique click counts of all links clicked in a campaign.
+func (c *Core) GetCampaignLinkStats(campID int) ([]models.CampaignLinkStats, error) {
+	out := []models.CampaignLinkStats{}
+	if err := c.q.GetCampaignLinkStats.Select(&out, campID); err != nil {
+		c.log.Printf("error fetching campaign link stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignAnalyticsSummary returns the aggregate analytics of the given campaign IDs,
+// or if there are no IDs, of campaigns created within the given date range.
+func (c *Core) GetCampaignAnalyticsSummary(campIDs []int, fromDate, toDate string, getAll bool, permittedLists []int) ([]models.CampaignAnalyticsSummary, error) {
+	if campIDs == nil {
+		campIDs = []int{}
+	}
+	if len(campIDs) == 0 && (!strHasLen(fromDate, 10, 30) || !strHasLen(toDate, 10, 30)) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("analytics.invalidDates"))
+	}
+
+	// Dates are irrelevant when there are campaign IDs.
+	if len(campIDs) > 0 {
+		fromDate, toDate = "-infinity", "infinity"
+	}
+
+	out := []models.CampaignAnalyticsSummary{}
+	if err := c.q.GetCampaignAnalyticsSummary.Select(&out, pq.Array(campIDs), fromDate, toDate, getAll, pq.Array(permittedLists)); err != nil {
+		c.log.Printf("error fetching campaign analytics summary: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// ExportCampaignAnalytics returns a batched iterator that returns per-subscriber analytics
+// rows of the given campaigns, one campaign after the other. An empty batch indicates the end.
+func (c *Core) ExportCampaignAnalytics(campIDs []int, batchSize int) func() ([]models.CampaignAnalyticsExport, error) {
+	var (
+		n     = 0
+		subID = 0
+	)
+	return func() ([]models.CampaignAnalyticsExport, error) {
+		for n < len(campIDs) {
+			var out []models.CampaignAnalyticsExport
+			if err := c.q.ExportCampaignSubscriberAnalytics.Select(&out, campIDs[n], subID, batchSize); err != nil {
+				c.log.Printf("error exporting campaign analytics: %v", err)
+				return nil, echo.NewHTTPError(http.StatusInternalServerError,
+					c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+			}
+
+			// This campaign is exhausted. Move on to the next one.
+			if len(out) == 0 {
+				n++
+				subID = 0
+				continue
+			}
+
+			subID = out[len(out)-1].SubscriberID
+			return out, nil
+		}
+
+		return nil, nil
+	}
+}
+
 // RegisterCampaignView registers a subscriber's view on a campaign.
 func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
 	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
@@ -1,12 +1,19 @@
 package core
 
 import (
+	"encoding/json"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+// digestReportLimit is the max number of lists, campaigns, and domains in a digest report.
+const digestReportLimit = 10
+
 // GetDashboardCharts returns chart data points to render on the dashboard.
 func (c *Core) GetDashboardCharts() (types.JSONText, error) {
 	_ = c.refreshCache(matDashboardCharts, false)
@@ -32,3 +39,47 @@ func (c *Core) GetDashboardCounts() (types.JSONText, error) {
 
 	return out, nil
 }
+
+// GetDigestReport returns the summary report of the given period for admin digests.
+// If uniqueCounts is true, campaign views and clicks are counted once per subscriber.
+func (c *Core) GetDigestReport(from, to time.Time, uniqueCounts bool) (models.DigestReport, error) {
+	var b types.JSONText
+	if err := c.q.GetDigestReport.Get(&b, from, to, uniqueCounts, digestReportLimit); err != nil {
+		c.log.Printf("error fetching digest report: %v", err)
+		return models.DigestReport{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	var out models.DigestReport
+	if err := json.Unmarshal(b, &out); err != nil {
+		c.log.Printf("error unmarshalling digest report: %v", err)
+		return models.DigestReport{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", err.Error()))
+	}
+
+	// Compute the rates.
+	if n := out.Messages.Sent; n > 0 {
+		out.Messages.BounceRate = percent(out.Messages.Bounces, n)
+		out.Messages.ComplaintRate = percent(out.Messages.Complaints, n)
+	}
+	for _, camps := range [][]models.DigestCampaign{out.TopOpened, out.TopClicked} {
+		for i, cm := range camps {
+			camps[i].OpenRate = percent(cm.Views, cm.Sent)
+			camps[i].ClickRate = percent(cm.Clicks, cm.Sent)
+		}
+	}
+	for i, d := range out.Domains {
+		out.Domains[i].BounceRate = percent(d.Bounces, d.Sent)
+		out.Domains[i].ComplaintRate = percent(d.Complaint, d.Sent)
+	}
+
+	return out, nil
+}
+
+// percent returns n as a percentage of total rounded to two decimals.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*10000) / 100
+}
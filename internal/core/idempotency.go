@@ -0,0 +1,81 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// CreateIdempotencyKey creates an in-progress idempotency key with the hash of its
+// request that expires after lease. It returns false if the (unexpired) key exists.
+func (c *Core) CreateIdempotencyKey(key, reqHash string, lease time.Duration) (bool, error) {
+	var out string
+	if err := c.q.CreateIdempotencyKey.Get(&out, key, reqHash, int(lease.Seconds())); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		c.log.Printf("error creating idempotency key: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.idempotencyKey}", "error", pqErrMsg(err)))
+	}
+
+	return true, nil
+}
+
+// GetIdempotencyKey retrieves an idempotency key and its stored response.
+func (c *Core) GetIdempotencyKey(key string) (models.IdempotencyKey, error) {
+	var out models.IdempotencyKey
+	if err := c.q.GetIdempotencyKey.Get(&out, key); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.idempotencyKey}"))
+		}
+
+		c.log.Printf("error fetching idempotency key: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.idempotencyKey}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpdateIdempotencyKey stores the response of the request of an idempotency key
+// and extends its expiry to ttl.
+func (c *Core) UpdateIdempotencyKey(key string, statusCode int, contentType string, resp []byte, ttl time.Duration) error {
+	if _, err := c.q.UpdateIdempotencyKey.Exec(key, statusCode, contentType, resp, int(ttl.Seconds())); err != nil {
+		c.log.Printf("error updating idempotency key: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.idempotencyKey}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency key.
+func (c *Core) DeleteIdempotencyKey(key string) error {
+	if _, err := c.q.DeleteIdempotencyKey.Exec(key); err != nil {
+		c.log.Printf("error deleting idempotency key: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.idempotencyKey}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes expired idempotency keys and
+// returns the number of keys deleted.
+func (c *Core) DeleteExpiredIdempotencyKeys() (int, error) {
+	res, err := c.q.DeleteExpiredIdempotencyKeys.Exec()
+	if err != nil {
+		c.log.Printf("error deleting expired idempotency keys: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.idempotencyKey}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
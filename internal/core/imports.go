@@ -0,0 +1,61 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// QueryImportJobs retrieves paginated import jobs, optionally filtered by status.
+// It also returns the total number of matching jobs in the DB.
+func (c *Core) QueryImportJobs(status string, offset, limit int) ([]models.ImportJob, int, error) {
+	out := []models.ImportJob{}
+	if err := c.q.QueryImportJobs.Select(&out, status, offset, limit); err != nil {
+		c.log.Printf("error fetching import jobs: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.importJob}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].JobCount
+	}
+	for n := range out {
+		out[n].HasRejects = out[n].RejectsFile != ""
+	}
+
+	return out, total, nil
+}
+
+// GetImportJob retrieves an import job by its ID.
+func (c *Core) GetImportJob(id int) (models.ImportJob, error) {
+	var out models.ImportJob
+	if err := c.q.GetImportJob.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.importJob}"))
+		}
+
+		c.log.Printf("error fetching import job: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.importJob}", "error", pqErrMsg(err)))
+	}
+	out.HasRejects = out.RejectsFile != ""
+
+	return out, nil
+}
+
+// DeleteImportJobs deletes finished import jobs older than the given number of
+// days and returns the paths of their rejects files.
+func (c *Core) DeleteImportJobs(days int) ([]string, error) {
+	var out []string
+	if err := c.q.DeleteImportJobs.Select(&out, days); err != nil {
+		c.log.Printf("error deleting import jobs: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.importJob}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
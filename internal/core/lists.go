@@ -2,6 +2,7 @@ package core
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/models"
@@ -118,6 +119,23 @@ func (c *Core) GetListsByOptin(ids []int, optinType string) ([]models.List, erro
 	return out, nil
 }
 
+// GetListsByNames returns lists by their names (case insensitive).
+func (c *Core) GetListsByNames(names []string) ([]models.List, error) {
+	lower := make([]string, len(names))
+	for n, name := range names {
+		lower[n] = strings.ToLower(name)
+	}
+
+	out := []models.List{}
+	if err := c.q.GetListsByNames.Select(&out, pq.Array(lower)); err != nil {
+		c.log.Printf("error fetching lists by names: %s", pqErrMsg(err))
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // GetListTypes returns lists by their IDs or UUIDs.
 // If ids is given, then the map returned has the list IDs as keys,
 // otherwise, they have UUIDs as the keys.
@@ -2,7 +2,6 @@ package core
 
 import (
 	"database/sql"
-	"fmt"
 	"net/http"
 	"strings"
 
@@ -10,18 +9,24 @@ import (
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 	"gopkg.in/volatiletech/null.v6"
 )
 
-// QueryMedia returns media entries optionally filtered by a query string.
-func (c *Core) QueryMedia(provider string, s media.Store, query string, offset, limit int) ([]media.Media, int, error) {
+// QueryMedia returns media entries optionally filtered by a filename query string,
+// folder (null for all folders), tags, content type prefix, and a date range.
+func (c *Core) QueryMedia(provider string, s media.Store, query string, folder null.String, tags []string,
+	contentType string, from, to null.Time, offset, limit int) ([]media.Media, int, error) {
 	out := []media.Media{}
 
 	if query != "" {
-		query = strings.ToLower(query)
+		query = "%" + strings.ToLower(query) + "%"
+	}
+	if tags == nil {
+		tags = []string{}
 	}
 
-	if err := c.q.QueryMedia.Select(&out, fmt.Sprintf("%%%s%%", query), provider, offset, limit); err != nil {
+	if err := c.q.QueryMedia.Select(&out, query, provider, folder, pq.StringArray(tags), contentType, from, to, offset, limit); err != nil {
 		return out, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching",
 				"name", "{globals.terms.media}", "error", pqErrMsg(err)))
@@ -32,11 +37,7 @@ func (c *Core) QueryMedia(provider string, s media.Store, query string, offset,
 		total = out[0].Total
 
 		for i := 0; i < len(out); i++ {
-			out[i].URL = s.GetURL(out[i].Filename)
-
-			if out[i].Thumb != "" {
-				out[i].ThumbURL = null.String{Valid: true, String: s.GetURL(out[i].Thumb)}
-			}
+			out[i].SetURLs(s)
 		}
 	}
 
@@ -61,16 +62,15 @@ func (c *Core) GetMedia(id int, uuid, fileName string, s media.Store) (media.Med
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
 	}
 
-	out.URL = s.GetURL(out.Filename)
-	if out.Thumb != "" {
-		out.ThumbURL = null.String{Valid: true, String: s.GetURL(out.Thumb)}
-	}
+	out.SetURLs(s)
 
 	return out, nil
 }
 
-// InsertMedia inserts a new media file into the DB.
-func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.JSON, provider string, s media.Store) (media.Media, error) {
+// InsertMedia inserts a new media file into the DB. hash is the SHA-256 of the uploaded content,
+// size is the total size of the stored files, and userID (optional) is the uploader.
+func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.JSON, folder string, tags []string,
+	hash string, size int64, userID int, provider string, s media.Store) (media.Media, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -80,7 +80,8 @@ func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.
 
 	// Write to the DB.
 	var newID int
-	if err := c.q.InsertMedia.Get(&newID, uu, fileName, thumbName, contentType, provider, meta); err != nil {
+	if err := c.q.InsertMedia.Get(&newID, uu, fileName, thumbName, contentType, provider, meta, folder, pq.StringArray(tags),
+		hash, size, null.NewInt(userID, userID > 0)); err != nil {
 		c.log.Printf("error inserting uploaded file to db: %v", err)
 		return media.Media{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
@@ -89,6 +90,97 @@ func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.
 	return c.GetMedia(newID, "", "", s)
 }
 
+// GetMediaByHash returns the (first) media item of a provider with the given content hash.
+func (c *Core) GetMediaByHash(provider, hash string, s media.Store) (media.Media, error) {
+	var out media.Media
+	if err := c.q.GetMediaByHash.Get(&out, provider, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return out, ErrNotFound
+		}
+
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	out.SetURLs(s)
+
+	return out, nil
+}
+
+// AddMediaAlias records another name that a media item was uploaded as.
+func (c *Core) AddMediaAlias(id int, alias string) error {
+	if _, err := c.q.AddMediaAlias.Exec(id, alias); err != nil {
+		c.log.Printf("error adding media alias: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetMediaStorage returns the storage used by the media files of a provider, and by the given user.
+// If withUsers is true, the storage used by every user is also returned.
+func (c *Core) GetMediaStorage(provider string, userID int, withUsers bool) (media.Storage, error) {
+	var out media.Storage
+	if err := c.q.GetMediaStorage.Get(&out, provider, userID); err != nil {
+		c.log.Printf("error fetching media storage: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	if withUsers {
+		out.Users = []media.UserStorage{}
+		if err := c.q.GetMediaUserStorage.Select(&out.Users, provider); err != nil {
+			c.log.Printf("error fetching media user storage: %v", err)
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+		}
+	}
+
+	return out, nil
+}
+
+// UpdateMedia updates the folder and tags of a media item.
+func (c *Core) UpdateMedia(id int, folder string, tags []string, s media.Store) (media.Media, error) {
+	res, err := c.q.UpdateMedia.Exec(id, folder, pq.StringArray(tags))
+	if err != nil {
+		c.log.Printf("error updating media: %v", err)
+		return media.Media{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return media.Media{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.media}"))
+	}
+
+	return c.GetMedia(id, "", "", s)
+}
+
+// GetMediaFolders returns the media folders and the number of items in them.
+func (c *Core) GetMediaFolders(provider string) ([]media.Folder, error) {
+	out := []media.Folder{}
+	if err := c.q.GetMediaFolders.Select(&out, provider); err != nil {
+		c.log.Printf("error fetching media folders: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetMediaUsage returns the campaigns and templates that reference a media item.
+func (c *Core) GetMediaUsage(id int) ([]media.Usage, error) {
+	out := []media.Usage{}
+	if err := c.q.GetMediaUsage.Select(&out, id); err != nil {
+		c.log.Printf("error fetching media usage: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // DeleteMedia deletes a given media item and returns the filename of the deleted item.
 func (c *Core) DeleteMedia(id int) (string, error) {
 	var fname string
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// UpsertPushSubscription adds (or updates) a web push subscription of a subscriber.
+func (c *Core) UpsertPushSubscription(subUUID, endpoint, p256dh, auth string) error {
+	var id int64
+	if err := c.q.UpsertPushSubscription.Get(&id, subUUID, endpoint, p256dh, auth); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+		}
+
+		c.log.Printf("error upserting push subscription: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.pushSubscription}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetPushSubscriptions retrieves the web push subscriptions of a subscriber.
+func (c *Core) GetPushSubscriptions(subID int) ([]models.PushSubscription, error) {
+	out := []models.PushSubscription{}
+	if err := c.q.GetPushSubscriptions.Select(&out, subID); err != nil {
+		c.log.Printf("error fetching push subscriptions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.pushSubscription}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// DeletePushSubscription deletes a web push subscription by its endpoint.
+// If subUUID is set, it's only deleted if it belongs to the subscriber.
+func (c *Core) DeletePushSubscription(endpoint, subUUID string) error {
+	if _, err := c.q.DeletePushSubscription.Exec(endpoint, subUUID); err != nil {
+		c.log.Printf("error deleting push subscription: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.pushSubscription}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
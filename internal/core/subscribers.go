@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
@@ -232,7 +233,8 @@ func (c *Core) GetSubscriberActivity(id int) (models.SubscriberActivity, error)
 // on the given criteria in an exportable form. The iterator function returned can be called
 // repeatedly until there are nil subscribers. It's an iterator because exports can be extremely
 // large and may have to be fetched in batches from the DB and streamed somewhere.
-func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int, subStatus string, batchSize int) (func() ([]models.SubscriberExport, error), error) {
+// If withSubs is set, the list subscriptions of each subscriber are included.
+func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int, subStatus string, withSubs bool, batchSize int) (func() ([]models.SubscriberExport, error), error) {
 	if subIDs == nil {
 		subIDs = []int{}
 	}
@@ -266,7 +268,7 @@ func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int,
 	id := 0
 	return func() ([]models.SubscriberExport, error) {
 		var out []models.SubscriberExport
-		if err := tx.Select(&out, pq.Array(listIDs), id, pq.Array(subIDs), subStatus, searchStr, batchSize); err != nil {
+		if err := tx.Select(&out, pq.Array(listIDs), id, pq.Array(subIDs), subStatus, searchStr, batchSize, withSubs); err != nil {
 			c.log.Printf("error exporting subscribers by query: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError,
 				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -280,10 +282,80 @@ func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int,
 	}, nil
 }
 
+// ClaimScheduledExport claims the run of a scheduled export at the given
+// (scheduled) time and returns the ID of the export file record. ok is false
+// if the run has already been claimed, eg: by another instance.
+func (c *Core) ClaimScheduledExport(name string, runAt time.Time) (int64, bool, error) {
+	var id int64
+	if err := c.q.ClaimScheduledExport.Get(&id, name, runAt); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+
+		c.log.Printf("error claiming scheduled export: %v", err)
+		return 0, false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{subscribers.export}", "error", pqErrMsg(err)))
+	}
+
+	return id, true, nil
+}
+
+// UpdateScheduledExportFile records the file written by a scheduled export run.
+func (c *Core) UpdateScheduledExportFile(id int64, filename string, size int64) error {
+	if _, err := c.q.UpdateScheduledExportFile.Exec(id, filename, size); err != nil {
+		c.log.Printf("error updating scheduled export file: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{subscribers.export}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// DeleteScheduledExportFile deletes the record of a scheduled export run.
+func (c *Core) DeleteScheduledExportFile(id int64) error {
+	if _, err := c.q.DeleteScheduledExportFile.Exec(id); err != nil {
+		c.log.Printf("error deleting scheduled export file: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{subscribers.export}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetScheduledExportFiles returns the files written by scheduled exports, newest first.
+func (c *Core) GetScheduledExportFiles() ([]models.ScheduledExportFile, error) {
+	out := []models.ScheduledExportFile{}
+	if err := c.q.GetScheduledExportFiles.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching scheduled export files: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscribers.export}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetScheduledExportFile returns a file written by a scheduled export.
+func (c *Core) GetScheduledExportFile(id int64) (models.ScheduledExportFile, error) {
+	var out models.ScheduledExportFile
+	if err := c.q.GetScheduledExportFiles.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{subscribers.export}"))
+		}
+
+		c.log.Printf("error fetching scheduled export file: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscribers.export}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // InsertSubscriber inserts a subscriber and returns the ID. The first bool indicates if
 // it was a new subscriber, and the second bool indicates if the subscriber was sent an optin confirmation.
+// source is recorded in the subscription history (models.SubscriptionSource*).
 // bool = optinSent?
-func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, assertOptin bool) (models.Subscriber, bool, error) {
+func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, assertOptin bool, source string) (models.Subscriber, bool, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -316,7 +388,8 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 		sub.Attribs,
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
-		subStatus); err != nil {
+		subStatus,
+		source); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "subscribers_email_key" {
 			return models.Subscriber{}, false, echo.NewHTTPError(http.StatusConflict, c.i18n.T("subscribers.emailExists"))
 		} else {
@@ -385,7 +458,7 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 // UpdateSubscriberWithLists updates a subscriber's properties.
 // If deleteLists is set to true, all existing subscriptions are deleted and only
 // the ones provided are added or retained.
-func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, deleteLists, assertOptin bool) (models.Subscriber, bool, error) {
+func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, deleteLists, assertOptin bool, source string) (models.Subscriber, bool, error) {
 	subStatus := models.SubscriptionStatusUnconfirmed
 	if preconfirm {
 		subStatus = models.SubscriptionStatusConfirmed
@@ -411,7 +484,8 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
 		subStatus,
-		deleteLists)
+		deleteLists,
+		source)
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
 		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusInternalServerError,
@@ -437,8 +511,8 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 }
 
 // BlocklistSubscribers blocklists the given list of subscribers.
-func (c *Core) BlocklistSubscribers(subIDs []int) error {
-	if _, err := c.q.BlocklistSubscribers.Exec(pq.Array(subIDs)); err != nil {
+func (c *Core) BlocklistSubscribers(subIDs []int, source string) error {
+	if _, err := c.q.BlocklistSubscribers.Exec(pq.Array(subIDs), source); err != nil {
 		c.log.Printf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", err.Error()))
@@ -448,8 +522,8 @@ func (c *Core) BlocklistSubscribers(subIDs []int) error {
 }
 
 // BlocklistSubscribersByQuery blocklists the given list of subscribers.
-func (c *Core) BlocklistSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) error {
-	if err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus); err != nil {
+func (c *Core) BlocklistSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus, source string) error {
+	if err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus, source); err != nil {
 		c.log.Printf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// InsertTxMessages records queued transactional messages (UUID, subscriber ID, e-mail,
+// subject, and the optional queue payload) in the message log. batchUUID is empty
+// for messages that aren't sent via the async batch API.
+func (c *Core) InsertTxMessages(batchUUID string, templateID int, messenger, callbackURL string, msgs []models.TxMessageLog) error {
+	var (
+		uuids    = make([]string, len(msgs))
+		subIDs   = make([]int, len(msgs))
+		emails   = make([]string, len(msgs))
+		subjects = make([]string, len(msgs))
+		payloads = make([]string, len(msgs))
+	)
+	for n, m := range msgs {
+		uuids[n] = m.UUID
+		subIDs[n] = m.SubscriberID.Int
+		emails[n] = m.Email
+		subjects[n] = m.Subject
+		payloads[n] = string(m.Payload)
+	}
+
+	if _, err := c.q.InsertTxMessages.Exec(pq.Array(uuids), batchUUID, pq.Array(subIDs), pq.Array(emails),
+		pq.Array(subjects), templateID, messenger, callbackURL, pq.Array(payloads)); err != nil {
+		c.log.Printf("error inserting tx messages: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// UpdateTxMessage updates the delivery status and the rendered subject (optional)
+// of a transactional message and returns the updated log entry.
+func (c *Core) UpdateTxMessage(uuid, status, errMsg, subject string) (models.TxMessageLog, error) {
+	var out models.TxMessageLog
+	if err := c.q.UpdateTxMessage.Get(&out, uuid, status, errMsg, subject); err != nil {
+		c.log.Printf("error updating tx message: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// NextTxMessages picks up to limit queued async transactional messages for
+// sending and returns their UUIDs, template IDs, and payloads.
+func (c *Core) NextTxMessages(limit int) ([]models.TxMessageLog, error) {
+	out := []models.TxMessageLog{}
+	if err := c.q.NextTxMessages.Select(&out, limit); err != nil {
+		c.log.Printf("error fetching queued tx messages: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// FailStaleTxMessages marks transactional messages that were picked up for
+// sending but whose status wasn't recorded within the given duration as failed
+// and returns them.
+func (c *Core) FailStaleTxMessages(age time.Duration, errMsg string) ([]models.TxMessageLog, error) {
+	out := []models.TxMessageLog{}
+	if err := c.q.FailStaleTxMessages.Select(&out, age.Seconds(), errMsg); err != nil {
+		c.log.Printf("error updating stale tx messages: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetTxMessage retrieves a transactional message's log entry by its UUID.
+func (c *Core) GetTxMessage(uuid string) (models.TxMessageLog, error) {
+	var out models.TxMessageLog
+	if err := c.q.GetTxMessage.Get(&out, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.txMessage}"))
+		}
+
+		c.log.Printf("error fetching tx message: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetTxBatch retrieves all the transactional messages in a batch.
+func (c *Core) GetTxBatch(batchUUID string) ([]models.TxMessageLog, error) {
+	out := []models.TxMessageLog{}
+	if err := c.q.GetTxBatch.Select(&out, batchUUID); err != nil {
+		c.log.Printf("error fetching tx batch: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.txMessage}"))
+	}
+
+	return out, nil
+}
+
+// QueryTxMessages retrieves paginated transactional message log entries filtered by
+// e-mail, status, template, subscriber, and the optional from - to date range.
+// It also returns the total number of matching entries.
+func (c *Core) QueryTxMessages(email, status string, templateID, subID int, from, to null.Time, offset, limit int) ([]models.TxMessageLog, int, error) {
+	out := []models.TxMessageLog{}
+	if err := c.q.QueryTxMessages.Select(&out, email, status, templateID, subID, from, to, offset, limit); err != nil {
+		c.log.Printf("error fetching tx messages: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// RegisterTxView registers a view of a transactional message.
+func (c *Core) RegisterTxView(uuid string) error {
+	if _, err := c.q.RegisterTxView.Exec(uuid); err != nil {
+		c.log.Printf("error registering tx message view: %s", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// RegisterTxLinkClick registers a link click on a transactional message and
+// returns the link's URL.
+func (c *Core) RegisterTxLinkClick(linkUUID, uuid string) (string, error) {
+	var url string
+	if err := c.q.RegisterTxLinkClick.Get(&url, linkUUID, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
+		}
+
+		c.log.Printf("error registering tx link click: %s", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return url, nil
+}
+
+// DeleteTxMessages deletes transactional message log entries older than the
+// given number of days and returns the number of entries deleted.
+func (c *Core) DeleteTxMessages(days int) (int, error) {
+	res, err := c.q.DeleteTxMessages.Exec(days)
+	if err != nil {
+		c.log.Printf("error deleting tx messages: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.txMessage}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
@@ -0,0 +1,293 @@
+// Package htmltext converts HTML and plain text message bodies to plain text
+// and the markup formats of chat platforms.
+package htmltext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Format is a text markup format that message bodies are converted to.
+type Format int
+
+const (
+	// Plain is plain text with link URLs in parentheses.
+	Plain Format = iota
+
+	// Slack is Slack's mrkdwn.
+	Slack
+
+	// HTML is the small subset of inline HTML tags supported by
+	// Telegram and Matrix (b, i, u, s, code, pre, a, blockquote). Blocks
+	// are separated by line breaks.
+	HTML
+)
+
+var (
+	reSpaces   = regexp.MustCompile(`[\s\p{Zs}]+`)
+	reNewlines = regexp.MustCompile(`\n{3,}`)
+
+	slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// converter converts an HTML tree into a markup format.
+type converter struct {
+	f   Format
+	b   []byte
+	pre int
+
+	// Item counters of nested lists. -1 marks an unordered list.
+	lists []int
+}
+
+// FromHTML converts an HTML message body to the given format.
+func FromHTML(body string, f Format) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return Escape(body, f)
+	}
+
+	c := &converter{f: f}
+	c.walk(doc)
+
+	return strings.TrimSpace(reNewlines.ReplaceAllString(string(c.b), "\n\n"))
+}
+
+// FromPlain converts a plain text message body to the given format.
+func FromPlain(body string, f Format) string {
+	return strings.TrimSpace(Escape(strings.ReplaceAll(body, "\r\n", "\n"), f))
+}
+
+// Escape escapes text for the given format.
+func Escape(s string, f Format) string {
+	switch f {
+	case Slack:
+		return slackEscaper.Replace(s)
+	case HTML:
+		return html.EscapeString(s)
+	}
+
+	return s
+}
+
+func (c *converter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Style, atom.Script, atom.Title, atom.Img, atom.Meta, atom.Link, atom.Noscript:
+		return
+
+	case atom.Br:
+		c.write("\n")
+
+	case atom.P, atom.Table, atom.Hr:
+		c.nl(2)
+		c.children(n)
+		c.nl(2)
+
+	case atom.Div, atom.Tr, atom.Section, atom.Header, atom.Footer, atom.Article:
+		c.nl(1)
+		c.children(n)
+		c.nl(1)
+
+	case atom.Td, atom.Th:
+		c.children(n)
+		c.write(" ")
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.nl(2)
+		c.wrap(n, "*", "*", "<b>", "</b>")
+		c.nl(2)
+
+	case atom.Ul, atom.Ol:
+		num := -1
+		if n.DataAtom == atom.Ol {
+			num = 0
+		}
+		c.lists = append(c.lists, num)
+
+		c.nl(1)
+		c.children(n)
+		c.nl(1)
+
+		c.lists = c.lists[:len(c.lists)-1]
+
+	case atom.Li:
+		c.nl(1)
+		c.write(strings.Repeat("  ", max(len(c.lists)-1, 0)))
+		if l := len(c.lists); l > 0 && c.lists[l-1] >= 0 {
+			c.lists[l-1]++
+			c.write(strconv.Itoa(c.lists[l-1]) + ". ")
+		} else {
+			c.write("• ")
+		}
+		c.children(n)
+		c.nl(1)
+
+	case atom.B, atom.Strong:
+		c.wrap(n, "*", "*", "<b>", "</b>")
+
+	case atom.I, atom.Em:
+		c.wrap(n, "_", "_", "<i>", "</i>")
+
+	case atom.S, atom.Del, atom.Strike:
+		c.wrap(n, "~", "~", "<s>", "</s>")
+
+	case atom.U:
+		c.wrap(n, "", "", "<u>", "</u>")
+
+	case atom.Code:
+		if c.pre > 0 {
+			c.children(n)
+			return
+		}
+		c.wrap(n, "`", "`", "<code>", "</code>")
+
+	case atom.Pre:
+		c.nl(1)
+		c.pre++
+		switch c.f {
+		case Slack:
+			c.write("```\n" + c.sub(n) + "\n```")
+		case HTML:
+			c.write("<pre>" + c.sub(n) + "</pre>")
+		default:
+			c.write(c.sub(n))
+		}
+		c.pre--
+		c.nl(1)
+
+	case atom.Blockquote:
+		c.nl(2)
+		inner := strings.TrimSpace(c.sub(n))
+		if c.f == HTML {
+			c.write("<blockquote>" + inner + "</blockquote>")
+		} else {
+			c.write("> " + strings.ReplaceAll(inner, "\n", "\n> "))
+		}
+		c.nl(2)
+
+	case atom.A:
+		c.link(n)
+
+	default:
+		c.children(n)
+	}
+}
+
+func (c *converter) children(n *html.Node) {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.walk(ch)
+	}
+}
+
+// sub renders the children of a node separately and returns the output.
+func (c *converter) sub(n *html.Node) string {
+	b := c.b
+	c.b = nil
+	c.children(n)
+
+	out := string(c.b)
+	c.b = b
+	return out
+}
+
+// wrap writes the children of an inline node wrapped in the Slack or
+// HTML markers of the current format.
+func (c *converter) wrap(n *html.Node, slackL, slackR, htmlL, htmlR string) {
+	inner := strings.TrimSpace(c.sub(n))
+	if inner == "" {
+		return
+	}
+
+	switch c.f {
+	case Slack:
+		c.write(slackL + inner + slackR)
+	case HTML:
+		c.write(htmlL + inner + htmlR)
+	default:
+		c.write(inner)
+	}
+}
+
+// link writes a link. Non-HTTP links are written as text.
+func (c *converter) link(n *html.Node) {
+	var href string
+	for _, a := range n.Attr {
+		if a.Key == "href" {
+			href = strings.TrimSpace(a.Val)
+		}
+	}
+
+	text := strings.TrimSpace(c.sub(n))
+	if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "mailto:") {
+		c.write(text)
+		return
+	}
+
+	switch c.f {
+	case Slack:
+		if text == "" {
+			c.write("<" + slackEscaper.Replace(href) + ">")
+		} else {
+			c.write("<" + slackEscaper.Replace(href) + "|" + strings.ReplaceAll(text, "|", "¦") + ">")
+		}
+	case HTML:
+		if text == "" {
+			text = html.EscapeString(href)
+		}
+		c.write(`<a href="` + html.EscapeString(href) + `">` + text + "</a>")
+	default:
+		if text == "" || text == href {
+			c.write(href)
+		} else {
+			c.write(text + " (" + href + ")")
+		}
+	}
+}
+
+// text writes a text node, collapsing whitespace outside preformatted blocks.
+func (c *converter) text(s string) {
+	if c.pre == 0 {
+		s = reSpaces.ReplaceAllString(s, " ")
+		if len(c.b) == 0 || c.b[len(c.b)-1] == '\n' || c.b[len(c.b)-1] == ' ' {
+			s = strings.TrimLeft(s, " ")
+		}
+	}
+
+	c.write(Escape(s, c.f))
+}
+
+func (c *converter) write(s string) {
+	c.b = append(c.b, s...)
+}
+
+// nl ends the current line and ensures that there are at least n line breaks.
+func (c *converter) nl(n int) {
+	for len(c.b) > 0 && c.b[len(c.b)-1] == ' ' {
+		c.b = c.b[:len(c.b)-1]
+	}
+	if len(c.b) == 0 {
+		return
+	}
+
+	has := 0
+	for i := len(c.b) - 1; i >= 0 && c.b[i] == '\n' && has < n; i-- {
+		has++
+	}
+	for ; has < n; has++ {
+		c.b = append(c.b, '\n')
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"log"
 	"net/textproto"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -37,11 +38,14 @@ type Store interface {
 	NextSubscribers(campID, limit int) ([]models.Subscriber, error)
 	GetCampaign(campID int) (*models.Campaign, error)
 	GetAttachment(mediaID int) (models.Attachment, error)
+	GetAttachmentByName(filename string) (models.Attachment, error)
 	UpdateCampaignStatus(campID int, status string) error
 	UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error
 	CreateLink(url string) (string, error)
 	BlocklistSubscriber(id int64) error
 	DeleteSubscriber(id int64) error
+	UpdateTxMessage(uuid, subject string, sendErr error) error
+	GetMediaURL(filename, rendition, format string) (string, error)
 }
 
 // Messenger is an interface for a generic messaging backend,
@@ -81,6 +85,10 @@ type Manager struct {
 	links    map[string]string
 	linksMut sync.RWMutex
 
+	// Media URLs resolved by {{ MediaURL }} are cached here for mediaURLTTL.
+	mediaURLs    map[string]mediaURL
+	mediaURLsMut sync.RWMutex
+
 	nextPipes chan *pipe
 	campMsgQ  chan CampaignMessage
 	msgQ      chan models.Message
@@ -107,6 +115,9 @@ type CampaignMessage struct {
 	altBody  []byte
 	unsubURL string
 
+	// Per-subscriber attachments.
+	attachments []models.Attachment
+
 	pipe *pipe
 }
 
@@ -128,6 +139,8 @@ type Config struct {
 	OptinURL              string
 	MessageURL            string
 	ViewTrackURL          string
+	TxLinkTrackURL        string
+	TxViewTrackURL        string
 	ArchiveURL            string
 	RootURL               string
 	UnsubHeader           bool
@@ -143,7 +156,16 @@ type Config struct {
 	ScanCampaigns bool
 }
 
-var pushTimeout = time.Second * 3
+// mediaURL is a cached media URL.
+type mediaURL struct {
+	url string
+	exp time.Time
+}
+
+var (
+	pushTimeout = time.Second * 3
+	mediaURLTTL = time.Minute * 5
+)
 
 // New returns a new instance of Mailer.
 func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
@@ -169,6 +191,7 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 		pipes:        make(map[int]*pipe),
 		tpls:         make(map[int]*models.Template),
 		links:        make(map[string]string),
+		mediaURLs:    make(map[string]mediaURL),
 		nextPipes:    make(chan *pipe, 1000),
 		campMsgQ:     make(chan CampaignMessage, cfg.Concurrency*cfg.MessageRate*2),
 		msgQ:         make(chan models.Message, cfg.Concurrency*cfg.MessageRate*2),
@@ -216,6 +239,9 @@ func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
 	if err := m.attachMedia(msg.Campaign); err != nil {
 		return err
 	}
+	if err := m.attachSubMedia(&msg); err != nil {
+		return err
+	}
 
 	select {
 	case m.campMsgQ <- msg:
@@ -378,6 +404,37 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 	return f
 }
 
+// TxTemplateFuncs returns the template functions to be applied into
+// compiled transactional templates. {{ TrackLink }} and {{ TrackView }}
+// only track messages that are recorded in the message log, and only
+// if individual tracking is enabled.
+func (m *Manager) TxTemplateFuncs() template.FuncMap {
+	f := maps.Clone(m.tplFuncs)
+	maps.Copy(f, template.FuncMap{
+		"TrackLink": func(url string, d models.TxTplData) string {
+			if !m.cfg.IndividualTracking || d.Tx == nil || d.Tx.UUID == "" {
+				return url
+			}
+
+			uu, err := m.getLinkUUID(url)
+			if err != nil {
+				return url
+			}
+			return fmt.Sprintf(m.cfg.TxLinkTrackURL, uu, d.Tx.UUID)
+		},
+		"TrackView": func(d models.TxTplData) template.HTML {
+			if !m.cfg.IndividualTracking || d.Tx == nil || d.Tx.UUID == "" {
+				return ""
+			}
+
+			return template.HTML(fmt.Sprintf(`<img src="%s" alt="" />`,
+				fmt.Sprintf(m.cfg.TxViewTrackURL, d.Tx.UUID)))
+		},
+	})
+
+	return f
+}
+
 func (m *Manager) GenericTemplateFuncs() template.FuncMap {
 	return m.tplFuncs
 }
@@ -471,6 +528,10 @@ func (m *Manager) worker() {
 				Subscriber:  msg.Subscriber,
 				Campaign:    msg.Campaign,
 				Attachments: msg.Campaign.Attachments,
+				Security:    msg.Campaign.MessageSecurity(),
+			}
+			if len(msg.attachments) > 0 {
+				out.Attachments = slices.Concat(msg.Campaign.Attachments, msg.attachments)
 			}
 
 			h := textproto.MIMEHeader{}
@@ -496,8 +557,11 @@ func (m *Manager) worker() {
 			out.Headers = h
 
 			// Push the message to the messenger.
+			// Skipped messages (the subscriber can't receive messages on the messenger)
+			// are neither counted as sent nor as errors.
 			err := m.messengers[msg.Campaign.Messenger].Push(out)
-			if err != nil {
+			skipped := errors.Is(err, models.ErrMessageSkipped)
+			if err != nil && !skipped {
 				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
 			}
 
@@ -506,7 +570,7 @@ func (m *Manager) worker() {
 				// Mark the message as done.
 				msg.pipe.wg.Done()
 
-				if err != nil {
+				if err != nil && !skipped {
 					// Call the error callback, which keeps track of the error count
 					// and stops the campaign if the error count exceeds the threshold.
 					msg.pipe.OnError()
@@ -515,8 +579,10 @@ func (m *Manager) worker() {
 					if id > msg.pipe.lastID.Load() {
 						msg.pipe.lastID.Store(uint64(msg.Subscriber.ID))
 					}
-					msg.pipe.rate.Incr(1)
-					msg.pipe.sent.Add(1)
+					if !skipped {
+						msg.pipe.rate.Incr(1)
+						msg.pipe.sent.Add(1)
+					}
 				}
 			}
 
@@ -527,9 +593,17 @@ func (m *Manager) worker() {
 			}
 
 			// Push the message to the messenger.
-			if err := m.messengers[msg.Messenger].Push(msg); err != nil {
+			err := m.messengers[msg.Messenger].Push(msg)
+			if err != nil {
 				m.log.Printf("error sending message '%s': %v", msg.Subject, err)
 			}
+
+			// Record the delivery status of tx messages.
+			if msg.TxUUID != "" {
+				if err := m.store.UpdateTxMessage(msg.TxUUID, msg.Subject, err); err != nil {
+					m.log.Printf("error recording tx message status: %v", err)
+				}
+			}
 		}
 	}
 }
@@ -560,12 +634,23 @@ func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
 // trackLink register a URL and return its UUID to be used in message templates
 // for tracking links.
 func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+	uu, err := m.getLinkUUID(url)
+	if err != nil {
+		// If the registration fails, fail over to the original URL.
+		return url
+	}
+
+	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+}
+
+// getLinkUUID returns the UUID of a tracked URL, registering it if it's new.
+func (m *Manager) getLinkUUID(url string) (string, error) {
 	url = strings.ReplaceAll(url, "&amp;", "&")
 
 	m.linksMut.RLock()
 	if uu, ok := m.links[url]; ok {
 		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+		return uu, nil
 	}
 	m.linksMut.RUnlock()
 
@@ -573,16 +658,14 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	uu, err := m.store.CreateLink(url)
 	if err != nil {
 		m.log.Printf("error registering tracking for link '%s': %v", url, err)
-
-		// If the registration fails, fail over to the original URL.
-		return url
+		return "", err
 	}
 
 	m.linksMut.Lock()
 	m.links[url] = uu
 	m.linksMut.Unlock()
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return uu, nil
 }
 
 // sendNotif sends a notification to registered admin e-mails.
@@ -618,6 +701,9 @@ func (m *Manager) makeGnericFuncMap() template.FuncMap {
 		"Safe": func(safeHTML string) template.HTML {
 			return template.HTML(safeHTML)
 		},
+		"MediaURL": func(filename string, opts ...string) string {
+			return m.getMediaURL(filename, opts...)
+		},
 	}
 
 	// Copy spring functions.
@@ -631,6 +717,39 @@ func (m *Manager) makeGnericFuncMap() template.FuncMap {
 	return funcs
 }
 
+// getMediaURL returns the URL of a media file, optionally of one of its named
+// renditions (opts[0]) and alternative formats (opts[1]), eg: "medium", "webp".
+// If the rendition or the format doesn't exist, the original's URL is returned.
+func (m *Manager) getMediaURL(filename string, opts ...string) string {
+	var rendition, format string
+	if len(opts) > 0 {
+		rendition = opts[0]
+	}
+	if len(opts) > 1 {
+		format = opts[1]
+	}
+
+	key := filename + "|" + rendition + "|" + format
+	m.mediaURLsMut.RLock()
+	u, ok := m.mediaURLs[key]
+	m.mediaURLsMut.RUnlock()
+	if ok && time.Now().Before(u.exp) {
+		return u.url
+	}
+
+	// Missing media are also cached to not query the DB for every message.
+	url, err := m.store.GetMediaURL(filename, rendition, format)
+	if err != nil {
+		m.log.Printf("error fetching media URL (%s): %v", filename, err)
+	}
+
+	m.mediaURLsMut.Lock()
+	m.mediaURLs[key] = mediaURL{url: url, exp: time.Now().Add(mediaURLTTL)}
+	m.mediaURLsMut.Unlock()
+
+	return url
+}
+
 // attachMedia loads any media/attachments from the media store and attaches
 // the byte blobs to the campaign.
 func (m *Manager) attachMedia(c *models.Campaign) error {
@@ -2,11 +2,27 @@ package manager
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"path"
+	"strings"
+	"unicode"
 
+	"github.com/knadh/listmonk/internal/htmltext"
 	"github.com/knadh/listmonk/models"
 )
 
+// attachmentError is returned when a per-subscriber attachment can't be
+// resolved and the message shouldn't be sent.
+type attachmentError struct {
+	err  error
+	fail bool
+}
+
+func (e *attachmentError) Error() string {
+	return e.err.Error()
+}
+
 // NewCampaignMessage creates and returns a CampaignMessage that is made available
 // to message templates while they're compiled. It represents a message from
 // a campaign that's bound to a single Subscriber.
@@ -64,6 +80,100 @@ func (m *CampaignMessage) render() error {
 	return nil
 }
 
+// attachSubMedia resolves or generates the campaign's per-subscriber attachments
+// and adds them to the message. Attachments that can't be resolved are handled
+// as per their OnMissing setting.
+func (m *Manager) attachSubMedia(msg *CampaignMessage) error {
+	msg.attachments = nil
+
+	for _, a := range msg.Campaign.SubAttachments {
+		att, err := m.makeSubAttachment(a, msg)
+		if err == nil {
+			msg.attachments = append(msg.attachments, att)
+			continue
+		}
+
+		if a.OnMissing == models.SubAttachmentMissingSend {
+			m.log.Printf("sending without attachment (%s) (%s): %v", msg.Campaign.Name, msg.Subscriber.Email, err)
+			continue
+		}
+
+		return &attachmentError{err: err, fail: a.OnMissing == models.SubAttachmentMissingFail}
+	}
+
+	return nil
+}
+
+// makeSubAttachment renders the name of a per-subscriber attachment and fetches
+// the media file by that name or generates the document.
+func (m *Manager) makeSubAttachment(a models.SubAttachment, msg *CampaignMessage) (models.Attachment, error) {
+	if a.NameTpl == nil || (a.Type != models.SubAttachmentMedia && a.BodyTpl == nil) {
+		return models.Attachment{}, fmt.Errorf("attachment %s is not compiled", a.Name)
+	}
+
+	b := bytes.Buffer{}
+	if err := a.NameTpl.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+		return models.Attachment{}, fmt.Errorf("error rendering attachment name %s: %v", a.Name, err)
+	}
+
+	name := cleanFilename(b.String())
+	if name == "" {
+		return models.Attachment{}, fmt.Errorf("attachment name %s is empty", a.Name)
+	}
+
+	// Fetch the media file.
+	if a.Type == models.SubAttachmentMedia {
+		att, err := m.store.GetAttachmentByName(name)
+		if err != nil {
+			return models.Attachment{}, fmt.Errorf("error fetching attachment %s: %v", name, err)
+		}
+		return att, nil
+	}
+
+	// Generate the document.
+	b.Reset()
+	if err := a.BodyTpl.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+		return models.Attachment{}, fmt.Errorf("error rendering attachment %s: %v", name, err)
+	}
+
+	body, cType := b.String(), "text/csv; charset=utf-8"
+	if a.Type == models.SubAttachmentText {
+		body, cType = htmltext.FromHTML(body, htmltext.Plain), "text/plain; charset=utf-8"
+	}
+
+	// An empty document (eg: from a conditional template) is a missing attachment.
+	if strings.TrimSpace(body) == "" {
+		return models.Attachment{}, errors.New("attachment " + name + " is empty")
+	}
+
+	return models.Attachment{
+		Name:    name,
+		Content: []byte(body),
+		Header:  MakeAttachmentHeader(name, "base64", cType),
+	}, nil
+}
+
+// cleanFilename removes paths, quotes, and control characters from a rendered
+// attachment filename as it's used in MIME headers.
+func cleanFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		if r == '\\' {
+			return '/'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimSpace(path.Base(strings.TrimSpace(name)))
+	if name == "." || name == "/" {
+		return ""
+	}
+
+	return name
+}
+
 // Subject returns a copy of the message subject
 func (m *CampaignMessage) Subject() string {
 	return m.subject
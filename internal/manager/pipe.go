@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -20,6 +21,10 @@ type pipe struct {
 	stopped    atomic.Bool
 	withErrors atomic.Bool
 
+	// Reason for pausing a campaign that's failed by an error (Fail) instead
+	// of the error threshold.
+	failReason atomic.Value
+
 	m *Manager
 }
 
@@ -74,6 +79,11 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 // in the current batch or not. A false indicates that all subscribers
 // have been processed, or that a campaign has been paused or cancelled.
 func (p *pipe) NextSubscribers() (bool, error) {
+	// The campaign has been stopped, eg: failed by an error.
+	if p.stopped.Load() {
+		return false, nil
+	}
+
 	// Fetch the next batch of subscribers from a 'running' campaign.
 	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize)
 	if err != nil {
@@ -96,6 +106,11 @@ func (p *pipe) NextSubscribers() (bool, error) {
 		msg, err := p.newMessage(s)
 		if err != nil {
 			p.m.log.Printf("error rendering message (%s) (%s): %v", p.camp.Name, s.Email, err)
+
+			// The campaign was failed by the error.
+			if p.stopped.Load() {
+				break
+			}
 			continue
 		}
 
@@ -151,6 +166,18 @@ func (p *pipe) OnError() {
 	p.m.log.Printf("error count exceeded %d. pausing campaign %s", p.m.cfg.MaxSendErrors, p.camp.Name)
 }
 
+// Fail stops and pauses a campaign due to an error that fails the whole
+// campaign irrespective of the error threshold.
+func (p *pipe) Fail(err error) {
+	if p.stopped.Load() {
+		return
+	}
+
+	p.failReason.Store(err.Error())
+	p.Stop(true)
+	p.m.log.Printf("pausing campaign %s: %v", p.camp.Name, err)
+}
+
 // Stop "marks" a campaign as stopped. It doesn't actually stop the processing
 // of messages. That happens when every queued message in the campaign is processed,
 // marking .wg, the waitgroup counter as done. That triggers cleanup().
@@ -176,6 +203,16 @@ func (p *pipe) newMessage(s models.Subscriber) (CampaignMessage, error) {
 		return msg, err
 	}
 
+	// Resolve per-subscriber attachments. Missing attachments that are set
+	// to fail pause the campaign.
+	if err := p.m.attachSubMedia(&msg); err != nil {
+		var aErr *attachmentError
+		if errors.As(err, &aErr) && aErr.fail {
+			p.Fail(fmt.Errorf("attachment for %s is missing: %v", s.Email, err))
+		}
+		return msg, err
+	}
+
 	msg.pipe = p
 	p.wg.Add(1)
 
@@ -192,6 +229,14 @@ func (p *pipe) cleanup() {
 		p.m.pipesMut.Unlock()
 	}()
 
+	// Send out messages that may be buffered (batched) by the messenger.
+	// Batched messages have already been counted as sent, so failures are
+	// only counted towards the error threshold.
+	if err := p.m.messengers[p.camp.Messenger].Flush(); err != nil {
+		p.m.log.Printf("error flushing messenger %s (%s): %v", p.camp.Messenger, p.camp.Name, err)
+		p.OnError()
+	}
+
 	// Update campaign's 'sent count.
 	if err := p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), int(p.lastID.Load())); err != nil {
 		p.m.log.Printf("error updating campaign counts (%s): %v", p.camp.Name, err)
@@ -205,7 +250,11 @@ func (p *pipe) cleanup() {
 			p.m.log.Printf("set campaign (%s) to %s", p.camp.Name, models.CampaignStatusPaused)
 		}
 
-		_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, "Too many errors")
+		reason := "Too many errors"
+		if r, ok := p.failReason.Load().(string); ok {
+			reason = r
+		}
+		_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, reason)
 		return
 	}
 
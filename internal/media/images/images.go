@@ -0,0 +1,313 @@
+// Package images processes uploaded images: resizing large images, re-encoding
+// them (which strips EXIF and other metadata), generating thumbnails and named
+// renditions, and encoding alternative formats (WebP, AVIF) with external
+// encoders if they're installed.
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Alternative formats and the external encoders that produce them.
+const (
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+const (
+	// ThumbSize is the width of thumbnails.
+	ThumbSize = 250
+
+	defaultQuality = 85
+	encodeTimeout  = time.Second * 60
+)
+
+// encoders are the commands (looked up in $PATH) that encode alternative
+// formats. {q}, {in}, and {out} in the args are replaced with the quality,
+// and the input and output file paths.
+var encoders = map[string]struct {
+	cmd  string
+	args []string
+}{
+	FormatWebP: {"cwebp", []string{"-quiet", "-q", "{q}", "-metadata", "none", "{in}", "-o", "{out}"}},
+	FormatAVIF: {"avifenc", []string{"-q", "{q}", "{in}", "{out}"}},
+}
+
+// Rendition is a named size of an image that's generated on upload.
+type Rendition struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	// Crop crops the image to fill the exact width and height. Otherwise,
+	// the image is scaled to fit in them, retaining the aspect ratio.
+	Crop bool `json:"crop"`
+}
+
+// Options represents image processing options.
+type Options struct {
+	// MaxWidth and MaxHeight are the max dimensions of stored originals.
+	// Larger images are scaled down to fit. 0 disables the limit.
+	MaxWidth  int
+	MaxHeight int
+
+	// Quality is the JPEG (and alternative format) quality (1-100).
+	Quality int
+
+	// StripEXIF re-encodes images to remove EXIF and other metadata.
+	StripEXIF bool
+
+	// Formats are alternative formats (webp, avif) to generate.
+	Formats []string
+
+	Renditions []Rendition
+}
+
+// Image is a processed image file.
+type Image struct {
+	Data   []byte
+	Width  int
+	Height int
+
+	// Alternative formats of the image (format => data).
+	Formats map[string][]byte
+}
+
+// Result is the result of processing an uploaded image.
+type Result struct {
+	// Original is the (resized and re-encoded) original. It's nil if the
+	// original upload is to be stored as is.
+	Original *Image
+
+	Width  int
+	Height int
+
+	// Thumb is the thumbnail, which is always a PNG.
+	Thumb []byte
+
+	// Renditions are the generated renditions by name.
+	Renditions map[string]Image
+}
+
+// Processor processes images.
+type Processor struct {
+	o   Options
+	log *log.Logger
+
+	// Paths to the available alternative format encoders (format => path).
+	encoders map[string]string
+}
+
+// New returns a new image Processor. Alternative formats whose
+// encoders aren't installed are skipped.
+func New(o Options, lo *log.Logger) *Processor {
+	if o.Quality < 1 || o.Quality > 100 {
+		o.Quality = defaultQuality
+	}
+
+	p := &Processor{o: o, log: lo, encoders: map[string]string{}}
+	for _, f := range o.Formats {
+		enc, ok := encoders[f]
+		if !ok {
+			lo.Printf("unknown image format: %s", f)
+			continue
+		}
+
+		path, err := exec.LookPath(enc.cmd)
+		if err != nil {
+			lo.Printf("%s encoder (%s) not found. %s images won't be generated.", f, enc.cmd, f)
+			continue
+		}
+		p.encoders[f] = path
+	}
+
+	return p
+}
+
+// Process processes an image of the given extension (jpg, png, gif). GIFs
+// are only thumbnailed as re-encoding breaks animations.
+func (p *Processor) Process(b []byte, ext string) (Result, error) {
+	// Decode the image, applying the EXIF orientation as EXIF is lost on re-encoding.
+	img, err := imaging.Decode(bytes.NewReader(b), imaging.AutoOrientation(true))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var (
+		size = img.Bounds().Size()
+		out  = Result{Width: size.X, Height: size.Y}
+	)
+
+	// Thumbnail.
+	thumb, err := encode(imaging.Resize(img, ThumbSize, 0, imaging.Lanczos), imaging.PNG, 0)
+	if err != nil {
+		return Result{}, err
+	}
+	out.Thumb = thumb
+
+	format, err := imaging.FormatFromExtension(ext)
+	if err != nil || format == imaging.GIF {
+		return out, nil
+	}
+
+	// Scale down the original if it's larger than the max dimensions.
+	resized := false
+	if w, h := p.o.MaxWidth, p.o.MaxHeight; (w > 0 && size.X > w) || (h > 0 && size.Y > h) {
+		img = fit(img, w, h)
+		resized = true
+
+		size = img.Bounds().Size()
+		out.Width, out.Height = size.X, size.Y
+	}
+
+	// Re-encode the original.
+	if resized || p.o.StripEXIF || len(p.encoders) > 0 {
+		o, err := p.makeImage(img, format)
+		if err != nil {
+			return Result{}, err
+		}
+
+		// Only replace the original upload if it's been changed.
+		if !resized && !p.o.StripEXIF {
+			o.Data = nil
+		}
+		out.Original = &o
+	}
+
+	// Renditions.
+	out.Renditions = make(map[string]Image, len(p.o.Renditions))
+	for _, r := range p.o.Renditions {
+		var ri image.Image
+		if r.Crop && r.Width > 0 && r.Height > 0 {
+			ri = imaging.Fill(img, r.Width, r.Height, imaging.Center, imaging.Lanczos)
+		} else {
+			ri = fit(img, r.Width, r.Height)
+		}
+
+		o, err := p.makeImage(ri, format)
+		if err != nil {
+			return Result{}, err
+		}
+		out.Renditions[r.Name] = o
+	}
+
+	return out, nil
+}
+
+// makeImage encodes an image in the given format and the alternative formats.
+func (p *Processor) makeImage(img image.Image, format imaging.Format) (Image, error) {
+	b, err := encode(img, format, p.o.Quality)
+	if err != nil {
+		return Image{}, err
+	}
+
+	size := img.Bounds().Size()
+	out := Image{Data: b, Width: size.X, Height: size.Y}
+
+	for f := range p.encoders {
+		// Alternative encoders read a lossless PNG to avoid double compression.
+		src := b
+		if format != imaging.PNG {
+			if src, err = encode(img, imaging.PNG, 0); err != nil {
+				return Image{}, err
+			}
+		}
+
+		alt, err := p.encodeAlt(f, src)
+		if err != nil {
+			p.log.Printf("error encoding %s image: %v", f, err)
+			continue
+		}
+
+		if out.Formats == nil {
+			out.Formats = map[string][]byte{}
+		}
+		out.Formats[f] = alt
+	}
+
+	return out, nil
+}
+
+// encodeAlt encodes a PNG image into an alternative format with its external encoder.
+func (p *Processor) encodeAlt(format string, png []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "listmonk-img")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		in  = filepath.Join(dir, "in.png")
+		out = filepath.Join(dir, "out."+format)
+	)
+	if err := os.WriteFile(in, png, 0600); err != nil {
+		return nil, err
+	}
+
+	enc := encoders[format]
+	args := make([]string, len(enc.args))
+	for i, a := range enc.args {
+		switch a {
+		case "{q}":
+			a = strconv.Itoa(p.o.Quality)
+		case "{in}":
+			a = in
+		case "{out}":
+			a = out
+		}
+		args[i] = a
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), encodeTimeout)
+	defer cancel()
+
+	if b, err := exec.CommandContext(ctx, p.encoders[format], args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(b))
+	}
+
+	return os.ReadFile(out)
+}
+
+// fit scales an image down to fit in the given dimensions retaining the
+// aspect ratio. 0 is unbounded. Smaller images aren't scaled up.
+func fit(img image.Image, w, h int) image.Image {
+	size := img.Bounds().Size()
+	if w <= 0 {
+		w = size.X
+	}
+	if h <= 0 {
+		h = size.Y
+	}
+	if size.X <= w && size.Y <= h {
+		return img
+	}
+
+	return imaging.Fit(img, w, h, imaging.Lanczos)
+}
+
+func encode(img image.Image, format imaging.Format, quality int) ([]byte, error) {
+	var (
+		b    bytes.Buffer
+		opts []imaging.EncodeOption
+	)
+	if quality > 0 {
+		opts = append(opts, imaging.JPEGQuality(quality))
+	}
+
+	if err := imaging.Encode(&b, img, format, opts...); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -1,28 +1,116 @@
 package media
 
 import (
+	"encoding/json"
 	"io"
 
 	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
 	"gopkg.in/volatiletech/null.v6"
 )
 
 // Media represents an uploaded object.
 type Media struct {
-	ID          int         `db:"id" json:"id"`
-	UUID        string      `db:"uuid" json:"uuid"`
-	Filename    string      `db:"filename" json:"filename"`
-	ContentType string      `db:"content_type" json:"content_type"`
-	Thumb       string      `db:"thumb" json:"-"`
-	CreatedAt   null.Time   `db:"created_at" json:"created_at"`
-	ThumbURL    null.String `json:"thumb_url"`
-	Provider    string      `json:"provider"`
-	Meta        models.JSON `db:"meta" json:"meta"`
-	URL         string      `json:"url"`
+	ID          int            `db:"id" json:"id"`
+	UUID        string         `db:"uuid" json:"uuid"`
+	Filename    string         `db:"filename" json:"filename"`
+	ContentType string         `db:"content_type" json:"content_type"`
+	Thumb       string         `db:"thumb" json:"-"`
+	Folder      string         `db:"folder" json:"folder"`
+	Tags        pq.StringArray `db:"tags" json:"tags"`
+	Aliases     pq.StringArray `db:"aliases" json:"aliases"`
+	Hash        string         `db:"hash" json:"hash"`
+	Size        int64          `db:"size" json:"size"`
+	UserID      null.Int       `db:"user_id" json:"user_id"`
+	CreatedAt   null.Time      `db:"created_at" json:"created_at"`
+	ThumbURL    null.String    `json:"thumb_url"`
+	Provider    string         `json:"provider"`
+	Meta        models.JSON    `db:"meta" json:"meta"`
+	URL         string         `json:"url"`
+
+	// URLs of the alternative formats of the image (format => URL).
+	Formats map[string]string `json:"formats"`
+
+	// Renditions of the image by name.
+	Renditions map[string]Rendition `json:"renditions"`
 
 	Total int `db:"total" json:"-"`
 }
 
+// Folder is a media folder and the number of items in it.
+type Folder struct {
+	Folder string `db:"folder" json:"folder"`
+	Count  int    `db:"count" json:"count"`
+}
+
+// Storage is the storage used by media files. Sizes are in bytes.
+// Quotas are 0 if they're not set.
+type Storage struct {
+	Size  int64 `db:"size" json:"size"`
+	Files int   `db:"files" json:"files"`
+	Quota int64 `json:"quota"`
+
+	// Storage used by the current user.
+	UserSize  int64 `db:"user_size" json:"user_size"`
+	UserFiles int   `db:"user_files" json:"user_files"`
+	UserQuota int64 `json:"user_quota"`
+
+	// Storage used by each user. This is only available to users who can manage users.
+	Users []UserStorage `json:"users,omitempty"`
+}
+
+// UserStorage is the storage used by the media files of a user.
+type UserStorage struct {
+	UserID   null.Int `db:"user_id" json:"user_id"`
+	Username string   `db:"username" json:"username"`
+	Size     int64    `db:"size" json:"size"`
+	Files    int      `db:"files" json:"files"`
+}
+
+// Usage is a campaign or a template that references a media item.
+type Usage struct {
+	// campaign or template.
+	Type   string `db:"type" json:"type"`
+	ID     int    `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Status string `db:"status" json:"status"`
+
+	// Archive is true if the campaign is published on the public archive.
+	Archive bool `db:"archive" json:"archive"`
+
+	// Via is how the media is referenced: attachment or url.
+	Via string `db:"via" json:"via"`
+}
+
+// Rendition is a named size of an image generated on upload.
+type Rendition struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	// URLs of the alternative formats of the rendition (format => URL).
+	Formats map[string]string `json:"formats"`
+}
+
+// FileMeta is the record of the generated files of an image
+// that's stored in the media item's meta.
+type FileMeta struct {
+	// Alternative formats of the original (format => filename).
+	Formats map[string]string `json:"formats,omitempty"`
+
+	Renditions map[string]RenditionFile `json:"renditions,omitempty"`
+}
+
+// RenditionFile is the record of a generated rendition.
+type RenditionFile struct {
+	Filename string `json:"filename"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+
+	// Alternative formats of the rendition (format => filename).
+	Formats map[string]string `json:"formats,omitempty"`
+}
+
 // Store represents functions to store and retrieve media (files).
 type Store interface {
 	Put(string, string, io.ReadSeeker) (string, error)
@@ -30,3 +118,65 @@ type Store interface {
 	GetURL(string) string
 	GetBlob(string) ([]byte, error)
 }
+
+// GetFileMeta returns the record of generated files in the media item's meta.
+func (m Media) GetFileMeta() FileMeta {
+	var out FileMeta
+	if b, err := json.Marshal(m.Meta); err == nil {
+		json.Unmarshal(b, &out)
+	}
+
+	return out
+}
+
+// Files returns the names of all the files of the media item:
+// the original, the thumbnail, renditions, and alternative formats.
+func (m Media) Files() []string {
+	out := []string{m.Filename}
+	if m.Thumb != "" && m.Thumb != m.Filename {
+		out = append(out, m.Thumb)
+	}
+
+	fm := m.GetFileMeta()
+	for _, f := range fm.Formats {
+		out = append(out, f)
+	}
+	for _, r := range fm.Renditions {
+		out = append(out, r.Filename)
+		for _, f := range r.Formats {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
+
+// SetURLs sets the URLs of the media item's files from the store.
+func (m *Media) SetURLs(s Store) {
+	m.URL = s.GetURL(m.Filename)
+	if m.Thumb != "" {
+		m.ThumbURL = null.String{Valid: true, String: s.GetURL(m.Thumb)}
+	}
+
+	var (
+		fm      = m.GetFileMeta()
+		getURLs = func(files map[string]string) map[string]string {
+			out := make(map[string]string, len(files))
+			for f, name := range files {
+				out[f] = s.GetURL(name)
+			}
+			return out
+		}
+	)
+
+	m.Formats = getURLs(fm.Formats)
+	m.Renditions = make(map[string]Rendition, len(fm.Renditions))
+	for name, r := range fm.Renditions {
+		m.Renditions[name] = Rendition{
+			URL:     s.GetURL(r.Filename),
+			Width:   r.Width,
+			Height:  r.Height,
+			Formats: getURLs(r.Formats),
+		}
+	}
+}
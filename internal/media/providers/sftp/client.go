@@ -0,0 +1,374 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A minimal client for the subset of the SFTP protocol (version 3) that's
+// required to store files.
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02
+
+const (
+	fxpInit    = 1
+	fxpVersion = 2
+	fxpOpen    = 3
+	fxpClose   = 4
+	fxpRead    = 5
+	fxpWrite   = 6
+	fxpRemove  = 13
+	fxpMkdir   = 14
+	fxpStat    = 17
+	fxpStatus  = 101
+	fxpHandle  = 102
+	fxpData    = 103
+	fxpAttrs   = 105
+
+	fxfRead  = 0x01
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfTrunc = 0x10
+
+	fxOK         = 0
+	fxEOF        = 1
+	fxNoSuchFile = 2
+
+	attrPermissions = 0x04
+
+	protocolVersion = 3
+
+	// Max size of data in a read or write request. Servers must support at least 32 KB packets.
+	chunkSize = 32 * 1024
+
+	// Max size of a response packet.
+	maxPacket = 256 * 1024
+)
+
+// statusError is an SFTP status response that's not OK.
+type statusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("sftp: %s (code %d)", e.msg, e.code)
+}
+
+// client is an SFTP client on an SSH connection. Requests are sent one at a time.
+type client struct {
+	conn *ssh.Client
+	sess *ssh.Session
+	w    io.WriteCloser
+	r    io.Reader
+
+	id  uint32
+	buf []byte
+	mut sync.Mutex
+
+	// Last time the client was used.
+	used time.Time
+}
+
+// newClient starts the SFTP subsystem on an SSH connection and initializes it.
+func newClient(conn *ssh.Client) (*client, error) {
+	sess, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	c := &client{conn: conn, sess: sess, w: w, r: r, used: time.Now()}
+
+	// The init packet has no request ID.
+	if err := c.send(fxpInit, func(b []byte) []byte {
+		return binary.BigEndian.AppendUint32(b, protocolVersion)
+	}); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	typ, _, err := c.recv()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if typ != fxpVersion {
+		c.close()
+		return nil, fmt.Errorf("sftp: unexpected response to init: %d", typ)
+	}
+
+	return c, nil
+}
+
+// writeFile creates or truncates a file and writes the data from r to it.
+func (c *client) writeFile(path string, perm uint32, r io.Reader) error {
+	h, err := c.open(path, fxfWrite|fxfCreat|fxfTrunc, perm)
+	if err != nil {
+		return err
+	}
+
+	var (
+		buf = make([]byte, chunkSize)
+		off uint64
+	)
+	for {
+		n, rErr := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := c.request(fxpWrite, func(b []byte) []byte {
+				b = appendString(b, h)
+				b = binary.BigEndian.AppendUint64(b, off)
+				return appendString(b, buf[:n])
+			}); err != nil {
+				c.closeHandle(h)
+				return err
+			}
+			off += uint64(n)
+		}
+
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rErr != nil {
+			c.closeHandle(h)
+			return rErr
+		}
+	}
+
+	return c.closeHandle(h)
+}
+
+// readFile reads a file.
+func (c *client) readFile(path string) ([]byte, error) {
+	h, err := c.open(path, fxfRead, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(h)
+
+	var out []byte
+	for {
+		data, err := c.request(fxpRead, func(b []byte) []byte {
+			b = appendString(b, h)
+			b = binary.BigEndian.AppendUint64(b, uint64(len(out)))
+			return binary.BigEndian.AppendUint32(b, chunkSize)
+		})
+		if err != nil {
+			var sErr *statusError
+			if errors.As(err, &sErr) && sErr.code == fxEOF {
+				return out, nil
+			}
+			return nil, err
+		}
+
+		d, _, err := readString(data)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d...)
+	}
+}
+
+// remove deletes a file.
+func (c *client) remove(path string) error {
+	_, err := c.request(fxpRemove, func(b []byte) []byte {
+		return appendString(b, []byte(path))
+	})
+	return err
+}
+
+// mkdirAll creates a directory and its parents if they don't exist.
+func (c *client) mkdirAll(path string) error {
+	if path == "" || path == "/" || path == "." {
+		return nil
+	}
+
+	if ok, err := c.exists(path); err != nil || ok {
+		return err
+	}
+
+	// Create the parent first.
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		if err := c.mkdirAll(path[:i]); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.request(fxpMkdir, func(b []byte) []byte {
+		b = appendString(b, []byte(path))
+		b = binary.BigEndian.AppendUint32(b, attrPermissions)
+		return binary.BigEndian.AppendUint32(b, 0755)
+	})
+	return err
+}
+
+// exists checks if a file or directory exists.
+func (c *client) exists(path string) (bool, error) {
+	_, err := c.request(fxpStat, func(b []byte) []byte {
+		return appendString(b, []byte(path))
+	})
+	if err != nil {
+		if isNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ping checks if the connection is alive.
+func (c *client) ping() error {
+	_, err := c.exists(".")
+	return err
+}
+
+func (c *client) open(path string, flags, perm uint32) ([]byte, error) {
+	data, err := c.request(fxpOpen, func(b []byte) []byte {
+		b = appendString(b, []byte(path))
+		b = binary.BigEndian.AppendUint32(b, flags)
+		if perm == 0 {
+			return binary.BigEndian.AppendUint32(b, 0)
+		}
+		b = binary.BigEndian.AppendUint32(b, attrPermissions)
+		return binary.BigEndian.AppendUint32(b, perm)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := readString(data)
+	return h, err
+}
+
+func (c *client) closeHandle(h []byte) error {
+	_, err := c.request(fxpClose, func(b []byte) []byte {
+		return appendString(b, h)
+	})
+	return err
+}
+
+// request sends a request and returns the data of its response (after the request ID).
+// Status responses are returned as errors unless they're OK.
+func (c *client) request(typ byte, payload func([]byte) []byte) ([]byte, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.id++
+	id := c.id
+
+	if err := c.send(typ, func(b []byte) []byte {
+		return payload(binary.BigEndian.AppendUint32(b, id))
+	}); err != nil {
+		return nil, err
+	}
+
+	rTyp, data, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != id {
+		return nil, errors.New("sftp: unexpected response ID")
+	}
+	data = data[4:]
+
+	switch rTyp {
+	case fxpStatus:
+		if len(data) < 4 {
+			return nil, errors.New("sftp: invalid status response")
+		}
+
+		code := binary.BigEndian.Uint32(data)
+		if code == fxOK {
+			return nil, nil
+		}
+
+		msg, _, _ := readString(data[4:])
+		return nil, &statusError{code: code, msg: string(msg)}
+
+	case fxpHandle, fxpData, fxpAttrs:
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("sftp: unexpected response type: %d", rTyp)
+}
+
+// send writes a packet: uint32 length, byte type, payload.
+func (c *client) send(typ byte, payload func([]byte) []byte) error {
+	b := payload(append(c.buf[:0], 0, 0, 0, 0, typ))
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	c.buf = b
+
+	_, err := c.w.Write(b)
+	return err
+}
+
+// recv reads a packet and returns its type and payload.
+func (c *client) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+
+	ln := binary.BigEndian.Uint32(hdr[:4])
+	if ln < 1 || ln > maxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length: %d", ln)
+	}
+
+	data := make([]byte, ln-1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, nil, err
+	}
+
+	return hdr[4], data, nil
+}
+
+func (c *client) close() error {
+	c.sess.Close()
+	return c.conn.Close()
+}
+
+func appendString(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	ln := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < ln {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	return b[4 : 4+ln], b[4+ln:], nil
+}
+
+// isNotExist checks if an error is an SFTP "no such file" status.
+func isNotExist(err error) bool {
+	var sErr *statusError
+	return errors.As(err, &sErr) && sErr.code == fxNoSuchFile
+}
@@ -0,0 +1,227 @@
+// Package sftp implements a media.Store that stores files on a server over SFTP.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/media"
+	"golang.org/x/crypto/ssh"
+)
+
+// idleCheck is the idle duration after which connections are checked before they're reused.
+const idleCheck = time.Second * 30
+
+// Opt represents SFTP params.
+type Opt struct {
+	// Host is the host:port of the SSH server. The port defaults to 22.
+	Host     string `koanf:"host"`
+	Username string `koanf:"username"`
+
+	// Password and/or a PEM encoded private key to authenticate with.
+	Password   string `koanf:"password"`
+	PrivateKey string `koanf:"private_key"`
+
+	// HostKey is the server's public key in the authorized_keys format
+	// (eg: from ssh-keyscan) that it's verified against. If it's not set,
+	// the server isn't verified.
+	HostKey string `koanf:"host_key"`
+
+	// Path is the directory on the server where files are uploaded.
+	Path string `koanf:"path"`
+
+	// PublicURL is the URL prefix with which the uploaded files are publicly accessible.
+	PublicURL string `koanf:"public_url"`
+
+	MaxConns int           `koanf:"max_conns"`
+	Timeout  time.Duration `koanf:"timeout"`
+}
+
+// Client implements `media.Store` for SFTP.
+type Client struct {
+	opt Opt
+	cfg *ssh.ClientConfig
+
+	// pool is a pool of connections. Its buffer size is the max number of
+	// connections. A nil value is a free slot to open a new connection in.
+	pool chan *client
+}
+
+// New initialises store for the SFTP provider. Connections are opened on demand
+// and pooled up to MaxConns.
+func New(opt Opt, lo *log.Logger) (media.Store, error) {
+	if opt.Host == "" {
+		return nil, fmt.Errorf("SFTP host is empty")
+	}
+	if _, _, err := net.SplitHostPort(opt.Host); err != nil {
+		opt.Host = net.JoinHostPort(opt.Host, "22")
+	}
+
+	opt.Path = path.Clean(opt.Path)
+	opt.PublicURL = strings.TrimRight(opt.PublicURL, "/")
+	if opt.MaxConns < 1 {
+		opt.MaxConns = 2
+	}
+	if opt.Timeout < time.Second {
+		opt.Timeout = time.Second * 30
+	}
+
+	// Auth methods.
+	var auth []ssh.AuthMethod
+	if opt.PrivateKey != "" {
+		key, err := ssh.ParsePrivateKey([]byte(opt.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SFTP private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(key))
+	}
+	if opt.Password != "" {
+		auth = append(auth, ssh.Password(opt.Password))
+	}
+
+	hostKeyCb := ssh.InsecureIgnoreHostKey()
+	if opt.HostKey != "" {
+		_, _, key, _, _, err := ssh.ParseKnownHosts([]byte(opt.HostKey))
+		if err != nil {
+			if key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(opt.HostKey)); err != nil {
+				return nil, fmt.Errorf("error parsing SFTP host key: %v", err)
+			}
+		}
+		hostKeyCb = ssh.FixedHostKey(key)
+	} else {
+		lo.Println("WARNING: SFTP host key is not set. The server's identity won't be verified.")
+	}
+
+	c := &Client{
+		opt: opt,
+		cfg: &ssh.ClientConfig{
+			User:            opt.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCb,
+			Timeout:         opt.Timeout,
+		},
+		pool: make(chan *client, opt.MaxConns),
+	}
+	for range opt.MaxConns {
+		c.pool <- nil
+	}
+
+	return c, nil
+}
+
+// Put uploads a file to the server, creating the upload directory if it doesn't exist.
+func (c *Client) Put(name string, cType string, src io.ReadSeeker) (string, error) {
+	err := c.do(func(cl *client) error {
+		err := cl.writeFile(c.filePath(name), 0644, src)
+		if err == nil || !isNotExist(err) {
+			return err
+		}
+
+		// The directory doesn't exist.
+		if err := cl.mkdirAll(c.opt.Path); err != nil {
+			return err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return cl.writeFile(c.filePath(name), 0644, src)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// GetURL returns the public URL of a file.
+func (c *Client) GetURL(name string) string {
+	return c.opt.PublicURL + "/" + url.PathEscape(name)
+}
+
+// GetBlob downloads a file by its URL (or name) from the server.
+func (c *Client) GetBlob(uurl string) ([]byte, error) {
+	name := uurl
+	if u, err := url.Parse(uurl); err == nil {
+		name = u.Path
+	}
+
+	var out []byte
+	err := c.do(func(cl *client) error {
+		b, err := cl.readFile(c.filePath(path.Base(name)))
+		out = b
+		return err
+	})
+
+	return out, err
+}
+
+// Delete deletes a file from the server. Files that don't exist are ignored.
+func (c *Client) Delete(name string) error {
+	return c.do(func(cl *client) error {
+		if err := cl.remove(c.filePath(name)); err != nil && !isNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// do runs fn with a pooled connection, opening a new one if there are no idle
+// connections. Connections are discarded on network errors.
+func (c *Client) do(fn func(*client) error) error {
+	cl := <-c.pool
+
+	// A connection that's been idle for a while may have been closed by the server.
+	if cl != nil && time.Since(cl.used) > idleCheck && cl.ping() != nil {
+		cl.close()
+		cl = nil
+	}
+
+	if cl == nil {
+		var err error
+		if cl, err = c.connect(); err != nil {
+			c.pool <- nil
+			return err
+		}
+	}
+
+	err := fn(cl)
+	cl.used = time.Now()
+
+	// SFTP status errors don't affect the connection.
+	var sErr *statusError
+	if err != nil && !errors.As(err, &sErr) {
+		cl.close()
+		cl = nil
+	}
+	c.pool <- cl
+
+	return err
+}
+
+func (c *Client) connect() (*client, error) {
+	conn, err := ssh.Dial("tcp", c.opt.Host, c.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SFTP server: %v", err)
+	}
+
+	cl, err := newClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting SFTP session: %v", err)
+	}
+
+	return cl, nil
+}
+
+// filePath returns the path of a file on the server.
+func (c *Client) filePath(name string) string {
+	return path.Join(c.opt.Path, path.Base(name))
+}
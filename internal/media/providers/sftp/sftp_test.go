@@ -0,0 +1,371 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUser = "listmonk"
+	testPass = "secret"
+)
+
+// testServer is an in-process SSH server with a minimal SFTP subsystem that
+// serves the requests that client sends on the local file system.
+type testServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(pass) == testPass {
+				return nil, nil
+			}
+			return nil, errors.New("invalid credentials")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The store doesn't close its pooled connections, so close them on the
+	// server side to stop the handlers.
+	var (
+		wg    sync.WaitGroup
+		mut   sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		mut.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mut.Unlock()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mut.Lock()
+			conns = append(conns, conn)
+			mut.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveConn(conn, cfg)
+			}()
+		}
+	}()
+
+	return &testServer{addr: ln.Addr().String(), hostKey: signer.PublicKey()}
+}
+
+func serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer ch.Close()
+			for r := range reqs {
+				// The payload is the subsystem name as an SSH string.
+				ok := r.Type == "subsystem" && len(r.Payload) > 4 && string(r.Payload[4:]) == "sftp"
+				r.Reply(ok, nil)
+				if ok {
+					serveSFTP(ch)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// serveSFTP serves SFTP requests on a channel until it's closed.
+func serveSFTP(rw io.ReadWriter) {
+	var (
+		files = map[string]*os.File{}
+		next  int
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[:4])-1)
+		if _, err := io.ReadFull(rw, data); err != nil {
+			return
+		}
+
+		if hdr[4] == fxpInit {
+			writePacket(rw, fxpVersion, binary.BigEndian.AppendUint32(nil, protocolVersion))
+			continue
+		}
+
+		id, data := data[:4], data[4:]
+		status := func(err error) {
+			code := uint32(fxOK)
+			msg := ""
+			switch {
+			case err == io.EOF:
+				code = fxEOF
+			case errors.Is(err, fs.ErrNotExist):
+				code, msg = fxNoSuchFile, err.Error()
+			case err != nil:
+				code, msg = 4, err.Error()
+			}
+
+			b := binary.BigEndian.AppendUint32(append([]byte{}, id...), code)
+			b = appendString(b, []byte(msg))
+			writePacket(rw, fxpStatus, appendString(b, nil))
+		}
+
+		switch hdr[4] {
+		case fxpOpen:
+			p, rest, _ := readString(data)
+			pflags := binary.BigEndian.Uint32(rest)
+
+			flags := os.O_RDONLY
+			if pflags&fxfWrite != 0 {
+				flags = os.O_WRONLY
+			}
+			if pflags&fxfCreat != 0 {
+				flags |= os.O_CREATE
+			}
+			if pflags&fxfTrunc != 0 {
+				flags |= os.O_TRUNC
+			}
+
+			f, err := os.OpenFile(string(p), flags, 0644)
+			if err != nil {
+				status(err)
+				continue
+			}
+
+			next++
+			h := strings.Repeat("h", next)
+			files[h] = f
+			writePacket(rw, fxpHandle, appendString(append([]byte{}, id...), []byte(h)))
+
+		case fxpClose:
+			h, _, _ := readString(data)
+			f, ok := files[string(h)]
+			if !ok {
+				status(errors.New("invalid handle"))
+				continue
+			}
+			delete(files, string(h))
+			status(f.Close())
+
+		case fxpRead:
+			h, rest, _ := readString(data)
+			off := binary.BigEndian.Uint64(rest)
+			ln := binary.BigEndian.Uint32(rest[8:])
+
+			buf := make([]byte, ln)
+			n, err := files[string(h)].ReadAt(buf, int64(off))
+			if n == 0 {
+				status(err)
+				continue
+			}
+			writePacket(rw, fxpData, appendString(append([]byte{}, id...), buf[:n]))
+
+		case fxpWrite:
+			h, rest, _ := readString(data)
+			off := binary.BigEndian.Uint64(rest)
+			b, _, _ := readString(rest[8:])
+
+			_, err := files[string(h)].WriteAt(b, int64(off))
+			status(err)
+
+		case fxpRemove:
+			p, _, _ := readString(data)
+			status(os.Remove(string(p)))
+
+		case fxpMkdir:
+			p, _, _ := readString(data)
+			status(os.Mkdir(string(p), 0755))
+
+		case fxpStat:
+			p, _, _ := readString(data)
+			if _, err := os.Stat(string(p)); err != nil {
+				status(err)
+				continue
+			}
+			// No attributes.
+			writePacket(rw, fxpAttrs, binary.BigEndian.AppendUint32(append([]byte{}, id...), 0))
+
+		default:
+			status(errors.New("unsupported request"))
+		}
+	}
+}
+
+func writePacket(w io.Writer, typ byte, payload []byte) {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	b = append(b, typ)
+	w.Write(append(b, payload...))
+}
+
+func newTestClient(t *testing.T, opt Opt) *Client {
+	t.Helper()
+
+	st, err := New(opt, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("error initializing SFTP store: %v", err)
+	}
+
+	return st.(*Client)
+}
+
+func TestStore(t *testing.T) {
+	srv := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "uploads", "media")
+
+	c := newTestClient(t, Opt{
+		Host:      srv.addr,
+		Username:  testUser,
+		Password:  testPass,
+		HostKey:   string(ssh.MarshalAuthorizedKey(srv.hostKey)),
+		Path:      dir,
+		PublicURL: "https://site.com/media/",
+		MaxConns:  1,
+	})
+
+	// Larger than a chunk to test chunked reads and writes.
+	data := bytes.Repeat([]byte("0123456789"), chunkSize/5)
+
+	// The upload directory doesn't exist and is created on the first upload.
+	name, err := c.Put("file 1.txt", "text/plain", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error uploading file: %v", err)
+	}
+	if name != "file 1.txt" {
+		t.Fatalf("unexpected file name: %s", name)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "file 1.txt")); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("uploaded file doesn't match: %v", err)
+	}
+
+	// Overwrite an existing file with a shorter one.
+	data = []byte("hello, world")
+	if _, err := c.Put("file 1.txt", "text/plain", bytes.NewReader(data)); err != nil {
+		t.Fatalf("error overwriting file: %v", err)
+	}
+
+	u := c.GetURL("file 1.txt")
+	if u != "https://site.com/media/file%201.txt" {
+		t.Fatalf("unexpected URL: %s", u)
+	}
+
+	// Fetch by URL and by name.
+	for _, in := range []string{u, "file 1.txt"} {
+		b, err := c.GetBlob(in)
+		if err != nil {
+			t.Fatalf("error fetching %s: %v", in, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("unexpected file contents for %s: %q", in, b)
+		}
+	}
+
+	if err := c.Delete("file 1.txt"); err != nil {
+		t.Fatalf("error deleting file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file 1.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("file wasn't deleted: %v", err)
+	}
+	if _, err := c.GetBlob("file 1.txt"); !isNotExist(err) {
+		t.Fatalf("expected a not found error fetching a deleted file, got: %v", err)
+	}
+
+	// Deleting a file that doesn't exist isn't an error.
+	if err := c.Delete("file 1.txt"); err != nil {
+		t.Fatalf("error deleting non-existent file: %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := newTestServer(t)
+
+	c := newTestClient(t, Opt{Host: srv.addr, Username: testUser, Password: "wrong", Path: t.TempDir()})
+	if _, err := c.Put("file.txt", "text/plain", bytes.NewReader([]byte("x"))); err == nil {
+		t.Fatal("expected an error uploading with invalid credentials")
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	srv := newTestServer(t)
+
+	// The host key of another server.
+	other := newTestServer(t)
+	c := newTestClient(t, Opt{
+		Host:     srv.addr,
+		Username: testUser,
+		Password: testPass,
+		HostKey:  string(ssh.MarshalAuthorizedKey(other.hostKey)),
+		Path:     t.TempDir(),
+	})
+	if _, err := c.Put("file.txt", "text/plain", bytes.NewReader([]byte("x"))); err == nil {
+		t.Fatal("expected an error connecting to a server with a mismatched host key")
+	}
+}
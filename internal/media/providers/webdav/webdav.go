@@ -0,0 +1,235 @@
+// Package webdav implements a media.Store that stores files on a WebDAV server.
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/media"
+)
+
+// Opt represents WebDAV params.
+type Opt struct {
+	// URL is the WebDAV root, eg: https://dav.site.com/remote.php/dav/files/user
+	URL      string `koanf:"url"`
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+
+	// Path is the directory under the root where files are uploaded.
+	Path string `koanf:"path"`
+
+	// PublicURL is the URL prefix with which files are publicly accessible.
+	// If it's not set, the WebDAV URLs of the files are used.
+	PublicURL string `koanf:"public_url"`
+
+	MaxConns int           `koanf:"max_conns"`
+	Timeout  time.Duration `koanf:"timeout"`
+}
+
+// Client implements `media.Store` for WebDAV.
+type Client struct {
+	opt Opt
+	c   *http.Client
+}
+
+// New initialises store for the WebDAV provider. Connections to the server are
+// pooled and reused up to MaxConns.
+func New(opt Opt) (media.Store, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV URL: %s", opt.URL)
+	}
+
+	opt.URL = strings.TrimRight(opt.URL, "/")
+	opt.Path = strings.Trim(path.Clean("/"+opt.Path), "/")
+	opt.PublicURL = strings.TrimRight(opt.PublicURL, "/")
+	if opt.MaxConns < 1 {
+		opt.MaxConns = 5
+	}
+	if opt.Timeout < time.Second {
+		opt.Timeout = time.Second * 30
+	}
+
+	return &Client{
+		opt: opt,
+		c: &http.Client{
+			Timeout: opt.Timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         (&net.Dialer{Timeout: opt.Timeout}).DialContext,
+				MaxConnsPerHost:     opt.MaxConns,
+				MaxIdleConnsPerHost: opt.MaxConns,
+				IdleConnTimeout:     time.Second * 90,
+			},
+		},
+	}, nil
+}
+
+// Put uploads a file to the WebDAV server. If the upload directory doesn't exist,
+// it's created.
+func (c *Client) Put(name string, cType string, src io.ReadSeeker) (string, error) {
+	err := c.put(name, cType, src)
+	if err == errNoDir {
+		if err := c.mkdirAll(); err != nil {
+			return "", err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		err = c.put(name, cType, src)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// GetURL returns the public URL of a file.
+func (c *Client) GetURL(name string) string {
+	if c.opt.PublicURL != "" {
+		return c.opt.PublicURL + "/" + url.PathEscape(name)
+	}
+
+	return c.fileURL(name)
+}
+
+// GetBlob downloads a file by its URL (or name) from the WebDAV server.
+func (c *Client) GetBlob(uurl string) ([]byte, error) {
+	name := uurl
+	if u, err := url.Parse(uurl); err == nil {
+		name = u.Path
+	}
+
+	resp, err := c.do(http.MethodGet, c.fileURL(path.Base(name)), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching file from WebDAV: %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Delete deletes a file from the WebDAV server. Files that don't exist are ignored.
+func (c *Client) Delete(name string) error {
+	resp, err := c.do(http.MethodDelete, c.fileURL(name), nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("error deleting file from WebDAV: %s", resp.Status)
+	}
+
+	return nil
+}
+
+var errNoDir = fmt.Errorf("WebDAV directory doesn't exist")
+
+func (c *Client) put(name, cType string, src io.Reader) error {
+	resp, err := c.do(http.MethodPut, c.fileURL(name), src, cType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound:
+		// The parent collection doesn't exist.
+		return errNoDir
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("error uploading file to WebDAV: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// mkdirAll creates the upload path on the server one collection at a time.
+func (c *Client) mkdirAll() error {
+	if c.opt.Path == "" {
+		return nil
+	}
+
+	u := c.opt.URL
+	for _, p := range strings.Split(c.opt.Path, "/") {
+		u += "/" + url.PathEscape(p)
+
+		resp, err := c.do("MKCOL", u+"/", nil, "")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// 405 is returned if the collection already exists.
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("error creating WebDAV directory %s: %s", p, resp.Status)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) do(method, u string, body io.Reader, cType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the content length of seekable bodies as not all servers accept chunked uploads.
+	if sk, ok := body.(io.Seeker); ok && req.ContentLength == 0 {
+		cur, err := sk.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		end, err := sk.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := sk.Seek(cur, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req.ContentLength = end - cur
+	}
+	if cType != "" {
+		req.Header.Set("Content-Type", cType)
+	}
+	if c.opt.Username != "" || c.opt.Password != "" {
+		req.SetBasicAuth(c.opt.Username, c.opt.Password)
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drain the body so that the connection can be reused.
+	if method != http.MethodGet {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	}
+
+	return resp, nil
+}
+
+// fileURL returns the WebDAV URL of a file.
+func (c *Client) fileURL(name string) string {
+	u := c.opt.URL
+	if c.opt.Path != "" {
+		for _, p := range strings.Split(c.opt.Path, "/") {
+			u += "/" + url.PathEscape(p)
+		}
+	}
+
+	return u + "/" + url.PathEscape(name)
+}
@@ -0,0 +1,128 @@
+package webdav
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+const (
+	testUser = "listmonk"
+	testPass = "secret"
+)
+
+// newTestServer starts a WebDAV server at /dav with an in-memory file system
+// that requires basic auth.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	h := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != testUser || p != testPass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestClient(t *testing.T, opt Opt) *Client {
+	t.Helper()
+
+	st, err := New(opt)
+	if err != nil {
+		t.Fatalf("error initializing WebDAV store: %v", err)
+	}
+
+	return st.(*Client)
+}
+
+func TestStore(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, Opt{
+		URL:      srv.URL + "/dav/",
+		Username: testUser,
+		Password: testPass,
+		Path:     "/uploads/media/",
+	})
+
+	data := []byte("hello, world")
+
+	// The upload directory doesn't exist and is created on the first upload.
+	name, err := c.Put("file 1.txt", "text/plain", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error uploading file: %v", err)
+	}
+	if name != "file 1.txt" {
+		t.Fatalf("unexpected file name: %s", name)
+	}
+
+	// Overwrite an existing file in the existing directory.
+	data = []byte("hello again")
+	if _, err := c.Put("file 1.txt", "text/plain", bytes.NewReader(data)); err != nil {
+		t.Fatalf("error overwriting file: %v", err)
+	}
+
+	u := c.GetURL("file 1.txt")
+	if want := srv.URL + "/dav/uploads/media/file%201.txt"; u != want {
+		t.Fatalf("unexpected URL: got %s, want %s", u, want)
+	}
+
+	// Fetch by URL and by name.
+	for _, in := range []string{u, "file 1.txt"} {
+		b, err := c.GetBlob(in)
+		if err != nil {
+			t.Fatalf("error fetching %s: %v", in, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("unexpected file contents for %s: %q", in, b)
+		}
+	}
+
+	if err := c.Delete("file 1.txt"); err != nil {
+		t.Fatalf("error deleting file: %v", err)
+	}
+	if _, err := c.GetBlob("file 1.txt"); err == nil {
+		t.Fatal("expected an error fetching a deleted file")
+	}
+
+	// Deleting a file that doesn't exist isn't an error.
+	if err := c.Delete("file 1.txt"); err != nil {
+		t.Fatalf("error deleting non-existent file: %v", err)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	c := newTestClient(t, Opt{URL: "https://dav.site.com/dav", PublicURL: "https://cdn.site.com/media/"})
+
+	if u := c.GetURL("a b.png"); u != "https://cdn.site.com/media/a%20b.png" {
+		t.Fatalf("unexpected URL: %s", u)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, Opt{URL: srv.URL + "/dav", Username: testUser, Password: "wrong"})
+
+	if _, err := c.Put("file.txt", "text/plain", bytes.NewReader([]byte("x"))); err == nil {
+		t.Fatal("expected an error uploading with invalid credentials")
+	}
+}
+
+func TestInvalidURL(t *testing.T) {
+	for _, u := range []string{"", "ftp://site.com", "http://"} {
+		if _, err := New(Opt{URL: u}); err == nil {
+			t.Fatalf("expected an error for URL %q", u)
+		}
+	}
+}
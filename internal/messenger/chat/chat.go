@@ -0,0 +1,455 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/knadh/listmonk/internal/htmltext"
+	"github.com/knadh/listmonk/models"
+	"golang.org/x/time/rate"
+)
+
+// Supported chat platforms.
+const (
+	PlatformSlack    = "slack"
+	PlatformMatrix   = "matrix"
+	PlatformTelegram = "telegram"
+)
+
+const (
+	slackAPIURL    = "https://slack.com/api/chat.postMessage"
+	telegramAPIURL = "https://api.telegram.org"
+
+	// Max message lengths (characters) accepted by the platforms.
+	slackMaxLen    = 40000
+	telegramMaxLen = 4096
+	matrixMaxLen   = 30000
+
+	// maxRetryWait is the longest a rate limited (429) request is retried after.
+	maxRetryWait = time.Minute
+
+	defaultTimeout = time.Second * 10
+)
+
+// Default messages per second per platform, below their documented rate limits.
+var defaultRates = map[string]float64{
+	PlatformSlack:    1,
+	PlatformTelegram: 25,
+	PlatformMatrix:   5,
+}
+
+// Options represents the chat messenger options.
+type Options struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+
+	// RootURL is the Slack incoming webhook URL (or the chat.postMessage API
+	// URL if there's a token), the Matrix homeserver URL, or an optional
+	// Telegram Bot API URL.
+	RootURL string `json:"root_url"`
+
+	// Token is the Slack bot token, the Matrix access token, or the Telegram bot token.
+	Token string `json:"password"`
+
+	// Attrib is the subscriber attribute that has the target Slack channel or
+	// user ID, Matrix room ID, or Telegram chat ID. Subscribers without
+	// it are skipped.
+	Attrib string `json:"attrib"`
+
+	// RateLimit is the max number of messages sent per second. If it's 0,
+	// the platform's default is used.
+	RateLimit float64 `json:"rate_limit"`
+
+	MaxConns int           `json:"max_conns"`
+	Retries  int           `json:"max_msg_retries"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// Chat is a messenger that posts messages to Slack, Matrix, or Telegram.
+type Chat struct {
+	o   Options
+	c   *http.Client
+	lim *rate.Limiter
+	log *log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Counter for unique Matrix transaction IDs.
+	txn atomic.Uint64
+}
+
+// message is a message converted to the platform's format.
+type message struct {
+	text  string
+	plain string
+}
+
+// New returns a new instance of the chat messenger.
+func New(o Options, lo *log.Logger) (*Chat, error) {
+	o.RootURL = strings.TrimSpace(o.RootURL)
+	o.Attrib = strings.TrimSpace(o.Attrib)
+
+	switch o.Platform {
+	case PlatformSlack:
+		if o.Token == "" && o.RootURL == "" {
+			return nil, errors.New("slack: webhook URL or token is required")
+		}
+		if o.Token != "" && o.RootURL == "" {
+			o.RootURL = slackAPIURL
+		}
+	case PlatformTelegram:
+		if o.RootURL == "" {
+			o.RootURL = telegramAPIURL
+		}
+	case PlatformMatrix:
+		if o.RootURL == "" {
+			return nil, errors.New("matrix: homeserver URL is required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown chat platform: %s", o.Platform)
+	}
+
+	if o.Platform != PlatformSlack && o.Token == "" {
+		return nil, fmt.Errorf("%s: token is required", o.Platform)
+	}
+	if o.Attrib == "" && (o.Platform != PlatformSlack || o.Token != "") {
+		return nil, fmt.Errorf("%s: target attribute is required", o.Platform)
+	}
+
+	if o.RateLimit <= 0 {
+		o.RateLimit = defaultRates[o.Platform]
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxConns < 1 {
+		o.MaxConns = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Chat{
+		o:      o,
+		lim:    rate.NewLimiter(rate.Limit(o.RateLimit), 1),
+		log:    lo,
+		ctx:    ctx,
+		cancel: cancel,
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+	}, nil
+}
+
+// Name returns the messenger's name.
+func (c *Chat) Name() string {
+	return c.o.Name
+}
+
+// Push posts a message to the chat channel, room, or user in the subscriber's
+// target attribute. Subscribers without the attribute are skipped with
+// models.ErrMessageSkipped. Requests are
+// rate limited, and rate limited (429) requests are retried after the wait
+// that the platform asks for.
+func (c *Chat) Push(m models.Message) error {
+	target := c.target(m.Subscriber)
+	if target == "" && c.o.Attrib != "" {
+		return models.ErrMessageSkipped
+	}
+
+	msg := c.makeMessage(m)
+	for n := 0; ; n++ {
+		if err := c.lim.Wait(c.ctx); err != nil {
+			return err
+		}
+
+		wait, err := c.send(target, msg)
+		if err == nil {
+			return nil
+		}
+		if wait <= 0 || n >= c.o.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(min(wait, maxRetryWait)):
+		case <-c.ctx.Done():
+			return err
+		}
+	}
+}
+
+// Flush is a no-op as messages are sent immediately.
+func (c *Chat) Flush() error {
+	return nil
+}
+
+// Close stops pending retries and closes idle HTTP connections.
+func (c *Chat) Close() error {
+	c.cancel()
+	c.c.CloseIdleConnections()
+	return nil
+}
+
+// target returns the target ID in the subscriber's attribute.
+func (c *Chat) target(s models.Subscriber) string {
+	if c.o.Attrib == "" {
+		return ""
+	}
+
+	switch v := s.Attribs[c.o.Attrib].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+
+	return ""
+}
+
+// makeMessage converts a message to the platform's format. The plain text
+// alt body is used if there's one. Otherwise, the body (HTML, or markdown that's
+// been rendered to HTML) is converted. The subject is prepended as a heading.
+func (c *Chat) makeMessage(m models.Message) message {
+	f := htmltext.HTML
+	if c.o.Platform == PlatformSlack {
+		f = htmltext.Slack
+	}
+
+	conv := func(f htmltext.Format) string {
+		var body string
+		if len(m.AltBody) > 0 {
+			body = htmltext.FromPlain(string(m.AltBody), f)
+		} else if m.ContentType == models.CampaignContentTypePlain {
+			body = htmltext.FromPlain(string(m.Body), f)
+		} else {
+			body = htmltext.FromHTML(string(m.Body), f)
+		}
+
+		sub := htmltext.Escape(strings.TrimSpace(m.Subject), f)
+		if sub == "" {
+			return body
+		}
+
+		switch f {
+		case htmltext.Slack:
+			sub = "*" + sub + "*"
+		case htmltext.HTML:
+			sub = "<b>" + sub + "</b>"
+		}
+		return sub + "\n\n" + body
+	}
+
+	return message{text: conv(f), plain: conv(htmltext.Plain)}
+}
+
+// send sends a message to the platform. On rate limit errors, it
+// returns the duration to wait before retrying.
+func (c *Chat) send(target string, msg message) (time.Duration, error) {
+	switch c.o.Platform {
+	case PlatformSlack:
+		return c.sendSlack(target, msg)
+	case PlatformTelegram:
+		return c.sendTelegram(target, msg)
+	case PlatformMatrix:
+		return c.sendMatrix(target, msg)
+	}
+
+	return 0, fmt.Errorf("unknown chat platform: %s", c.o.Platform)
+}
+
+func (c *Chat) sendSlack(target string, msg message) (time.Duration, error) {
+	body := map[string]any{
+		"text":   truncate(msg.text, slackMaxLen),
+		"mrkdwn": true,
+	}
+	if target != "" {
+		body["channel"] = target
+	}
+
+	hdr := http.Header{}
+	if c.o.Token != "" {
+		hdr.Set("Authorization", "Bearer "+c.o.Token)
+	}
+
+	code, b, rHdr, err := c.do(http.MethodPost, c.o.RootURL, body, hdr)
+	if err != nil {
+		return 0, err
+	}
+	if code == http.StatusTooManyRequests {
+		return retryAfter(rHdr), errors.New("slack: rate limited")
+	}
+	if code != http.StatusOK {
+		return 0, fmt.Errorf("slack: non-OK response: %d: %s", code, truncate(string(b), 200))
+	}
+
+	// Incoming webhooks respond with "ok". The API responds with {"ok": bool, "error": ""}.
+	if c.o.Token != "" {
+		var r struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(b, &r); err != nil {
+			return 0, fmt.Errorf("slack: error parsing response: %v", err)
+		}
+		if !r.OK {
+			return 0, fmt.Errorf("slack: %s", r.Error)
+		}
+	}
+
+	return 0, nil
+}
+
+func (c *Chat) sendTelegram(target string, msg message) (time.Duration, error) {
+	body := map[string]any{
+		"chat_id":    target,
+		"text":       msg.text,
+		"parse_mode": "HTML",
+	}
+
+	// Truncating HTML may leave broken tags that Telegram rejects. Send plain text instead.
+	if len([]rune(msg.text)) > telegramMaxLen {
+		body["text"] = truncate(msg.plain, telegramMaxLen)
+		delete(body, "parse_mode")
+	}
+
+	u := strings.TrimRight(c.o.RootURL, "/") + "/bot" + c.o.Token + "/sendMessage"
+	code, b, _, err := c.do(http.MethodPost, u, body, nil)
+	if err != nil {
+		// Don't leak the token in the URL in the error.
+		return 0, errors.New(strings.ReplaceAll(err.Error(), c.o.Token, "***"))
+	}
+
+	var r struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return 0, fmt.Errorf("telegram: non-OK response: %d", code)
+	}
+
+	if code == http.StatusTooManyRequests {
+		return time.Duration(max(r.Parameters.RetryAfter, 1)) * time.Second, errors.New("telegram: rate limited")
+	}
+	if !r.OK {
+		return 0, fmt.Errorf("telegram: %s", r.Description)
+	}
+
+	return 0, nil
+}
+
+func (c *Chat) sendMatrix(target string, msg message) (time.Duration, error) {
+	body := map[string]any{
+		"msgtype": "m.text",
+		"body":    truncate(msg.plain, matrixMaxLen),
+	}
+	if len([]rune(msg.text)) <= matrixMaxLen {
+		body["format"] = "org.matrix.custom.html"
+		body["formatted_body"] = strings.ReplaceAll(msg.text, "\n", "<br>")
+	}
+
+	// Transaction IDs make retries idempotent.
+	txn := fmt.Sprintf("listmonk-%d-%d", time.Now().UnixNano(), c.txn.Add(1))
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(c.o.RootURL, "/"), url.PathEscape(target), txn)
+
+	hdr := http.Header{}
+	hdr.Set("Authorization", "Bearer "+c.o.Token)
+
+	code, b, rHdr, err := c.do(http.MethodPut, u, body, hdr)
+	if err != nil {
+		return 0, err
+	}
+	if code == http.StatusOK {
+		return 0, nil
+	}
+
+	var r struct {
+		ErrCode      string `json:"errcode"`
+		Error        string `json:"error"`
+		RetryAfterMS int    `json:"retry_after_ms"`
+	}
+	json.Unmarshal(b, &r)
+
+	if code == http.StatusTooManyRequests {
+		wait := time.Duration(r.RetryAfterMS) * time.Millisecond
+		if wait <= 0 {
+			wait = retryAfter(rHdr)
+		}
+		return wait, errors.New("matrix: rate limited")
+	}
+
+	return 0, fmt.Errorf("matrix: non-OK response: %d: %s %s", code, r.ErrCode, r.Error)
+}
+
+// do makes a JSON request and returns the response status, body, and headers.
+func (c *Chat) do(method, u string, body any, hdr http.Header) (int, []byte, http.Header, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, method, u, bytes.NewReader(b))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if hdr != nil {
+		req.Header = hdr
+	}
+	req.Header.Set("User-Agent", "listmonk")
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := c.c.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer r.Body.Close()
+
+	// Responses are small. Limit reading to guard against misbehaving servers.
+	out, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return r.StatusCode, out, r.Header, nil
+}
+
+// retryAfter returns the wait in the Retry-After (seconds) header, or a second.
+func retryAfter(h http.Header) time.Duration {
+	if n, err := strconv.Atoi(h.Get("Retry-After")); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+
+	return time.Second
+}
+
+// truncate truncates a string to n characters.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+
+	return string(r[:n-1]) + "…"
+}
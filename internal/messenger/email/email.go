@@ -37,21 +37,25 @@ type Server struct {
 	smtppool.Opt `json:",squash"`
 
 	pool *smtppool.Pool
+	raw  *rawPool
 }
 
 // Emailer is the SMTP e-mail messenger.
 type Emailer struct {
 	servers []*Server
 	name    string
+	sec     *Security
 }
 
 // New returns an SMTP e-mail Messenger backend with the given SMTP servers.
 // Group indicates whether the messenger represents a group of SMTP servers (1 or more)
-// that are used as a round-robin pool, or a single server.
-func New(name string, servers ...Server) (*Emailer, error) {
+// that are used as a round-robin pool, or a single server. sec, if set, signs and
+// encrypts messages as per their security policy.
+func New(name string, sec *Security, servers ...Server) (*Emailer, error) {
 	e := &Emailer{
 		servers: make([]*Server, 0, len(servers)),
 		name:    name,
+		sec:     sec,
 	}
 
 	for _, srv := range servers {
@@ -96,6 +100,7 @@ func New(name string, servers ...Server) (*Emailer, error) {
 		}
 
 		s.pool = pool
+		s.raw = newRawPool(s.Opt)
 		e.servers = append(e.servers, &s)
 	}
 
@@ -189,6 +194,29 @@ func (e *Emailer) Push(m models.Message) error {
 		}
 	}
 
+	// Sign and/or encrypt the message. As smtppool only sends the messages that
+	// it builds, secured messages are sent on a separate pool of connections.
+	if e.sec != nil && m.Security != "" && m.Security != models.SecurityNone {
+		p, err := e.sec.plan(m.Security, m.Subscriber)
+		if err != nil {
+			return err
+		}
+
+		if p != nil {
+			b, err := em.Bytes()
+			if err != nil {
+				return err
+			}
+
+			out, err := e.sec.wrap(b, p)
+			if err != nil {
+				return err
+			}
+
+			return srv.raw.send(em, out)
+		}
+	}
+
 	return srv.pool.Send(em)
 }
 
@@ -201,6 +229,7 @@ func (e *Emailer) Flush() error {
 func (e *Emailer) Close() error {
 	for _, s := range e.servers {
 		s.pool.Close()
+		s.raw.close()
 	}
 	return nil
 }
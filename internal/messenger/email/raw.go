@@ -0,0 +1,226 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/knadh/smtppool/v2"
+)
+
+// rawPool is a pool of SMTP connections for sending messages that are already
+// built, ie: signed or encrypted ones, as smtppool only sends the messages that
+// it builds itself.
+type rawPool struct {
+	opt smtppool.Opt
+
+	// conns holds the idle connections. Its capacity is the max number of
+	// connections. A nil value is a free slot to open a new connection in.
+	conns chan *rawConn
+}
+
+type rawConn struct {
+	c    *smtp.Client
+	used time.Time
+}
+
+func newRawPool(opt smtppool.Opt) *rawPool {
+	n := max(opt.MaxConns, 1)
+
+	p := &rawPool{
+		opt:   opt,
+		conns: make(chan *rawConn, n),
+	}
+	for range n {
+		p.conns <- nil
+	}
+
+	return p
+}
+
+// send sends a raw message with the envelope of the given e-mail. Failures
+// are retried on new connections up to MaxMessageRetries times.
+func (p *rawPool) send(em smtppool.Email, msg []byte) error {
+	from, to, err := envelope(em)
+	if err != nil {
+		return err
+	}
+
+	for range max(p.opt.MaxMessageRetries, 1) {
+		var retry bool
+		if retry, err = p.trySend(from, to, msg); err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// trySend sends a message on a pooled connection. It returns true if the
+// failed message can be retried.
+func (p *rawPool) trySend(from string, to []string, msg []byte) (bool, error) {
+	wait := p.opt.PoolWaitTimeout
+	if wait <= 0 {
+		wait = time.Second * 10
+	}
+
+	var c *rawConn
+	select {
+	case c = <-p.conns:
+	case <-time.After(wait):
+		return false, errors.New("timed out waiting for a free SMTP connection")
+	}
+
+	// The server may have closed connections that have been idle for long.
+	if c != nil && p.opt.IdleTimeout > 0 && time.Since(c.used) > p.opt.IdleTimeout {
+		c.c.Close()
+		c = nil
+	}
+
+	if c == nil {
+		sm, err := p.dial()
+		if err != nil {
+			p.conns <- nil
+			return true, err
+		}
+		c = &rawConn{c: sm}
+	}
+
+	if err := sendRaw(c.c, from, to, msg); err != nil {
+		// The connection may be in an unknown state. Discard it.
+		c.c.Close()
+		p.conns <- nil
+
+		// Permanent (5xx) SMTP errors are not retried.
+		var tErr *textproto.Error
+		return !(errors.As(err, &tErr) && tErr.Code >= 500), err
+	}
+
+	c.used = time.Now()
+	p.conns <- c
+
+	return false, nil
+}
+
+// dial opens and authenticates a new SMTP connection the same way smtppool does.
+func (p *rawPool) dial() (sm *smtp.Client, err error) {
+	var (
+		conn net.Conn
+		addr = net.JoinHostPort(p.opt.Host, strconv.Itoa(p.opt.Port))
+	)
+	if p.opt.SSL == smtppool.SSLTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: p.opt.PoolWaitTimeout}, "tcp", addr, p.opt.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, p.opt.PoolWaitTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err = smtp.NewClient(conn, p.opt.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			sm.Close()
+		}
+	}()
+
+	if p.opt.HelloHostname != "" {
+		if err := sm.Hello(p.opt.HelloHostname); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.opt.SSL == smtppool.SSLSTARTTLS {
+		if ok, _ := sm.Extension("STARTTLS"); !ok {
+			return nil, errors.New("SMTP STARTTLS extension not found")
+		}
+		if err := sm.StartTLS(p.opt.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.opt.Auth != nil {
+		if ok, _ := sm.Extension("AUTH"); !ok {
+			return nil, errors.New("SMTP AUTH extension not found")
+		}
+		if err := sm.Auth(p.opt.Auth); err != nil {
+			return nil, err
+		}
+	}
+
+	return sm, nil
+}
+
+// close closes the idle connections in the pool.
+func (p *rawPool) close() {
+	for range cap(p.conns) {
+		select {
+		case c := <-p.conns:
+			if c != nil {
+				c.c.Quit()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// sendRaw sends a message in a single SMTP transaction.
+func sendRaw(c *smtp.Client, from string, to []string, msg []byte) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, r := range to {
+		if err := c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// envelope returns the SMTP envelope sender and recipients of an e-mail.
+func envelope(em smtppool.Email) (string, []string, error) {
+	sender := em.Sender
+	if sender == "" {
+		sender = em.From
+	}
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", nil, err
+	}
+
+	to := make([]string, 0, len(em.To)+len(em.Cc)+len(em.Bcc))
+	for _, lst := range [][]string{em.To, em.Cc, em.Bcc} {
+		for _, a := range lst {
+			addr, err := mail.ParseAddress(a)
+			if err != nil {
+				return "", nil, err
+			}
+			to = append(to, addr.Address)
+		}
+	}
+	if len(to) == 0 {
+		return "", nil, errors.New("no recipients")
+	}
+
+	return from.Address, to, nil
+}
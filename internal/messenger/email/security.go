@@ -0,0 +1,538 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/knadh/listmonk/models"
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	MethodSMIME = "smime"
+	MethodPGP   = "pgp"
+
+	// Subscriber attributes that hold the recipient's public keys.
+	AttribPGPKey    = "pgp_key"
+	AttribSMIMECert = "smime_cert"
+)
+
+var (
+	// ErrNoRecipientKey is returned when a message has to be encrypted
+	// but the recipient doesn't have a public key or certificate.
+	ErrNoRecipientKey = errors.New("recipient has no PGP key or S/MIME certificate to encrypt with")
+
+	// ErrNoSigningKey is returned when a message has to be signed
+	// but no S/MIME or PGP signing key is configured.
+	ErrNoSigningKey = errors.New("no S/MIME or PGP signing key is configured in security settings")
+)
+
+// SecurityOpt represents the keys used to sign e-mails.
+type SecurityOpt struct {
+	// Method is the preferred method (smime, pgp) for signing, and for
+	// encrypting to recipients who have keys for both.
+	Method string `json:"method"`
+
+	// PEM encoded S/MIME certificate (followed by any intermediate
+	// certificates) and its private key.
+	SMIMECert string `json:"smime_cert"`
+	SMIMEKey  string `json:"smime_key"`
+
+	// ASCII armored OpenPGP private key and its passphrase, if any.
+	PGPKey        string `json:"pgp_key"`
+	PGPPassphrase string `json:"pgp_passphrase"`
+}
+
+// Security signs and encrypts outgoing e-mails with S/MIME or OpenPGP.
+// Messages are only signed if the keys for the method are set. Recipients'
+// public keys for encryption are read from their subscriber attributes.
+type Security struct {
+	method string
+
+	smimeCerts []*x509.Certificate
+	smimeKey   crypto.PrivateKey
+
+	pgpKey *openpgp.Entity
+	pgpCfg *packet.Config
+}
+
+// plan is what's to be done to a particular message.
+type plan struct {
+	method string
+	sign   bool
+
+	// Recipient's key to encrypt with. Only one of them is set.
+	pgpTo  *openpgp.Entity
+	certTo *x509.Certificate
+}
+
+func init() {
+	// The library defaults to DES, which is insecure. AES-256-CBC is
+	// supported by all S/MIME clients.
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// NewSecurity returns a Security instance with the given signing keys.
+// Keys that are not set are ignored.
+func NewSecurity(o SecurityOpt) (*Security, error) {
+	s := &Security{
+		method: o.Method,
+		pgpCfg: &packet.Config{DefaultHash: crypto.SHA256},
+	}
+	if s.method != MethodPGP {
+		s.method = MethodSMIME
+	}
+
+	// S/MIME certificate chain and key.
+	if strings.TrimSpace(o.SMIMECert) != "" || strings.TrimSpace(o.SMIMEKey) != "" {
+		certs, err := parseCerts(o.SMIMECert)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing S/MIME certificate: %v", err)
+		}
+
+		key, err := parsePrivateKey(o.SMIMEKey)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing S/MIME private key: %v", err)
+		}
+
+		pub, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported S/MIME private key type")
+		}
+		if k, ok := pub.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(certs[0].PublicKey) {
+			return nil, errors.New("S/MIME private key doesn't match the certificate")
+		}
+
+		s.smimeCerts = certs
+		s.smimeKey = key
+
+		// Sign a dummy message to validate the chain and the key type.
+		if _, err := s.smimeSign([]byte("\r\n")); err != nil {
+			return nil, fmt.Errorf("error signing with S/MIME key: %v", err)
+		}
+	}
+
+	// OpenPGP key.
+	if strings.TrimSpace(o.PGPKey) != "" {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(o.PGPKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing PGP key: %v", err)
+		}
+		if len(keys) == 0 {
+			return nil, errors.New("no PGP key found")
+		}
+
+		e := keys[0]
+		if e.PrivateKey == nil {
+			return nil, errors.New("PGP key is not a private key")
+		}
+		if o.PGPPassphrase != "" {
+			if err := e.DecryptPrivateKeys([]byte(o.PGPPassphrase)); err != nil {
+				return nil, fmt.Errorf("error decrypting PGP key: %v", err)
+			}
+		}
+		if e.PrivateKey.Encrypted {
+			return nil, errors.New("PGP key is encrypted and requires a passphrase")
+		}
+
+		s.pgpKey = e
+	}
+
+	return s, nil
+}
+
+// plan decides how a message to a subscriber is to be secured as per the
+// security policy (models.Security*). It returns nil if the message is to be
+// sent as-is. Every policy signs messages, and it's an error if there's no
+// signing key.
+func (s *Security) plan(policy string, sub models.Subscriber) (*plan, error) {
+	switch policy {
+	case models.SecuritySign, models.SecurityEncrypt, models.SecurityEncryptRequired:
+	default:
+		return nil, nil
+	}
+
+	if s.smimeKey == nil && s.pgpKey == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	// Sign with the preferred method, or the other one if there's no key for it.
+	p := &plan{method: s.method}
+	if !s.canSign(p.method) {
+		p.method = MethodPGP
+		if s.method == MethodPGP {
+			p.method = MethodSMIME
+		}
+	}
+
+	if policy != models.SecuritySign {
+		// If encryption is optional, messages to recipients with invalid or
+		// expired keys are only signed, the same as those without keys.
+		pgpTo, certTo, err := recipientKeys(sub)
+		if err != nil {
+			if policy == models.SecurityEncryptRequired {
+				return nil, err
+			}
+			pgpTo, certTo = nil, nil
+		}
+
+		// Use the signing method if the recipient has keys for both.
+		switch {
+		case pgpTo != nil && (certTo == nil || p.method == MethodPGP):
+			p.method, p.pgpTo = MethodPGP, pgpTo
+		case certTo != nil:
+			p.method, p.certTo = MethodSMIME, certTo
+		case policy == models.SecurityEncryptRequired:
+			return nil, ErrNoRecipientKey
+		}
+	}
+
+	// Messages encrypted with a method that there's no signing key for
+	// are not signed.
+	p.sign = s.canSign(p.method)
+
+	return p, nil
+}
+
+// wrap applies a plan to a message.
+func (s *Security) wrap(msg []byte, p *plan) ([]byte, error) {
+	hdr, entity, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.method {
+	case MethodPGP:
+		// A signature is embedded in the encrypted data, instead of it being
+		// signed and then encrypted.
+		if p.pgpTo != nil {
+			entity, err = s.pgpEncrypt(entity, p.pgpTo, p.sign)
+		} else {
+			entity, err = s.pgpSign(entity)
+		}
+
+	default:
+		if p.sign {
+			if entity, err = s.smimeSign(entity); err != nil {
+				return nil, err
+			}
+		}
+		if p.certTo != nil {
+			entity, err = smimeEncrypt(entity, p.certTo)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(hdr, entity...), nil
+}
+
+func (s *Security) canSign(method string) bool {
+	if method == MethodPGP {
+		return s.pgpKey != nil
+	}
+	return s.smimeKey != nil
+}
+
+// smimeSign returns a multipart/signed entity with a detached S/MIME signature.
+func (s *Security) smimeSign(entity []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(s.smimeCerts[0], s.smimeKey, s.smimeCerts[1:], pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	sd.Detach()
+
+	sig, err := sd.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bound = newBoundary()
+		b     bytes.Buffer
+	)
+	fmt.Fprintf(&b, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;\r\n boundary=\"%s\"\r\n\r\n", bound)
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.Write(entity)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", bound)
+	b.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	writeBase64(&b, sig)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// smimeEncrypt returns an S/MIME enveloped entity encrypted to the given certificate.
+func smimeEncrypt(entity []byte, cert *x509.Certificate) ([]byte, error) {
+	enc, err := pkcs7.Encrypt(entity, []*x509.Certificate{cert})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	writeBase64(&b, enc)
+
+	return b.Bytes(), nil
+}
+
+// pgpSign returns a multipart/signed entity with a detached OpenPGP signature (RFC 3156).
+func (s *Security) pgpSign(entity []byte) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, s.pgpKey, bytes.NewReader(entity), s.pgpCfg); err != nil {
+		return nil, err
+	}
+
+	// micalg has to match the hash that the signature was made with.
+	pkt, err := packet.Read(bytes.NewReader(sig.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	sp, ok := pkt.(*packet.Signature)
+	if !ok {
+		return nil, errors.New("invalid PGP signature")
+	}
+	micalg := "pgp-" + strings.ToLower(strings.ReplaceAll(sp.Hash.String(), "-", ""))
+
+	arm, err := armorBytes("PGP SIGNATURE", sig.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bound = newBoundary()
+		b     bytes.Buffer
+	)
+	fmt.Fprintf(&b, "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=%s;\r\n boundary=\"%s\"\r\n\r\n", micalg, bound)
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.Write(entity)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", bound)
+	b.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+	b.WriteString("Content-Description: OpenPGP digital signature\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	b.Write(arm)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// pgpEncrypt returns a multipart/encrypted entity (RFC 3156) encrypted to
+// the given key, and optionally signed.
+func (s *Security) pgpEncrypt(entity []byte, to *openpgp.Entity, sign bool) ([]byte, error) {
+	var signer *openpgp.Entity
+	if sign {
+		signer = s.pgpKey
+	}
+
+	var enc bytes.Buffer
+	w, err := openpgp.Encrypt(&enc, []*openpgp.Entity{to}, signer, nil, s.pgpCfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(entity); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	arm, err := armorBytes("PGP MESSAGE", enc.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bound = newBoundary()
+		b     bytes.Buffer
+	)
+	fmt.Fprintf(&b, "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";\r\n boundary=\"%s\"\r\n\r\n", bound)
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	b.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	b.WriteString("Version: 1\r\n")
+	fmt.Fprintf(&b, "\r\n--%s\r\n", bound)
+	b.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	b.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	b.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	b.Write(arm)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// recipientKeys returns the PGP key and S/MIME certificate in a subscriber's
+// attributes, if any. Keys that are set but are invalid are errors.
+func recipientKeys(sub models.Subscriber) (*openpgp.Entity, *x509.Certificate, error) {
+	var (
+		now    = time.Now()
+		pgpTo  *openpgp.Entity
+		certTo *x509.Certificate
+	)
+
+	if v, _ := sub.Attribs[AttribPGPKey].(string); strings.TrimSpace(v) != "" {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(v))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid PGP key in subscriber attributes: %v", err)
+		}
+		if len(keys) == 0 {
+			return nil, nil, errors.New("no PGP key found in subscriber attributes")
+		}
+		if _, ok := keys[0].EncryptionKey(now); !ok {
+			return nil, nil, errors.New("subscriber's PGP key has expired or can't encrypt")
+		}
+		pgpTo = keys[0]
+	}
+
+	if v, _ := sub.Attribs[AttribSMIMECert].(string); strings.TrimSpace(v) != "" {
+		certs, err := parseCerts(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid S/MIME certificate in subscriber attributes: %v", err)
+		}
+
+		c := certs[0]
+		if now.Before(c.NotBefore) || now.After(c.NotAfter) {
+			return nil, nil, errors.New("subscriber's S/MIME certificate has expired or isn't valid yet")
+		}
+		if _, ok := c.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, nil, errors.New("only RSA S/MIME certificates are supported for encryption")
+		}
+		certTo = c
+	}
+
+	return pgpTo, certTo, nil
+}
+
+// splitMessage splits a raw message into its header fields and its MIME entity,
+// which is the Content-* header fields that describe the body, followed by the body.
+func splitMessage(msg []byte) ([]byte, []byte, error) {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, errors.New("invalid message: no header separator")
+	}
+
+	var (
+		hdr, entity []byte
+		isContent   bool
+	)
+	for _, ln := range bytes.SplitAfter(msg[:i+2], []byte("\r\n")) {
+		if len(ln) == 0 {
+			continue
+		}
+
+		// Folded lines belong to the previous field.
+		if ln[0] != ' ' && ln[0] != '\t' {
+			isContent = len(ln) > 8 && strings.EqualFold(string(ln[:8]), "content-")
+		}
+
+		if isContent {
+			entity = append(entity, ln...)
+		} else {
+			hdr = append(hdr, ln...)
+		}
+	}
+
+	entity = append(entity, "\r\n"...)
+	entity = append(entity, msg[i+4:]...)
+
+	return hdr, entity, nil
+}
+
+// parseCerts parses one or more PEM encoded certificates.
+func parseCerts(s string) ([]*x509.Certificate, error) {
+	var (
+		rest  = []byte(s)
+		certs []*x509.Certificate
+	)
+	for {
+		var blk *pem.Block
+		blk, rest = pem.Decode(rest)
+		if blk == nil {
+			break
+		}
+		if blk.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #8, PKCS #1, or EC private key.
+func parsePrivateKey(s string) (crypto.PrivateKey, error) {
+	blk, _ := pem.Decode([]byte(s))
+	if blk == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+
+	switch blk.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(blk.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(blk.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(blk.Bytes)
+	}
+}
+
+// armorBytes ASCII armors b with CRLF line endings.
+func armorBytes(blockType string, b []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := armor.Encode(&out, blockType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return bytes.ReplaceAll(out.Bytes(), []byte("\n"), []byte("\r\n")), nil
+}
+
+// writeBase64 writes b as base64 in 76 character lines.
+func writeBase64(w io.Writer, b []byte) {
+	enc := base64.StdEncoding.EncodeToString(b)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc)
+}
+
+// newBoundary returns a random MIME boundary.
+func newBoundary() string {
+	return multipart.NewWriter(io.Discard).Boundary()
+}
@@ -0,0 +1,514 @@
+// Package plugin implements a messenger that delegates the delivery of
+// messages to an external plugin process. listmonk talks to the plugin with
+// newline-delimited JSON (NDJSON) requests and responses, either over the
+// stdin/stdout of a process that it launches, or over a TCP connection to
+// a plugin that runs independently.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// ProtocolVersion is the version of the plugin protocol. It's sent to
+// plugins in the init request.
+const ProtocolVersion = 1
+
+// RPC methods.
+const (
+	MethodInit   = "init"
+	MethodPush   = "push"
+	MethodFlush  = "flush"
+	MethodHealth = "health"
+	MethodClose  = "close"
+)
+
+const (
+	defaultTimeout = time.Second * 10
+
+	// healthInterval is the interval at which plugins are health checked.
+	// A plugin that has exited or disconnected is restarted on the next check.
+	healthInterval = time.Second * 30
+
+	// closeWait is the duration to wait for a launched plugin process to
+	// exit after it's closed before it's killed.
+	closeWait = time.Second * 5
+
+	// maxLineSize is the max size of a response line from a plugin.
+	maxLineSize = 10 * 1024 * 1024
+)
+
+var errNotConnected = errors.New("plugin is not running")
+
+// Options represents the options of a plugin messenger.
+type Options struct {
+	Name string `json:"name"`
+
+	// Command is the plugin executable (in Dir) to launch, followed by its
+	// optional whitespace separated arguments. Either Command or Address is required.
+	Command string `json:"command"`
+
+	// Address is the TCP host:port of a plugin that runs independently.
+	Address string `json:"address"`
+
+	// Config is an optional JSON object that's passed to the plugin on init.
+	Config string `json:"config"`
+
+	Timeout time.Duration `json:"timeout"`
+
+	// Dir is the directory that plugin executables are launched from.
+	Dir string `json:"-"`
+}
+
+// Plugin is a messenger that pushes messages to an external plugin.
+type Plugin struct {
+	o   Options
+	log *log.Logger
+
+	// Current connection to the plugin, if it's running.
+	conn    *conn
+	connMut sync.Mutex
+
+	chClose  chan struct{}
+	closeOne sync.Once
+}
+
+// conn is a connection to a running plugin that multiplexes concurrent
+// requests and matches responses to them by their IDs.
+type conn struct {
+	w   io.WriteCloser
+	cmd *exec.Cmd
+	nc  net.Conn
+
+	lastID  uint64
+	pending map[uint64]chan response
+	mut     sync.Mutex
+	wMut    sync.Mutex
+
+	// Closed when the plugin exits or disconnects.
+	done chan struct{}
+	err  error
+}
+
+// request is an RPC request to a plugin.
+type request struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+// response is an RPC response from a plugin. A non-empty error is a failure.
+type response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// initParams are the params of the init request.
+type initParams struct {
+	Name     string          `json:"name"`
+	Protocol int             `json:"protocol"`
+	Config   json.RawMessage `json:"config,omitempty"`
+}
+
+// message is the payload of a push request.
+type message struct {
+	Subject     string               `json:"subject"`
+	FromEmail   string               `json:"from_email"`
+	To          []string             `json:"to"`
+	ContentType string               `json:"content_type"`
+	Body        string               `json:"body"`
+	AltBody     string               `json:"alt_body,omitempty"`
+	Headers     textproto.MIMEHeader `json:"headers,omitempty"`
+	Subscriber  subscriber           `json:"subscriber"`
+	Campaign    *campaign            `json:"campaign,omitempty"`
+	Attachments []attachment         `json:"attachments,omitempty"`
+}
+
+type subscriber struct {
+	UUID    string      `json:"uuid"`
+	Email   string      `json:"email"`
+	Name    string      `json:"name"`
+	Attribs models.JSON `json:"attribs"`
+	Status  string      `json:"status"`
+}
+
+type campaign struct {
+	UUID      string         `json:"uuid"`
+	Name      string         `json:"name"`
+	FromEmail string         `json:"from_email"`
+	Headers   models.Headers `json:"headers"`
+	Tags      []string       `json:"tags"`
+}
+
+type attachment struct {
+	Name    string               `json:"name"`
+	Header  textproto.MIMEHeader `json:"header"`
+	Content []byte               `json:"content"`
+}
+
+// New returns a new instance of a plugin messenger. The plugin is launched
+// (or connected to) and initialized immediately. If that fails, it's retried
+// on the next request or health check.
+func New(o Options, lo *log.Logger) (*Plugin, error) {
+	if o.Command == "" && o.Address == "" {
+		return nil, errors.New("plugin command or address is required")
+	}
+	if o.Config != "" && !json.Valid([]byte(o.Config)) {
+		return nil, errors.New("plugin config is not valid JSON")
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+
+	p := &Plugin{
+		o:       o,
+		log:     lo,
+		chClose: make(chan struct{}),
+	}
+
+	if _, err := p.getConn(); err != nil {
+		lo.Println(err)
+	}
+
+	go p.checkHealth()
+
+	return p, nil
+}
+
+// Name returns the messenger's name.
+func (p *Plugin) Name() string {
+	return p.o.Name
+}
+
+// Push pushes a message to the plugin.
+func (p *Plugin) Push(m models.Message) error {
+	msg := message{
+		Subject:     m.Subject,
+		FromEmail:   m.From,
+		To:          m.To,
+		ContentType: m.ContentType,
+		Body:        string(m.Body),
+		AltBody:     string(m.AltBody),
+		Headers:     m.Headers,
+		Subscriber: subscriber{
+			UUID:    m.Subscriber.UUID,
+			Email:   m.Subscriber.Email,
+			Name:    m.Subscriber.Name,
+			Attribs: m.Subscriber.Attribs,
+			Status:  m.Subscriber.Status,
+		},
+	}
+
+	if m.Campaign != nil {
+		msg.Campaign = &campaign{
+			UUID:      m.Campaign.UUID,
+			Name:      m.Campaign.Name,
+			FromEmail: m.Campaign.FromEmail,
+			Headers:   m.Campaign.Headers,
+			Tags:      m.Campaign.Tags,
+		}
+	}
+
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, attachment{
+			Name:    a.Name,
+			Header:  a.Header,
+			Content: a.Content,
+		})
+	}
+
+	return p.call(MethodPush, msg)
+}
+
+// Flush asks the plugin to send out messages that it may have buffered.
+func (p *Plugin) Flush() error {
+	return p.call(MethodFlush, nil)
+}
+
+// Health checks whether the plugin is running and healthy.
+func (p *Plugin) Health() error {
+	return p.call(MethodHealth, nil)
+}
+
+// Close asks the plugin to close and stops it.
+func (p *Plugin) Close() error {
+	p.closeOne.Do(func() {
+		close(p.chClose)
+	})
+
+	p.connMut.Lock()
+	c := p.conn
+	p.conn = nil
+	p.connMut.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	err := c.call(MethodClose, nil, p.o.Timeout)
+	c.close()
+	return err
+}
+
+// call makes a request to the plugin, (re)starting it if it isn't running.
+func (p *Plugin) call(method string, params any) error {
+	c, err := p.getConn()
+	if err != nil {
+		return err
+	}
+
+	return c.call(method, params, p.o.Timeout)
+}
+
+// getConn returns the current connection to the plugin. If the plugin
+// has exited or disconnected, it's (re)started and initialized.
+func (p *Plugin) getConn() (*conn, error) {
+	p.connMut.Lock()
+	defer p.connMut.Unlock()
+
+	select {
+	case <-p.chClose:
+		return nil, errNotConnected
+	default:
+	}
+
+	if p.conn != nil {
+		select {
+		case <-p.conn.done:
+			p.log.Printf("plugin messenger %s exited: %v. restarting", p.o.Name, p.conn.err)
+			p.conn.close()
+			p.conn = nil
+		default:
+			return p.conn, nil
+		}
+	}
+
+	c, err := p.connect()
+	if err != nil {
+		return nil, fmt.Errorf("error starting plugin %s: %v", p.o.Name, err)
+	}
+
+	// Initialize the plugin.
+	params := initParams{Name: p.o.Name, Protocol: ProtocolVersion}
+	if p.o.Config != "" {
+		params.Config = json.RawMessage(p.o.Config)
+	}
+	if err := c.call(MethodInit, params, p.o.Timeout); err != nil {
+		c.close()
+		return nil, fmt.Errorf("error initializing plugin %s: %v", p.o.Name, err)
+	}
+
+	p.conn = c
+	return c, nil
+}
+
+// connect launches the plugin process or connects to its address.
+func (p *Plugin) connect() (*conn, error) {
+	c := &conn{
+		pending: make(map[uint64]chan response),
+		done:    make(chan struct{}),
+	}
+
+	// Connect to a plugin running independently.
+	if p.o.Address != "" {
+		nc, err := net.DialTimeout("tcp", p.o.Address, p.o.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.nc = nc
+		c.w = nc
+
+		go c.read(nc)
+		return c, nil
+	}
+
+	// Launch the plugin process.
+	path, args, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = p.o.Dir
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// Relay the plugin's stderr to the log.
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c.cmd = cmd
+	c.w = stdin
+
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			p.log.Printf("plugin %s: %s", p.o.Name, sc.Text())
+		}
+	}()
+
+	go c.read(stdout)
+	return c, nil
+}
+
+// command returns the path of the plugin executable in the plugin directory
+// and its arguments.
+func (p *Plugin) command() (string, []string, error) {
+	if p.o.Dir == "" {
+		return "", nil, errors.New("plugin directory is not configured")
+	}
+
+	f := strings.Fields(p.o.Command)
+	if len(f) == 0 {
+		return "", nil, errors.New("plugin command is empty")
+	}
+
+	// Only executables directly in the plugin directory can be launched.
+	if filepath.Base(f[0]) != f[0] || f[0] == "." || f[0] == ".." {
+		return "", nil, fmt.Errorf("invalid plugin command: %s", f[0])
+	}
+
+	return filepath.Join(p.o.Dir, f[0]), f[1:], nil
+}
+
+// checkHealth periodically health checks the plugin, which also restarts
+// it if it has exited.
+func (p *Plugin) checkHealth() {
+	t := time.NewTicker(healthInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := p.Health(); err != nil {
+				p.log.Printf("plugin messenger %s is unhealthy: %v", p.o.Name, err)
+			}
+		case <-p.chClose:
+			return
+		}
+	}
+}
+
+// call writes a request to the plugin and waits for its response.
+func (c *conn) call(method string, params any, timeout time.Duration) error {
+	ch := make(chan response, 1)
+
+	c.mut.Lock()
+	c.lastID++
+	id := c.lastID
+	c.pending[id] = ch
+	c.mut.Unlock()
+
+	defer func() {
+		c.mut.Lock()
+		delete(c.pending, id)
+		c.mut.Unlock()
+	}()
+
+	b, err := json.Marshal(request{ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	c.wMut.Lock()
+	_, err = c.w.Write(b)
+	c.wMut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case r := <-ch:
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+		return nil
+	case <-c.done:
+		return errNotConnected
+	case <-t.C:
+		return fmt.Errorf("plugin timed out on %s", method)
+	}
+}
+
+// read reads responses from the plugin and dispatches them to the pending
+// requests until the plugin exits or disconnects.
+func (c *conn) read(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for sc.Scan() {
+		var res response
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			continue
+		}
+
+		c.mut.Lock()
+		ch, ok := c.pending[res.ID]
+		c.mut.Unlock()
+		if ok {
+			select {
+			case ch <- res:
+			default:
+			}
+		}
+	}
+
+	c.err = sc.Err()
+	if c.err == nil {
+		c.err = io.EOF
+	}
+	close(c.done)
+}
+
+// close closes the connection to the plugin and stops the plugin
+// process if it was launched.
+func (c *conn) close() {
+	c.w.Close()
+	if c.nc != nil {
+		c.nc.Close()
+		return
+	}
+
+	// Wait for the process to exit, or kill it.
+	exited := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(closeWait):
+		c.cmd.Process.Kill()
+	}
+}
@@ -2,16 +2,35 @@ package postback
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/textproto"
+	"strconv"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/Masterminds/sprig/v3"
 	"github.com/knadh/listmonk/models"
 )
 
+const (
+	// hdrSignature is the header that carries the HMAC-SHA256 signature of
+	// the request body in the format t={unix_timestamp},v1={hex_signature}.
+	hdrSignature = "X-Listmonk-Signature"
+
+	// defaultBatchWait is the max duration a batch waits to fill up before
+	// it's sent if a wait isn't configured.
+	defaultBatchWait = time.Second
+)
+
 // postback is the payload that's posted as JSON to the HTTP Postback server.
 //
 //easyjson:json
@@ -54,8 +73,22 @@ type Options struct {
 	Password string        `json:"password"`
 	RootURL  string        `json:"root_url"`
 	MaxConns int           `json:"max_conns"`
-	Retries  int           `json:"retries"`
+	Retries  int           `json:"max_msg_retries"`
 	Timeout  time.Duration `json:"timeout"`
+
+	// BatchSize is the max number of messages (recipients) sent in a single
+	// request. Batched messages are sent when the batch is full, BatchWait
+	// elapses, or the messenger is flushed. 0 or 1 disables batching.
+	// Batched messages are reported as sent optimistically when they're
+	// added to a batch.
+	BatchSize int           `json:"batch_size"`
+	BatchWait time.Duration `json:"batch_wait"`
+
+	// HMACSecret, if set, is used to sign request bodies.
+	HMACSecret string `json:"hmac_secret"`
+
+	// BodyTemplate is an optional Go template for the request body.
+	BodyTemplate string `json:"body_template"`
 }
 
 // Postback represents an HTTP Message server.
@@ -63,19 +96,45 @@ type Postback struct {
 	authStr string
 	o       Options
 	c       *http.Client
+	tpl     *template.Template
+	log     *log.Logger
+
+	// Messages waiting to be sent in the current batch.
+	batch    []postback
+	batchMut sync.Mutex
+	timer    *time.Timer
+
+	// Errors of batches that were sent in the background on BatchWait.
+	// They're returned by the next Flush().
+	batchErr error
 }
 
 // New returns a new instance of the HTTP Postback messenger.
-func New(o Options) (*Postback, error) {
+func New(o Options, lo *log.Logger) (*Postback, error) {
 	authStr := ""
 	if o.Username != "" && o.Password != "" {
 		authStr = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString(
 			[]byte(o.Username+":"+o.Password)))
 	}
 
+	var tpl *template.Template
+	if o.BodyTemplate != "" {
+		t, err := CompileTemplate(o.BodyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		tpl = t
+	}
+
+	if o.BatchSize > 1 && o.BatchWait <= 0 {
+		o.BatchWait = defaultBatchWait
+	}
+
 	return &Postback{
 		authStr: authStr,
 		o:       o,
+		tpl:     tpl,
+		log:     lo,
 		c: &http.Client{
 			Timeout: o.Timeout,
 			Transport: &http.Transport{
@@ -93,8 +152,159 @@ func (p *Postback) Name() string {
 	return p.o.Name
 }
 
-// Push pushes a message to the server.
+// CompileTemplate compiles a Postback request body template.
+func CompileTemplate(body string) (*template.Template, error) {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	delete(funcs, "getHostByName")
+
+	tpl, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling postback body template: %v", err)
+	}
+
+	return tpl, nil
+}
+
+// Push pushes a message to the server. In the batch mode, the message is
+// added to the current batch, which is sent once it's full. A message that's
+// added to a batch is reported as sent (nil). If the batch fails to send,
+// the error is returned by the Push that fills it or by Flush.
 func (p *Postback) Push(m models.Message) error {
+	pb := makePostback(m)
+	if p.o.BatchSize <= 1 {
+		return p.send([]postback{pb})
+	}
+
+	p.batchMut.Lock()
+	p.batch = append(p.batch, pb)
+
+	// The batch isn't full yet. Start the wait timer on the first message of the batch.
+	if len(p.batch) < p.o.BatchSize {
+		if len(p.batch) == 1 {
+			p.timer = time.AfterFunc(p.o.BatchWait, func() {
+				if err := p.sendBatch(); err != nil {
+					p.log.Printf("error sending postback batch (%s): %v", p.o.Name, err)
+
+					p.batchMut.Lock()
+					p.batchErr = errors.Join(p.batchErr, err)
+					p.batchMut.Unlock()
+				}
+			})
+		}
+		p.batchMut.Unlock()
+		return nil
+	}
+
+	batch := p.takeBatch()
+	p.batchMut.Unlock()
+
+	return p.send(batch)
+}
+
+// Flush sends the messages in the current batch to the server. It also
+// returns the errors of the batches that failed in the background since
+// the last flush.
+func (p *Postback) Flush() error {
+	err := p.sendBatch()
+
+	p.batchMut.Lock()
+	err = errors.Join(p.batchErr, err)
+	p.batchErr = nil
+	p.batchMut.Unlock()
+
+	return err
+}
+
+// Close flushes pending messages and closes idle HTTP connections.
+func (p *Postback) Close() error {
+	err := p.Flush()
+	p.c.CloseIdleConnections()
+
+	return err
+}
+
+// sendBatch sends the messages in the current batch to the server.
+func (p *Postback) sendBatch() error {
+	p.batchMut.Lock()
+	batch := p.takeBatch()
+	p.batchMut.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return p.send(batch)
+}
+
+// takeBatch returns the messages in the current batch and resets it.
+// The caller should hold batchMut.
+func (p *Postback) takeBatch() []postback {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	out := p.batch
+	p.batch = nil
+	return out
+}
+
+// send POSTs messages to the server. In the batch mode, the body is a JSON
+// array of postback payloads. If there's a body template, it's executed
+// with the payload (or the array of payloads in the batch mode) instead.
+// Failed requests are retried up to Retries times.
+func (p *Postback) send(msgs []postback) error {
+	var (
+		data any = msgs[0]
+		b    []byte
+	)
+	if p.o.BatchSize > 1 {
+		data = msgs
+	}
+
+	if p.tpl != nil {
+		var buf bytes.Buffer
+		if err := p.tpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("error executing postback body template: %v", err)
+		}
+		b = buf.Bytes()
+	} else if p.o.BatchSize > 1 {
+		buf := bytes.Buffer{}
+		buf.WriteByte('[')
+		for n, pb := range msgs {
+			j, err := pb.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(j)
+		}
+		buf.WriteByte(']')
+		b = buf.Bytes()
+	} else {
+		j, err := msgs[0].MarshalJSON()
+		if err != nil {
+			return err
+		}
+		b = j
+	}
+
+	var err error
+	for range max(p.o.Retries, 0) + 1 {
+		if err = p.exec(http.MethodPost, p.o.RootURL, b, nil); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// makePostback returns the postback payload of a message.
+func makePostback(m models.Message) postback {
 	pb := postback{
 		Subject:     m.Subject,
 		FromEmail:   m.From,
@@ -133,24 +343,7 @@ func (p *Postback) Push(m models.Message) error {
 		pb.Attachments = files
 	}
 
-	b, err := pb.MarshalJSON()
-	if err != nil {
-		return err
-	}
-
-	return p.exec(http.MethodPost, p.o.RootURL, b, nil)
-}
-
-// Flush flushes the message queue to the server.
-func (p *Postback) Flush() error {
-	return nil
-}
-
-// Close closes idle HTTP connections.
-func (p *Postback) Close() error {
-	p.c.CloseIdleConnections()
-
-	return nil
+	return pb
 }
 
 func (p *Postback) exec(method, rURL string, reqBody []byte, headers http.Header) error {
@@ -181,6 +374,16 @@ func (p *Postback) exec(method, rURL string, reqBody []byte, headers http.Header
 		req.Header.Set("Authorization", p.authStr)
 	}
 
+	// Optional HMAC signature of the timestamp and the body.
+	if p.o.HMACSecret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+		mac := hmac.New(sha256.New, []byte(p.o.HMACSecret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(reqBody)
+		req.Header.Set(hdrSignature, fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil))))
+	}
+
 	// If a content-type isn't set, set the default one.
 	if req.Header.Get("Content-Type") == "" {
 		if method == http.MethodPost || method == http.MethodPut {
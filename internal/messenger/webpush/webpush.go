@@ -0,0 +1,448 @@
+// Package webpush implements a messenger that sends messages as browser
+// push notifications to the web push subscriptions of subscribers
+// with VAPID (RFC 8292) authentication and aes128gcm (RFC 8291)
+// payload encryption.
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Name is the name of the web push messenger.
+const Name = "webpush"
+
+const (
+	// ttl is the duration (seconds) for which push services retain
+	// undelivered notifications.
+	ttl = 86400
+
+	// vapidExpiry is the validity of the VAPID JWTs.
+	vapidExpiry = time.Hour * 12
+
+	// recordSize is the aes128gcm record size. Payloads are always a single record.
+	recordSize = 4096
+
+	// maxBodyLen is the max number of characters of the notification body.
+	maxBodyLen = 500
+
+	defaultTimeout = time.Second * 10
+)
+
+var (
+	b64 = base64.RawURLEncoding
+
+	reStripBlocks = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	reStripTags   = regexp.MustCompile(`(?s)<[^>]+>`)
+	reSpaces      = regexp.MustCompile(`\s+`)
+)
+
+// Store is the interface to the store of web push subscriptions.
+type Store interface {
+	GetPushSubscriptions(subID int) ([]models.PushSubscription, error)
+	DeletePushSubscription(endpoint, subUUID string) error
+}
+
+// Options represents the web push messenger options.
+type Options struct {
+	// Subject is the VAPID contact of the sender (mailto: or https: URL).
+	Subject    string
+	PublicKey  string
+	PrivateKey string
+
+	// MessageURL is the format of the URL of a campaign message (campaign UUID,
+	// subscriber UUID) that's opened when a notification is clicked.
+	MessageURL string
+
+	// RootURL is the URL that's opened for notifications of messages that
+	// aren't campaigns, and IconURL is the notification icon.
+	RootURL string
+	IconURL string
+
+	MaxConns int
+	Timeout  time.Duration
+}
+
+// WebPush is a messenger that sends web push notifications.
+type WebPush struct {
+	o     Options
+	store Store
+	key   *ecdsa.PrivateKey
+	c     *http.Client
+	log   *log.Logger
+}
+
+// payload is the JSON notification payload that's sent to browsers
+// and displayed by the service worker.
+type payload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Icon  string `json:"icon,omitempty"`
+}
+
+// New returns a new instance of the web push messenger.
+func New(o Options, store Store, lo *log.Logger) (*WebPush, error) {
+	if o.Subject == "" {
+		return nil, errors.New("web push subject (mailto: or https: URL) is required")
+	}
+
+	key, err := parseVAPIDKey(o.PublicKey, o.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+
+	return &WebPush{
+		o:     o,
+		store: store,
+		key:   key,
+		log:   lo,
+		c: &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   o.MaxConns,
+				MaxConnsPerHost:       o.MaxConns,
+				ResponseHeaderTimeout: o.Timeout,
+				IdleConnTimeout:       o.Timeout,
+			},
+		},
+	}, nil
+}
+
+// GenerateVAPIDKeys generates a new VAPID key pair and returns the
+// base64 (URL) encoded public and private keys.
+func GenerateVAPIDKeys() (string, string, error) {
+	k, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return b64.EncodeToString(k.PublicKey().Bytes()), b64.EncodeToString(k.Bytes()), nil
+}
+
+// Name returns the messenger's name.
+func (w *WebPush) Name() string {
+	return Name
+}
+
+// Push sends a message as a push notification to all the web push
+// subscriptions of the message's subscriber. Subscribers without
+// subscriptions are skipped. Subscriptions that have expired or
+// have been unsubscribed (404, 410) are deleted.
+func (w *WebPush) Push(m models.Message) error {
+	if m.Subscriber.ID == 0 {
+		return nil
+	}
+
+	subs, err := w.store.GetPushSubscriptions(m.Subscriber.ID)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	p := payload{
+		Title: m.Subject,
+		Body:  makeBody(m),
+		URL:   w.o.RootURL,
+		Icon:  w.o.IconURL,
+	}
+	if m.Campaign != nil && w.o.MessageURL != "" {
+		p.URL = fmt.Sprintf(w.o.MessageURL, m.Campaign.UUID, m.Subscriber.UUID)
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	var (
+		sent    = 0
+		lastErr error
+	)
+	for _, s := range subs {
+		gone, err := w.send(s, b)
+		if gone {
+			if err := w.store.DeletePushSubscription(s.Endpoint, ""); err != nil {
+				w.log.Printf("error deleting expired push subscription: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+
+	// Only fail if the message couldn't be sent to any subscription.
+	if sent == 0 && lastErr != nil {
+		return lastErr
+	}
+
+	return nil
+}
+
+// Flush is a no-op as notifications are sent immediately.
+func (w *WebPush) Flush() error {
+	return nil
+}
+
+// Close closes idle HTTP connections.
+func (w *WebPush) Close() error {
+	w.c.CloseIdleConnections()
+	return nil
+}
+
+// send encrypts and POSTs a payload to a push subscription's endpoint.
+// It returns true if the subscription no longer exists.
+func (w *WebPush) send(s models.PushSubscription, b []byte) (bool, error) {
+	body, err := encrypt(b, s.KeyP256dh, s.KeyAuth)
+	if err != nil {
+		// The subscription's keys are invalid. It can never be sent to.
+		return true, err
+	}
+
+	auth, err := w.vapidAuth(s.Endpoint)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprintf("%d", ttl))
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := w.c.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		// Drain and close the body to let the Transport reuse the connection
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	switch {
+	case r.StatusCode == http.StatusNotFound || r.StatusCode == http.StatusGone:
+		return true, nil
+	case r.StatusCode >= http.StatusBadRequest:
+		return false, fmt.Errorf("non-OK response from push service: %d", r.StatusCode)
+	}
+
+	return false, nil
+}
+
+// vapidAuth returns the VAPID Authorization header with a signed JWT
+// (ES256) for the origin of a push endpoint.
+func (w *WebPush) vapidAuth(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidExpiry).Unix(),
+		"sub": w.o.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + b64.EncodeToString(claims)
+
+	h := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, w.key, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	// The JWS signature is the fixed size concatenation of r and s.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, b64.EncodeToString(sig), w.o.PublicKey), nil
+}
+
+// encrypt encrypts a payload for a push subscription's keys with the
+// aes128gcm content encoding (RFC 8291).
+func encrypt(plain []byte, p256dh, auth string) ([]byte, error) {
+	uaPubBytes, err := decodeB64(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %v", err)
+	}
+	uaPub, err := ecdh.P256().NewPublicKey(uaPubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %v", err)
+	}
+	authSecret, err := decodeB64(auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth key: %v", err)
+	}
+
+	// Ephemeral application server key pair and a random salt.
+	asPriv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return encryptWithKey(plain, uaPub, authSecret, asPriv, salt)
+}
+
+// encryptWithKey encrypts a payload with the given application server key and salt.
+func encryptWithKey(plain []byte, uaPub *ecdh.PublicKey, authSecret []byte, asPriv *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	var (
+		uaPubBytes = uaPub.Bytes()
+		asPub      = asPriv.PublicKey().Bytes()
+	)
+
+	secret, err := asPriv.ECDH(uaPub)
+	if err != nil {
+		return nil, err
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public, 32).
+	prkKey, err := hkdf.Extract(sha256.New, secret, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	info := "WebPush: info\x00" + string(uaPubBytes) + string(asPub)
+	ikm, err := hkdf.Expand(sha256.New, prkKey, info, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the content encryption key and the nonce.
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The payload is a single (last) record, which is terminated by the 0x02 delimiter.
+	if len(plain)+1+gcm.Overhead() > recordSize {
+		return nil, errors.New("push payload is too large")
+	}
+	record := append(append([]byte{}, plain...), 0x02)
+
+	// Header: salt (16) || record size (4) || key ID length (1) || key ID (as_public).
+	out := bytes.Buffer{}
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(recordSize))
+	out.WriteByte(byte(len(asPub)))
+	out.Write(asPub)
+	out.Write(gcm.Seal(nil, nonce, record, nil))
+
+	return out.Bytes(), nil
+}
+
+// parseVAPIDKey parses a base64 (URL) encoded VAPID key pair into an ECDSA key.
+func parseVAPIDKey(pub, priv string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeB64(priv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %v", err)
+	}
+
+	k, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %v", err)
+	}
+
+	// The public key should match the private key.
+	p := k.PublicKey().Bytes()
+	if b64.EncodeToString(p) != strings.TrimRight(pub, "=") {
+		return nil, errors.New("VAPID public key doesn't match the private key")
+	}
+
+	// Uncompressed point: 0x04 || X (32) || Y (32).
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(p[1:33]),
+			Y:     new(big.Int).SetBytes(p[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}, nil
+}
+
+// makeBody returns the plain text notification body of a message.
+func makeBody(m models.Message) string {
+	body := string(m.AltBody)
+	if body == "" {
+		body = string(m.Body)
+		if m.ContentType != models.CampaignContentTypePlain {
+			body = reStripBlocks.ReplaceAllString(body, " ")
+			body = html.UnescapeString(reStripTags.ReplaceAllString(body, " "))
+		}
+	}
+	body = strings.TrimSpace(reSpaces.ReplaceAllString(body, " "))
+
+	if utf8.RuneCountInString(body) > maxBodyLen {
+		body = string([]rune(body)[:maxBodyLen-1]) + "…"
+	}
+
+	return body
+}
+
+// decodeB64 decodes base64 (URL) strings with or without padding
+// as browsers encode subscription keys differently.
+func decodeB64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.NewReplacer("+", "-", "/", "_").Replace(s), "=")
+	return b64.DecodeString(s)
+}
@@ -0,0 +1,346 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+func V6_1_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	// Add the admin digest report, scheduled export, import concurrency, audit log retention,
+	// idempotency key TTL, tx message log retention, import job retention, web push, image processing, media
+	// deduplication and quota, WebDAV and SFTP media provider, and e-mail signing and encryption settings.
+	_, err := db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES
+			('app.digest_report', '{"enabled": false, "frequency": "weekly", "user_ids": []}', NOW()),
+			('app.scheduled_exports', '[]', NOW()),
+			('app.import_concurrency', '1', NOW()),
+			('security.audit_retention_days', '90', NOW()),
+			('app.idempotency_ttl', '"24h"', NOW()),
+			('privacy.tx_log_retention_days', '30', NOW()),
+			('privacy.import_retention_days', '30', NOW()),
+			('webpush.enabled', 'false', NOW()),
+			('webpush.subject', '""', NOW()),
+			('webpush.vapid_public_key', '""', NOW()),
+			('webpush.vapid_private_key', '""', NOW()),
+			('upload.image.max_width', '0', NOW()),
+			('upload.image.max_height', '0', NOW()),
+			('upload.image.quality', '85', NOW()),
+			('upload.image.strip_exif', 'true', NOW()),
+			('upload.image.formats', '[]', NOW()),
+			('upload.image.renditions', '[]', NOW()),
+			('upload.deduplicate', 'true', NOW()),
+			('upload.quota', '0', NOW()),
+			('upload.user_quota', '0', NOW()),
+			('upload.webdav.url', '""', NOW()),
+			('upload.webdav.username', '""', NOW()),
+			('upload.webdav.password', '""', NOW()),
+			('upload.webdav.path', '"/"', NOW()),
+			('upload.webdav.public_url', '""', NOW()),
+			('upload.webdav.max_conns', '5', NOW()),
+			('upload.webdav.timeout', '"30s"', NOW()),
+			('upload.sftp.host', '""', NOW()),
+			('upload.sftp.username', '""', NOW()),
+			('upload.sftp.password', '""', NOW()),
+			('upload.sftp.private_key', '""', NOW()),
+			('upload.sftp.host_key', '""', NOW()),
+			('upload.sftp.path', '"uploads"', NOW()),
+			('upload.sftp.public_url', '""', NOW()),
+			('upload.sftp.max_conns', '2', NOW()),
+			('upload.sftp.timeout', '"30s"', NOW()),
+			('security.email_crypto', '{"method": "smime", "smime_cert": "", "smime_key": "", "pgp_key": "", "pgp_passphrase": ""}', NOW())
+		ON CONFLICT (key) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the subscription history table.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'subscription_event') THEN
+			CREATE TYPE subscription_event AS ENUM ('subscribe', 'confirm', 'unsubscribe');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS subscription_events (
+		    id                 BIGSERIAL PRIMARY KEY,
+		    subscriber_id      INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		    list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		    event              subscription_event NOT NULL,
+		    source             TEXT NOT NULL DEFAULT '',
+		    created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_events_sub_id ON subscription_events(subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_sub_events_list_id ON subscription_events(list_id);
+		CREATE INDEX IF NOT EXISTS idx_sub_events_date ON subscription_events((TIMEZONE('UTC', created_at)::DATE));
+
+		-- Retain the events of deleted subscribers in tables created with ON DELETE CASCADE.
+		ALTER TABLE subscription_events ALTER COLUMN subscriber_id DROP NOT NULL;
+		ALTER TABLE subscription_events DROP CONSTRAINT IF EXISTS subscription_events_subscriber_id_fkey;
+		ALTER TABLE subscription_events ADD CONSTRAINT subscription_events_subscriber_id_fkey
+		    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+		CREATE OR REPLACE FUNCTION subscription_event_type(prev subscription_status, cur subscription_status)
+		RETURNS subscription_event AS $$
+		    SELECT (CASE
+		        WHEN prev IS NOT DISTINCT FROM cur THEN NULL
+		        WHEN cur = 'unsubscribed' THEN (CASE WHEN prev IS NULL THEN NULL ELSE 'unsubscribe' END)
+		        WHEN prev IS NULL OR prev = 'unsubscribed' THEN 'subscribe'
+		        WHEN cur = 'confirmed' THEN 'confirm'
+		        ELSE NULL
+		    END)::subscription_event;
+		$$ LANGUAGE SQL IMMUTABLE;
+	`); err != nil {
+		return err
+	}
+
+	// Recreate the dashboard charts view with the list growth data.
+	if _, err := db.Exec(`
+		DROP MATERIALIZED VIEW IF EXISTS mat_dashboard_charts;
+		CREATE MATERIALIZED VIEW mat_dashboard_charts AS
+		    WITH clicks AS (
+		        SELECT JSON_AGG(ROW_TO_JSON(row))
+		        FROM (
+		            WITH viewDates AS (
+		              SELECT TIMEZONE('UTC', created_at)::DATE AS to_date,
+		                     TIMEZONE('UTC', created_at)::DATE - INTERVAL '30 DAY' AS from_date
+		                     FROM link_clicks ORDER BY id DESC LIMIT 1
+		            )
+		            SELECT COUNT(*) AS count, created_at::DATE as date FROM link_clicks
+		              WHERE TIMEZONE('UTC', created_at)::DATE BETWEEN (SELECT from_date FROM viewDates) AND (SELECT to_date FROM viewDates)
+		              GROUP by date ORDER BY date
+		        ) row
+		    ),
+		    views AS (
+		        SELECT JSON_AGG(ROW_TO_JSON(row))
+		        FROM (
+		            WITH viewDates AS (
+		              SELECT TIMEZONE('UTC', created_at)::DATE AS to_date,
+		                     TIMEZONE('UTC', created_at)::DATE - INTERVAL '30 DAY' AS from_date
+		                     FROM campaign_views ORDER BY id DESC LIMIT 1
+		            )
+		            SELECT COUNT(*) AS count, created_at::DATE as date FROM campaign_views
+		              WHERE TIMEZONE('UTC', created_at)::DATE BETWEEN (SELECT from_date FROM viewDates) AND (SELECT to_date FROM viewDates)
+		              GROUP by date ORDER BY date
+		        ) row
+		    ),
+		    growth AS (
+		        SELECT JSON_AGG(ROW_TO_JSON(row))
+		        FROM (
+		            SELECT TIMEZONE('UTC', e.created_at)::DATE AS date, e.list_id, lists.name AS list_name,
+		                COUNT(*) FILTER (WHERE e.event = 'subscribe') AS subscribed,
+		                COUNT(*) FILTER (WHERE e.event = 'confirm') AS confirmed,
+		                COUNT(*) FILTER (WHERE e.event = 'unsubscribe') AS unsubscribed
+		            FROM subscription_events e
+		            JOIN lists ON (lists.id = e.list_id)
+		            WHERE TIMEZONE('UTC', e.created_at)::DATE BETWEEN TIMEZONE('UTC', NOW())::DATE - INTERVAL '30 DAY' AND TIMEZONE('UTC', NOW())::DATE
+		            GROUP BY date, e.list_id, lists.name ORDER BY date, e.list_id
+		        ) row
+		    )
+		    SELECT NOW() AS updated_at, JSON_BUILD_OBJECT('link_clicks', COALESCE((SELECT * FROM clicks), '[]'),
+		                                  'campaign_views', COALESCE((SELECT * FROM views), '[]'),
+		                                  'list_growth', COALESCE((SELECT * FROM growth), '[]')
+		                                ) AS data;
+		CREATE UNIQUE INDEX IF NOT EXISTS mat_dashboard_charts_idx ON mat_dashboard_charts (updated_at);
+	`); err != nil {
+		return err
+	}
+
+	// Add the audit log table.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_logs (
+		    id               BIGSERIAL PRIMARY KEY,
+		    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		    username         TEXT NOT NULL DEFAULT '',
+		    action           TEXT NOT NULL,
+		    target_type      TEXT NOT NULL,
+		    target_id        INTEGER NULL,
+		    route            TEXT NOT NULL DEFAULT '',
+		    diff             JSONB NOT NULL DEFAULT '{}',
+		    ip               TEXT NOT NULL DEFAULT '',
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_user_id ON audit_logs(user_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_target ON audit_logs(target_type, target_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_logs(created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Add the function for deep merging subscriber attributes in imports.
+	if _, err := db.Exec(`
+		-- Recursively merges JSONB object b into a. Keys in b overwrite the ones in a,
+		-- except where both values are objects, in which case they're merged.
+		CREATE OR REPLACE FUNCTION jsonb_deep_merge(a JSONB, b JSONB)
+		RETURNS JSONB AS $$
+		    SELECT (CASE
+		        WHEN b IS NULL THEN a
+		        WHEN JSONB_TYPEOF(a) = 'object' AND JSONB_TYPEOF(b) = 'object' THEN (
+		            SELECT COALESCE(JSONB_OBJECT_AGG(
+		                COALESCE(e1.key, e2.key),
+		                CASE
+		                    WHEN e1.value IS NULL THEN e2.value
+		                    WHEN e2.value IS NULL THEN e1.value
+		                    ELSE jsonb_deep_merge(e1.value, e2.value)
+		                END
+		            ), '{}')
+		            FROM JSONB_EACH(a) e1 FULL JOIN JSONB_EACH(b) e2 ON (e1.key = e2.key)
+		        )
+		        ELSE b
+		    END);
+		$$ LANGUAGE SQL IMMUTABLE;
+	`); err != nil {
+		return err
+	}
+
+	// Add the import jobs table.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS import_jobs (
+		    id               SERIAL PRIMARY KEY,
+		    name             TEXT NOT NULL,
+		    mode             TEXT NOT NULL,
+		    status           TEXT NOT NULL DEFAULT 'queued',
+		    options          JSONB NOT NULL DEFAULT '{}',
+		    total            INTEGER NOT NULL DEFAULT 0,
+		    imported         INTEGER NOT NULL DEFAULT 0,
+		    report           JSONB NOT NULL DEFAULT '{}',
+		    log              TEXT NOT NULL DEFAULT '',
+		    rejects_file     TEXT NOT NULL DEFAULT '',
+		    heartbeat_at     TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    started_at       TIMESTAMP WITH TIME ZONE NULL,
+		    finished_at      TIMESTAMP WITH TIME ZONE NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs(status);
+		CREATE INDEX IF NOT EXISTS idx_import_jobs_created_at ON import_jobs(created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Add the transactional message log tables.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tx_messages (
+		    id               BIGSERIAL PRIMARY KEY,
+		    uuid             UUID NOT NULL UNIQUE,
+		    batch_uuid       UUID NULL,
+		    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		    email            TEXT NOT NULL,
+		    template_id      INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+		    subject          TEXT NOT NULL DEFAULT '',
+		    messenger        TEXT NOT NULL DEFAULT '',
+		    status           TEXT NOT NULL DEFAULT 'queued',
+		    error            TEXT NOT NULL DEFAULT '',
+		    callback_url     TEXT NOT NULL DEFAULT '',
+		    payload          JSONB NULL,
+		    views            INTEGER NOT NULL DEFAULT 0,
+		    clicks           INTEGER NOT NULL DEFAULT 0,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    viewed_at        TIMESTAMP WITH TIME ZONE NULL,
+		    clicked_at       TIMESTAMP WITH TIME ZONE NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_tx_messages_batch_uuid ON tx_messages(batch_uuid);
+		CREATE INDEX IF NOT EXISTS idx_tx_messages_email ON tx_messages(LOWER(email));
+		CREATE INDEX IF NOT EXISTS idx_tx_messages_sub_id ON tx_messages(subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_tx_messages_created_at ON tx_messages(created_at);
+		CREATE INDEX IF NOT EXISTS idx_tx_messages_queued ON tx_messages(id) WHERE status = 'queued';
+
+		CREATE TABLE IF NOT EXISTS tx_link_clicks (
+		    id               BIGSERIAL PRIMARY KEY,
+		    tx_message_id    BIGINT NOT NULL REFERENCES tx_messages(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		    link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_tx_clicks_msg_id ON tx_link_clicks(tx_message_id);
+	`); err != nil {
+		return err
+	}
+
+	// Add the idempotency keys table.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+		    key              TEXT NOT NULL PRIMARY KEY,
+		    request_hash     TEXT NOT NULL,
+		    status_code      INTEGER NOT NULL DEFAULT 0,
+		    content_type     TEXT NOT NULL DEFAULT '',
+		    response         BYTEA NULL,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    expires_at       TIMESTAMP WITH TIME ZONE NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+	`); err != nil {
+		return err
+	}
+
+	// Add the scheduled export files table.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS scheduled_export_files (
+		    id               BIGSERIAL PRIMARY KEY,
+		    name             TEXT NOT NULL,
+		    run_at           TIMESTAMP WITH TIME ZONE NOT NULL,
+		    filename         TEXT NOT NULL DEFAULT '',
+		    size             BIGINT NOT NULL DEFAULT 0,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		    UNIQUE (name, run_at)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Add the web push subscriptions table.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS push_subscriptions (
+		    id               BIGSERIAL PRIMARY KEY,
+		    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		    endpoint         TEXT NOT NULL UNIQUE,
+		    key_p256dh       TEXT NOT NULL,
+		    key_auth         TEXT NOT NULL,
+		    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_push_subs_sub_id ON push_subscriptions(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// Add folders and tags to media.
+	if _, err := db.Exec(`
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS folder TEXT NOT NULL DEFAULT '';
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS tags VARCHAR(100)[] NOT NULL DEFAULT '{}';
+		CREATE INDEX IF NOT EXISTS idx_media_folder ON media(provider, folder);
+		CREATE INDEX IF NOT EXISTS idx_media_tags ON media USING GIN(tags);
+	`); err != nil {
+		return err
+	}
+
+	// Add content hashes, aliases, sizes, and uploaders to media.
+	if _, err := db.Exec(`
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS aliases TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE;
+		CREATE INDEX IF NOT EXISTS idx_media_hash ON media(provider, hash);
+		CREATE INDEX IF NOT EXISTS idx_media_user_id ON media(user_id);
+	`); err != nil {
+		return err
+	}
+
+	// Add per-subscriber attachments to campaigns.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS sub_attachments JSONB NOT NULL DEFAULT '[]'`); err != nil {
+		return err
+	}
+
+	// Add message security (signing and encryption) policies to templates and campaigns.
+	if _, err := db.Exec(`
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS security TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS security TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package subimporter
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+// copyBatchSize is the number of records that are streamed into the staging
+// table with COPY and upserted in a single SQL transaction.
+const copyBatchSize = 50000
+
+// copyBatch is a batch of records being streamed into the staging table.
+type copyBatch struct {
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	num  int
+}
+
+// copyRun is a blocking function that consumes the subscriber queue like Start,
+// but instead of upserting records one by one, streams them into a temporary
+// staging table with COPY and upserts each batch with set-based queries.
+func (s *Session) copyRun() {
+	var (
+		b      *copyBatch
+		err    error
+		seq    = 0
+		total  = 0
+		failed = false
+	)
+
+	// All the lists subscribed to in the session including the
+	// per-record lists in JSON imports for updating list dates.
+	allListIDs := make([]int, len(s.opt.ListIDs))
+	copy(allListIDs, s.opt.ListIDs)
+
+	for sub := range s.subQueue {
+		if b == nil {
+			if b, err = s.newCopyBatch(); err != nil {
+				s.log.Printf("error creating import staging table: %v", err)
+				failed = true
+				break
+			}
+		}
+
+		uu, err := uuid.NewV4()
+		if err != nil {
+			s.log.Printf("error generating UUID: %v", err)
+			failed = true
+			break
+		}
+
+		if sub.Attribs == nil {
+			sub.Attribs = models.JSON{}
+		}
+		attribs, err := json.Marshal(sub.Attribs)
+		if err != nil {
+			s.log.Printf("error marshalling attributes: %v", err)
+			failed = true
+			break
+		}
+
+		// Records in subscribe mode may also be blocklisted individually.
+		blocklist := sub.Status == models.SubscriberStatusBlockListed
+
+		lists := []int{}
+		if s.opt.Mode == ModeSubscribe && !blocklist && len(sub.Lists) > 0 {
+			lists = sub.Lists
+			allListIDs = mergeIDs(allListIDs, sub.Lists)
+		}
+
+		seq++
+		if _, err := b.stmt.Exec(seq, uu.String(), sub.Email, sub.Name, string(attribs), pq.Array(lists),
+			sub.SubStatus, blocklist); err != nil {
+			s.log.Printf("error copying record: %v", err)
+			failed = true
+			break
+		}
+		b.num++
+
+		// Batch size is met. Upsert.
+		if b.num%copyBatchSize == 0 {
+			if err := s.commitCopyBatch(b); err != nil {
+				b = nil
+				failed = true
+				break
+			}
+
+			total += b.num
+			s.log.Printf("imported %d", total)
+			s.saveJob(false)
+			b = nil
+		}
+	}
+
+	if failed {
+		if b != nil {
+			b.stmt.Close()
+			b.tx.Rollback()
+		}
+
+		s.drainQueue()
+		s.setStatus(StatusFailed)
+		s.sendNotif(StatusFailed)
+		return
+	}
+
+	// Queue's closed and there are records left to upsert.
+	if b != nil {
+		if err := s.commitCopyBatch(b); err != nil {
+			s.setStatus(StatusFailed)
+			s.sendNotif(StatusFailed)
+			return
+		}
+	}
+
+	s.finish(allListIDs)
+}
+
+// newCopyBatch begins a transaction, creates the staging table in it,
+// and prepares the COPY statement for streaming records into it.
+func (s *Session) newCopyBatch() (*copyBatch, error) {
+	tx, err := s.im.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(s.im.opt.CreateStagingQuery); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("import_staging", "seq", "uuid", "email", "name", "attribs", "lists",
+		"sub_status", "blocklist"))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &copyBatch{tx: tx, stmt: stmt}, nil
+}
+
+// commitCopyBatch flushes the COPY stream of a batch, upserts the staged
+// records, and commits the transaction, which drops the staging table.
+func (s *Session) commitCopyBatch(b *copyBatch) error {
+	err := func() error {
+		// An Exec() without arguments flushes the COPY buffer.
+		if _, err := b.stmt.Exec(); err != nil {
+			return err
+		}
+		if err := b.stmt.Close(); err != nil {
+			return err
+		}
+
+		var n int
+		if s.opt.Mode == ModeSubscribe {
+			err := b.tx.QueryRow(s.im.opt.UpsertStagingQuery, pq.Array(s.opt.ListIDs), s.opt.SubStatus,
+				s.opt.OverwriteUserInfo, s.opt.OverwriteSubStatus, s.opt.AttribsMerge).Scan(&n)
+			if err != nil {
+				return err
+			}
+		}
+
+		// In subscribe mode, only the records marked for blocklisting are blocklisted.
+		if err := b.tx.QueryRow(s.im.opt.BlocklistStagingQuery, s.opt.Mode == ModeBlocklist).Scan(&n); err != nil {
+			return err
+		}
+
+		return b.tx.Commit()
+	}()
+
+	if err != nil {
+		b.tx.Rollback()
+		s.log.Printf("error upserting import batch: %v", err)
+		return err
+	}
+
+	s.incrementImportCount(b.num)
+	return nil
+}
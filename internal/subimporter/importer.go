@@ -1,9 +1,8 @@
-// Package subimporter implements a bulk ZIP/CSV importer of subscribers.
+// Package subimporter implements a bulk ZIP/CSV/JSON importer of subscribers.
 // It implements a simple queue for buffering imports and committing records
-// to DB along with ZIP and CSV handling utilities. It is meant to be used as
-// a singleton as each Importer instance is stateful, where it keeps track of
-// an import in progress. Only one import should happen on a single importer
-// instance at a time.
+// to DB along with ZIP, CSV, and JSON handling utilities. It is meant to be used as
+// a singleton. Each import is a session (job) that is persisted in the DB and
+// queued on the importer, which runs a configurable number of jobs concurrently.
 package subimporter
 
 import (
@@ -19,8 +18,10 @@ import (
 	"net/mail"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/internal/i18n"
@@ -33,15 +34,26 @@ import (
 const (
 	// commitBatchSize is the number of inserts to commit in a single SQL transaction.
 	commitBatchSize = 10000
+
+	// maxQueued is the maximum number of import jobs that can be queued.
+	maxQueued = 100
+
+	// The heartbeats of the jobs queued and running on an instance are refreshed
+	// every jobHeartbeat. Jobs whose heartbeats are older than jobLease are
+	// considered interrupted (eg: the instance was stopped) and marked as failed.
+	jobHeartbeat = time.Second * 30
+	jobLease     = time.Minute * 2
 )
 
 // Various import statuses.
 const (
 	StatusNone      = "none"
+	StatusQueued    = "queued"
 	StatusImporting = "importing"
 	StatusStopping  = "stopping"
 	StatusFinished  = "finished"
 	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
 
 	ModeSubscribe = "subscribe"
 	ModeBlocklist = "blocklist"
@@ -61,8 +73,15 @@ type Importer struct {
 	hasAllowlistWildcards bool
 	hasAllowlist          bool
 
-	stop   chan bool
-	status Status
+	queue chan *Session
+
+	// Queued and running sessions by their job IDs.
+	sessions map[int]*Session
+
+	// The last session that was created. The status of this session
+	// is what's returned by the importer's GetStats() etc.
+	last *Session
+
 	sync.RWMutex
 }
 
@@ -71,18 +90,65 @@ type Options struct {
 	UpsertStmt         *sql.Stmt
 	BlocklistStmt      *sql.Stmt
 	UpdateListDateStmt *sql.Stmt
+	GetEmailsStmt      *sql.Stmt
+	CreateJobStmt      *sql.Stmt
+	UpdateJobStmt      *sql.Stmt
+	TouchJobsStmt      *sql.Stmt
+	FailJobsStmt       *sql.Stmt
 	PostCB             func(subject string, data any) error
 
+	// Raw queries for COPY imports that operate on a temporary staging table
+	// that only exists in the import's transaction, and hence can't be prepared.
+	CreateStagingQuery    string
+	UpsertStagingQuery    string
+	BlocklistStagingQuery string
+
+	// ListIDsCB returns the IDs of the lists with the given names mapped to their
+	// lowercased names for mapping tags in ESP exports to lists. If create is set,
+	// lists that don't exist are created.
+	ListIDsCB func(names []string, create bool) (map[string]int, error)
+
+	// Concurrency is the number of import jobs that can run in parallel.
+	Concurrency int
+
 	DomainBlocklist []string
 	DomainAllowlist []string
 }
 
-// Session represents a single import session.
+// Session represents a single import session (job).
 type Session struct {
+	id       int
 	im       *Importer
 	subQueue chan SubReq
 	log      *log.Logger
 
+	// load reads the import file and sends the records to subQueue.
+	load      func() error
+	closeOnce sync.Once
+
+	stop   chan bool
+	status Status
+	sync.RWMutex
+
+	// Rejected records are written to a temporary file that can be downloaded
+	// and fixed for re-importing. For CSV imports, it's a CSV file with the
+	// original columns and an additional error column, and for JSON imports,
+	// an NDJSON file with an additional error field in each record.
+	rejectsFile *os.File
+	rejectsCSV  *csv.Writer
+	rejectsHdr  []string
+
+	// Cache of list names (lowercased) mapped to list IDs for mapping tags
+	// in preset imports to lists. Missing lists have the ID 0.
+	listIDs map[string]int
+
+	// Mapped CSV columns in the order they appear in the CSV header.
+	mappedCols []string
+
+	// filterLists filters list IDs in the import by the importing user's
+	// list permissions. Lists the user doesn't have access to are dropped.
+	filterLists func(listIDs []int) []int
+
 	opt SessionOpt
 }
 
@@ -96,15 +162,57 @@ type SessionOpt struct {
 	OverwriteSubStatus bool   `json:"overwrite_subscription_status"`
 	Delim              string `json:"delim"`
 	ListIDs            []int  `json:"lists"`
+
+	// DryRun validates the file and reports the changes it would
+	// make without writing anything to the DB.
+	DryRun bool `json:"dry_run"`
+
+	// Copy streams records into a staging table with COPY and upserts them
+	// in batches with set-based queries instead of one query per record.
+	// It's much faster for large imports.
+	Copy bool `json:"copy"`
+
+	// Optional map of CSV column names to subscriber fields and attributes.
+	// If it's not set, the fixed email, name, attributes headers are used.
+	Columns map[string]ColumnMap `json:"columns"`
+
+	// Strategy for combining the attributes of existing subscribers
+	// with imported ones when OverwriteUserInfo is set.
+	AttribsMerge string `json:"attribs_merge"`
+
+	// Optional preset for importing the CSV exports of other e-mail service
+	// providers, eg: mailchimp. See presets.go.
+	Preset string `json:"preset"`
+
+	// CreateLists creates lists for the tags in preset imports that
+	// don't match existing lists.
+	CreateLists bool `json:"create_lists"`
 }
 
 // Status represents statistics from an ongoing import session.
 type Status struct {
-	Name     string `json:"name"`
-	Total    int    `json:"total"`
-	Imported int    `json:"imported"`
-	Status   string `json:"status"`
-	logBuf   *bytes.Buffer
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Total      int    `json:"total"`
+	Imported   int    `json:"imported"`
+	Status     string `json:"status"`
+	DryRun     bool   `json:"dry_run"`
+	Report     Report `json:"report"`
+	HasRejects bool   `json:"has_rejects"`
+
+	logBuf      *bytes.Buffer
+	rejectsFile string
+}
+
+// Report represents the validation report of an import session. Created, Updated,
+// and Blocklisted are only computed in dry runs. Rejected records are counted
+// by the reason for their rejection.
+type Report struct {
+	Created     int            `json:"created"`
+	Updated     int            `json:"updated"`
+	Blocklisted int            `json:"blocklisted"`
+	Rejected    int            `json:"rejected"`
+	Reasons     map[string]int `json:"reasons"`
 }
 
 // SubReq is a wrapper over the Subscriber model.
@@ -113,6 +221,10 @@ type SubReq struct {
 	Lists          []int    `json:"lists"`
 	ListUUIDs      []string `json:"list_uuids"`
 	PreconfirmSubs bool     `json:"preconfirm_subscriptions"`
+
+	// Per-record subscription status that overrides the session's status.
+	// Records with the blocklisted (Subscriber.)Status are blocklisted.
+	SubStatus string `json:"-"`
 }
 
 type importStatusTpl struct {
@@ -127,11 +239,18 @@ var (
 	// import is already running.
 	ErrIsImporting = errors.New("import is already running")
 
+	// ErrQueueFull is thrown when an import is queued when there are
+	// already maxQueued imports in the queue.
+	ErrQueueFull = errors.New("too many imports in the queue")
+
 	csvHeaders = map[string]bool{
 		"email":      true,
 		"name":       true,
 		"attributes": true}
 
+	// importExts are the extensions of the files that can be imported.
+	importExts = []string{".csv", ".json", ".ndjson", ".jsonl"}
+
 	regexCleanStr = regexp.MustCompile("[[:^ascii:]]")
 )
 
@@ -143,8 +262,8 @@ func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
 		i18n:            i,
 		domainBlocklist: make(map[string]struct{}, len(opt.DomainBlocklist)),
 		domainAllowlist: make(map[string]struct{}, len(opt.DomainAllowlist)),
-		status:          Status{Status: StatusNone, logBuf: bytes.NewBuffer(nil)},
-		stop:            make(chan bool, 1),
+		queue:           make(chan *Session, maxQueued),
+		sessions:        make(map[int]*Session),
 	}
 
 	// Domain blocklist.
@@ -159,16 +278,51 @@ func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
 	im.hasAllowlistWildcards = hasWildcards
 	im.hasAllowlist = len(mp) > 0
 
+	// Start the import workers.
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+	for n := 0; n < opt.Concurrency; n++ {
+		go im.worker()
+	}
+	go im.heartbeat()
+
 	return &im
 }
 
-// NewSession returns an new instance of Session. It takes the name
-// of the uploaded file, but doesn't do anything with it but retains it for stats.
-func (im *Importer) NewSession(opt SessionOpt) (*Session, error) {
-	if !im.isDone() {
-		return nil, errors.New("an import is already running")
+// heartbeat periodically refreshes the heartbeats of the jobs on this instance
+// and fails the jobs of other instances (or this instance before a restart)
+// whose heartbeats have expired, as their sessions can't be resumed.
+func (im *Importer) heartbeat() {
+	t := time.NewTicker(jobHeartbeat)
+	defer t.Stop()
+
+	for {
+		im.RLock()
+		ids := make([]int64, 0, len(im.sessions))
+		for id := range im.sessions {
+			ids = append(ids, int64(id))
+		}
+		im.RUnlock()
+
+		if len(ids) > 0 {
+			if _, err := im.opt.TouchJobsStmt.Exec(pq.Array(ids)); err != nil {
+				log.Printf("error updating import job heartbeats: %v", err)
+			}
+		}
+
+		if _, err := im.opt.FailJobsStmt.Exec(jobLease.Seconds()); err != nil {
+			log.Printf("error updating interrupted import jobs: %v", err)
+		}
+
+		<-t.C
 	}
+}
 
+// NewSession returns an new instance of Session and records it as a queued job
+// in the DB. userID is the ID of the user who started the import (0 if none).
+// The session only runs once it's queued with Queue().
+func (im *Importer) NewSession(opt SessionOpt, userID int, filterLists func(listIDs []int) []int) (*Session, error) {
 	// For API backwards compatibility, if the old 'overwrite'
 	// field is set, set both overwrite fields to true.
 	if opt.Overwrite {
@@ -176,112 +330,354 @@ func (im *Importer) NewSession(opt SessionOpt) (*Session, error) {
 		opt.OverwriteSubStatus = true
 	}
 
-	im.Lock()
-	im.status = Status{Status: StatusImporting,
-		Name:   opt.Filename,
-		logBuf: bytes.NewBuffer(nil)}
-	im.Unlock()
+	if opt.AttribsMerge == "" {
+		opt.AttribsMerge = AttribsReplace
+	}
+
+	if err := ValidateColumns(opt.Columns); err != nil {
+		return nil, err
+	}
+
+	// Clean the mapped column names the same way as CSV headers.
+	if len(opt.Columns) > 0 {
+		cols := make(map[string]ColumnMap, len(opt.Columns))
+		for c, m := range opt.Columns {
+			h := cleanHeader(c)
+			if _, ok := cols[h]; ok {
+				return nil, fmt.Errorf("duplicate mapping for column '%s'", h)
+			}
+			cols[h] = m
+		}
+		opt.Columns = cols
+	}
+
+	// Record the job.
+	o, err := json.Marshal(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int
+	if err := im.opt.CreateJobStmt.QueryRow(opt.Filename, opt.Mode, StatusQueued, o, userID).Scan(&id); err != nil {
+		return nil, err
+	}
 
 	s := &Session{
+		id:       id,
 		im:       im,
-		log:      log.New(im.status.logBuf, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile),
 		subQueue: make(chan SubReq, commitBatchSize),
+		stop:     make(chan bool, 1),
 		opt:      opt,
+
+		filterLists: filterLists,
+		status: Status{ID: id,
+			Status: StatusQueued,
+			Name:   opt.Filename,
+			DryRun: opt.DryRun,
+			Report: Report{Reasons: map[string]int{}},
+			logBuf: bytes.NewBuffer(nil)},
 	}
+	s.log = log.New(s.status.logBuf, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+
+	im.Lock()
+	im.sessions[id] = s
+	im.last = s
+	im.Unlock()
 
-	s.log.Printf("processing '%s'", opt.Filename)
+	if opt.DryRun {
+		s.log.Printf("processing '%s' (dry run)", opt.Filename)
+	} else {
+		s.log.Printf("processing '%s'", opt.Filename)
+	}
 	return s, nil
 }
 
-// GetStats returns the global Stats of the importer.
-func (im *Importer) GetStats() Status {
+// Queue queues the session to be run by the importer's workers. load is
+// the function that reads the import file, eg: LoadCSV().
+func (s *Session) Queue(load func() error) error {
+	s.load = load
+
+	select {
+	case s.im.queue <- s:
+		s.log.Printf("queued import #%d", s.id)
+		return nil
+	default:
+		s.setStatus(StatusFailed)
+		s.log.Printf("error queueing import: %v", ErrQueueFull)
+		s.done()
+		return ErrQueueFull
+	}
+}
+
+// worker runs queued import sessions one after the other.
+func (im *Importer) worker() {
+	for s := range im.queue {
+		// The session may have been cancelled while it was in the queue.
+		s.Lock()
+		if s.status.Status != StatusQueued {
+			s.Unlock()
+			continue
+		}
+		s.status.Status = StatusImporting
+		s.Unlock()
+
+		s.saveJob(false)
+
+		done := make(chan struct{})
+		go func() {
+			s.Start()
+			close(done)
+		}()
+
+		if err := s.load(); err != nil {
+			s.log.Printf("error loading import: %v", err)
+		}
+
+		// The loader closes the queue once it's done, but not on errors.
+		s.closeQueue()
+		<-done
+
+		s.done()
+	}
+}
+
+// done saves the final state of the session and removes it from
+// the importer's active sessions.
+func (s *Session) done() {
+	s.saveJob(true)
+
+	s.im.Lock()
+	delete(s.im.sessions, s.id)
+	s.im.Unlock()
+}
+
+// saveJob saves the session's status and counts to its job in the DB, and if
+// withLog is set, the log as well.
+func (s *Session) saveJob(withLog bool) {
+	st := s.GetStats()
+
+	rep, err := json.Marshal(st.Report)
+	if err != nil {
+		s.log.Printf("error marshalling import report: %v", err)
+		return
+	}
+
+	var logs sql.NullString
+	if withLog {
+		logs = sql.NullString{String: string(s.GetLogs()), Valid: true}
+	}
+
+	s.RLock()
+	rejects := s.status.rejectsFile
+	s.RUnlock()
+
+	if _, err := s.im.opt.UpdateJobStmt.Exec(s.id, st.Status, st.Total, st.Imported, rep, logs, rejects); err != nil {
+		s.log.Printf("error saving import job: %v", err)
+	}
+}
+
+// GetSession returns a queued or running session by its job ID, or the
+// last session if it has the ID. It returns nil if there's no such session.
+func (im *Importer) GetSession(id int) *Session {
 	im.RLock()
 	defer im.RUnlock()
 
-	return Status{
-		Name:     im.status.Name,
-		Status:   im.status.Status,
-		Total:    im.status.Total,
-		Imported: im.status.Imported,
+	if s, ok := im.sessions[id]; ok {
+		return s
+	}
+	if im.last != nil && im.last.id == id {
+		return im.last
 	}
+
+	return nil
 }
 
-// GetLogs returns the log entries of the last import session.
-func (im *Importer) GetLogs() []byte {
+// getLast returns the last session, if any.
+func (im *Importer) getLast() *Session {
 	im.RLock()
 	defer im.RUnlock()
 
-	if im.status.logBuf == nil {
+	return im.last
+}
+
+// GetStats returns the Stats of the last import session.
+func (im *Importer) GetStats() Status {
+	s := im.getLast()
+	if s == nil {
+		return Status{Status: StatusNone}
+	}
+
+	return s.GetStats()
+}
+
+// GetRejectsFile returns the path and the download filename of the
+// file with the rejected records of the last import session, if any.
+func (im *Importer) GetRejectsFile() (string, string) {
+	s := im.getLast()
+	if s == nil {
+		return "", ""
+	}
+
+	return s.GetRejectsFile()
+}
+
+// GetLogs returns the log entries of the last import session.
+func (im *Importer) GetLogs() []byte {
+	s := im.getLast()
+	if s == nil {
 		return []byte{}
 	}
 
-	return im.status.logBuf.Bytes()
+	return s.GetLogs()
 }
 
-// setStatus sets the Importer's status.
-func (im *Importer) setStatus(status string) {
-	im.Lock()
-	im.status.Status = status
-	im.Unlock()
+// ID returns the job ID of the session.
+func (s *Session) ID() int {
+	return s.id
 }
 
-// getStatus get's the Importer's status.
-func (im *Importer) getStatus() string {
-	im.RLock()
-	status := im.status.Status
-	im.RUnlock()
+// GetStats returns the Stats of the session.
+func (s *Session) GetStats() Status {
+	s.RLock()
+	defer s.RUnlock()
+
+	rep := s.status.Report
+	rep.Reasons = make(map[string]int, len(s.status.Report.Reasons))
+	for k, v := range s.status.Report.Reasons {
+		rep.Reasons[k] = v
+	}
+
+	return Status{
+		ID:         s.status.ID,
+		Name:       s.status.Name,
+		Status:     s.status.Status,
+		Total:      s.status.Total,
+		Imported:   s.status.Imported,
+		DryRun:     s.status.DryRun,
+		Report:     rep,
+		HasRejects: s.status.rejectsFile != "",
+	}
+}
+
+// GetRejectsFile returns the path and the download filename of the
+// file with the rejected records of the session, if any.
+func (s *Session) GetRejectsFile() (string, string) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.status.rejectsFile == "" {
+		return "", ""
+	}
+
+	return s.status.rejectsFile, RejectsFilename(s.status.Name, s.status.rejectsFile)
+}
+
+// GetLogs returns the log entries of the session.
+func (s *Session) GetLogs() []byte {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.status.logBuf == nil {
+		return []byte{}
+	}
+
+	return s.status.logBuf.Bytes()
+}
+
+// setStatus sets the session's status.
+func (s *Session) setStatus(status string) {
+	s.Lock()
+	s.status.Status = status
+	s.Unlock()
+}
+
+// getStatus get's the session's status.
+func (s *Session) getStatus() string {
+	s.RLock()
+	status := s.status.Status
+	s.RUnlock()
 	return status
 }
 
-// isDone returns true if the importer is working (importing|stopping).
-func (im *Importer) isDone() bool {
-	s := true
-	im.RLock()
-	if im.getStatus() == StatusImporting || im.getStatus() == StatusStopping {
-		s = false
+// isDone returns true if the session is not queued or working (importing|stopping).
+func (s *Session) isDone() bool {
+	switch s.getStatus() {
+	case StatusQueued, StatusImporting, StatusStopping:
+		return false
 	}
-	im.RUnlock()
 
-	return s
+	return true
 }
 
-// incrementImportCount sets the Importer's "imported" counter.
-func (im *Importer) incrementImportCount(n int) {
-	im.Lock()
-	im.status.Imported += n
-	im.Unlock()
+// incrementImportCount sets the session's "imported" counter.
+func (s *Session) incrementImportCount(n int) {
+	s.Lock()
+	s.status.Imported += n
+	s.Unlock()
+}
+
+// closeQueue closes the subscriber queue if it's not already closed.
+func (s *Session) closeQueue() {
+	s.closeOnce.Do(func() {
+		close(s.subQueue)
+	})
+}
+
+// drainQueue discards the records in the subscriber queue in the background
+// so that the loader isn't blocked after the import has failed.
+func (s *Session) drainQueue() {
+	go func() {
+		for range s.subQueue {
+		}
+	}()
 }
 
 // sendNotif sends admin notifications for import completions.
-func (im *Importer) sendNotif(status string) error {
+func (s *Session) sendNotif(status string) error {
 	var (
-		s   = im.GetStats()
+		st  = s.GetStats()
 		out = importStatusTpl{
-			Name:     s.Name,
+			Name:     st.Name,
 			Status:   status,
-			Imported: s.Imported,
-			Total:    s.Total,
+			Imported: st.Imported,
+			Total:    st.Total,
 		}
-		subject = fmt.Sprintf("%s: %s import", cases.Title(language.Und).String(status), s.Name)
+		subject = fmt.Sprintf("%s: %s import", cases.Title(language.Und).String(status), st.Name)
 	)
-	return im.opt.PostCB(subject, out)
+	return s.im.opt.PostCB(subject, out)
 }
 
 // Start is a blocking function that selects on a channel queue until all
 // subscriber entries in the import session are imported. It should be
 // invoked as a goroutine.
 func (s *Session) Start() {
+	if s.opt.DryRun {
+		s.dryRun()
+		return
+	}
+	if s.opt.Copy {
+		s.copyRun()
+		return
+	}
+
 	var (
-		tx    *sql.Tx
-		stmt  *sql.Stmt
-		err   error
-		total = 0
-		cur   = 0
+		tx     *sql.Tx
+		stmt   *sql.Stmt
+		blStmt *sql.Stmt
+		err    error
+		total  = 0
+		cur    = 0
+		failed = false
 	)
 
 	listIDs := make([]int, len(s.opt.ListIDs))
 	copy(listIDs, s.opt.ListIDs)
 
+	// All the lists subscribed to in the session including the
+	// per-record lists in JSON imports for updating list dates.
+	allListIDs := make([]int, len(listIDs))
+	copy(allListIDs, listIDs)
+
 	for sub := range s.subQueue {
 		if cur == 0 {
 			// New transaction batch.
@@ -291,28 +687,45 @@ func (s *Session) Start() {
 				continue
 			}
 
-			if s.opt.Mode == ModeSubscribe {
-				stmt = tx.Stmt(s.im.opt.UpsertStmt)
-			} else {
-				stmt = tx.Stmt(s.im.opt.BlocklistStmt)
-			}
+			// Records in subscribe mode may also be blocklisted individually.
+			stmt = tx.Stmt(s.im.opt.UpsertStmt)
+			blStmt = tx.Stmt(s.im.opt.BlocklistStmt)
 		}
 
 		uu, err := uuid.NewV4()
 		if err != nil {
 			s.log.Printf("error generating UUID: %v", err)
 			tx.Rollback()
+			failed = true
 			break
 		}
 
-		if s.opt.Mode == ModeSubscribe {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(listIDs), s.opt.SubStatus, s.opt.OverwriteUserInfo, s.opt.OverwriteSubStatus)
-		} else if s.opt.Mode == ModeBlocklist {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs)
+		if s.opt.Mode == ModeSubscribe && sub.Status != models.SubscriberStatusBlockListed {
+			// Records may have their own lists in addition to the session's lists.
+			subListIDs := listIDs
+			if len(sub.Lists) > 0 {
+				subListIDs = mergeIDs(listIDs, sub.Lists)
+				allListIDs = mergeIDs(allListIDs, sub.Lists)
+			}
+
+			if sub.Attribs == nil {
+				sub.Attribs = models.JSON{}
+			}
+
+			subStatus := s.opt.SubStatus
+			if sub.SubStatus != "" {
+				subStatus = sub.SubStatus
+			}
+
+			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(subListIDs), subStatus,
+				s.opt.OverwriteUserInfo, s.opt.OverwriteSubStatus, s.opt.AttribsMerge, models.SubscriptionSourceImport)
+		} else {
+			_, err = blStmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, models.SubscriptionSourceImport)
 		}
 		if err != nil {
 			s.log.Printf("error executing insert: %v", err)
 			tx.Rollback()
+			failed = true
 			break
 		}
 		cur++
@@ -324,61 +737,170 @@ func (s *Session) Start() {
 				tx.Rollback()
 				s.log.Printf("error committing to DB: %v", err)
 			} else {
-				s.im.incrementImportCount(cur)
+				s.incrementImportCount(cur)
 				s.log.Printf("imported %d", total)
+				s.saveJob(false)
 			}
 
 			cur = 0
 		}
 	}
 
-	// Queue's closed and there's nothing left to commit.
-	if cur == 0 {
-		s.im.setStatus(StatusFinished)
-		s.log.Printf("imported finished")
-		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-			s.log.Printf("error updating lists date: %v", err)
-		}
-		s.im.sendNotif(StatusFinished)
+	if failed {
+		s.drainQueue()
+		s.setStatus(StatusFailed)
+		s.sendNotif(StatusFailed)
 		return
 	}
 
 	// Queue's closed and there are records left to commit.
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		s.im.setStatus(StatusFailed)
-		s.log.Printf("error committing to DB: %v", err)
-		s.im.sendNotif(StatusFailed)
+	if cur > 0 {
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			s.setStatus(StatusFailed)
+			s.log.Printf("error committing to DB: %v", err)
+			s.sendNotif(StatusFailed)
+			return
+		}
+		s.incrementImportCount(cur)
+	}
+
+	s.finish(allListIDs)
+}
+
+// finish marks the session as finished (unless the loader failed midway), updates
+// the dates of the given lists, and sends the import notification.
+func (s *Session) finish(listIDs []int) {
+	// The loader may have failed midway.
+	if s.getStatus() == StatusFailed {
+		s.log.Printf("import failed")
+		s.sendNotif(StatusFailed)
 		return
 	}
 
-	s.im.incrementImportCount(cur)
-	s.im.setStatus(StatusFinished)
+	s.setStatus(StatusFinished)
 	s.log.Printf("imported finished")
 	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
 		s.log.Printf("error updating lists date: %v", err)
 	}
 
-	s.im.sendNotif(StatusFinished)
+	s.sendNotif(StatusFinished)
 }
 
-// Stop stops an active import session.
-func (s *Session) Stop() {
-	close(s.subQueue)
+// dryRun is a blocking function that consumes the subscriber queue like Start,
+// but instead of importing records, only looks up the DB to report the number
+// of subscribers that would be created, updated, or blocklisted.
+func (s *Session) dryRun() {
+	var (
+		batch = make([]string, 0, commitBatchSize)
+
+		// E-mails already seen in the file. Subsequent records with the
+		// same e-mail would update the first one.
+		seen = map[string]struct{}{}
+	)
+
+	// Look up the batch of e-mails in the DB and update the report.
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		rows, err := s.im.opt.GetEmailsStmt.Query(pq.Array(batch))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		exists := make(map[string]struct{}, len(batch))
+		for rows.Next() {
+			var em string
+			if err := rows.Scan(&em); err != nil {
+				return err
+			}
+			exists[em] = struct{}{}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		s.Lock()
+		for _, em := range batch {
+			if s.opt.Mode == ModeBlocklist {
+				s.status.Report.Blocklisted++
+			} else if _, ok := exists[em]; ok {
+				s.status.Report.Updated++
+			} else {
+				s.status.Report.Created++
+			}
+		}
+		s.status.Imported += len(batch)
+		s.Unlock()
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for sub := range s.subQueue {
+		// Records in subscribe mode may also be blocklisted individually.
+		blocklist := s.opt.Mode == ModeBlocklist || sub.Status == models.SubscriberStatusBlockListed
+
+		_, ok := seen[sub.Email]
+		seen[sub.Email] = struct{}{}
+		if ok || (blocklist && s.opt.Mode == ModeSubscribe) {
+			s.Lock()
+			if blocklist {
+				s.status.Report.Blocklisted++
+			} else {
+				s.status.Report.Updated++
+			}
+			s.status.Imported++
+			s.Unlock()
+			continue
+		}
+
+		batch = append(batch, sub.Email)
+		if len(batch) < commitBatchSize {
+			continue
+		}
+
+		if err := flush(); err != nil {
+			s.drainQueue()
+			s.setStatus(StatusFailed)
+			s.log.Printf("error looking up subscribers: %v", err)
+			return
+		}
+	}
+
+	if err := flush(); err != nil {
+		s.setStatus(StatusFailed)
+		s.log.Printf("error looking up subscribers: %v", err)
+		return
+	}
+
+	if s.getStatus() == StatusFailed {
+		s.log.Printf("dry run failed")
+		return
+	}
+
+	st := s.GetStats()
+	s.setStatus(StatusFinished)
+	s.log.Printf("dry run finished. created: %d, updated: %d, blocklisted: %d, rejected: %d",
+		st.Report.Created, st.Report.Updated, st.Report.Blocklisted, st.Report.Rejected)
 }
 
-// ExtractZIP takes a ZIP file's path and extracts all .csv files in it to
-// a temporary directory, and returns the name of the temp directory and the
-// list of extracted .csv files.
+// ExtractZIP takes a ZIP file's path and extracts all .csv and .json/.ndjson files
+// in it to a temporary directory, and returns the name of the temp directory and the
+// list of extracted files.
 func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, error) {
-	if s.im.isDone() {
+	if s.isDone() {
 		return "", nil, ErrIsImporting
 	}
 
 	failed := true
 	defer func() {
 		if failed {
-			s.im.setStatus(StatusFailed)
+			s.setStatus(StatusFailed)
+			s.done()
 		}
 	}()
 
@@ -405,9 +927,9 @@ func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, err
 			continue
 		}
 
-		// Skip files without the .csv extension.
-		if !strings.HasSuffix(strings.ToLower(fName), ".csv") {
-			s.log.Printf("skipping non .csv file '%s'", fName)
+		// Skip files that can't be imported.
+		if !IsImportable(fName) {
+			s.log.Printf("skipping non .csv/.json file '%s'", fName)
 			continue
 		}
 
@@ -440,8 +962,8 @@ func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, err
 	}
 
 	if len(files) == 0 {
-		s.log.Println("no CSV files found in the ZIP")
-		return "", nil, errors.New("no CSV files found in the ZIP")
+		s.log.Println("no CSV or JSON files found in the ZIP")
+		return "", nil, errors.New("no CSV or JSON files found in the ZIP")
 	}
 
 	failed = false
@@ -450,7 +972,7 @@ func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, err
 
 // LoadCSV loads a CSV file and validates and imports the subscriber entries in it.
 func (s *Session) LoadCSV(srcPath string, delim rune) error {
-	if s.im.isDone() {
+	if s.isDone() {
 		return ErrIsImporting
 	}
 
@@ -459,7 +981,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 	failed := true
 	defer func() {
 		if failed {
-			s.im.setStatus(StatusFailed)
+			s.setStatus(StatusFailed)
 		}
 	}()
 
@@ -482,9 +1004,9 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 	}
 
 	// Exclude the header from count.
-	s.im.Lock()
-	s.im.status.Total = numLines - 1
-	s.im.Unlock()
+	s.Lock()
+	s.status.Total = numLines - 1
+	s.Unlock()
 
 	// Rewind, now that we've done a linecount on the same handler.
 	_, _ = f.Seek(0, 0)
@@ -498,11 +1020,42 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		return err
 	}
 
-	hdrKeys := s.mapCSVHeaders(csvHdr, csvHeaders)
+	defer s.closeRejects()
+	s.rejectsHdr = append(csvHdr, "error")
+
+	// If there's a column mapping, the mapped columns are the known headers.
+	var (
+		knownHdrs = csvHeaders
+		emailHdr  = "email"
+	)
+	if len(s.opt.Columns) > 0 {
+		knownHdrs = make(map[string]bool, len(s.opt.Columns))
+		for col, m := range s.opt.Columns {
+			knownHdrs[col] = true
+			if m.Field == FieldEmail {
+				emailHdr = col
+			}
+		}
+	}
+
+	hdrKeys := s.mapCSVHeaders(csvHdr, knownHdrs)
+
+	// Mapped columns are applied in the header order so that when multiple
+	// columns set the same field or attribute, the last one consistently wins.
+	if len(s.opt.Columns) > 0 {
+		s.mappedCols = make([]string, 0, len(hdrKeys))
+		for _, h := range csvHdr {
+			h = cleanHeader(h)
+			if _, ok := hdrKeys[h]; ok && !slices.Contains(s.mappedCols, h) {
+				s.mappedCols = append(s.mappedCols, h)
+			}
+		}
+	}
+
 	// email is a required header.
-	if _, ok := hdrKeys["email"]; !ok {
-		s.log.Printf("'email' column not found in '%s'", srcPath)
-		return errors.New("'email' column not found")
+	if _, ok := hdrKeys[emailHdr]; !ok {
+		s.log.Printf("'%s' column not found in '%s'", emailHdr, srcPath)
+		return fmt.Errorf("'%s' column not found", emailHdr)
 	}
 
 	var (
@@ -514,9 +1067,9 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 
 		// Check for the stop signal.
 		select {
-		case <-s.im.stop:
+		case <-s.stop:
 			failed = false
-			close(s.subQueue)
+			s.closeQueue()
 			s.log.Println("stop request received")
 			return nil
 		default:
@@ -528,6 +1081,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		} else if err != nil {
 			if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
 				s.log.Printf("skipping line %d. %v", i, err)
+				s.rejectCSV(cols, csv.ErrFieldCount.Error())
 				continue
 			} else {
 				s.log.Printf("error reading CSV '%s'", err)
@@ -538,6 +1092,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		lnCols := len(cols)
 		if lnCols < lnHdr {
 			s.log.Printf("skipping line %d. column count (%d) does not match minimum header count (%d)", i, lnCols, lnHdr)
+			s.rejectCSV(cols, csv.ErrFieldCount.Error())
 			continue
 		}
 
@@ -548,6 +1103,22 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 			row[key] = cols[hdrKeys[key]]
 		}
 
+		// Mapped columns.
+		if len(s.opt.Columns) > 0 {
+			sub, err := s.mapCSVRow(row)
+			if err == nil {
+				sub, err = s.im.ValidateFields(sub)
+			}
+			if err != nil {
+				s.log.Printf("skipping line %d: %v: %v", i, err, cols)
+				s.rejectCSV(cols, err.Error())
+				continue
+			}
+
+			s.subQueue <- sub
+			continue
+		}
+
 		sub := SubReq{}
 		sub.Email = row["email"]
 
@@ -558,6 +1129,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		sub, err = s.im.ValidateFields(sub)
 		if err != nil {
 			s.log.Printf("skipping line %d: %v: %v", i, err, cols)
+			s.rejectCSV(cols, err.Error())
 			continue
 		}
 
@@ -578,25 +1150,176 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		s.subQueue <- sub
 	}
 
-	close(s.subQueue)
+	s.closeQueue()
 	failed = false
 
 	return nil
 }
 
-// Stop sends a signal to stop the existing import.
+// LoadJSON loads a JSON file with an array of subscriber records or an NDJSON
+// (newline delimited JSON) file with one record per line, and validates and
+// imports the subscriber entries in it. The file is stream-parsed and is never
+// loaded fully into memory. Each record is an object with the fields
+// email, name, attributes (object), and optionally, lists (array of list IDs)
+// to subscribe to in addition to the lists in the session.
+func (s *Session) LoadJSON(srcPath string) error {
+	if s.isDone() {
+		return ErrIsImporting
+	}
+
+	// Default status is "failed" in case the function
+	// returns at one of the many possible errors.
+	failed := true
+	defer func() {
+		if failed {
+			s.setStatus(StatusFailed)
+		}
+	}()
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Is the file a JSON array or an NDJSON stream?
+	isArray, err := isJSONArray(f)
+	if err != nil {
+		s.log.Printf("error reading '%s': '%v'", srcPath, err)
+		return err
+	}
+
+	// Count the total number of records in the file for deriving the
+	// progress percentage for the frontend. For NDJSON, this doesn't
+	// distinguish between "blank" and non "blank" lines.
+	_, _ = f.Seek(0, 0)
+	var numRecs int
+	if isArray {
+		numRecs, err = countJSONArray(newJSONBufReader(f))
+	} else {
+		numRecs, err = countLines(f)
+	}
+	if err != nil {
+		s.log.Printf("error counting records in '%s': '%v'", srcPath, err)
+		return err
+	}
+
+	if numRecs == 0 {
+		return errors.New("empty file")
+	}
+
+	s.Lock()
+	s.status.Total = numRecs
+	s.Unlock()
+
+	defer s.closeRejects()
+
+	// Rewind, now that we've done a count on the same handler.
+	_, _ = f.Seek(0, 0)
+	var rd jsonReader
+	if isArray {
+		r, err := newJSONArrayReader(newJSONBufReader(f))
+		if err != nil {
+			s.log.Printf("error reading JSON array from '%s': '%v'", srcPath, err)
+			return err
+		}
+		rd = r
+	} else {
+		rd = &ndjsonReader{rd: newJSONBufReader(f)}
+	}
+
+	i := 0
+	for {
+		i++
+
+		// Check for the stop signal.
+		select {
+		case <-s.stop:
+			failed = false
+			s.closeQueue()
+			s.log.Println("stop request received")
+			return nil
+		default:
+		}
+
+		rec, raw, err := rd.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			var skip errSkipRecord
+			if errors.As(err, &skip) {
+				s.log.Printf("skipping record %d. %v", i, skip.err)
+				s.rejectJSON(raw, skip.err.Error())
+				continue
+			}
+
+			s.log.Printf("error reading JSON '%s'", err)
+			return err
+		}
+
+		sub := SubReq{Lists: s.permittedLists(rec.Lists)}
+		sub.Email = rec.Email
+		sub.Name = rec.Name
+		sub.Attribs = rec.Attribs
+
+		sub, err = s.im.ValidateFields(sub)
+		if err != nil {
+			s.log.Printf("skipping record %d: %v: %s", i, err, rec.Email)
+			s.rejectJSON(raw, err.Error())
+			continue
+		}
+
+		// Send the subscriber to the queue.
+		s.subQueue <- sub
+	}
+
+	s.closeQueue()
+	failed = false
+
+	return nil
+}
+
+// Stop stops the last import session if it's running, or clears it
+// if it has finished.
 func (im *Importer) Stop() {
-	if im.getStatus() != StatusImporting {
+	s := im.getLast()
+	if s == nil {
+		return
+	}
+
+	if s.isDone() {
 		im.Lock()
-		im.status = Status{Status: StatusNone}
+		if im.last == s {
+			im.last = nil
+		}
 		im.Unlock()
+		return
+	}
+
+	s.Stop()
+}
+
+// Stop sends a signal to stop the session if it's running, or cancels it
+// if it's still in the queue.
+func (s *Session) Stop() {
+	s.Lock()
+	if s.status.Status == StatusQueued {
+		s.status.Status = StatusCancelled
+		s.Unlock()
 
+		s.log.Printf("import cancelled")
+		s.done()
+		return
+	}
+	s.Unlock()
+
+	if s.getStatus() != StatusImporting {
 		return
 	}
 
 	select {
-	case im.stop <- true:
-		im.setStatus(StatusStopping)
+	case s.stop <- true:
+		s.setStatus(StatusStopping)
 	default:
 	}
 }
@@ -699,8 +1422,7 @@ func (s *Session) mapCSVHeaders(csvHdrs []string, knownHdrs map[string]bool) map
 	// This is to allow dynamic ordering of columns in th CSV.
 	hdrKeys := make(map[string]int)
 	for i, h := range csvHdrs {
-		// Clean the string of non-ASCII characters (BOM etc.).
-		h := regexCleanStr.ReplaceAllString(strings.TrimSpace(h), "")
+		h := cleanHeader(h)
 		if _, ok := knownHdrs[h]; !ok {
 			s.log.Printf("ignoring unknown header '%s'", h)
 			continue
@@ -711,6 +1433,20 @@ func (s *Session) mapCSVHeaders(csvHdrs []string, knownHdrs map[string]bool) map
 	return hdrKeys
 }
 
+// permittedLists returns the given list IDs that the importing user has access to.
+func (s *Session) permittedLists(listIDs []int) []int {
+	if s.filterLists == nil || len(listIDs) == 0 {
+		return listIDs
+	}
+
+	return s.filterLists(listIDs)
+}
+
+// cleanHeader cleans a CSV header of whitespace and non-ASCII characters (BOM etc.).
+func cleanHeader(h string) string {
+	return regexCleanStr.ReplaceAllString(strings.TrimSpace(h), "")
+}
+
 // countLines counts the number of line breaks in a file. This does not
 // distinguish between "blank" and non "blank" lines.
 // Credit: https://stackoverflow.com/a/24563853
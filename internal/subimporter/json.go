@@ -0,0 +1,212 @@
+package subimporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// jsonSub represents a single subscriber record in a JSON or NDJSON file.
+type jsonSub struct {
+	Email   string      `json:"email"`
+	Name    string      `json:"name"`
+	Attribs models.JSON `json:"attributes"`
+	Lists   []int       `json:"lists"`
+}
+
+// jsonReader reads subscriber records one by one from a JSON stream.
+// next returns the record and its raw JSON, io.EOF when there are no more
+// records, and errSkipRecord when a record is invalid but the stream can
+// continue to be read.
+type jsonReader interface {
+	next() (jsonSub, []byte, error)
+}
+
+// errSkipRecord wraps the error of an invalid record that can be skipped.
+type errSkipRecord struct {
+	err error
+}
+
+func (e errSkipRecord) Error() string {
+	return e.err.Error()
+}
+
+// jsonArrayReader reads records from a JSON array, eg: [{}, {}].
+type jsonArrayReader struct {
+	dec *json.Decoder
+}
+
+// ndjsonReader reads records from an NDJSON stream, one per line.
+type ndjsonReader struct {
+	rd *bufio.Reader
+}
+
+// newJSONArrayReader returns a jsonArrayReader after consuming the
+// opening '[' of the array in the given reader.
+func newJSONArrayReader(r io.Reader) (*jsonArrayReader, error) {
+	dec := json.NewDecoder(r)
+	if err := readJSONDelim(dec, '['); err != nil {
+		return nil, err
+	}
+
+	return &jsonArrayReader{dec: dec}, nil
+}
+
+func (r *jsonArrayReader) next() (jsonSub, []byte, error) {
+	var rec jsonSub
+	if !r.dec.More() {
+		return rec, nil, io.EOF
+	}
+
+	// Read the raw record first so that records with invalid
+	// field types can be skipped. Syntax errors can't be recovered from.
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		return rec, nil, err
+	}
+
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return rec, raw, errSkipRecord{err}
+	}
+
+	return rec, raw, nil
+}
+
+func (r *ndjsonReader) next() (jsonSub, []byte, error) {
+	var rec jsonSub
+	for {
+		b, err := r.rd.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return rec, nil, err
+		}
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			if err == io.EOF {
+				return rec, nil, io.EOF
+			}
+
+			// Skip blank lines.
+			continue
+		}
+
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return rec, b, errSkipRecord{err}
+		}
+
+		return rec, b, nil
+	}
+}
+
+// IsImportable checks if the given filename has an extension that can be imported.
+func IsImportable(fName string) bool {
+	ext := strings.ToLower(filepath.Ext(fName))
+	for _, e := range importExts {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsJSON checks if the given filename has a JSON or NDJSON extension.
+func IsJSON(fName string) bool {
+	return IsImportable(fName) && strings.ToLower(filepath.Ext(fName)) != ".csv"
+}
+
+// newJSONBufReader returns a buffered reader on the given reader
+// that skips the UTF-8 BOM, if there's one.
+func newJSONBufReader(r io.Reader) *bufio.Reader {
+	rd := bufio.NewReader(r)
+	if b, err := rd.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		_, _ = rd.Discard(len(utf8BOM))
+	}
+
+	return rd
+}
+
+// isJSONArray checks whether the first non-whitespace character in the
+// given reader is '[', that is, whether it's a JSON array and not NDJSON.
+func isJSONArray(r io.Reader) (bool, error) {
+	rd := newJSONBufReader(r)
+	for {
+		c, _, err := rd.ReadRune()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+// countJSONArray counts the number of elements in a top level JSON array
+// by streaming through it.
+func countJSONArray(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	if err := readJSONDelim(dec, '['); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for dec.More() {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// readJSONDelim reads the next token from the decoder and checks that
+// it's the given delimiter.
+func readJSONDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected '%s' but found '%v'", delim, t)
+	}
+
+	return nil
+}
+
+// mergeIDs returns a new slice with the unique IDs of a and b.
+func mergeIDs(a, b []int) []int {
+	var (
+		out  = make([]int, 0, len(a)+len(b))
+		seen = make(map[int]struct{}, len(a)+len(b))
+	)
+	for _, ids := range [][]int{a, b} {
+		for _, id := range ids {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, id)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,229 @@
+package subimporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Subscriber fields that CSV columns can be mapped to.
+const (
+	FieldEmail      = "email"
+	FieldName       = "name"
+	FieldAttributes = "attributes"
+	FieldAttribute  = "attribute"
+)
+
+// Types of attribute values that CSV columns can be cast to.
+const (
+	AttribString = "string"
+	AttribNumber = "number"
+	AttribBool   = "bool"
+	AttribDate   = "date"
+	AttribList   = "list"
+)
+
+// Strategies for combining the attributes of existing subscribers with imported ones.
+const (
+	AttribsReplace   = "replace"
+	AttribsMerge     = "merge"
+	AttribsDeepMerge = "deep_merge"
+)
+
+// ColumnMap maps a CSV column to a subscriber field, or to an attribute key
+// with a value type.
+type ColumnMap struct {
+	Field string `json:"field"`
+
+	// Attribute key. Dots denote nested keys, eg: address.city.
+	Key  string `json:"key"`
+	Type string `json:"type"`
+
+	// Optional Go time layout for date values. By default,
+	// RFC3339, YYYY-MM-DD HH:MM:SS, and YYYY-MM-DD are accepted.
+	Format string `json:"format"`
+
+	// Separator for list values. Default is comma.
+	Separator string `json:"separator"`
+}
+
+var dateFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// ValidateColumns validates a map of CSV column names to subscriber fields.
+func ValidateColumns(cols map[string]ColumnMap) error {
+	if len(cols) == 0 {
+		return nil
+	}
+
+	hasEmail := false
+	for col, m := range cols {
+		switch m.Field {
+		case FieldEmail:
+			if hasEmail {
+				return errors.New("only one column can be mapped to email")
+			}
+			hasEmail = true
+		case FieldName, FieldAttributes:
+		case FieldAttribute:
+			if strings.Trim(m.Key, ". ") == "" {
+				return fmt.Errorf("no attribute key for column '%s'", col)
+			}
+
+			switch m.Type {
+			case "", AttribString, AttribNumber, AttribBool, AttribDate, AttribList:
+			default:
+				return fmt.Errorf("unknown attribute type '%s' for column '%s'", m.Type, col)
+			}
+		default:
+			return fmt.Errorf("unknown field '%s' for column '%s'", m.Field, col)
+		}
+	}
+
+	if !hasEmail {
+		return errors.New("no column mapped to email")
+	}
+
+	return nil
+}
+
+// mapCSVRow creates a subscriber from a CSV row (map of column: value)
+// using the column mapping in the session. Columns are applied in the CSV
+// header order, so if multiple columns map to the same field or attribute,
+// the rightmost one wins.
+func (s *Session) mapCSVRow(row map[string]string) (SubReq, error) {
+	var (
+		sub     = SubReq{}
+		attribs = models.JSON{}
+	)
+
+	// The JSON attributes column is applied first so that it can be
+	// added to, or overridden by individually mapped attributes.
+	for _, col := range s.mappedCols {
+		m := s.opt.Columns[col]
+		v, ok := row[col]
+		if !ok {
+			continue
+		}
+
+		switch m.Field {
+		case FieldEmail:
+			sub.Email = v
+		case FieldName:
+			sub.Name = v
+		case FieldAttributes:
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+
+			var a models.JSON
+			if err := json.Unmarshal([]byte(v), &a); err != nil {
+				return sub, fmt.Errorf("invalid attributes JSON in '%s': %v", col, err)
+			}
+			for k, val := range a {
+				attribs[k] = val
+			}
+		}
+	}
+
+	for _, col := range s.mappedCols {
+		m := s.opt.Columns[col]
+		v, ok := row[col]
+		if !ok || m.Field != FieldAttribute {
+			continue
+		}
+
+		val, err := castAttrib(strings.TrimSpace(v), m)
+		if err != nil {
+			return sub, fmt.Errorf("invalid %s value in '%s': %v", m.Type, col, err)
+		}
+		if val == nil {
+			continue
+		}
+
+		setAttrib(attribs, m.Key, val)
+	}
+
+	if len(attribs) > 0 {
+		sub.Attribs = attribs
+	}
+
+	return sub, nil
+}
+
+// castAttrib casts a CSV value to the attribute type in the column map.
+// Empty values return nil.
+func castAttrib(v string, m ColumnMap) (any, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	switch m.Type {
+	case AttribNumber:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New(v)
+		}
+		return n, nil
+
+	case AttribBool:
+		switch strings.ToLower(v) {
+		case "true", "t", "yes", "y", "on", "1":
+			return true, nil
+		case "false", "f", "no", "n", "off", "0":
+			return false, nil
+		}
+		return nil, errors.New(v)
+
+	case AttribDate:
+		formats := dateFormats
+		if m.Format != "" {
+			formats = []string{m.Format}
+		}
+		for _, f := range formats {
+			if t, err := time.Parse(f, v); err == nil {
+				return t.Format(time.RFC3339), nil
+			}
+		}
+		return nil, errors.New(v)
+
+	case AttribList:
+		sep := m.Separator
+		if sep == "" {
+			sep = ","
+		}
+
+		out := []string{}
+		for _, item := range strings.Split(v, sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// setAttrib sets a value in the attribute map by its key where dots
+// in the key denote nested maps, eg: address.city.
+func setAttrib(attribs models.JSON, key string, val any) {
+	var (
+		parts = strings.Split(strings.Trim(key, "."), ".")
+		mp    = map[string]any(attribs)
+	)
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := mp[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			mp[p] = next
+		}
+		mp = next
+	}
+
+	mp[parts[len(parts)-1]] = val
+}
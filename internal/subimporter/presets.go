@@ -0,0 +1,443 @@
+package subimporter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Presets for importing the CSV exports of other e-mail service providers (ESP).
+const (
+	PresetMailchimp = "mailchimp"
+	PresetSendy     = "sendy"
+)
+
+// preset describes the CSV export format of an ESP. All column
+// names are lowercased.
+type preset struct {
+	// Column names for the e-mail. The first one that's found is used.
+	emailCols []string
+
+	// Columns that are joined to form the name, eg: first name, last name.
+	nameCols []string
+
+	// Column with the tags of a subscriber that are mapped to lists.
+	tagsCol string
+
+	// Column with the status of a subscriber.
+	statusCol string
+
+	// Values of the status column, and keywords in file names, mapped to the
+	// subscription status to import subscribers with. An empty status is the
+	// session's status and models.SubscriberStatusBlockListed blocklists.
+	statuses map[string]string
+
+	// Keywords in file names in the order they're matched.
+	fileStatuses []string
+
+	// System columns that aren't imported as attributes. All
+	// other columns (merge/custom fields) are.
+	ignoreCols map[string]bool
+}
+
+var (
+	presets = map[string]preset{
+		// Mailchimp audience exports are ZIPs with subscribed_members_export_*.csv,
+		// unsubscribed_members_export_*.csv, cleaned_members_export_*.csv,
+		// and nonsubscribed_members_export_*.csv files.
+		PresetMailchimp: {
+			emailCols: []string{"email address", "email"},
+			nameCols:  []string{"first name", "last name"},
+			tagsCol:   "tags",
+			statuses: map[string]string{
+				"unsubscribed":  models.SubscriptionStatusUnsubscribed,
+				"nonsubscribed": models.SubscriptionStatusUnsubscribed,
+				"cleaned":       models.SubscriberStatusBlockListed,
+				"subscribed":    "",
+			},
+			fileStatuses: []string{"unsubscribed", "nonsubscribed", "cleaned", "subscribed"},
+			ignoreCols: map[string]bool{
+				"member_rating": true, "optin_time": true, "optin_ip": true, "confirm_time": true,
+				"confirm_ip": true, "latitude": true, "longitude": true, "gmtoff": true, "dstoff": true,
+				"timezone": true, "cc": true, "region": true, "last_changed": true, "leid": true,
+				"euid": true, "notes": true, "unsub_time": true, "unsub_campaign_title": true,
+				"unsub_campaign_id": true, "unsub_reason": true, "unsub_reason_other": true,
+				"clean_time": true, "clean_campaign_title": true, "clean_campaign_id": true,
+			},
+		},
+
+		// Sendy list exports have the Name, Email, custom field columns, and
+		// optionally, a Status column. Exports of a single segment (eg: unsubscribed)
+		// have the status in their file names.
+		PresetSendy: {
+			emailCols: []string{"email"},
+			nameCols:  []string{"name"},
+			statusCol: "status",
+			statuses: map[string]string{
+				"subscribed":     "",
+				"active":         "",
+				"soft bounced":   "",
+				"unconfirmed":    models.SubscriptionStatusUnconfirmed,
+				"unsubscribed":   models.SubscriptionStatusUnsubscribed,
+				"bounced":        models.SubscriberStatusBlockListed,
+				"hard bounced":   models.SubscriberStatusBlockListed,
+				"complaint":      models.SubscriberStatusBlockListed,
+				"marked as spam": models.SubscriberStatusBlockListed,
+				"spam":           models.SubscriberStatusBlockListed,
+			},
+			fileStatuses: []string{"unsubscribed", "unconfirmed", "bounced", "complaint", "spam"},
+			ignoreCols: map[string]bool{
+				"joined": true, "last activity": true, "status": true,
+			},
+		},
+	}
+
+	regexAttribKey = regexp.MustCompile("[^a-z0-9]+")
+)
+
+// IsPreset checks if the given name is a known ESP import preset.
+func IsPreset(name string) bool {
+	_, ok := presets[name]
+	return ok
+}
+
+// LoadPreset loads one or more CSV files (eg: from a ZIP) exported from
+// an ESP with the session's preset, and validates and imports the subscriber
+// entries in them. The status of the subscribers in a file is derived from
+// the file's name or from each record's status column.
+func (s *Session) LoadPreset(srcPaths []string, delim rune) error {
+	if s.isDone() {
+		return ErrIsImporting
+	}
+
+	// Default status is "failed" in case the function
+	// returns at one of the many possible errors.
+	failed := true
+	defer func() {
+		if failed {
+			s.setStatus(StatusFailed)
+		}
+	}()
+
+	p, ok := presets[s.opt.Preset]
+	if !ok {
+		return fmt.Errorf("unknown preset '%s'", s.opt.Preset)
+	}
+
+	// Count the lines in all the files to derive the progress.
+	total := 0
+	for _, path := range srcPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		n, err := countLines(f)
+		f.Close()
+		if err != nil {
+			s.log.Printf("error counting lines in '%s': '%v'", path, err)
+			return err
+		}
+		if n > 0 {
+			total += n - 1
+		}
+	}
+
+	if total == 0 {
+		return errors.New("empty file")
+	}
+
+	s.Lock()
+	s.status.Total = total
+	s.Unlock()
+
+	// Files may have different columns. Rejected records are recorded
+	// with their file names and line numbers.
+	defer s.closeRejects()
+	s.rejectsHdr = []string{"file", "line", "email", "error"}
+
+	for _, path := range srcPaths {
+		stopped, err := s.loadPresetCSV(p, path, delim)
+		if err != nil {
+			return err
+		}
+
+		if stopped {
+			failed = false
+			s.closeQueue()
+			s.log.Println("stop request received")
+			return nil
+		}
+	}
+
+	s.closeQueue()
+	failed = false
+
+	return nil
+}
+
+// loadPresetCSV loads the records in an ESP export CSV file into the queue.
+// It returns true if a stop signal was received.
+func (s *Session) loadPresetCSV(p preset, srcPath string, delim rune) (bool, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fName := filepath.Base(srcPath)
+	s.log.Printf("importing '%s'", fName)
+
+	rd := csv.NewReader(f)
+	rd.Comma = delim
+	rd.FieldsPerRecord = -1
+
+	csvHdr, err := rd.Read()
+	if err != nil {
+		s.log.Printf("error reading header from '%s': '%v'", fName, err)
+		return false, err
+	}
+
+	var (
+		hdr       = make([]string, len(csvHdr))
+		emailIdx  = -1
+		tagsIdx   = -1
+		statusIdx = -1
+		nameIdx   = []int{}
+	)
+	for n, h := range csvHdr {
+		hdr[n] = strings.ToLower(cleanHeader(h))
+	}
+
+	for _, c := range p.emailCols {
+		if emailIdx = slices.Index(hdr, c); emailIdx > -1 {
+			break
+		}
+	}
+	if emailIdx < 0 {
+		s.log.Printf("e-mail column not found in '%s'", fName)
+		return false, fmt.Errorf("e-mail column not found in '%s'", fName)
+	}
+
+	for _, c := range p.nameCols {
+		if n := slices.Index(hdr, c); n > -1 {
+			nameIdx = append(nameIdx, n)
+		}
+	}
+	if p.tagsCol != "" {
+		tagsIdx = slices.Index(hdr, p.tagsCol)
+	}
+	if p.statusCol != "" {
+		statusIdx = slices.Index(hdr, p.statusCol)
+	}
+
+	// All other columns are attributes.
+	attribKeys := make(map[int]string)
+	for n, h := range hdr {
+		if n == emailIdx || n == tagsIdx || n == statusIdx || slices.Contains(nameIdx, n) || p.ignoreCols[h] {
+			continue
+		}
+		if k := strings.Trim(regexAttribKey.ReplaceAllString(h, "_"), "_"); k != "" {
+			attribKeys[n] = k
+		}
+	}
+
+	// Subscribers in the file may have a status based on the file's name.
+	fileStatus := ""
+	for _, k := range p.fileStatuses {
+		if strings.Contains(strings.ToLower(fName), k) {
+			fileStatus = p.statuses[k]
+			s.log.Printf("importing '%s' with the status '%s'", fName, k)
+			break
+		}
+	}
+
+	i := 1
+	for {
+		i++
+
+		// Check for the stop signal.
+		select {
+		case <-s.stop:
+			return true, nil
+		default:
+		}
+
+		cols, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			s.log.Printf("error reading CSV '%s'", err)
+			return false, err
+		}
+
+		if len(cols) < len(hdr) {
+			s.log.Printf("skipping line %d in '%s'. column count (%d) does not match header count (%d)", i, fName, len(cols), len(hdr))
+			s.rejectPreset(fName, i, "", csv.ErrFieldCount.Error())
+			continue
+		}
+
+		sub := SubReq{}
+		sub.Email = strings.TrimSpace(cols[emailIdx])
+
+		names := make([]string, 0, len(nameIdx))
+		for _, n := range nameIdx {
+			if v := strings.TrimSpace(cols[n]); v != "" {
+				names = append(names, v)
+			}
+		}
+		sub.Name = strings.Join(names, " ")
+
+		sub, err = s.im.ValidateFields(sub)
+		if err != nil {
+			s.log.Printf("skipping line %d in '%s': %v", i, fName, err)
+			s.rejectPreset(fName, i, cols[emailIdx], err.Error())
+			continue
+		}
+
+		attribs := models.JSON{}
+		for n, k := range attribKeys {
+			if v := strings.TrimSpace(cols[n]); v != "" {
+				attribs[k] = v
+			}
+		}
+		if len(attribs) > 0 {
+			sub.Attribs = attribs
+		}
+
+		// Status.
+		status := fileStatus
+		if statusIdx > -1 {
+			v := strings.ToLower(strings.TrimSpace(cols[statusIdx]))
+			if st, ok := p.statuses[v]; ok {
+				status = st
+			} else if v != "" {
+				s.log.Printf("unknown status '%s' on line %d in '%s'", v, i, fName)
+			}
+		}
+		if status == models.SubscriberStatusBlockListed {
+			sub.Status = models.SubscriberStatusBlockListed
+		} else {
+			sub.SubStatus = status
+		}
+
+		// Tags to lists.
+		if tagsIdx > -1 && s.opt.Mode == ModeSubscribe {
+			ids, err := s.getTagListIDs(parseTags(cols[tagsIdx]))
+			if err != nil {
+				s.log.Printf("error getting lists for tags: %v", err)
+				return false, err
+			}
+			sub.Lists = ids
+		}
+
+		s.subQueue <- sub
+	}
+
+	return false, nil
+}
+
+// getTagListIDs returns the IDs of the lists with the names of the given tags.
+// Lists are looked up (and created if CreateLists is set) once per session.
+func (s *Session) getTagListIDs(tags []string) ([]int, error) {
+	if len(tags) == 0 || s.im.opt.ListIDsCB == nil {
+		return nil, nil
+	}
+
+	if s.listIDs == nil {
+		s.listIDs = make(map[string]int)
+	}
+
+	// Look up the tags that haven't been seen before.
+	var names []string
+	for _, t := range tags {
+		if _, ok := s.listIDs[strings.ToLower(t)]; !ok {
+			names = append(names, t)
+		}
+	}
+	if len(names) > 0 {
+		ids, err := s.im.opt.ListIDsCB(names, s.opt.CreateLists)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tags that map to lists the user doesn't have access to are ignored.
+		found := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if id > 0 {
+				found = append(found, id)
+			}
+		}
+		permitted := make(map[int]bool, len(found))
+		for _, id := range s.permittedLists(found) {
+			permitted[id] = true
+		}
+
+		for _, name := range names {
+			key := strings.ToLower(name)
+			if _, ok := s.listIDs[key]; ok {
+				continue
+			}
+
+			id := ids[key]
+			switch {
+			case id == 0:
+				s.log.Printf("no list found for the tag '%s'", name)
+			case !permitted[id]:
+				s.log.Printf("no permission for the list of the tag '%s'", name)
+				id = 0
+			}
+			s.listIDs[key] = id
+		}
+	}
+
+	out := make([]int, 0, len(tags))
+	for _, t := range tags {
+		if id := s.listIDs[strings.ToLower(t)]; id > 0 {
+			out = append(out, id)
+		}
+	}
+
+	return out, nil
+}
+
+// rejectPreset records a rejected record in a preset import.
+func (s *Session) rejectPreset(fName string, line int, email, reason string) {
+	s.rejectCSV([]string{fName, strconv.Itoa(line), email}, reason)
+}
+
+// parseTags parses a tags column which is either a comma separated list of tags
+// or a list of quoted tags as in Mailchimp exports, eg: "tag one","tag two".
+func parseTags(v string) []string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+
+	rd := csv.NewReader(strings.NewReader(v))
+	rd.LazyQuotes = true
+	rd.TrimLeadingSpace = true
+
+	tags, err := rd.Read()
+	if err != nil {
+		tags = strings.Split(v, ",")
+	}
+
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,121 @@
+package subimporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rejectCSV records a rejected CSV row with the reason for its rejection.
+func (s *Session) rejectCSV(cols []string, reason string) {
+	s.addReject(reason)
+
+	if s.opt.DryRun {
+		return
+	}
+
+	if s.rejectsCSV == nil {
+		if err := s.openRejects(".csv"); err != nil {
+			return
+		}
+
+		s.rejectsCSV = csv.NewWriter(s.rejectsFile)
+		if len(s.opt.Delim) == 1 {
+			s.rejectsCSV.Comma = rune(s.opt.Delim[0])
+		}
+		_ = s.rejectsCSV.Write(s.rejectsHdr)
+	}
+
+	// Pad or trim the row to the header so that it's a valid CSV row.
+	row := make([]string, len(s.rejectsHdr))
+	copy(row, cols)
+	row[len(row)-1] = reason
+
+	if err := s.rejectsCSV.Write(row); err != nil {
+		s.log.Printf("error writing rejected record: %v", err)
+	}
+}
+
+// rejectJSON records a rejected raw JSON record with the reason for its rejection.
+// An error field is added to the record if it's an object, or else, the record
+// is wrapped in an object with the error.
+func (s *Session) rejectJSON(raw []byte, reason string) {
+	s.addReject(reason)
+
+	if s.opt.DryRun {
+		return
+	}
+
+	if s.rejectsFile == nil {
+		if err := s.openRejects(".ndjson"); err != nil {
+			return
+		}
+	}
+
+	var rec map[string]any
+	if err := json.Unmarshal(raw, &rec); err != nil || rec == nil {
+		rec = map[string]any{"record": string(raw)}
+	}
+	rec["error"] = reason
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		s.log.Printf("error writing rejected record: %v", err)
+		return
+	}
+
+	if _, err := s.rejectsFile.Write(append(b, '\n')); err != nil {
+		s.log.Printf("error writing rejected record: %v", err)
+	}
+}
+
+// addReject increments the rejected count for the given reason in the session's report.
+func (s *Session) addReject(reason string) {
+	s.Lock()
+	s.status.Report.Rejected++
+	s.status.Report.Reasons[reason]++
+	s.Unlock()
+}
+
+// openRejects creates the temporary file to which rejected records are written.
+func (s *Session) openRejects(ext string) error {
+	f, err := os.CreateTemp("", "listmonk-rejects-*"+ext)
+	if err != nil {
+		s.log.Printf("error creating rejects file: %v", err)
+		return err
+	}
+	s.rejectsFile = f
+
+	s.Lock()
+	s.status.rejectsFile = f.Name()
+	s.Unlock()
+
+	return nil
+}
+
+// closeRejects flushes and closes the session's rejects file, if there's one.
+func (s *Session) closeRejects() {
+	if s.rejectsFile == nil {
+		return
+	}
+
+	if s.rejectsCSV != nil {
+		s.rejectsCSV.Flush()
+		if err := s.rejectsCSV.Error(); err != nil {
+			s.log.Printf("error writing rejects file: %v", err)
+		}
+	}
+
+	if err := s.rejectsFile.Close(); err != nil {
+		s.log.Printf("error closing rejects file: %v", err)
+	}
+}
+
+// RejectsFilename returns the download filename for the rejects file
+// of an import of the given file, eg: subs.csv => subs-rejects.csv.
+func RejectsFilename(name, rejectsFile string) string {
+	ext := filepath.Ext(rejectsFile)
+	return strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)) + "-rejects" + ext
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Audit log actions.
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// AuditLog represents an audit log entry of a mutating action performed by a user.
+type AuditLog struct {
+	ID         int64           `db:"id" json:"id"`
+	UserID     null.Int        `db:"user_id" json:"user_id"`
+	Username   string          `db:"username" json:"username"`
+	Action     string          `db:"action" json:"action"`
+	TargetType string          `db:"target_type" json:"target_type"`
+	TargetID   null.Int        `db:"target_id" json:"target_id"`
+	Route      string          `db:"route" json:"route"`
+	Diff       json.RawMessage `db:"diff" json:"diff"`
+	IP         string          `db:"ip" json:"ip"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of entries
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
+
+// AuditDiff represents the before and after states of an audited target.
+type AuditDiff struct {
+	Before map[string]any `json:"before"`
+	After  map[string]any `json:"after"`
+	Query  map[string]any `json:"query,omitempty"`
+}
@@ -2,10 +2,12 @@ package models
 
 import (
 	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"strings"
 	txttpl "text/template"
 
@@ -29,6 +31,16 @@ const (
 	CampaignContentTypeMarkdown = "markdown"
 	CampaignContentTypePlain    = "plain"
 	CampaignContentTypeVisual   = "visual"
+
+	// Per-subscriber attachment types.
+	SubAttachmentMedia = "media"
+	SubAttachmentText  = "text"
+	SubAttachmentCSV   = "csv"
+
+	// What to do when a per-subscriber attachment can't be resolved.
+	SubAttachmentMissingSkip = "skip"
+	SubAttachmentMissingSend = "send"
+	SubAttachmentMissingFail = "fail"
 )
 
 // Campaigns represents a slice of Campaigns.
@@ -52,6 +64,8 @@ type Campaign struct {
 	ContentType       string          `db:"content_type" json:"content_type"`
 	Tags              pq.StringArray  `db:"tags" json:"tags"`
 	Headers           Headers         `db:"headers" json:"headers"`
+	SubAttachments    SubAttachments  `db:"sub_attachments" json:"sub_attachments"`
+	Security          string          `db:"security" json:"security"`
 	Attribs           JSON            `db:"attribs" json:"attribs"`
 	TemplateID        null.Int        `db:"template_id" json:"template_id"`
 	Messenger         string          `db:"messenger" json:"messenger"`
@@ -62,6 +76,7 @@ type Campaign struct {
 
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
+	TemplateSecurity    string             `db:"template_security" json:"-"`
 	ArchiveTemplateBody string             `db:"archive_template_body" json:"-"`
 	Tpl                 *template.Template `json:"-"`
 	SubjectTpl          *txttpl.Template   `json:"-"`
@@ -79,6 +94,35 @@ type Campaign struct {
 	Total int `db:"total" json:"-"`
 }
 
+// SubAttachment is an attachment that's resolved or generated for every
+// subscriber when a campaign message is rendered.
+type SubAttachment struct {
+	// Type is one of media, text, csv.
+	Type string `json:"type"`
+
+	// Name is a template that's rendered into the filename of a media file
+	// (media) or the filename of the generated document (text, csv).
+	Name string `json:"name"`
+
+	// Template is the body of a generated document. For text, it's HTML that's
+	// converted to plain text.
+	Template string `json:"template"`
+
+	// OnMissing is one of skip, send, fail.
+	OnMissing string `json:"on_missing"`
+
+	NameTpl *txttpl.Template `json:"-"`
+	BodyTpl Renderer         `json:"-"`
+}
+
+// SubAttachments represents a list of per-subscriber attachments on a campaign.
+type SubAttachments []SubAttachment
+
+// Renderer is a compiled html or text template.
+type Renderer interface {
+	ExecuteTemplate(w io.Writer, name string, data any) error
+}
+
 // CampaignMeta contains fields tracking a campaign's progress.
 type CampaignMeta struct {
 	CampaignID int `db:"campaign_id" json:"-"`
@@ -133,6 +177,16 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 	return nil
 }
 
+// MessageSecurity returns the signing and encryption policy of the campaign's
+// messages. If the campaign doesn't have one, its template's is used.
+func (c *Campaign) MessageSecurity() string {
+	if c.Security != "" {
+		return c.Security
+	}
+
+	return c.TemplateSecurity
+}
+
 // CompileTemplate compiles a campaign body template into its base
 // template and sets the resultant template to Campaign.Tpl.
 func (c *Campaign) CompileTemplate(f template.FuncMap) error {
@@ -206,9 +260,82 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 		c.AltBodyTpl = bTpl
 	}
 
+	if err := c.SubAttachments.compile(f); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// compile compiles the name and body templates of per-subscriber attachments.
+func (s SubAttachments) compile(f template.FuncMap) error {
+	var txtFuncs map[string]any = f
+
+	for i, a := range s {
+		name := a.Name
+		for _, r := range regTplFuncs {
+			name = r.regExp.ReplaceAllString(name, r.replace)
+		}
+
+		nameTpl, err := txttpl.New(ContentTpl).Funcs(txtFuncs).Parse(name)
+		if err != nil {
+			return fmt.Errorf("error compiling attachment name %s: %v", a.Name, err)
+		}
+		s[i].NameTpl = nameTpl
+
+		if a.Type == SubAttachmentMedia {
+			continue
+		}
+
+		body := a.Template
+		for _, r := range regTplFuncs {
+			body = r.regExp.ReplaceAllString(body, r.replace)
+		}
+
+		// The text document is HTML that's converted to text, so it's escaped
+		// like message bodies. CSV is not.
+		if a.Type == SubAttachmentText {
+			s[i].BodyTpl, err = template.New(ContentTpl).Funcs(f).Parse(body)
+		} else {
+			s[i].BodyTpl, err = txttpl.New(ContentTpl).Funcs(txtFuncs).Parse(body)
+		}
+		if err != nil {
+			return fmt.Errorf("error compiling attachment %s: %v", a.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *SubAttachments) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, s)
+}
+
+// Value implements the driver.Valuer interface.
+func (s SubAttachments) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
 // ConvertContent converts a campaign's body from one format to another,
 // for example, Markdown to HTML.
 func (c *Campaign) ConvertContent(from, to string) (string, error) {
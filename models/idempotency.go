@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdempotencyKey represents the idempotency key of an API request
+// and its stored response.
+type IdempotencyKey struct {
+	Key         string    `db:"key"`
+	RequestHash string    `db:"request_hash"`
+	StatusCode  int       `db:"status_code"`
+	ContentType string    `db:"content_type"`
+	Response    []byte    `db:"response"`
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
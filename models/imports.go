@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// ImportJob represents a bulk subscriber import job.
+type ImportJob struct {
+	ID          int             `db:"id" json:"id"`
+	Name        string          `db:"name" json:"name"`
+	Mode        string          `db:"mode" json:"mode"`
+	Status      string          `db:"status" json:"status"`
+	Options     json.RawMessage `db:"options" json:"options"`
+	Total       int             `db:"total" json:"total"`
+	Imported    int             `db:"imported" json:"imported"`
+	Report      json.RawMessage `db:"report" json:"report"`
+	Log         string          `db:"log" json:"log,omitempty"`
+	RejectsFile string          `db:"rejects_file" json:"-"`
+	HasRejects  bool            `db:"-" json:"has_rejects"`
+	UserID      null.Int        `db:"user_id" json:"user_id"`
+	Username    string          `db:"username" json:"username"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	StartedAt   null.Time       `db:"started_at" json:"started_at"`
+	FinishedAt  null.Time       `db:"finished_at" json:"finished_at"`
+
+	// Pseudofield for getting the total number of jobs
+	// in searches and queries.
+	JobCount int `db:"job_count" json:"-"`
+}
@@ -2,13 +2,33 @@ package models
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/textproto"
 	"strings"
 	txttpl "text/template"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
+// Message security (signing and encryption) policies of campaigns and templates.
+// An empty policy on a campaign falls back to its template's.
+const (
+	SecurityNone            = "none"
+	SecuritySign            = "sign"
+	SecurityEncrypt         = "encrypt"
+	SecurityEncryptRequired = "encrypt_required"
+)
+
+// ErrMessageSkipped is returned by a Messenger's Push for messages that are
+// skipped as the subscriber can't receive them on the messenger, eg: subscribers
+// without a chat ID attribute. Skipped messages are neither sent nor failed.
+var ErrMessageSkipped = errors.New("message skipped")
+
 // Message is the message pushed to a Messenger.
 type Message struct {
 	From        string
@@ -27,6 +47,14 @@ type Message struct {
 
 	// Messenger is the messenger backend to use: email|postback.
 	Messenger string
+
+	// TxUUID is the ID of an asynchronous transactional message
+	// whose delivery status is recorded after it's pushed.
+	TxUUID string
+
+	// Security is the signing and encryption policy of the message. Messengers
+	// that don't support it ignore it.
+	Security string
 }
 
 // Attachment represents a file or blob attachment that can be
@@ -44,6 +72,13 @@ const (
 	TxSubModeExternal = "external"
 )
 
+// Asynchronous TxMessage statuses.
+const (
+	TxStatusQueued = "queued"
+	TxStatusSent   = "sent"
+	TxStatusFailed = "failed"
+)
+
 // TxMessage represents an e-mail campaign.
 type TxMessage struct {
 	SubscriberMode   string   `json:"subscriber_mode"`
@@ -65,16 +100,74 @@ type TxMessage struct {
 	// File attachments added from multi-part form data.
 	Attachments []Attachment `json:"-"`
 
+	// UUID of the message's log entry used in {{ TrackView }} and {{ TrackLink }}.
+	UUID string `json:"-"`
+
 	Body       []byte             `json:"-"`
 	Tpl        *template.Template `json:"-"`
 	SubjectTpl *txttpl.Template   `json:"-"`
 }
 
+// TxBatch represents a batch of asynchronous transactional messages
+// with common message fields and per-recipient template data.
+type TxBatch struct {
+	SubscriberMode string  `json:"subscriber_mode"`
+	TemplateID     int     `json:"template_id"`
+	FromEmail      string  `json:"from_email"`
+	Headers        Headers `json:"headers"`
+	ContentType    string  `json:"content_type"`
+	Messenger      string  `json:"messenger"`
+	Subject        string  `json:"subject"`
+
+	// Optional URL that the delivery status of every message is POSTed to.
+	CallbackURL string `json:"callback_url"`
+
+	Messages []TxRecipient `json:"messages"`
+}
+
+// TxRecipient is a recipient in a TxBatch.
+type TxRecipient struct {
+	SubscriberEmail string         `json:"subscriber_email"`
+	SubscriberID    int            `json:"subscriber_id"`
+	Data            map[string]any `json:"data"`
+}
+
+// TxMessageLog represents the log entry of a transactional message
+// with its delivery status and tracked views and clicks.
+type TxMessageLog struct {
+	ID           int64           `db:"id" json:"-"`
+	UUID         string          `db:"uuid" json:"id"`
+	BatchUUID    null.String     `db:"batch_uuid" json:"batch_id"`
+	SubscriberID null.Int        `db:"subscriber_id" json:"subscriber_id"`
+	Email        string          `db:"email" json:"subscriber_email"`
+	TemplateID   null.Int        `db:"template_id" json:"template_id"`
+	Subject      string          `db:"subject" json:"subject"`
+	Messenger    string          `db:"messenger" json:"messenger"`
+	Status       string          `db:"status" json:"status"`
+	Error        string          `db:"error" json:"error"`
+	CallbackURL  string          `db:"callback_url" json:"-"`
+	Payload      json.RawMessage `db:"payload" json:"-"`
+	Views        int             `db:"views" json:"views"`
+	Clicks       int             `db:"clicks" json:"clicks"`
+	ClickedLinks pq.StringArray  `db:"clicked_links" json:"clicked_links,omitempty"`
+	CreatedAt    time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time       `db:"updated_at" json:"updated_at"`
+	ViewedAt     null.Time       `db:"viewed_at" json:"viewed_at"`
+	ClickedAt    null.Time       `db:"clicked_at" json:"clicked_at"`
+
+	// Pseudofield for getting the total number of entries
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
+
+// TxTplData is the data context of a rendered tx template.
+type TxTplData struct {
+	Subscriber Subscriber
+	Tx         *TxMessage
+}
+
 func (m *TxMessage) Render(sub Subscriber, tpl *Template) error {
-	data := struct {
-		Subscriber Subscriber
-		Tx         *TxMessage
-	}{sub, m}
+	data := TxTplData{Subscriber: sub, Tx: m}
 
 	// Render the body.
 	b := bytes.Buffer{}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PushSubscription represents the web push subscription of
+// a subscriber's browser.
+type PushSubscription struct {
+	ID           int64     `db:"id" json:"id"`
+	SubscriberID int       `db:"subscriber_id" json:"subscriber_id"`
+	Endpoint     string    `db:"endpoint" json:"endpoint"`
+	KeyP256dh    string    `db:"key_p256dh" json:"-"`
+	KeyAuth      string    `db:"key_auth" json:"-"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
@@ -13,6 +13,7 @@ import (
 type Queries struct {
 	GetDashboardCharts *sqlx.Stmt `query:"get-dashboard-charts"`
 	GetDashboardCounts *sqlx.Stmt `query:"get-dashboard-counts"`
+	GetDigestReport    *sqlx.Stmt `query:"get-digest-report"`
 
 	InsertSubscriber                *sqlx.Stmt `query:"insert-subscriber"`
 	UpsertSubscriber                *sqlx.Stmt `query:"upsert-subscriber"`
@@ -20,6 +21,7 @@ type Queries struct {
 	GetSubscriber                   *sqlx.Stmt `query:"get-subscriber"`
 	HasSubscriberLists              *sqlx.Stmt `query:"has-subscriber-list"`
 	GetSubscribersByEmails          *sqlx.Stmt `query:"get-subscribers-by-emails"`
+	GetSubscriberEmails             *sqlx.Stmt `query:"get-subscriber-emails"`
 	GetSubscriberLists              *sqlx.Stmt `query:"get-subscriber-lists"`
 	GetSubscriptions                *sqlx.Stmt `query:"get-subscriptions"`
 	GetSubscriberListsLazy          *sqlx.Stmt `query:"get-subscriber-lists-lazy"`
@@ -37,6 +39,10 @@ type Queries struct {
 	UnsubscribeByCampaign           *sqlx.Stmt `query:"unsubscribe-by-campaign"`
 	ExportSubscriberData            *sqlx.Stmt `query:"export-subscriber-data"`
 	GetSubscriberActivity           *sqlx.Stmt `query:"get-subscriber-activity"`
+	ClaimScheduledExport            *sqlx.Stmt `query:"claim-scheduled-export"`
+	UpdateScheduledExportFile       *sqlx.Stmt `query:"update-scheduled-export-file"`
+	DeleteScheduledExportFile       *sqlx.Stmt `query:"delete-scheduled-export-file"`
+	GetScheduledExportFiles         *sqlx.Stmt `query:"get-scheduled-export-files"`
 
 	// Non-prepared arbitrary subscriber queries.
 	QuerySubscribers                       string     `query:"query-subscribers"`
@@ -54,6 +60,7 @@ type Queries struct {
 	QueryLists      string     `query:"query-lists"`
 	GetLists        *sqlx.Stmt `query:"get-lists"`
 	GetListsByOptin *sqlx.Stmt `query:"get-lists-by-optin"`
+	GetListsByNames *sqlx.Stmt `query:"get-lists-by-names"`
 	GetListTypes    *sqlx.Stmt `query:"get-list-types"`
 	UpdateList      *sqlx.Stmt `query:"update-list"`
 	UpdateListsDate *sqlx.Stmt `query:"update-lists-date"`
@@ -70,13 +77,16 @@ type Queries struct {
 
 	// These two queries are read as strings and based on settings.individual_tracking=on/off,
 	// are interpolated and copied to view and click counts. Same query, different tables.
-	GetCampaignAnalyticsCounts string     `query:"get-campaign-analytics-counts"`
-	GetCampaignViewCounts      *sqlx.Stmt `query:"get-campaign-view-counts"`
-	GetCampaignClickCounts     *sqlx.Stmt `query:"get-campaign-click-counts"`
-	GetCampaignLinkCounts      *sqlx.Stmt `query:"get-campaign-link-counts"`
-	GetCampaignBounceCounts    *sqlx.Stmt `query:"get-campaign-bounce-counts"`
-	DeleteCampaignViews        *sqlx.Stmt `query:"delete-campaign-views"`
-	DeleteCampaignLinkClicks   *sqlx.Stmt `query:"delete-campaign-link-clicks"`
+	GetCampaignAnalyticsCounts        string     `query:"get-campaign-analytics-counts"`
+	GetCampaignViewCounts             *sqlx.Stmt `query:"get-campaign-view-counts"`
+	GetCampaignClickCounts            *sqlx.Stmt `query:"get-campaign-click-counts"`
+	GetCampaignLinkCounts             *sqlx.Stmt `query:"get-campaign-link-counts"`
+	GetCampaignLinkStats              *sqlx.Stmt `query:"get-campaign-link-stats"`
+	GetCampaignAnalyticsSummary       *sqlx.Stmt `query:"get-campaign-analytics-summary"`
+	ExportCampaignSubscriberAnalytics *sqlx.Stmt `query:"export-campaign-subscriber-analytics"`
+	GetCampaignBounceCounts           *sqlx.Stmt `query:"get-campaign-bounce-counts"`
+	DeleteCampaignViews               *sqlx.Stmt `query:"delete-campaign-views"`
+	DeleteCampaignLinkClicks          *sqlx.Stmt `query:"delete-campaign-link-clicks"`
 
 	NextCampaigns            *sqlx.Stmt `query:"next-campaigns"`
 	GetRunningCampaign       *sqlx.Stmt `query:"get-running-campaign"`
@@ -94,6 +104,15 @@ type Queries struct {
 	GetMedia    *sqlx.Stmt `query:"get-media"`
 	QueryMedia  *sqlx.Stmt `query:"query-media"`
 	DeleteMedia *sqlx.Stmt `query:"delete-media"`
+	UpdateMedia *sqlx.Stmt `query:"update-media"`
+
+	GetMediaFolders *sqlx.Stmt `query:"get-media-folders"`
+	GetMediaUsage   *sqlx.Stmt `query:"get-media-usage"`
+
+	GetMediaByHash      *sqlx.Stmt `query:"get-media-by-hash"`
+	AddMediaAlias       *sqlx.Stmt `query:"add-media-alias"`
+	GetMediaStorage     *sqlx.Stmt `query:"get-media-storage"`
+	GetMediaUserStorage *sqlx.Stmt `query:"get-media-user-storage"`
 
 	CreateTemplate     *sqlx.Stmt `query:"create-template"`
 	GetTemplates       *sqlx.Stmt `query:"get-templates"`
@@ -116,6 +135,42 @@ type Queries struct {
 	DeleteBouncesBySubscriber   *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
 	GetDBInfo                   string     `query:"get-db-info"`
 
+	CreateImportJob           *sqlx.Stmt `query:"create-import-job"`
+	UpdateImportJob           *sqlx.Stmt `query:"update-import-job"`
+	QueryImportJobs           *sqlx.Stmt `query:"query-import-jobs"`
+	GetImportJob              *sqlx.Stmt `query:"get-import-job"`
+	TouchImportJobs           *sqlx.Stmt `query:"touch-import-jobs"`
+	FailInterruptedImportJobs *sqlx.Stmt `query:"fail-interrupted-import-jobs"`
+	DeleteImportJobs          *sqlx.Stmt `query:"delete-import-jobs"`
+	CreateImportStaging       string     `query:"create-import-staging"`
+	UpsertImportStaging       string     `query:"upsert-import-staging"`
+	BlocklistImportStaging    string     `query:"blocklist-import-staging"`
+
+	InsertTxMessages    *sqlx.Stmt `query:"insert-tx-messages"`
+	UpdateTxMessage     *sqlx.Stmt `query:"update-tx-message"`
+	NextTxMessages      *sqlx.Stmt `query:"next-tx-messages"`
+	FailStaleTxMessages *sqlx.Stmt `query:"fail-stale-tx-messages"`
+	GetTxMessage        *sqlx.Stmt `query:"get-tx-message"`
+	GetTxBatch          *sqlx.Stmt `query:"get-tx-batch"`
+	QueryTxMessages     *sqlx.Stmt `query:"query-tx-messages"`
+	RegisterTxView      *sqlx.Stmt `query:"register-tx-view"`
+	RegisterTxLinkClick *sqlx.Stmt `query:"register-tx-link-click"`
+	DeleteTxMessages    *sqlx.Stmt `query:"delete-tx-messages"`
+
+	CreateIdempotencyKey         *sqlx.Stmt `query:"create-idempotency-key"`
+	GetIdempotencyKey            *sqlx.Stmt `query:"get-idempotency-key"`
+	UpdateIdempotencyKey         *sqlx.Stmt `query:"update-idempotency-key"`
+	DeleteIdempotencyKey         *sqlx.Stmt `query:"delete-idempotency-key"`
+	DeleteExpiredIdempotencyKeys *sqlx.Stmt `query:"delete-expired-idempotency-keys"`
+
+	UpsertPushSubscription *sqlx.Stmt `query:"upsert-push-subscription"`
+	GetPushSubscriptions   *sqlx.Stmt `query:"get-push-subscriptions"`
+	DeletePushSubscription *sqlx.Stmt `query:"delete-push-subscription"`
+
+	InsertAuditLog  *sqlx.Stmt `query:"insert-audit-log"`
+	QueryAuditLogs  *sqlx.Stmt `query:"query-audit-logs"`
+	DeleteAuditLogs *sqlx.Stmt `query:"delete-audit-logs"`
+
 	CreateUser        *sqlx.Stmt `query:"create-user"`
 	UpdateUser        *sqlx.Stmt `query:"update-user"`
 	UpdateUserProfile *sqlx.Stmt `query:"update-user-profile"`
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+const (
+	DigestFrequencyWeekly  = "weekly"
+	DigestFrequencyMonthly = "monthly"
+)
+
+// DigestReport represents the data of a periodic summary report that is e-mailed to admins.
+type DigestReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	Subscribers struct {
+		Total        int `json:"total"`
+		New          int `json:"new"`
+		Blocklisted  int `json:"blocklisted"`
+		Unsubscribed int `json:"unsubscribed"`
+	} `json:"subscribers"`
+
+	Messages struct {
+		Campaigns     int     `json:"campaigns"`
+		Sent          int     `json:"sent"`
+		Bounces       int     `json:"bounces"`
+		Complaints    int     `json:"complaints"`
+		BounceRate    float64 `json:"bounce_rate"`
+		ComplaintRate float64 `json:"complaint_rate"`
+	} `json:"messages"`
+
+	Lists      []DigestList     `json:"lists"`
+	TopOpened  []DigestCampaign `json:"top_opened"`
+	TopClicked []DigestCampaign `json:"top_clicked"`
+	Domains    []DigestDomain   `json:"domains"`
+}
+
+// DigestList represents the growth of a list in a digest report.
+type DigestList struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Subscribed   int    `json:"subscribed"`
+	Unsubscribed int    `json:"unsubscribed"`
+	Total        int    `json:"total"`
+}
+
+// DigestCampaign represents the performance of a campaign in a digest report.
+type DigestCampaign struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Sent      int     `json:"sent"`
+	Views     int     `json:"views"`
+	Clicks    int     `json:"clicks"`
+	OpenRate  float64 `json:"open_rate"`
+	ClickRate float64 `json:"click_rate"`
+}
+
+// DigestDomain represents the deliverability of a recipient domain in a digest report.
+// Bounces are hard and soft bounces.
+type DigestDomain struct {
+	Domain        string  `json:"domain"`
+	Sent          int     `json:"sent"`
+	Bounces       int     `json:"bounces"`
+	Hard          int     `json:"hard"`
+	Soft          int     `json:"soft"`
+	Complaint     int     `json:"complaint"`
+	BounceRate    float64 `json:"bounce_rate"`
+	ComplaintRate float64 `json:"complaint_rate"`
+}
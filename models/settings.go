@@ -17,27 +17,39 @@ type Settings struct {
 	CheckUpdates                  bool     `json:"app.check_updates"`
 	AppLang                       string   `json:"app.lang"`
 
+	AppDigestReport struct {
+		Enabled   bool   `json:"enabled"`
+		Frequency string `json:"frequency"`
+		UserIDs   []int  `json:"user_ids"`
+	} `json:"app.digest_report"`
+
+	AppScheduledExports []ScheduledExport `json:"app.scheduled_exports"`
+
 	AppBatchSize             int    `json:"app.batch_size"`
 	AppConcurrency           int    `json:"app.concurrency"`
 	AppMaxSendErrors         int    `json:"app.max_send_errors"`
+	AppImportConcurrency     int    `json:"app.import_concurrency"`
 	AppMessageRate           int    `json:"app.message_rate"`
 	CacheSlowQueries         bool   `json:"app.cache_slow_queries"`
 	CacheSlowQueriesInterval string `json:"app.cache_slow_queries_interval"`
+	AppIdempotencyTTL        string `json:"app.idempotency_ttl"`
 
 	AppMessageSlidingWindow         bool   `json:"app.message_sliding_window"`
 	AppMessageSlidingWindowDuration string `json:"app.message_sliding_window_duration"`
 	AppMessageSlidingWindowRate     int    `json:"app.message_sliding_window_rate"`
 
-	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
-	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
-	PrivacyAllowBlocklist     bool     `json:"privacy.allow_blocklist"`
-	PrivacyAllowPreferences   bool     `json:"privacy.allow_preferences"`
-	PrivacyAllowExport        bool     `json:"privacy.allow_export"`
-	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
-	PrivacyExportable         []string `json:"privacy.exportable"`
-	PrivacyRecordOptinIP      bool     `json:"privacy.record_optin_ip"`
-	DomainBlocklist           []string `json:"privacy.domain_blocklist"`
-	DomainAllowlist           []string `json:"privacy.domain_allowlist"`
+	PrivacyIndividualTracking  bool     `json:"privacy.individual_tracking"`
+	PrivacyUnsubHeader         bool     `json:"privacy.unsubscribe_header"`
+	PrivacyAllowBlocklist      bool     `json:"privacy.allow_blocklist"`
+	PrivacyAllowPreferences    bool     `json:"privacy.allow_preferences"`
+	PrivacyAllowExport         bool     `json:"privacy.allow_export"`
+	PrivacyAllowWipe           bool     `json:"privacy.allow_wipe"`
+	PrivacyExportable          []string `json:"privacy.exportable"`
+	PrivacyRecordOptinIP       bool     `json:"privacy.record_optin_ip"`
+	PrivacyTxLogRetentionDays  int      `json:"privacy.tx_log_retention_days"`
+	PrivacyImportRetentionDays int      `json:"privacy.import_retention_days"`
+	DomainBlocklist            []string `json:"privacy.domain_blocklist"`
+	DomainAllowlist            []string `json:"privacy.domain_allowlist"`
 
 	SecurityCaptcha struct {
 		Altcha struct {
@@ -62,7 +74,16 @@ type Settings struct {
 		DefaultListRoleID null.Int `json:"default_list_role_id"`
 	} `json:"security.oidc"`
 
-	SecurityCORSOrigins []string `json:"security.cors_origins"`
+	SecurityEmailCrypto struct {
+		Method        string `json:"method"`
+		SMIMECert     string `json:"smime_cert"`
+		SMIMEKey      string `json:"smime_key"`
+		PGPKey        string `json:"pgp_key"`
+		PGPPassphrase string `json:"pgp_passphrase"`
+	} `json:"security.email_crypto"`
+
+	SecurityCORSOrigins        []string `json:"security.cors_origins"`
+	SecurityAuditRetentionDays int      `json:"security.audit_retention_days"`
 
 	UploadProvider             string   `json:"upload.provider"`
 	UploadExtensions           []string `json:"upload.extensions"`
@@ -79,6 +100,40 @@ type Settings struct {
 	UploadS3BucketType         string   `json:"upload.s3.bucket_type"`
 	UploadS3Expiry             string   `json:"upload.s3.expiry"`
 
+	UploadWebDAVURL       string `json:"upload.webdav.url"`
+	UploadWebDAVUsername  string `json:"upload.webdav.username"`
+	UploadWebDAVPassword  string `json:"upload.webdav.password,omitempty"`
+	UploadWebDAVPath      string `json:"upload.webdav.path"`
+	UploadWebDAVPublicURL string `json:"upload.webdav.public_url"`
+	UploadWebDAVMaxConns  int    `json:"upload.webdav.max_conns"`
+	UploadWebDAVTimeout   string `json:"upload.webdav.timeout"`
+
+	UploadSFTPHost       string `json:"upload.sftp.host"`
+	UploadSFTPUsername   string `json:"upload.sftp.username"`
+	UploadSFTPPassword   string `json:"upload.sftp.password,omitempty"`
+	UploadSFTPPrivateKey string `json:"upload.sftp.private_key,omitempty"`
+	UploadSFTPHostKey    string `json:"upload.sftp.host_key"`
+	UploadSFTPPath       string `json:"upload.sftp.path"`
+	UploadSFTPPublicURL  string `json:"upload.sftp.public_url"`
+	UploadSFTPMaxConns   int    `json:"upload.sftp.max_conns"`
+	UploadSFTPTimeout    string `json:"upload.sftp.timeout"`
+
+	UploadImageMaxWidth   int      `json:"upload.image.max_width"`
+	UploadImageMaxHeight  int      `json:"upload.image.max_height"`
+	UploadImageQuality    int      `json:"upload.image.quality"`
+	UploadImageStripEXIF  bool     `json:"upload.image.strip_exif"`
+	UploadImageFormats    []string `json:"upload.image.formats"`
+	UploadImageRenditions []struct {
+		Name   string `json:"name"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+		Crop   bool   `json:"crop"`
+	} `json:"upload.image.renditions"`
+
+	UploadDeduplicate bool `json:"upload.deduplicate"`
+	UploadQuota       int  `json:"upload.quota"`
+	UploadUserQuota   int  `json:"upload.user_quota"`
+
 	SMTP []struct {
 		Name          string              `json:"name"`
 		UUID          string              `json:"uuid"`
@@ -99,17 +154,33 @@ type Settings struct {
 	} `json:"smtp"`
 
 	Messengers []struct {
-		UUID          string `json:"uuid"`
-		Enabled       bool   `json:"enabled"`
-		Name          string `json:"name"`
-		RootURL       string `json:"root_url"`
-		Username      string `json:"username"`
-		Password      string `json:"password,omitempty"`
-		MaxConns      int    `json:"max_conns"`
-		Timeout       string `json:"timeout"`
-		MaxMsgRetries int    `json:"max_msg_retries"`
+		UUID          string  `json:"uuid"`
+		Enabled       bool    `json:"enabled"`
+		Type          string  `json:"type"`
+		Name          string  `json:"name"`
+		RootURL       string  `json:"root_url"`
+		Username      string  `json:"username"`
+		Password      string  `json:"password,omitempty"`
+		MaxConns      int     `json:"max_conns"`
+		Timeout       string  `json:"timeout"`
+		MaxMsgRetries int     `json:"max_msg_retries"`
+		BatchSize     int     `json:"batch_size"`
+		BatchWait     string  `json:"batch_wait"`
+		HMACSecret    string  `json:"hmac_secret,omitempty"`
+		BodyTemplate  string  `json:"body_template"`
+		Command       string  `json:"command"`
+		Address       string  `json:"address"`
+		Config        string  `json:"config"`
+		Platform      string  `json:"platform"`
+		Attrib        string  `json:"attrib"`
+		RateLimit     float64 `json:"rate_limit"`
 	} `json:"messengers"`
 
+	WebPushEnabled         bool   `json:"webpush.enabled"`
+	WebPushSubject         string `json:"webpush.subject"`
+	WebPushVAPIDPublicKey  string `json:"webpush.vapid_public_key"`
+	WebPushVAPIDPrivateKey string `json:"webpush.vapid_private_key"`
+
 	BounceEnabled        bool `json:"bounce.enabled"`
 	BounceEnableWebhooks bool `json:"bounce.webhooks_enabled"`
 	BounceActions        map[string]struct {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
@@ -19,6 +20,14 @@ const (
 	SubscriptionStatusUnconfirmed  = "unconfirmed"
 	SubscriptionStatusConfirmed    = "confirmed"
 	SubscriptionStatusUnsubscribed = "unsubscribed"
+
+	// Sources of subscription events recorded in the subscription history.
+	SubscriptionSourceAdmin    = "admin"
+	SubscriptionSourceAPI      = "api"
+	SubscriptionSourceImport   = "import"
+	SubscriptionSourcePublic   = "public"
+	SubscriptionSourceCampaign = "campaign"
+	SubscriptionSourceBounce   = "bounce"
 )
 
 // Subscribers represents a slice of Subscriber.
@@ -118,6 +127,37 @@ type SubscriberExport struct {
 	Name    string `db:"name" json:"name"`
 	Attribs string `db:"attribs" json:"attribs"`
 	Status  string `db:"status" json:"status"`
+
+	// JSON array of list subscriptions (only if requested).
+	Subscriptions json.RawMessage `db:"subscriptions" json:"subscriptions"`
+}
+
+// ScheduledExport represents a subscriber export that runs periodically
+// and writes a file to the media store.
+type ScheduledExport struct {
+	Enabled bool   `json:"enabled"`
+	Name    string `json:"name"`
+	Cron    string `json:"cron"`
+	Format  string `json:"format"`
+
+	// Filters.
+	ListIDs   []int  `json:"list_ids"`
+	SubStatus string `json:"subscription_status"`
+	Query     string `json:"query"`
+
+	// Columns to export and attribute keys to flatten into columns.
+	Columns []string `json:"columns"`
+	Attribs []string `json:"attribs"`
+}
+
+// ScheduledExportFile represents a file written by a scheduled export.
+type ScheduledExportFile struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	RunAt     time.Time `db:"run_at" json:"run_at"`
+	Filename  string    `db:"filename" json:"filename"`
+	Size      int64     `db:"size" json:"size"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
 
 // SubscriberExportProfile represents a subscriber's collated data in JSON for export.
@@ -129,8 +169,12 @@ type SubscriberExportProfile struct {
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks,omitempty"`
 }
 
-// SubscriberActivity represents a subscriber's campaign views and link clicks for the Activity tab.
+// SubscriberActivity represents a subscriber's campaign views, link clicks, and
+// subscription history for the Activity tab.
 type SubscriberActivity struct {
 	CampaignViews json.RawMessage `db:"campaign_views" json:"campaign_views"`
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks"`
+
+	// Subscribe, confirm, and unsubscribe events from the subscription history.
+	SubscriptionEvents json.RawMessage `db:"subscription_events" json:"subscription_events"`
 }
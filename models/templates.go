@@ -7,6 +7,7 @@ import (
 	txttpl "text/template"
 	"time"
 
+	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
 
@@ -30,6 +31,9 @@ type Template struct {
 	BodySource null.String `db:"body_source" json:"body_source,omitempty"`
 	IsDefault  bool        `db:"is_default" json:"is_default"`
 
+	// Security is the signing and encryption policy of messages sent with the template.
+	Security string `db:"security" json:"security"`
+
 	// Only relevant to tx (transactional) templates.
 	SubjectTpl *txttpl.Template   `json:"-"`
 	Tpl        *template.Template `json:"-"`
@@ -38,7 +42,13 @@ type Template struct {
 // Compile compiles a template body and subject (only for tx templates) and
 // caches the templat references to be executed later.
 func (t *Template) Compile(f template.FuncMap) error {
-	tpl, err := template.New(BaseTpl).Funcs(f).Parse(t.Body)
+	// Expand the {{ TrackLink "url" }}, url@TrackLink, and {{ TrackView }} shorthands.
+	body := t.Body
+	for _, r := range regTplFuncs {
+		body = r.regExp.ReplaceAllString(body, r.replace)
+	}
+
+	tpl, err := template.New(BaseTpl).Funcs(f).Parse(body)
 	if err != nil {
 		return fmt.Errorf("error compiling transactional template: %v", err)
 	}
@@ -79,3 +89,62 @@ type CampaignAnalyticsLink struct {
 	URL   string `db:"url" json:"url"`
 	Count int    `db:"count" json:"count"`
 }
+
+// CampaignLinkStats represents the click stats of a single link in a campaign.
+type CampaignLinkStats struct {
+	URL          string `db:"url" json:"url"`
+	Clicks       int    `db:"clicks" json:"clicks"`
+	UniqueClicks int    `db:"unique_clicks" json:"unique_clicks"`
+
+	// Positions are the 1-based positions of the link's occurrences
+	// among all the links in the rendered campaign body.
+	Positions []int `db:"-" json:"positions"`
+
+	// Tracked indicates whether the link in the body is a {{ TrackLink }}.
+	Tracked bool `db:"-" json:"tracked"`
+
+	// CTR is the percentage of sent messages that resulted in a click.
+	CTR float64 `db:"-" json:"ctr"`
+}
+
+// CampaignLinkReport represents the per-link click report of a campaign.
+type CampaignLinkReport struct {
+	CampaignID int                 `json:"campaign_id"`
+	Sent       int                 `json:"sent"`
+	Links      []CampaignLinkStats `json:"links"`
+}
+
+// CampaignAnalyticsSummary represents the aggregate analytics of a campaign for export.
+type CampaignAnalyticsSummary struct {
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	Name         string    `db:"name" json:"name"`
+	Subject      string    `db:"subject" json:"subject"`
+	Status       string    `db:"status" json:"status"`
+	CreatedAt    null.Time `db:"created_at" json:"created_at"`
+	StartedAt    null.Time `db:"started_at" json:"started_at"`
+	ToSend       int       `db:"to_send" json:"to_send"`
+	Sent         int       `db:"sent" json:"sent"`
+	Views        int       `db:"views" json:"views"`
+	UniqueViews  int       `db:"unique_views" json:"unique_views"`
+	Clicks       int       `db:"clicks" json:"clicks"`
+	UniqueClicks int       `db:"unique_clicks" json:"unique_clicks"`
+	Bounces      int       `db:"bounces" json:"bounces"`
+	Unsubscribes int       `db:"unsubscribes" json:"unsubscribes"`
+}
+
+// CampaignAnalyticsExport represents a subscriber's analytics row in a campaign for export.
+type CampaignAnalyticsExport struct {
+	CampaignID     int            `db:"campaign_id" json:"campaign_id"`
+	SubscriberID   int            `db:"subscriber_id" json:"subscriber_id"`
+	SubscriberUUID string         `db:"subscriber_uuid" json:"subscriber_uuid"`
+	Email          string         `db:"email" json:"email"`
+	Name           string         `db:"name" json:"name"`
+	Sent           bool           `db:"sent" json:"sent"`
+	Views          int            `db:"views" json:"views"`
+	FirstViewedAt  null.Time      `db:"first_viewed_at" json:"first_viewed_at"`
+	LastViewedAt   null.Time      `db:"last_viewed_at" json:"last_viewed_at"`
+	Clicks         int            `db:"clicks" json:"clicks"`
+	ClickedLinks   pq.StringArray `db:"clicked_links" json:"clicked_links"`
+	BounceType     null.String    `db:"bounce_type" json:"bounce_type"`
+	Unsubscribed   bool           `db:"unsubscribed" json:"unsubscribed"`
+}
@@ -0,0 +1,21 @@
+-- name: insert-audit-log
+INSERT INTO audit_logs (user_id, username, action, target_type, target_id, route, diff, ip)
+    VALUES(NULLIF($1, 0), $2, $3, $4, NULLIF($5, 0), $6, $7, $8);
+
+-- name: query-audit-logs
+-- Retrieves paginated audit log entries filtered by user ($1), action ($2),
+-- target type ($3), target ID ($4), and the optional $5 - $6 date range.
+SELECT COUNT(*) OVER () AS total, id, user_id, username, action, target_type,
+    target_id, route, diff, ip, created_at
+FROM audit_logs
+WHERE ($1 = 0 OR user_id = $1)
+    AND ($2 = '' OR action = $2)
+    AND ($3 = '' OR target_type = $3)
+    AND ($4 = 0 OR target_id = $4)
+    AND ($5::TIMESTAMP WITH TIME ZONE IS NULL OR created_at >= $5)
+    AND ($6::TIMESTAMP WITH TIME ZONE IS NULL OR created_at < $6)
+ORDER BY created_at DESC, id DESC OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
+
+-- name: delete-audit-logs
+-- Deletes audit log entries older than $1 days.
+DELETE FROM audit_logs WHERE created_at < NOW() - MAKE_INTERVAL(days => $1);
@@ -18,6 +18,12 @@ block1 AS (
 block2 AS (
     UPDATE subscriber_lists SET status='unsubscribed'
     WHERE $9 = 'unsubscribe' AND (SELECT num FROM num) >= $8 AND subscriber_id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+        AND status != 'unsubscribed'
+    RETURNING subscriber_id, list_id
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', 'bounce' FROM block2
 ),
 bounce AS (
     -- Record the bounce if the subscriber is not already blocklisted;
@@ -70,6 +76,11 @@ WITH subs AS (
 b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
     WHERE id = ANY(SELECT subscriber_id FROM subs)
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', 'bounce' FROM subscriber_lists
+    WHERE subscriber_id = ANY(SELECT subscriber_id FROM subs) AND status != 'unsubscribed'
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = ANY(SELECT subscriber_id FROM subs);
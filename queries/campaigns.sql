@@ -25,7 +25,7 @@ WITH tpl AS (
 camp AS (
     INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody,
         content_type, send_at, headers, attribs, tags, messenger, template_id, to_send,
-        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source)
+        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source, sub_attachments, security)
         SELECT $1, $2, $3, $4, $5,
             -- body
             COALESCE(NULLIF($6, ''), (SELECT body FROM tpl), ''),
@@ -40,7 +40,8 @@ camp AS (
             $18,
             $19,
             -- body_source
-            COALESCE($21, (SELECT body_source FROM tpl))
+            COALESCE($21, (SELECT body_source FROM tpl)),
+            $22, $23
         RETURNING id
 ),
 med AS (
@@ -150,7 +151,7 @@ LEFT JOIN bounces AS b ON (b.campaign_id = id)
 ORDER BY ARRAY_POSITION($1, id);
 
 -- name: get-campaign-for-preview
-SELECT campaigns.*, COALESCE(templates.body, '') AS template_body,
+SELECT campaigns.*, COALESCE(templates.body, '') AS template_body, COALESCE(templates.security, '') AS template_security,
 (
 	SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
 		SELECT COALESCE(campaign_lists.list_id, 0) AS id,
@@ -180,7 +181,8 @@ SELECT EXISTS (
 -- a campaign. This is used to fetch and slice subscribers for the campaign in next-campaign-subscribers.
 WITH camps AS (
     -- Get all running campaigns and their template bodies (if the template's deleted, the default template body instead)
-    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+        COALESCE(templates.security, '') AS template_security
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
@@ -275,6 +277,125 @@ SELECT COUNT(%s) AS "count", url
     WHERE campaign_id=ANY($1) AND link_clicks.created_at >= $2 AND link_clicks.created_at <= $3
     GROUP BY links.url ORDER BY "count" DESC LIMIT 50;
 
+-- name: get-campaign-link-stats
+-- Returns the total and unique click counts of every link clicked in a campaign.
+-- Unique counts are only meaningful when individual subscriber tracking is enabled.
+SELECT links.url, COUNT(*) AS clicks, COUNT(DISTINCT link_clicks.subscriber_id) AS unique_clicks
+    FROM link_clicks
+    JOIN links ON (links.id = link_clicks.link_id)
+    WHERE link_clicks.campaign_id = $1
+    GROUP BY links.url ORDER BY clicks DESC;
+
+-- name: get-campaign-analytics-summary
+-- Returns aggregate analytics of campaigns, either the ones given in $1, or the ones
+-- created within the $2 - $3 date range. $4 and $5 filter campaigns by list permissions.
+-- Unsubscribes are approximated as subscriptions to the campaign's lists that were
+-- unsubscribed after the campaign started.
+WITH camps AS (
+    SELECT * FROM campaigns c
+    WHERE (CASE WHEN CARDINALITY($1::INT[]) > 0 THEN id = ANY($1::INT[])
+        ELSE created_at >= $2::TIMESTAMP AND created_at <= $3::TIMESTAMP END)
+    AND (
+        $4 OR EXISTS (
+            SELECT 1 FROM campaign_lists WHERE campaign_id = c.id AND list_id = ANY($5::INT[])
+        )
+    )
+),
+views AS (
+    SELECT campaign_id, COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM campaign_views
+    WHERE campaign_id = ANY(SELECT id FROM camps) GROUP BY campaign_id
+),
+clicks AS (
+    SELECT campaign_id, COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM link_clicks
+    WHERE campaign_id = ANY(SELECT id FROM camps) GROUP BY campaign_id
+),
+bounces AS (
+    SELECT campaign_id, COUNT(*) AS num FROM bounces
+    WHERE campaign_id = ANY(SELECT id FROM camps) GROUP BY campaign_id
+),
+unsubs AS (
+    SELECT camps.id AS campaign_id, COUNT(DISTINCT subscriber_lists.subscriber_id) AS num FROM camps
+    JOIN campaign_lists ON (campaign_lists.campaign_id = camps.id)
+    JOIN subscriber_lists ON (subscriber_lists.list_id = campaign_lists.list_id)
+    WHERE camps.started_at IS NOT NULL AND subscriber_lists.status = 'unsubscribed'
+        AND subscriber_lists.updated_at >= camps.started_at
+        AND subscriber_lists.subscriber_id <= camps.last_subscriber_id
+    GROUP BY camps.id
+)
+SELECT camps.id AS campaign_id, camps.name, camps.subject, camps.status,
+    camps.created_at, camps.started_at, camps.to_send, camps.sent,
+    COALESCE(v.num, 0) AS views, COALESCE(v.uniq, 0) AS unique_views,
+    COALESCE(c.num, 0) AS clicks, COALESCE(c.uniq, 0) AS unique_clicks,
+    COALESCE(b.num, 0) AS bounces, COALESCE(u.num, 0) AS unsubscribes
+FROM camps
+LEFT JOIN views v ON (v.campaign_id = camps.id)
+LEFT JOIN clicks c ON (c.campaign_id = camps.id)
+LEFT JOIN bounces b ON (b.campaign_id = camps.id)
+LEFT JOIN unsubs u ON (u.campaign_id = camps.id)
+ORDER BY camps.id;
+
+-- name: export-campaign-subscriber-analytics
+-- Returns a batch ($3) of per-subscriber analytics rows of a campaign ($1) for subscribers
+-- in the campaign's lists after the subscriber ID $2. Individual campaign messages aren't logged,
+-- so whether a subscriber was sent the campaign is derived like next-campaign-subscribers picks
+-- subscribers: they're up to the campaign's checkpoint (last_subscriber_id) and have a subscription
+-- to one of its lists that existed when the campaign started with a status that qualifies, or
+-- that was unsubscribed after it started. Views and clicks are only recorded against subscribers
+-- when individual subscriber tracking is enabled.
+WITH camp AS (
+    SELECT id, type, started_at, last_subscriber_id, max_subscriber_id FROM campaigns WHERE id = $1
+),
+campLists AS (
+    SELECT list_id FROM campaign_lists WHERE campaign_id = $1 AND list_id IS NOT NULL
+),
+subs AS (
+    SELECT DISTINCT subscriber_id AS id FROM subscriber_lists
+    WHERE list_id = ANY(SELECT list_id FROM campLists)
+        AND subscriber_id > $2 AND subscriber_id <= (SELECT max_subscriber_id FROM camp)
+    ORDER BY subscriber_id LIMIT $3
+)
+SELECT $1::INT AS campaign_id, subscribers.id AS subscriber_id, subscribers.uuid AS subscriber_uuid,
+    subscribers.email, subscribers.name,
+    (subscribers.id <= (SELECT last_subscriber_id FROM camp) AND EXISTS (
+        SELECT 1 FROM subscriber_lists sl
+        JOIN lists ON (lists.id = sl.list_id)
+        CROSS JOIN camp
+        WHERE sl.subscriber_id = subscribers.id AND sl.list_id = ANY(SELECT list_id FROM campLists)
+            AND sl.created_at <= camp.started_at
+            AND (
+                (sl.status = 'unsubscribed' AND sl.updated_at >= camp.started_at)
+                OR (camp.type = 'optin' AND sl.status = 'unconfirmed' AND lists.optin = 'double')
+                OR (camp.type != 'optin' AND (
+                    (lists.optin = 'double' AND sl.status = 'confirmed') OR
+                    (lists.optin != 'double' AND sl.status != 'unsubscribed')
+                ))
+            )
+    )) AS sent,
+    COALESCE(v.num, 0) AS views, v.first_at AS first_viewed_at, v.last_at AS last_viewed_at,
+    COALESCE(cl.num, 0) AS clicks, COALESCE(cl.urls, '{}') AS clicked_links,
+    b.type AS bounce_type,
+    EXISTS (
+        SELECT 1 FROM subscriber_lists WHERE subscriber_id = subscribers.id
+            AND list_id = ANY(SELECT list_id FROM campLists) AND status = 'unsubscribed'
+            AND updated_at >= (SELECT started_at FROM camp)
+    ) AS unsubscribed
+FROM subs
+JOIN subscribers ON (subscribers.id = subs.id)
+LEFT JOIN LATERAL (
+    SELECT COUNT(*) AS num, MIN(created_at) AS first_at, MAX(created_at) AS last_at FROM campaign_views
+    WHERE campaign_id = $1 AND subscriber_id = subs.id
+) v ON TRUE
+LEFT JOIN LATERAL (
+    SELECT COUNT(*) AS num, ARRAY_AGG(DISTINCT links.url) AS urls FROM link_clicks
+    JOIN links ON (links.id = link_clicks.link_id)
+    WHERE campaign_id = $1 AND subscriber_id = subs.id
+) cl ON TRUE
+LEFT JOIN LATERAL (
+    SELECT type FROM bounces WHERE campaign_id = $1 AND subscriber_id = subs.id
+    ORDER BY created_at DESC LIMIT 1
+) b ON TRUE
+ORDER BY subscribers.id;
+
 -- name: get-running-campaign
 -- Returns the metadata for a running campaign that is required by next-campaign-subscribers to retrieve
 -- a batch of campaign subscribers for processing.
@@ -381,6 +502,8 @@ WITH camp AS (
         archive_template_id=(CASE WHEN $7::content_type = 'visual' THEN NULL ELSE $17::INT END),
         archive_meta=$18,
         body_source=$20,
+        sub_attachments=$21,
+        security=$22,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
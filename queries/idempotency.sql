@@ -0,0 +1,27 @@
+-- name: create-idempotency-key
+-- Creates an in-progress idempotency key ($1) with the hash of its request ($2) that
+-- expires after a short lease of $3 seconds so that a key that's abandoned by a
+-- crashed request can be retried. An existing key is only replaced if it has expired.
+-- Returns no rows if the (unexpired) key exists.
+INSERT INTO idempotency_keys (key, request_hash, expires_at)
+    VALUES($1, $2, NOW() + MAKE_INTERVAL(secs => $3))
+    ON CONFLICT (key) DO UPDATE SET request_hash=EXCLUDED.request_hash, status_code=0, content_type='',
+        response=NULL, created_at=NOW(), expires_at=EXCLUDED.expires_at
+    WHERE idempotency_keys.expires_at < NOW()
+RETURNING key;
+
+-- name: get-idempotency-key
+SELECT * FROM idempotency_keys WHERE key=$1;
+
+-- name: update-idempotency-key
+-- Stores the response of the request of an idempotency key and extends its expiry
+-- to the TTL of $5 seconds.
+UPDATE idempotency_keys SET status_code=$2, content_type=$3, response=$4,
+    expires_at=NOW() + MAKE_INTERVAL(secs => $5)
+    WHERE key=$1;
+
+-- name: delete-idempotency-key
+DELETE FROM idempotency_keys WHERE key=$1;
+
+-- name: delete-expired-idempotency-keys
+DELETE FROM idempotency_keys WHERE expires_at < NOW();
@@ -0,0 +1,141 @@
+-- name: create-import-job
+INSERT INTO import_jobs (name, mode, status, options, user_id)
+    VALUES($1, $2, $3, $4, NULLIF($5, 0)) RETURNING id;
+
+-- name: update-import-job
+-- Updates the status and counts of an import job. The log ($6) is only updated if it's not null.
+UPDATE import_jobs SET
+    status=$2,
+    total=$3,
+    imported=$4,
+    report=$5,
+    log=COALESCE($6, log),
+    rejects_file=$7,
+    heartbeat_at=NOW(),
+    started_at=(CASE WHEN $2 = 'importing' THEN COALESCE(started_at, NOW()) ELSE started_at END),
+    finished_at=(CASE WHEN $2 IN ('finished', 'failed', 'cancelled') THEN NOW() ELSE NULL END)
+WHERE id=$1;
+
+-- name: query-import-jobs
+-- Retrieves paginated import jobs (without logs) optionally filtered by status ($1).
+SELECT COUNT(*) OVER () AS job_count, j.id, j.name, j.mode, j.status, j.options, j.total, j.imported,
+    j.report, '' AS log, j.rejects_file, j.user_id, COALESCE(u.username, '') AS username,
+    j.created_at, j.started_at, j.finished_at
+FROM import_jobs j
+LEFT JOIN users u ON (u.id = j.user_id)
+WHERE ($1 = '' OR j.status = $1)
+ORDER BY j.id DESC OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
+
+-- name: get-import-job
+SELECT 0 AS job_count, j.*, COALESCE(u.username, '') AS username
+FROM import_jobs j
+LEFT JOIN users u ON (u.id = j.user_id)
+WHERE j.id = $1;
+
+-- name: touch-import-jobs
+-- Refreshes the heartbeat of the queued and running import jobs ($1) of an instance.
+UPDATE import_jobs SET heartbeat_at=NOW() WHERE id = ANY($1::INT[]);
+
+-- name: fail-interrupted-import-jobs
+-- Marks queued and running import jobs whose heartbeats are older than $1 seconds as failed.
+-- Their instances have stopped, as jobs can't be resumed, or lost connection to the DB.
+UPDATE import_jobs SET status='failed', finished_at=NOW(),
+    log=log || 'import interrupted by app restart' || E'\n'
+WHERE status IN ('queued', 'importing', 'stopping') AND heartbeat_at < NOW() - MAKE_INTERVAL(secs => $1);
+
+-- name: delete-import-jobs
+-- Deletes finished import jobs older than $1 days and returns their rejects files.
+DELETE FROM import_jobs
+WHERE status NOT IN ('queued', 'importing', 'stopping') AND created_at < NOW() - MAKE_INTERVAL(days => $1)
+RETURNING rejects_file;
+
+-- name: create-import-staging
+-- Creates the temporary staging table that a batch of records in a COPY import
+-- are streamed into. It's dropped when the batch's transaction is committed.
+CREATE TEMP TABLE import_staging (
+    seq     INT NOT NULL,
+    uuid    UUID NOT NULL,
+    email   TEXT NOT NULL,
+    name    TEXT NOT NULL,
+    attribs JSONB NOT NULL DEFAULT '{}',
+    lists   INT[] NOT NULL DEFAULT '{}',
+    -- Optional per-record subscription status and blocklisting in ESP (preset) imports.
+    sub_status TEXT NOT NULL DEFAULT '',
+    blocklist  BOOL NOT NULL DEFAULT false
+) ON COMMIT DROP;
+
+-- name: upsert-import-staging
+-- Upserts the subscribers in the staging table and their subscriptions to the lists in $1
+-- and their per-record lists. This is the set-based equivalent of upsert-subscriber.
+-- $2 is the subscription status, and if $3 = true, name/attribs are updated, and if $4 = true,
+-- subscription statuses are updated. $5 is the strategy for combining attribs.
+-- Records with the same e-mail are collapsed to the last one. Records marked for blocklisting
+-- are skipped and are blocklisted with blocklist-import-staging.
+WITH rows AS (
+    SELECT DISTINCT ON (LOWER(email)) uuid, email, name, attribs, lists, sub_status, blocklist
+    FROM import_staging ORDER BY LOWER(email), seq DESC
+),
+sub AS (
+    INSERT INTO subscribers as s (uuid, email, name, attribs, status)
+    SELECT uuid, email, name, attribs, 'enabled' FROM rows WHERE NOT blocklist
+    ON CONFLICT (email)
+    DO UPDATE SET
+        name=(CASE WHEN $3 THEN EXCLUDED.name ELSE s.name END),
+        attribs=(CASE WHEN NOT $3 THEN s.attribs
+            WHEN $5::TEXT = 'merge' THEN s.attribs || EXCLUDED.attribs
+            WHEN $5::TEXT = 'deep_merge' THEN jsonb_deep_merge(s.attribs, EXCLUDED.attribs)
+            ELSE EXCLUDED.attribs END),
+        updated_at=NOW()
+    RETURNING id, email, status
+),
+prev AS (
+    -- Subscription statuses before the upsert for recording subscription events.
+    SELECT subscriber_id, list_id, status FROM subscriber_lists WHERE subscriber_id IN (SELECT id FROM sub)
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    SELECT DISTINCT ON (sub.id, listID) sub.id, listID,
+        CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed'
+            ELSE COALESCE(NULLIF(rows.sub_status, ''), $2)::subscription_status END
+    FROM sub
+    JOIN rows ON (rows.email = sub.email)
+    CROSS JOIN UNNEST($1::INT[] || rows.lists) AS listID
+    -- Per-record list IDs in imported files may not exist.
+    WHERE listID IN (SELECT id FROM lists)
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE
+    SET updated_at = NOW(),
+        status = CASE WHEN $4 THEN EXCLUDED.status ELSE subscriber_lists.status END
+    RETURNING subscriber_id, list_id, status
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subs.subscriber_id, subs.list_id, subscription_event_type(prev.status, subs.status), 'import'
+    FROM subs LEFT JOIN prev ON (prev.subscriber_id = subs.subscriber_id AND prev.list_id = subs.list_id)
+    WHERE subscription_event_type(prev.status, subs.status) IS NOT NULL
+)
+SELECT COUNT(*) FROM sub;
+
+-- name: blocklist-import-staging
+-- Blocklists the subscribers in the staging table and unsubscribes them from all lists.
+-- If $1 = false, only the records marked for blocklisting are blocklisted.
+-- This is the set-based equivalent of upsert-blocklist-subscriber.
+WITH rows AS (
+    SELECT DISTINCT ON (LOWER(email)) uuid, email, name, attribs, blocklist
+    FROM import_staging ORDER BY LOWER(email), seq DESC
+),
+sub AS (
+    INSERT INTO subscribers (uuid, email, name, attribs, status)
+    SELECT uuid, email, name, attribs, 'blocklisted' FROM rows WHERE $1 OR blocklist
+    ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
+    RETURNING id
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', 'import' FROM subscriber_lists
+    WHERE subscriber_id IN (SELECT id FROM sub) AND status != 'unsubscribed'
+),
+unsubs AS (
+    UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE subscriber_id IN (SELECT id FROM sub)
+)
+SELECT COUNT(*) FROM sub;
@@ -45,6 +45,10 @@ SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRU
           WHEN $3::UUID[] IS NOT NULL THEN uuid = ANY($3::UUID[])
     END) ORDER BY name;
 
+-- name: get-lists-by-names
+-- Retrieves lists by their names (case insensitive). $1 should be lowercase names.
+SELECT * FROM lists WHERE LOWER(name) = ANY($1::TEXT[]) ORDER BY id;
+
 -- name: get-list-types
 -- Retrieves the private|public type of lists by ID or uuid. Used for filtering.
 SELECT id, uuid, type FROM lists WHERE
@@ -1,20 +1,74 @@
 -- media
 -- name: insert-media
-INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, created_at) VALUES($1, $2, $3, $4, $5, $6, NOW()) RETURNING id;
+INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, folder, tags, hash, size, user_id, created_at)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW()) RETURNING id;
 
 -- name: query-media
+-- $3 folder is NULL for all folders. $4 tags must all match. $5 is a content type prefix, eg: image/.
 SELECT COUNT(*) OVER () AS total, * FROM media
-    WHERE ($1 = '' OR filename ILIKE $1) AND provider=$2 ORDER BY created_at DESC OFFSET $3 LIMIT $4;
+    WHERE ($1 = '' OR filename ILIKE $1 OR EXISTS (SELECT 1 FROM UNNEST(aliases) a WHERE a ILIKE $1)) AND provider=$2
+    AND ($3::TEXT IS NULL OR folder = $3)
+    AND (CARDINALITY($4::VARCHAR(100)[]) = 0 OR tags @> $4)
+    AND ($5 = '' OR content_type ILIKE $5 || '%')
+    AND ($6::TIMESTAMP WITH TIME ZONE IS NULL OR created_at >= $6)
+    AND ($7::TIMESTAMP WITH TIME ZONE IS NULL OR created_at <= $7)
+    ORDER BY created_at DESC OFFSET $8 LIMIT $9;
 
 -- name: get-media
 SELECT * FROM media WHERE
     CASE
         WHEN $1 > 0 THEN id = $1
         WHEN $2 != '' THEN uuid = $2::UUID
-        WHEN $3 != '' THEN filename = $3    
+        WHEN $3 != '' THEN (filename = $3 OR $3 = ANY(aliases))
         ELSE false
-    END;
+    END
+    ORDER BY (filename = $3) DESC LIMIT 1;
+
+-- name: get-media-by-hash
+SELECT * FROM media WHERE provider=$1 AND hash=$2 ORDER BY id LIMIT 1;
+
+-- name: add-media-alias
+-- Records an alias (another name the media was uploaded as) if it's not the filename or an existing alias.
+UPDATE media SET aliases = ARRAY_APPEND(aliases, $2::TEXT)
+    WHERE id=$1 AND filename != $2 AND NOT ($2 = ANY(aliases));
+
+-- name: get-media-storage
+-- Total size and number of media files of a provider, and the ones uploaded by the user $2.
+SELECT COALESCE(SUM(size), 0) AS size, COUNT(*) AS files,
+    COALESCE(SUM(size) FILTER (WHERE user_id = $2), 0) AS user_size,
+    COUNT(*) FILTER (WHERE user_id = $2) AS user_files
+    FROM media WHERE provider=$1;
+
+-- name: get-media-user-storage
+-- Size and number of media files of a provider by user.
+SELECT m.user_id, COALESCE(u.username, '') AS username, SUM(m.size) AS size, COUNT(*) AS files
+    FROM media m LEFT JOIN users u ON u.id = m.user_id
+    WHERE m.provider=$1
+    GROUP BY m.user_id, u.username ORDER BY size DESC;
+
+-- name: update-media
+UPDATE media SET folder=$2, tags=$3 WHERE id=$1;
+
+-- name: get-media-folders
+SELECT folder, COUNT(*) AS count FROM media WHERE provider=$1 GROUP BY folder ORDER BY folder;
+
+-- name: get-media-usage
+-- Campaigns (archive = their public archive pages) and templates that reference a media item, either
+-- as a campaign attachment, or by its filename in their bodies, which also matches the URLs
+-- of its thumbnail and renditions, and {{ MediaURL "filename" }}.
+WITH m AS (SELECT id, filename FROM media WHERE id = $1),
+att AS (SELECT campaign_id FROM campaign_media WHERE media_id = (SELECT id FROM m))
+SELECT 'campaign' AS type, c.id, c.name, c.status::TEXT AS status, c.archive,
+    (CASE WHEN c.id IN (SELECT campaign_id FROM att) THEN 'attachment' ELSE 'url' END) AS via
+    FROM campaigns c, m
+    WHERE c.id IN (SELECT campaign_id FROM att)
+        OR STRPOS(c.body, m.filename) > 0
+        OR STRPOS(COALESCE(c.altbody, ''), m.filename) > 0
+UNION ALL
+SELECT 'template' AS type, t.id, t.name, '' AS status, false AS archive, 'url' AS via
+    FROM templates t, m
+    WHERE STRPOS(t.body, m.filename) > 0 OR STRPOS(t.subject, m.filename) > 0
+ORDER BY type, id;
 
 -- name: delete-media
 DELETE FROM media WHERE id=$1 RETURNING filename;
-
@@ -4,6 +4,123 @@ SELECT data FROM mat_dashboard_charts;
 -- name: get-dashboard-counts
 SELECT data FROM mat_dashboard_counts;
 
+-- name: get-digest-report
+-- Returns the data for the periodic admin digest report for the $1 - $2 period as JSON.
+-- If $3 is true, unique views and clicks per subscriber are counted (individual tracking).
+-- $4 is the max number of campaigns, lists, and domains to return.
+WITH camps AS (
+    SELECT id, name, type, sent, started_at, last_subscriber_id FROM campaigns
+    WHERE started_at >= $1 AND started_at < $2 AND sent > 0
+),
+campStats AS (
+    SELECT camps.id, camps.name, camps.sent,
+        (SELECT CASE WHEN $3 THEN COUNT(DISTINCT subscriber_id) ELSE COUNT(*) END
+            FROM campaign_views WHERE campaign_id = camps.id) AS views,
+        (SELECT CASE WHEN $3 THEN COUNT(DISTINCT subscriber_id) ELSE COUNT(*) END
+            FROM link_clicks WHERE campaign_id = camps.id) AS clicks
+    FROM camps
+),
+sends AS (
+    -- Individual campaign messages aren't logged, so the recipients of the campaigns
+    -- are derived like next-campaign-subscribers picks them: subscribers in the
+    -- campaign's lists up to its checkpoint (last_subscriber_id) who were
+    -- subscribed when it started and whose subscription status qualifies, or who
+    -- unsubscribed after it started. See export-campaign-subscriber-analytics.
+    SELECT DISTINCT camps.id AS campaign_id, subscriber_lists.subscriber_id
+    FROM camps
+    JOIN campaign_lists ON (campaign_lists.campaign_id = camps.id)
+    JOIN lists ON (lists.id = campaign_lists.list_id)
+    JOIN subscriber_lists ON (subscriber_lists.list_id = campaign_lists.list_id)
+    WHERE subscriber_lists.subscriber_id <= camps.last_subscriber_id
+        AND subscriber_lists.created_at <= camps.started_at
+        AND (
+            (subscriber_lists.status = 'unsubscribed' AND subscriber_lists.updated_at >= camps.started_at)
+            OR (camps.type = 'optin' AND subscriber_lists.status = 'unconfirmed' AND lists.optin = 'double')
+            OR (camps.type != 'optin' AND (
+                (lists.optin = 'double' AND subscriber_lists.status = 'confirmed') OR
+                (lists.optin != 'double' AND subscriber_lists.status != 'unsubscribed')
+            ))
+        )
+),
+bnc AS (
+    SELECT bounces.type, bounces.subscriber_id FROM bounces
+    WHERE bounces.created_at >= $1 AND bounces.created_at < $2
+),
+events AS (
+    SELECT list_id,
+        COUNT(*) FILTER (WHERE event = 'subscribe') AS subscribed,
+        COUNT(*) FILTER (WHERE event = 'unsubscribe') AS unsubscribed
+    FROM subscription_events
+    WHERE created_at >= $1 AND created_at < $2
+    GROUP BY list_id
+),
+lists AS (
+    SELECT lists.id, lists.name,
+        COALESCE(MAX(events.subscribed), 0) AS subscribed,
+        COALESCE(MAX(events.unsubscribed), 0) AS unsubscribed,
+        COUNT(*) FILTER (WHERE subscriber_lists.status != 'unsubscribed') AS total
+    FROM lists
+    JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
+    LEFT JOIN events ON (events.list_id = lists.id)
+    WHERE lists.status = 'active'
+    GROUP BY lists.id
+),
+domainSends AS (
+    SELECT SPLIT_PART(subscribers.email, '@', 2) AS domain, COUNT(*) AS sent
+    FROM sends
+    JOIN subscribers ON (subscribers.id = sends.subscriber_id)
+    GROUP BY domain
+),
+domainBounces AS (
+    SELECT SPLIT_PART(subscribers.email, '@', 2) AS domain,
+        COUNT(*) FILTER (WHERE bnc.type != 'complaint') AS bounces,
+        COUNT(*) FILTER (WHERE bnc.type = 'hard') AS hard,
+        COUNT(*) FILTER (WHERE bnc.type = 'soft') AS soft,
+        COUNT(*) FILTER (WHERE bnc.type = 'complaint') AS complaint
+    FROM bnc
+    JOIN subscribers ON (subscribers.id = bnc.subscriber_id)
+    GROUP BY domain
+),
+domains AS (
+    -- Domains with the most bounces and complaints followed by the ones with
+    -- the most messages sent.
+    SELECT COALESCE(s.domain, b.domain) AS domain,
+        COALESCE(s.sent, 0) AS sent,
+        COALESCE(b.bounces, 0) AS bounces,
+        COALESCE(b.hard, 0) AS hard,
+        COALESCE(b.soft, 0) AS soft,
+        COALESCE(b.complaint, 0) AS complaint
+    FROM domainSends s
+    FULL OUTER JOIN domainBounces b ON (b.domain = s.domain)
+    ORDER BY COALESCE(b.bounces, 0) + COALESCE(b.complaint, 0) DESC, sent DESC, domain LIMIT $4
+)
+SELECT JSON_BUILD_OBJECT(
+    'from', $1::TIMESTAMP WITH TIME ZONE,
+    'to', $2::TIMESTAMP WITH TIME ZONE,
+    'subscribers', JSON_BUILD_OBJECT(
+        'total', (SELECT COUNT(*) FROM subscribers),
+        'new', (SELECT COUNT(*) FROM subscribers WHERE created_at >= $1 AND created_at < $2),
+        'blocklisted', (SELECT COUNT(*) FROM subscribers WHERE status = 'blocklisted' AND updated_at >= $1 AND updated_at < $2),
+        'unsubscribed', (SELECT COUNT(*) FROM subscriber_lists WHERE status = 'unsubscribed' AND updated_at >= $1 AND updated_at < $2)
+    ),
+    'messages', JSON_BUILD_OBJECT(
+        'campaigns', (SELECT COUNT(*) FROM camps),
+        'sent', (SELECT COALESCE(SUM(sent), 0) FROM camps),
+        'bounces', (SELECT COUNT(*) FROM bnc WHERE type != 'complaint'),
+        'complaints', (SELECT COUNT(*) FROM bnc WHERE type = 'complaint')
+    ),
+    'lists', COALESCE((SELECT JSON_AGG(l) FROM (
+        SELECT * FROM lists ORDER BY subscribed DESC, id LIMIT $4
+    ) l), '[]'),
+    'top_opened', COALESCE((SELECT JSON_AGG(c) FROM (
+        SELECT * FROM campStats ORDER BY views::FLOAT / sent DESC, id LIMIT $4
+    ) c), '[]'),
+    'top_clicked', COALESCE((SELECT JSON_AGG(c) FROM (
+        SELECT * FROM campStats ORDER BY clicks::FLOAT / sent DESC, id LIMIT $4
+    ) c), '[]'),
+    'domains', COALESCE((SELECT JSON_AGG(d) FROM domains d), '[]')
+) AS data;
+
 -- name: get-settings
 SELECT JSON_OBJECT_AGG(key, value) AS settings FROM (SELECT * FROM settings ORDER BY key) t;
 
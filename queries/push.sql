@@ -0,0 +1,17 @@
+-- name: upsert-push-subscription
+-- Adds (or updates) a web push subscription (endpoint) of a subscriber by their UUID ($1).
+-- Returns no rows if the subscriber doesn't exist or is blocklisted.
+INSERT INTO push_subscriptions (subscriber_id, endpoint, key_p256dh, key_auth)
+    SELECT id, $2, $3, $4 FROM subscribers WHERE uuid = $1 AND status != 'blocklisted'
+    ON CONFLICT (endpoint) DO UPDATE SET subscriber_id=EXCLUDED.subscriber_id,
+        key_p256dh=EXCLUDED.key_p256dh, key_auth=EXCLUDED.key_auth
+RETURNING id;
+
+-- name: get-push-subscriptions
+SELECT * FROM push_subscriptions WHERE subscriber_id=$1 ORDER BY id;
+
+-- name: delete-push-subscription
+-- Deletes a push subscription by its endpoint ($1), optionally only if it
+-- belongs to the subscriber with the given UUID ($2).
+DELETE FROM push_subscriptions WHERE endpoint=$1
+    AND ($2 = '' OR subscriber_id = (SELECT id FROM subscribers WHERE uuid::TEXT = $2));
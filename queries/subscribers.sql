@@ -22,6 +22,10 @@ FROM subscribers s WHERE s.id = ANY($1);
 -- Get subscribers by emails.
 SELECT * FROM subscribers WHERE email=ANY($1);
 
+-- name: get-subscriber-emails
+-- Get the (lowercased) e-mails of the given e-mails that exist in the DB. Used in import dry runs.
+SELECT LOWER(email) FROM subscribers WHERE LOWER(email) = ANY($1::TEXT[]);
+
 -- name: get-subscriber-lists
 WITH sub AS (
     SELECT id FROM subscribers WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE uuid = $2 END
@@ -84,6 +88,7 @@ SELECT lists.*,
     ORDER BY subscriber_lists.status;
 
 -- name: insert-subscriber
+-- $9 is the source of the subscription recorded in subscription_events.
 WITH sub AS (
     INSERT INTO subscribers (uuid, email, name, status, attribs)
     VALUES($1, $2, $3, $4, $5)
@@ -108,29 +113,53 @@ subs AS (
                 THEN 'unsubscribed'::subscription_status
                 ELSE $8::subscription_status END
             )
+    RETURNING subscriber_id, list_id, status
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, subscription_event_type(NULL, status), $9 FROM subs
+    WHERE subscription_event_type(NULL, status) IS NOT NULL
 )
 SELECT id from sub;
 
 -- name: upsert-subscriber
 -- Upserts a subscriber where existing subscribers get their names and attributes overwritten.
 -- If $7 = true, update name/attribs. If $8 = true, update subscription status.
+-- $9 is the strategy for combining attribs with existing ones: replace, merge (shallow), or deep_merge.
+-- $10 is the source of the subscription changes recorded in subscription_events.
 WITH sub AS (
     INSERT INTO subscribers as s (uuid, email, name, attribs, status)
     VALUES($1, $2, $3, $4, 'enabled')
     ON CONFLICT (email)
     DO UPDATE SET
         name=(CASE WHEN $7 THEN $3 ELSE s.name END),
-        attribs=(CASE WHEN $7 THEN $4 ELSE s.attribs END),
+        attribs=(CASE WHEN NOT $7 THEN s.attribs
+            WHEN $9::TEXT = 'merge' THEN s.attribs || $4
+            WHEN $9::TEXT = 'deep_merge' THEN jsonb_deep_merge(s.attribs, $4)
+            ELSE $4 END),
         updated_at=NOW()
     RETURNING uuid, id, status
 ),
+prev AS (
+    -- Subscription statuses before the upsert for recording subscription events.
+    SELECT list_id, status FROM subscriber_lists WHERE subscriber_id = (SELECT id FROM sub)
+),
 subs AS (
     INSERT INTO subscriber_lists (subscriber_id, list_id, status)
     SELECT sub.id, listID, CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed' ELSE $6::subscription_status END
     FROM sub, UNNEST($5::INT[]) AS listID
+    -- Per-record list IDs in imported files may not exist.
+    WHERE listID IN (SELECT id FROM lists)
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
     SET updated_at = NOW(),
         status = CASE WHEN $8 THEN EXCLUDED.status ELSE subscriber_lists.status END
+    RETURNING subscriber_id, list_id, status
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subs.subscriber_id, subs.list_id, subscription_event_type(prev.status, subs.status), $10
+    FROM subs LEFT JOIN prev ON (prev.list_id = subs.list_id)
+    WHERE subscription_event_type(prev.status, subs.status) IS NOT NULL
 )
 SELECT uuid, id from sub;
 
@@ -138,12 +167,18 @@ SELECT uuid, id from sub;
 -- Upserts a subscriber where the update will only set the status to blocklisted
 -- unlike upsert-subscribers where name and attributes are updated. In addition, all
 -- existing subscriptions are marked as 'unsubscribed'.
--- This is used in the bulk importer.
+-- This is used in the bulk importer. $5 is the source of the unsubscriptions
+-- recorded in subscription_events.
 WITH sub AS (
     INSERT INTO subscribers (uuid, email, name, attribs, status)
     VALUES($1, $2, $3, $4, 'blocklisted')
     ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
     RETURNING id
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', $5 FROM subscriber_lists
+    WHERE subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed'
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM sub);
@@ -160,6 +195,7 @@ WHERE id = $1;
 -- name: update-subscriber-with-lists
 -- Updates a subscriber's data, and given a list of list_ids, inserts subscriptions
 -- for them while deleting existing subscriptions not in the list.
+-- $10 is the source of the subscription changes recorded in subscription_events.
 WITH s AS (
     UPDATE subscribers SET
         email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
@@ -176,8 +212,12 @@ listIDs AS (
 ),
 d AS (
     DELETE FROM subscriber_lists WHERE $9 = TRUE AND subscriber_id = $1 AND list_id != ALL(SELECT id FROM listIDs)
-)
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+),
+prev AS (
+    SELECT list_id, status FROM subscriber_lists WHERE subscriber_id = $1
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
     VALUES(
         (SELECT id FROM s),
         UNNEST(ARRAY(SELECT id FROM listIDs)),
@@ -193,7 +233,13 @@ INSERT INTO subscriber_lists (subscriber_id, list_id, status)
             WHEN subscriber_lists.status = 'unsubscribed' THEN 'unsubscribed'::subscription_status
             ELSE $8::subscription_status
         END
-    );
+    )
+    RETURNING subscriber_id, list_id, status
+)
+INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subs.subscriber_id, subs.list_id, subscription_event_type(prev.status, subs.status), $10
+    FROM subs LEFT JOIN prev ON (prev.list_id = subs.list_id)
+    WHERE subscription_event_type(prev.status, subs.status) IS NOT NULL;
 
 -- name: delete-subscribers
 -- Delete one or more subscribers by ID or UUID.
@@ -207,38 +253,70 @@ DELETE FROM subscribers a WHERE NOT EXISTS
     (SELECT 1 FROM subscriber_lists b WHERE b.subscriber_id = a.id);
 
 -- name: blocklist-subscribers
+-- $2 is the source of the unsubscriptions recorded in subscription_events.
 WITH b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
     WHERE id = ANY($1::INT[])
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', $2 FROM subscriber_lists
+    WHERE subscriber_id = ANY($1::INT[]) AND status != 'unsubscribed'
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = ANY($1::INT[]);
 
 -- name: add-subscribers-to-lists
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    (SELECT a, b, (CASE WHEN $3 != '' THEN $3::subscription_status ELSE 'unconfirmed' END) FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b)
-    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status=(CASE WHEN $3 != '' THEN $3::subscription_status ELSE subscriber_lists.status END);
+-- $4 is the source of the subscriptions recorded in subscription_events.
+WITH prev AS (
+    SELECT subscriber_id, list_id, status FROM subscriber_lists
+    WHERE subscriber_id = ANY($1::INT[]) AND list_id = ANY($2::INT[])
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+        (SELECT a, b, (CASE WHEN $3 != '' THEN $3::subscription_status ELSE 'unconfirmed' END) FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b)
+        ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status=(CASE WHEN $3 != '' THEN $3::subscription_status ELSE subscriber_lists.status END)
+    RETURNING subscriber_id, list_id, status
+)
+INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subs.subscriber_id, subs.list_id, subscription_event_type(prev.status, subs.status), $4
+    FROM subs LEFT JOIN prev ON (prev.subscriber_id = subs.subscriber_id AND prev.list_id = subs.list_id)
+    WHERE subscription_event_type(prev.status, subs.status) IS NOT NULL;
 
 -- name: delete-subscriptions
 DELETE FROM subscriber_lists
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b);
 
 -- name: confirm-subscription-optin
+-- Opt-ins are only confirmed from the public pages, which is recorded as the event source.
 WITH subID AS (
     SELECT id FROM subscribers WHERE uuid = $1::UUID
 ),
 listIDs AS (
     SELECT id FROM lists WHERE uuid = ANY($2::UUID[])
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, subscription_event_type(status, 'confirmed'), 'public' FROM subscriber_lists
+    WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs)
+        AND subscription_event_type(status, 'confirmed') IS NOT NULL
 )
 UPDATE subscriber_lists SET status='confirmed', meta=meta || $3, updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs);
 
 -- name: unsubscribe-subscribers-from-lists
+-- $4 is the source of the unsubscriptions recorded in subscription_events.
 WITH listIDs AS (
     SELECT ARRAY(
         SELECT id FROM lists WHERE
         (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id=ANY($2) ELSE uuid=ANY($3::UUID[]) END)
     ) id
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', $4 FROM subscriber_lists
+    WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST((SELECT id FROM listIDs)) b)
+        AND status != 'unsubscribed'
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST((SELECT id FROM listIDs)) b);
@@ -255,11 +333,16 @@ WITH lists AS (
 sub AS (
     UPDATE subscribers SET status = (CASE WHEN $3 IS TRUE THEN 'blocklisted' ELSE status END)
     WHERE uuid = $2 RETURNING id
+),
+unsubs AS (
+    UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
+        subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
+        -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
+        CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END
+    RETURNING subscriber_id, list_id
 )
-UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
-    subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
-    -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
-    CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END;
+INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', 'campaign' FROM unsubs;
 
 -- name: delete-unconfirmed-subscriptions
 WITH optins AS (
@@ -316,6 +399,7 @@ SELECT COALESCE(SUM(subscriber_count), 0) AS total FROM mat_list_subscriber_stat
 -- raw: true
 -- Unprepared statement for issuring arbitrary WHERE conditions for
 -- searching subscribers to do bulk CSV export.
+-- If $7 = true, the subscriber's list subscriptions are fetched as a JSON array.
 SELECT subscribers.id,
        subscribers.uuid,
        subscribers.email,
@@ -323,7 +407,14 @@ SELECT subscribers.id,
        subscribers.status,
        subscribers.attribs,
        subscribers.created_at,
-       subscribers.updated_at
+       subscribers.updated_at,
+       (CASE WHEN $7 THEN (
+            SELECT COALESCE(JSON_AGG(JSON_BUILD_OBJECT('id', l.id, 'uuid', l.uuid, 'name', l.name,
+                'subscription_status', sl.status, 'created_at', sl.created_at, 'updated_at', sl.updated_at)
+                ORDER BY l.id), '[]')
+            FROM subscriber_lists sl JOIN lists l ON (l.id = sl.list_id)
+            WHERE sl.subscriber_id = subscribers.id
+        ) ELSE '[]' END) AS subscriptions
        FROM subscribers
     LEFT JOIN subscriber_lists
     ON (
@@ -367,20 +458,33 @@ DELETE FROM subscribers WHERE id=ANY(SELECT id FROM subs);
 
 -- name: blocklist-subscribers-by-query
 -- raw: true
+-- $5 is the source of the unsubscriptions recorded in subscription_events.
 WITH subs AS (%query%),
 b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
     WHERE id = ANY(SELECT id FROM subs)
+),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', $5 FROM subscriber_lists
+    WHERE subscriber_id = ANY(SELECT id FROM subs) AND status != 'unsubscribed'
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = ANY(SELECT id FROM subs);
 
 -- name: add-subscribers-to-lists-by-query
 -- raw: true
-WITH subs AS (%query%)
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    (SELECT a, b, (CASE WHEN $6 != '' THEN $6::subscription_status ELSE 'unconfirmed' END) FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b)
-    ON CONFLICT (subscriber_id, list_id) DO NOTHING;
+-- $7 is the source of the subscriptions recorded in subscription_events.
+WITH subs AS (%query%),
+ins AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+        (SELECT a, b, (CASE WHEN $6 != '' THEN $6::subscription_status ELSE 'unconfirmed' END) FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b)
+        ON CONFLICT (subscriber_id, list_id) DO NOTHING
+    RETURNING subscriber_id, list_id, status
+)
+INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, subscription_event_type(NULL, status), $7 FROM ins
+    WHERE subscription_event_type(NULL, status) IS NOT NULL;
 
 -- name: delete-subscriptions-by-query
 -- raw: true
@@ -390,7 +494,14 @@ DELETE FROM subscriber_lists
 
 -- name: unsubscribe-subscribers-from-lists-by-query
 -- raw: true
-WITH subs AS (%query%)
+-- $6 is the source of the unsubscriptions recorded in subscription_events.
+WITH subs AS (%query%),
+events AS (
+    INSERT INTO subscription_events (subscriber_id, list_id, event, source)
+    SELECT subscriber_id, list_id, 'unsubscribe', $6 FROM subscriber_lists
+    WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b)
+        AND status != 'unsubscribed'
+)
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b);
 
@@ -428,8 +539,8 @@ SELECT (SELECT email FROM prof) as email,
         COALESCE((SELECT JSON_AGG(t) FROM clicks t), '[]') AS link_clicks;
 
 -- name: get-subscriber-activity
--- Gets the subscriber's campaign views and link clicks with detailed information
--- for display in the Activity tab
+-- Gets the subscriber's campaign views, link clicks, and subscription history with
+-- detailed information for display in the Activity tab
 WITH views AS (
     SELECT
         c.id,
@@ -460,7 +571,39 @@ clicks AS (
     WHERE lc.subscriber_id = $1
     GROUP BY l.id, l.url, c.id, c.uuid, c.name, c.subject
     ORDER BY last_clicked_at DESC
+),
+events AS (
+    SELECT
+        e.id,
+        e.event,
+        e.source,
+        l.id as list_id,
+        l.name as list_name,
+        e.created_at
+    FROM subscription_events e
+    LEFT JOIN lists l ON l.id = e.list_id
+    WHERE e.subscriber_id = $1
+    ORDER BY e.created_at DESC, e.id DESC
 )
 SELECT
     COALESCE((SELECT JSON_AGG(v) FROM views v), '[]') as campaign_views,
-    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks;
+    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks,
+    COALESCE((SELECT JSON_AGG(e) FROM events e), '[]') as subscription_events;
+
+-- name: claim-scheduled-export
+-- Claims the run of a scheduled export ($1) at a scheduled time ($2). Nothing is
+-- returned if the run has already been claimed by another instance.
+INSERT INTO scheduled_export_files (name, run_at) VALUES ($1, $2)
+    ON CONFLICT (name, run_at) DO NOTHING RETURNING id;
+
+-- name: update-scheduled-export-file
+UPDATE scheduled_export_files SET filename=$2, size=$3 WHERE id=$1;
+
+-- name: delete-scheduled-export-file
+DELETE FROM scheduled_export_files WHERE id=$1;
+
+-- name: get-scheduled-export-files
+-- Returns the files of completed scheduled exports, newest first. $1 is an optional ID.
+SELECT * FROM scheduled_export_files
+    WHERE filename != '' AND ($1 = 0 OR id = $1)
+    ORDER BY created_at DESC;
@@ -4,12 +4,12 @@
 SELECT id, name, type, subject,
     (CASE WHEN $2 = false THEN body ELSE '' END) as body,
     (CASE WHEN $2 = false THEN body_source ELSE NULL END) as body_source,
-    is_default, created_at, updated_at
+    is_default, security, created_at, updated_at
     FROM templates WHERE ($1 = 0 OR id = $1) AND ($3 = '' OR type = $3::template_type)
     ORDER BY created_at;
 
 -- name: create-template
-INSERT INTO templates (name, type, subject, body, body_source) VALUES($1, $2, $3, $4, $5) RETURNING id;
+INSERT INTO templates (name, type, subject, body, body_source, security) VALUES($1, $2, $3, $4, $5, $6) RETURNING id;
 
 -- name: update-template
 UPDATE templates SET
@@ -17,6 +17,7 @@ UPDATE templates SET
     subject=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     body=(CASE WHEN $4 != '' THEN $4 ELSE body END),
     body_source=(CASE WHEN $5 != '' THEN $5 ELSE body_source END),
+    security=$6,
     updated_at=NOW()
 WHERE id = $1;
 
@@ -0,0 +1,77 @@
+-- name: insert-tx-messages
+-- Records queued transactional messages in the log. Subscriber IDs ($3) are 0 for
+-- ephemeral (non-DB) recipients and the batch UUID ($2) is empty for messages
+-- that aren't sent via the async batch API. Payloads ($9) are only set for async
+-- batch messages that are picked up by the queue worker.
+INSERT INTO tx_messages (uuid, batch_uuid, subscriber_id, email, subject, template_id, messenger, callback_url, payload)
+    SELECT t.uuid, NULLIF($2, '')::UUID, NULLIF(t.sub_id, 0), t.email, t.subject, NULLIF($6, 0), $7, $8, NULLIF(t.payload, '')::JSONB
+    FROM UNNEST($1::UUID[], $3::INT[], $4::TEXT[], $5::TEXT[], $9::TEXT[]) AS t(uuid, sub_id, email, subject, payload);
+
+-- name: update-tx-message
+-- Updates the status of a message. The subject ($4), if set, is the subject rendered at the time of sending.
+UPDATE tx_messages SET status=$2, error=$3, subject=COALESCE(NULLIF($4, ''), subject), payload=NULL, updated_at=NOW()
+    WHERE uuid=$1 RETURNING *;
+
+-- name: next-tx-messages
+-- Picks up to $1 queued async messages for sending and clears their payloads so that
+-- they're not picked up again, by this or any other instance.
+WITH msgs AS (
+    SELECT id, payload FROM tx_messages WHERE status = 'queued' AND payload IS NOT NULL
+    ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+)
+UPDATE tx_messages SET payload=NULL, updated_at=NOW() FROM msgs WHERE tx_messages.id = msgs.id
+    RETURNING tx_messages.uuid, tx_messages.template_id, msgs.payload;
+
+-- name: fail-stale-tx-messages
+-- Marks messages that were picked up for sending but whose status was never recorded
+-- in $1 seconds, eg: because the instance sending them was restarted, as failed with
+-- the error $2.
+UPDATE tx_messages SET status='failed', error=$2, updated_at=NOW()
+    WHERE status = 'queued' AND payload IS NULL AND updated_at < NOW() - MAKE_INTERVAL(secs => $1)
+    RETURNING *;
+
+-- name: get-tx-message
+SELECT *, ARRAY(
+    SELECT DISTINCT l.url FROM tx_link_clicks c
+    LEFT JOIN links l ON (l.id = c.link_id)
+    WHERE c.tx_message_id = tx_messages.id
+) AS clicked_links
+FROM tx_messages WHERE uuid=$1;
+
+-- name: get-tx-batch
+SELECT * FROM tx_messages WHERE batch_uuid=$1 ORDER BY id;
+
+-- name: query-tx-messages
+-- Searches the message log by e-mail ($1), status ($2), template ($3), subscriber ($4),
+-- and the time range ($5, $6).
+SELECT COUNT(*) OVER () AS total, tx_messages.* FROM tx_messages
+    WHERE ($1 = '' OR LOWER(email) LIKE '%' || LOWER($1) || '%')
+    AND ($2 = '' OR status = $2)
+    AND ($3 = 0 OR template_id = $3)
+    AND ($4 = 0 OR subscriber_id = $4)
+    AND ($5::TIMESTAMP WITH TIME ZONE IS NULL OR created_at >= $5)
+    AND ($6::TIMESTAMP WITH TIME ZONE IS NULL OR created_at < $6)
+    ORDER BY id DESC OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
+
+-- name: register-tx-view
+UPDATE tx_messages SET views=views+1, viewed_at=COALESCE(viewed_at, NOW()) WHERE uuid=$1;
+
+-- name: register-tx-link-click
+-- Records a click on a link ($1) in a message ($2) and returns the link's URL.
+-- The click isn't recorded if the message UUID is empty.
+WITH link AS (
+    SELECT id, url FROM links WHERE uuid = $1
+),
+msg AS (
+    UPDATE tx_messages SET clicks=clicks+1, clicked_at=COALESCE(clicked_at, NOW())
+    WHERE uuid = NULLIF($2::TEXT, '')::UUID AND EXISTS (SELECT 1 FROM link)
+    RETURNING id
+),
+ins AS (
+    INSERT INTO tx_link_clicks (tx_message_id, link_id) SELECT msg.id, link.id FROM msg, link
+)
+SELECT url FROM link;
+
+-- name: delete-tx-messages
+-- Deletes log entries older than $1 days.
+DELETE FROM tx_messages WHERE created_at < NOW() - MAKE_INTERVAL(days => $1);
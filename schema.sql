@@ -12,6 +12,7 @@ DROP TYPE IF EXISTS user_type CASCADE; CREATE TYPE user_type AS ENUM ('user', 'a
 DROP TYPE IF EXISTS user_status CASCADE; CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
 DROP TYPE IF EXISTS role_type CASCADE; CREATE TYPE role_type AS ENUM ('user', 'list');
 DROP TYPE IF EXISTS twofa_type CASCADE; CREATE TYPE twofa_type AS ENUM ('none', 'totp');
+DROP TYPE IF EXISTS subscription_event CASCADE; CREATE TYPE subscription_event AS ENUM ('subscribe', 'confirm', 'unsubscribe');
 
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
@@ -73,6 +74,56 @@ DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
 
+-- subscription events
+-- Append-only log of subscription status changes used for list growth analytics.
+-- Events are retained when subscribers are deleted (subscriber_id is NULL).
+DROP TABLE IF EXISTS subscription_events CASCADE;
+CREATE TABLE subscription_events (
+    id                 BIGSERIAL PRIMARY KEY,
+    subscriber_id      INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    list_id            INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    event              subscription_event NOT NULL,
+    source             TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_events_sub_id; CREATE INDEX idx_sub_events_sub_id ON subscription_events(subscriber_id);
+DROP INDEX IF EXISTS idx_sub_events_list_id; CREATE INDEX idx_sub_events_list_id ON subscription_events(list_id);
+DROP INDEX IF EXISTS idx_sub_events_date; CREATE INDEX idx_sub_events_date ON subscription_events((TIMEZONE('UTC', created_at)::DATE));
+
+-- Returns the subscription event for a subscription status change from prev to cur,
+-- or NULL if the change is not an event (eg: no change, or a new, unsubscribed subscription).
+CREATE OR REPLACE FUNCTION subscription_event_type(prev subscription_status, cur subscription_status)
+RETURNS subscription_event AS $$
+    SELECT (CASE
+        WHEN prev IS NOT DISTINCT FROM cur THEN NULL
+        WHEN cur = 'unsubscribed' THEN (CASE WHEN prev IS NULL THEN NULL ELSE 'unsubscribe' END)
+        WHEN prev IS NULL OR prev = 'unsubscribed' THEN 'subscribe'
+        WHEN cur = 'confirmed' THEN 'confirm'
+        ELSE NULL
+    END)::subscription_event;
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- Recursively merges JSONB object b into a. Keys in b overwrite the ones in a,
+-- except where both values are objects, in which case they're merged.
+CREATE OR REPLACE FUNCTION jsonb_deep_merge(a JSONB, b JSONB)
+RETURNS JSONB AS $$
+    SELECT (CASE
+        WHEN b IS NULL THEN a
+        WHEN JSONB_TYPEOF(a) = 'object' AND JSONB_TYPEOF(b) = 'object' THEN (
+            SELECT COALESCE(JSONB_OBJECT_AGG(
+                COALESCE(e1.key, e2.key),
+                CASE
+                    WHEN e1.value IS NULL THEN e2.value
+                    WHEN e2.value IS NULL THEN e1.value
+                    ELSE jsonb_deep_merge(e1.value, e2.value)
+                END
+            ), '{}')
+            FROM JSONB_EACH(a) e1 FULL JOIN JSONB_EACH(b) e2 ON (e1.key = e2.key)
+        )
+        ELSE b
+    END);
+$$ LANGUAGE SQL IMMUTABLE;
+
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
 CREATE TABLE templates (
@@ -83,6 +134,7 @@ CREATE TABLE templates (
     body            TEXT NOT NULL,
     body_source     TEXT NULL,
     is_default      BOOLEAN NOT NULL DEFAULT false,
+    security        TEXT NOT NULL DEFAULT '',
 
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
@@ -104,6 +156,8 @@ CREATE TABLE campaigns (
     content_type     content_type NOT NULL DEFAULT 'richtext',
     send_at          TIMESTAMP WITH TIME ZONE,
     headers          JSONB NOT NULL DEFAULT '[]',
+    sub_attachments  JSONB NOT NULL DEFAULT '[]',
+    security         TEXT NOT NULL DEFAULT '',
     attribs          JSONB NOT NULL DEFAULT '{}',
     status           campaign_status NOT NULL DEFAULT 'draft',
     tags             VARCHAR(100)[],
@@ -174,10 +228,24 @@ CREATE TABLE media (
     filename         TEXT NOT NULL,
     content_type     TEXT NOT NULL DEFAULT 'application/octet-stream',
     thumb            TEXT NOT NULL,
+    folder           TEXT NOT NULL DEFAULT '',
+    tags             VARCHAR(100)[] NOT NULL DEFAULT '{}',
     meta             JSONB NOT NULL DEFAULT '{}',
+
+    -- SHA-256 of the uploaded content, other names it's been uploaded as,
+    -- the total size of its stored files, and the user who uploaded it.
+    hash             TEXT NOT NULL DEFAULT '',
+    aliases          TEXT[] NOT NULL DEFAULT '{}',
+    size             BIGINT NOT NULL DEFAULT 0,
+    user_id          INTEGER NULL,
+
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_media_filename; CREATE INDEX idx_media_filename ON media(provider, filename);
+DROP INDEX IF EXISTS idx_media_folder; CREATE INDEX idx_media_folder ON media(provider, folder);
+DROP INDEX IF EXISTS idx_media_tags; CREATE INDEX idx_media_tags ON media USING GIN(tags);
+DROP INDEX IF EXISTS idx_media_hash; CREATE INDEX idx_media_hash ON media(provider, hash);
+DROP INDEX IF EXISTS idx_media_user_id; CREATE INDEX idx_media_user_id ON media(user_id);
 
 -- campaign_media
 DROP TABLE IF EXISTS campaign_media CASCADE;
@@ -235,18 +303,22 @@ INSERT INTO settings (key, value) VALUES
     ('app.concurrency', '10'),
     ('app.message_rate', '10'),
     ('app.batch_size', '1000'),
+    ('app.import_concurrency', '1'),
     ('app.max_send_errors', '1000'),
     ('app.message_sliding_window', 'false'),
     ('app.message_sliding_window_duration', '"1h"'),
     ('app.message_sliding_window_rate', '10000'),
     ('app.cache_slow_queries', 'false'),
     ('app.cache_slow_queries_interval', '"0 3 * * *"'),
+    ('app.idempotency_ttl', '"24h"'),
     ('app.enable_public_archive', 'true'),
     ('app.enable_public_subscription_page', 'true'),
     ('app.enable_public_archive_rss_content', 'true'),
     ('app.send_optin_confirmation', 'true'),
     ('app.check_updates', 'true'),
     ('app.notify_emails', '[]'),
+    ('app.digest_report', '{"enabled": false, "frequency": "weekly", "user_ids": []}'),
+    ('app.scheduled_exports', '[]'),
     ('app.lang', '"en"'),
     ('privacy.individual_tracking', 'false'),
     ('privacy.unsubscribe_header', 'true'),
@@ -258,9 +330,13 @@ INSERT INTO settings (key, value) VALUES
     ('privacy.domain_blocklist', '[]'),
     ('privacy.domain_allowlist', '[]'),
     ('privacy.record_optin_ip', 'false'),
+    ('privacy.tx_log_retention_days', '30'),
+    ('privacy.import_retention_days', '30'),
     ('security.captcha', '{"altcha": {"enabled": false, "complexity": 300000}, "hcaptcha": {"enabled": false, "key": "", "secret": ""}}'),
     ('security.oidc', '{"enabled": false, "provider_url": "", "provider_name": "", "client_id": "", "client_secret": "", "auto_create_users": false, "default_user_role_id": null, "default_list_role_id": null}'),
     ('security.cors_origins', '[]'),
+    ('security.audit_retention_days', '90'),
+    ('security.email_crypto', '{"method": "smime", "smime_cert": "", "smime_key": "", "pgp_key": "", "pgp_passphrase": ""}'),
     ('upload.provider', '"filesystem"'),
     ('upload.max_file_size', '5000'),
     ('upload.extensions', '["jpg","jpeg","png","gif","svg","*"]'),
@@ -276,10 +352,39 @@ INSERT INTO settings (key, value) VALUES
     ('upload.s3.bucket_path', '"/"'),
     ('upload.s3.bucket_type', '"public"'),
     ('upload.s3.expiry', '"167h"'),
+    ('upload.webdav.url', '""'),
+    ('upload.webdav.username', '""'),
+    ('upload.webdav.password', '""'),
+    ('upload.webdav.path', '"/"'),
+    ('upload.webdav.public_url', '""'),
+    ('upload.webdav.max_conns', '5'),
+    ('upload.webdav.timeout', '"30s"'),
+    ('upload.sftp.host', '""'),
+    ('upload.sftp.username', '""'),
+    ('upload.sftp.password', '""'),
+    ('upload.sftp.private_key', '""'),
+    ('upload.sftp.host_key', '""'),
+    ('upload.sftp.path', '"uploads"'),
+    ('upload.sftp.public_url', '""'),
+    ('upload.sftp.max_conns', '2'),
+    ('upload.sftp.timeout', '"30s"'),
+    ('upload.image.max_width', '0'),
+    ('upload.image.max_height', '0'),
+    ('upload.image.quality', '85'),
+    ('upload.image.strip_exif', 'true'),
+    ('upload.image.formats', '[]'),
+    ('upload.image.renditions', '[]'),
+    ('upload.deduplicate', 'true'),
+    ('upload.quota', '0'),
+    ('upload.user_quota', '0'),
     ('smtp',
         '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[]},
           {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"TLS","tls_skip_verify":false,"email_headers":[]}]'),
     ('messengers', '[]'),
+    ('webpush.enabled', 'false'),
+    ('webpush.subject', '""'),
+    ('webpush.vapid_public_key', '""'),
+    ('webpush.vapid_private_key', '""'),
     ('bounce.enabled', 'false'),
     ('bounce.webhooks_enabled', 'false'),
     ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "complaint" : {"count": 1, "action": "blocklist"}}'),
@@ -357,6 +462,134 @@ CREATE TABLE sessions (
 );
 DROP INDEX IF EXISTS idx_sessions; CREATE INDEX idx_sessions ON sessions (id, created_at);
 
+-- media is created before users.
+ALTER TABLE media ADD CONSTRAINT media_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- audit logs
+DROP TABLE IF EXISTS audit_logs CASCADE;
+CREATE TABLE audit_logs (
+    id               BIGSERIAL PRIMARY KEY,
+    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    username         TEXT NOT NULL DEFAULT '',
+    action           TEXT NOT NULL,
+    target_type      TEXT NOT NULL,
+    target_id        INTEGER NULL,
+    route            TEXT NOT NULL DEFAULT '',
+    diff             JSONB NOT NULL DEFAULT '{}',
+    ip               TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_audit_user_id; CREATE INDEX idx_audit_user_id ON audit_logs(user_id);
+DROP INDEX IF EXISTS idx_audit_target; CREATE INDEX idx_audit_target ON audit_logs(target_type, target_id);
+DROP INDEX IF EXISTS idx_audit_created_at; CREATE INDEX idx_audit_created_at ON audit_logs(created_at);
+
+-- import jobs
+DROP TABLE IF EXISTS import_jobs CASCADE;
+CREATE TABLE import_jobs (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    mode             TEXT NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'queued',
+    options          JSONB NOT NULL DEFAULT '{}',
+    total            INTEGER NOT NULL DEFAULT 0,
+    imported         INTEGER NOT NULL DEFAULT 0,
+    report           JSONB NOT NULL DEFAULT '{}',
+    log              TEXT NOT NULL DEFAULT '',
+    rejects_file     TEXT NOT NULL DEFAULT '',
+
+    -- Refreshed periodically by the instance that's running (or has queued) the job.
+    heartbeat_at     TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at       TIMESTAMP WITH TIME ZONE NULL,
+    finished_at      TIMESTAMP WITH TIME ZONE NULL
+);
+DROP INDEX IF EXISTS idx_import_jobs_status; CREATE INDEX idx_import_jobs_status ON import_jobs(status);
+DROP INDEX IF EXISTS idx_import_jobs_created_at; CREATE INDEX idx_import_jobs_created_at ON import_jobs(created_at);
+
+-- transactional message log
+DROP TABLE IF EXISTS tx_messages CASCADE;
+CREATE TABLE tx_messages (
+    id               BIGSERIAL PRIMARY KEY,
+    uuid             UUID NOT NULL UNIQUE,
+
+    -- Only for messages sent via the async batch API.
+    batch_uuid       UUID NULL,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    email            TEXT NOT NULL,
+    template_id      INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    subject          TEXT NOT NULL DEFAULT '',
+    messenger        TEXT NOT NULL DEFAULT '',
+    status           TEXT NOT NULL DEFAULT 'queued',
+    error            TEXT NOT NULL DEFAULT '',
+    callback_url     TEXT NOT NULL DEFAULT '',
+
+    -- Message and recipient of an async batch message that's waiting to be
+    -- picked up by the queue worker. Cleared once it's picked up.
+    payload          JSONB NULL,
+    views            INTEGER NOT NULL DEFAULT 0,
+    clicks           INTEGER NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    viewed_at        TIMESTAMP WITH TIME ZONE NULL,
+    clicked_at       TIMESTAMP WITH TIME ZONE NULL
+);
+DROP INDEX IF EXISTS idx_tx_messages_batch_uuid; CREATE INDEX idx_tx_messages_batch_uuid ON tx_messages(batch_uuid);
+DROP INDEX IF EXISTS idx_tx_messages_email; CREATE INDEX idx_tx_messages_email ON tx_messages(LOWER(email));
+DROP INDEX IF EXISTS idx_tx_messages_sub_id; CREATE INDEX idx_tx_messages_sub_id ON tx_messages(subscriber_id);
+DROP INDEX IF EXISTS idx_tx_messages_created_at; CREATE INDEX idx_tx_messages_created_at ON tx_messages(created_at);
+DROP INDEX IF EXISTS idx_tx_messages_queued; CREATE INDEX idx_tx_messages_queued ON tx_messages(id) WHERE status = 'queued';
+
+DROP TABLE IF EXISTS tx_link_clicks CASCADE;
+CREATE TABLE tx_link_clicks (
+    id               BIGSERIAL PRIMARY KEY,
+    tx_message_id    BIGINT NOT NULL REFERENCES tx_messages(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_tx_clicks_msg_id; CREATE INDEX idx_tx_clicks_msg_id ON tx_link_clicks(tx_message_id);
+
+-- idempotency keys and stored responses of API requests
+DROP TABLE IF EXISTS idempotency_keys CASCADE;
+CREATE TABLE idempotency_keys (
+    key              TEXT NOT NULL PRIMARY KEY,
+    request_hash     TEXT NOT NULL,
+    status_code      INTEGER NOT NULL DEFAULT 0,
+    content_type     TEXT NOT NULL DEFAULT '',
+    response         BYTEA NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at       TIMESTAMP WITH TIME ZONE NOT NULL
+);
+DROP INDEX IF EXISTS idx_idempotency_keys_expires_at; CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+-- files written by scheduled subscriber exports to the media store. The unique
+-- (name, run_at) ensures that only one instance runs a scheduled export.
+DROP TABLE IF EXISTS scheduled_export_files CASCADE;
+CREATE TABLE scheduled_export_files (
+    id               BIGSERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    run_at           TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    -- Empty while the export is running.
+    filename         TEXT NOT NULL DEFAULT '',
+    size             BIGINT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    UNIQUE (name, run_at)
+);
+
+-- web push subscriptions of subscribers' browsers
+DROP TABLE IF EXISTS push_subscriptions CASCADE;
+CREATE TABLE push_subscriptions (
+    id               BIGSERIAL PRIMARY KEY,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    endpoint         TEXT NOT NULL UNIQUE,
+    key_p256dh       TEXT NOT NULL,
+    key_auth         TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_push_subs_sub_id; CREATE INDEX idx_push_subs_sub_id ON push_subscriptions(subscriber_id);
+
 -- materialized views
 
 -- dashboard stats
@@ -424,9 +657,24 @@ CREATE MATERIALIZED VIEW mat_dashboard_charts AS
               WHERE TIMEZONE('UTC', created_at)::DATE BETWEEN (SELECT from_date FROM viewDates) AND (SELECT to_date FROM viewDates)
               GROUP by date ORDER BY date
         ) row
+    ),
+    growth AS (
+        -- Per-list daily subscription events for the last 30 days.
+        SELECT JSON_AGG(ROW_TO_JSON(row))
+        FROM (
+            SELECT TIMEZONE('UTC', e.created_at)::DATE AS date, e.list_id, lists.name AS list_name,
+                COUNT(*) FILTER (WHERE e.event = 'subscribe') AS subscribed,
+                COUNT(*) FILTER (WHERE e.event = 'confirm') AS confirmed,
+                COUNT(*) FILTER (WHERE e.event = 'unsubscribe') AS unsubscribed
+            FROM subscription_events e
+            JOIN lists ON (lists.id = e.list_id)
+            WHERE TIMEZONE('UTC', e.created_at)::DATE BETWEEN TIMEZONE('UTC', NOW())::DATE - INTERVAL '30 DAY' AND TIMEZONE('UTC', NOW())::DATE
+            GROUP BY date, e.list_id, lists.name ORDER BY date, e.list_id
+        ) row
     )
     SELECT NOW() AS updated_at, JSON_BUILD_OBJECT('link_clicks', COALESCE((SELECT * FROM clicks), '[]'),
-                                  'campaign_views', COALESCE((SELECT * FROM views), '[]')
+                                  'campaign_views', COALESCE((SELECT * FROM views), '[]'),
+                                  'list_growth', COALESCE((SELECT * FROM growth), '[]')
                                 ) AS data;
 DROP INDEX IF EXISTS mat_dashboard_charts_idx; CREATE UNIQUE INDEX mat_dashboard_charts_idx ON mat_dashboard_charts (updated_at);
 
@@ -0,0 +1,22 @@
+// Service worker that displays listmonk web push notifications.
+self.addEventListener('push', function (e) {
+  if (!e.data) {
+    return;
+  }
+
+  var d = e.data.json();
+  e.waitUntil(self.registration.showNotification(d.title, {
+    body: d.body,
+    icon: d.icon,
+    data: { url: d.url },
+  }));
+});
+
+self.addEventListener('notificationclick', function (e) {
+  e.notification.close();
+
+  var url = e.notification.data && e.notification.data.url;
+  if (url) {
+    e.waitUntil(self.clients.openWindow(url));
+  }
+});
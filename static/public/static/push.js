@@ -0,0 +1,71 @@
+// Web push subscription management on the public subscription page.
+(function () {
+  var el = document.querySelector('#push');
+  if (!el || !('serviceWorker' in navigator) || !('PushManager' in window)) {
+    return;
+  }
+
+  var btnOn = document.querySelector('#btn-push-on'),
+    btnOff = document.querySelector('#btn-push-off'),
+    status = el.querySelector('.push-status');
+
+  // Convert the base64 (URL) encoded VAPID key to bytes.
+  function decodeKey(s) {
+    var b = window.atob((s + '==='.slice((s.length + 3) % 4)).replace(/-/g, '+').replace(/_/g, '/')),
+      out = new Uint8Array(b.length);
+    for (var i = 0; i < b.length; i++) {
+      out[i] = b.charCodeAt(i);
+    }
+    return out;
+  }
+
+  function send(method, sub) {
+    return fetch(el.dataset.url, {
+      method: method,
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(sub),
+    }).then(function (r) {
+      if (!r.ok) {
+        throw new Error(r.statusText);
+      }
+    });
+  }
+
+  function render(sub) {
+    btnOn.hidden = !!sub;
+    btnOff.hidden = !sub;
+    status.textContent = sub ? status.dataset.on : '';
+  }
+
+  function fail(err) {
+    status.textContent = status.dataset.err + ' ' + (err.message || '');
+  }
+
+  navigator.serviceWorker.register('/public/static/push-sw.js').then(function (reg) {
+    el.hidden = false;
+
+    reg.pushManager.getSubscription().then(render);
+
+    btnOn.onclick = function () {
+      reg.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: decodeKey(el.dataset.key),
+      }).then(function (sub) {
+        return send('POST', sub.toJSON()).then(function () { render(sub); });
+      }).catch(fail);
+    };
+
+    btnOff.onclick = function () {
+      reg.pushManager.getSubscription().then(function (sub) {
+        if (!sub) {
+          render(null);
+          return null;
+        }
+
+        return send('DELETE', { endpoint: sub.endpoint }).then(function () {
+          return sub.unsubscribe();
+        }).then(function () { render(null); });
+      }).catch(fail);
+    };
+  });
+}());